@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// A game in progress is abandoned when none of its players has been
+// connected for -abandon-after, or none has made a move for -abandon-idle,
+// however long their own reconnect windows are. It is concluded there and
+// then: -abandon-winner decides whether the richest player wins or nobody
+// does, the summary records it as abandoned, whoever is still watching
+// gets GAME_OVER, and the room is closed. Bots don't count as players
+// here; nor do saved games waiting for theirs to come back.
+const (
+	AbandonLeader   = "leader"
+	AbandonNoWinner = "none"
+)
+
+// checkAbandoned arms the abandonment timer if nobody is left at the
+// table, and cancels it if somebody is. It must run on the room's
+// goroutine.
+func (room *GameRoom) checkAbandoned() {
+	if hub.config.AbandonAfter <= 0 || room.GameState.Status != StatusInProgress || room.awaitingPlayers || room.anyoneAtTable() {
+		room.cancelAbandon()
+		return
+	}
+	if room.abandonTimer != nil {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(hub.config.AbandonAfter, func() {
+		room.do(func() {
+			// A timer cancelled after it fired is ignored.
+			if room.abandonTimer != timer {
+				return
+			}
+			room.abandonTimer = nil
+			if room.GameState.Status == StatusInProgress && !room.awaitingPlayers && !room.anyoneAtTable() {
+				room.abandonGame("everyone left")
+			}
+		})
+	})
+	room.abandonTimer = timer
+}
+
+// cancelAbandon stops a pending abandonment. It must run on the room's
+// goroutine.
+func (room *GameRoom) cancelAbandon() {
+	if room.abandonTimer != nil {
+		room.abandonTimer.Stop()
+		room.abandonTimer = nil
+	}
+}
+
+// anyoneAtTable reports whether a player still in the game is connected.
+// It must run on the room's goroutine.
+func (room *GameRoom) anyoneAtTable() bool {
+	for _, name := range room.GameState.TurnOrder {
+		if p := room.GameState.Players[name]; p.Connected && !p.Bot {
+			return true
+		}
+	}
+	return false
+}
+
+// movesStalled reports whether the game has gone -abandon-idle without a
+// move. It must run on the room's goroutine.
+func (room *GameRoom) movesStalled() bool {
+	return hub.config.AbandonIdle > 0 && room.GameState.Status == StatusInProgress && !room.awaitingPlayers &&
+		time.Since(room.lastMove) > hub.config.AbandonIdle
+}
+
+// abandonGame concludes the game and closes the room. It must run on the
+// room's goroutine.
+func (room *GameRoom) abandonGame(reason string) {
+	winner := ""
+	if hub.config.AbandonWinner == AbandonLeader {
+		winner = room.leader()
+	}
+	room.logger().Info("game abandoned", "reason", reason)
+	room.GameState.Abandoned = true
+	room.finishGame(winner)
+	hub.closeRoom(room, "game abandoned")
+}
+
+// leader returns the player still in the game with the highest net worth,
+// breaking ties by name. It must run on the room's goroutine.
+func (room *GameRoom) leader() string {
+	seated := room.seatedByWorth()
+	if len(seated) == 0 {
+		return ""
+	}
+	return seated[0]
+}
+
+// seatedByWorth returns the players still in the game, richest first and
+// ties broken by name. It must run on the room's goroutine.
+func (room *GameRoom) seatedByWorth() []string {
+	seated := append([]string(nil), room.GameState.TurnOrder...)
+	sort.Slice(seated, func(i, j int) bool {
+		a, b := game.NetWorth(room.board, room.GameState.Players[seated[i]]), game.NetWorth(room.board, room.GameState.Players[seated[j]])
+		if a != b {
+			return a > b
+		}
+		return seated[i] < seated[j]
+	})
+	return seated
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// actionLogSize is how many action log entries a room keeps for clients
+// that join or reconnect to backfill their feed from.
+const actionLogSize = 100
+
+// ActionLogEntry is a line of the game's action feed, such as "alex rolled
+// 8 and landed on Marvin Gardens". Key and Params say what happened for
+// clients that render the line themselves; Text is it rendered in the
+// connection's locale.
+// Seq is the sequence number of the ACTION_LOG broadcast that carried the
+// entry, so it sorts among the other broadcasts. Auto is set on moves the
+// server made for a player because their preferences asked it to.
+type ActionLogEntry struct {
+	Seq    uint64                 `json:"seq"`
+	Key    string                 `json:"key"`
+	Params map[string]interface{} `json:"params"`
+	Text   string                 `json:"text"`
+	Auto   bool                   `json:"auto,omitempty"`
+}
+
+// renderAction writes the action log line for key in locale, filling in
+// the catalog's "action.<key>" template with params. Square names and
+// reasons are translated too.
+func (room *GameRoom) renderAction(locale string, key string, params map[string]interface{}) string {
+	text, _ := message(locale, "action."+key)
+	for name, value := range params {
+		s := fmt.Sprint(value)
+		switch name {
+		case "square", "property":
+			s = room.translateText(locale, "square.", s)
+		case "reason":
+			if reason, ok := message(locale, "reason."+s); ok {
+				s = reason
+			}
+		}
+		text = strings.ReplaceAll(text, "{"+name+"}", s)
+	}
+	return text
+}
+
+func (e ActionLogEntry) localize(room *GameRoom, locale string) interface{} {
+	e.Text = room.renderAction(locale, e.Key, e.Params)
+	return e
+}
+
+// logAction adds an entry to the action log and broadcasts it as
+// ACTION_LOG. It is called after the broadcast of the change it describes.
+// It must run on the room's goroutine.
+func (room *GameRoom) logAction(key string, params map[string]interface{}) {
+	entry := ActionLogEntry{
+		// The ACTION_LOG broadcast below takes the next number.
+		Seq:    room.seq + 1,
+		Key:    key,
+		Params: params,
+		Text:   room.renderAction(defaultLocale, key, params),
+		Auto:   room.autoActing,
+	}
+	room.actionLog = append(room.actionLog, entry)
+	if len(room.actionLog) > actionLogSize {
+		room.actionLog = room.actionLog[len(room.actionLog)-actionLogSize:]
+	}
+	SendGameEventToAll(room, "ACTION_LOG", room.ID, entry)
+}
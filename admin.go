@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The admin endpoints are only served when -admin-token is set, and then
+// only to requests bearing it.
+
+type AdminRoomsResponse struct {
+	Rooms      []AdminRoom `json:"rooms"`
+	Goroutines int         `json:"goroutines"`
+}
+
+// AdminRoom is what an operator sees of a room.
+type AdminRoom struct {
+	ID           string            `json:"id"`
+	Status       string            `json:"status"`
+	Paused       bool              `json:"paused"`
+	Private      bool              `json:"private"`
+	Host         string            `json:"host"`
+	Turn         string            `json:"turn,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	LastActivity time.Time         `json:"lastActivity"`
+	Players      []AdminPlayer     `json:"players"`
+	Connections  []AdminConnection `json:"connections"`
+	Subscribers  int               `json:"subscribers"`
+	Seq          uint64            `json:"seq"`
+	Bots         int               `json:"bots"`
+	GraceTimers  int               `json:"graceTimers"`
+}
+
+type AdminPlayer struct {
+	Name      string `json:"name"`
+	Connected bool   `json:"connected"`
+	Bot       bool   `json:"bot,omitempty"`
+	Forfeited bool   `json:"forfeited,omitempty"`
+}
+
+type AdminConnection struct {
+	ID          uint64    `json:"id"`
+	Name        string    `json:"name"`
+	Spectator   bool      `json:"spectator,omitempty"`
+	Remote      string    `json:"remote"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	// Queued is how many messages are waiting in the connection's send
+	// queue, out of SendBufferSize.
+	Queued         int `json:"queued"`
+	SendBufferSize int `json:"sendBufferSize"`
+	// LatencyMs is the connection's smoothed round trip, if it answers
+	// PING.
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+}
+
+// isAdmin reports whether r carries the admin bearer token.
+func isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && hub.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(hub.config.AdminToken)) == 1
+}
+
+// requireAdmin wraps next so it only serves requests carrying the admin
+// bearer token.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, "a valid admin token is required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminRoom describes room. It must run on the room's goroutine.
+func adminRoom(room *GameRoom) AdminRoom {
+	info := AdminRoom{
+		ID:           room.ID,
+		Status:       room.GameState.Status,
+		Paused:       room.GameState.Paused,
+		Private:      room.Options.Private,
+		Host:         room.GameState.Host,
+		Turn:         room.GameState.Turn,
+		CreatedAt:    room.CreatedAt,
+		LastActivity: room.lastActivity,
+		Players:      make([]AdminPlayer, 0, len(room.GameState.Players)),
+		Bots:         len(room.bots),
+		GraceTimers:  len(room.graceTimers),
+	}
+	for _, p := range room.GameState.Players {
+		info.Players = append(info.Players, AdminPlayer{Name: p.Name, Connected: p.Connected, Bot: p.Bot, Forfeited: p.Forfeited})
+	}
+	sort.Slice(info.Players, func(i, j int) bool { return info.Players[i].Name < info.Players[j].Name })
+
+	info.Connections = make([]AdminConnection, 0, len(room.Players)+len(room.Spectators))
+	for i, clients := range []map[*Client]string{room.Players, room.Spectators} {
+		for client, name := range clients {
+			info.Connections = append(info.Connections, AdminConnection{
+				ID:             client.id,
+				Name:           name,
+				Spectator:      i == 1,
+				Remote:         client.conn.RemoteAddr().String(),
+				ConnectedAt:    client.connectedAt,
+				Queued:         len(client.send),
+				SendBufferSize: cap(client.send),
+				LatencyMs:      client.latencyMs(),
+			})
+		}
+	}
+	sort.Slice(info.Connections, func(i, j int) bool { return info.Connections[i].ID < info.Connections[j].ID })
+	info.Subscribers = len(room.subscribers)
+	info.Seq = room.seq
+	return info
+}
+
+// handleAdminRooms lists every room on this instance in detail.
+func handleAdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms := hubRooms()
+	resp := AdminRoomsResponse{Rooms: make([]AdminRoom, 0, len(rooms)), Goroutines: runtime.NumGoroutine()}
+	for _, room := range rooms {
+		// A room that has closed since is left out.
+		room.do(func() { resp.Rooms = append(resp.Rooms, adminRoom(room)) })
+	}
+	sort.Slice(resp.Rooms, func(i, j int) bool { return resp.Rooms[i].ID < resp.Rooms[j].ID })
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// adminLookup finds the room named in the path, answering 404 if there is
+// none.
+func adminLookup(w http.ResponseWriter, r *http.Request) *GameRoom {
+	hub.Mutex.RLock()
+	room, ok := hub.lookup(r.PathValue("id"))
+	hub.Mutex.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "room not found")
+		return nil
+	}
+	return room
+}
+
+// handleAdminRoomState dumps a room's full GameState. It is encoded on the
+// room's goroutine and written from the handler's.
+func handleAdminRoomState(w http.ResponseWriter, r *http.Request) {
+	room := adminLookup(w, r)
+	if room == nil {
+		return
+	}
+	var data []byte
+	var err error
+	if !room.do(func() { data, err = json.Marshal(&room.GameState) }) {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	if err != nil {
+		room.logger().Error("encoding state", "err", err)
+		writeError(w, http.StatusInternalServerError, "couldn't encode the state")
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(data))
+}
+
+// handleAdminCloseRoom force-closes a room: everyone in it is told why and
+// disconnected, and it is removed from the hub.
+func handleAdminCloseRoom(w http.ResponseWriter, r *http.Request) {
+	room := adminLookup(w, r)
+	if room == nil {
+		return
+	}
+	if !room.do(func() {
+		room.logger().Warn("room closed by admin", "remote", clientIP(r))
+		hub.closeRoom(room, "closed by an administrator")
+	}) {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
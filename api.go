@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+const (
+	defaultRoomListLimit = 50
+	maxRoomListLimit     = 200
+
+	// maxRoomOptionsSize bounds a create-room request, which may carry a
+	// board definition.
+	maxRoomOptionsSize = 64 << 10
+)
+
+type RoomSummary struct {
+	ID          string    `json:"id"`
+	PlayerCount int       `json:"playerCount"`
+	MaxPlayers  int       `json:"maxPlayers"`
+	Status      string    `json:"status"`
+	Started     bool      `json:"started"`
+	Paused      bool      `json:"paused"`
+	PausedBy    string    `json:"pausedBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type CreateRoomResponse struct {
+	Code        string `json:"code"`
+	WSURL       string `json:"wsUrl"`
+	InviteToken string `json:"inviteToken,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("writing response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func handleRooms(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleListRooms(w, r)
+	case http.MethodPost:
+		handleCreateRoom(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	if refuseWhileShuttingDown(w) {
+		return
+	}
+	var opts RoomOptions
+	if r.ContentLength != 0 {
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRoomOptionsSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&opts); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid room options: "+err.Error())
+			return
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		var boardErr *game.BoardError
+		if errors.As(err, &boardErr) {
+			writeJSON(w, http.StatusBadRequest, BoardErrorResponse{Error: err.Error(), Problems: boardErr.Problems})
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	room, err := createCodedRoom(opts)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	room.logger().Info("room created", "remote", clientIP(r))
+	writeJSON(w, http.StatusCreated, CreateRoomResponse{
+		Code:        room.ID,
+		WSURL:       joinURL(r, room.ID, room.inviteToken),
+		InviteToken: room.inviteToken,
+	})
+}
+
+// errRoomsUnavailable is a room code that couldn't be claimed in the
+// cluster.
+var errRoomsUnavailable = errors.New("rooms are unavailable right now")
+
+// createCodedRoom creates a room with opts under a newly generated code.
+// The code is claimed first so no other instance hands it out too.
+func createCodedRoom(opts RoomOptions) (*GameRoom, error) {
+	for {
+		code := generateRoomCode()
+		owner, err := cluster.Claim(code)
+		if err != nil {
+			slog.Error("claiming room", "gameId", code, "err", err)
+			return nil, errRoomsUnavailable
+		}
+		if owner != "" {
+			continue
+		}
+		hub.Mutex.Lock()
+		room, err := hub.CreateRoom(code, opts)
+		hub.Mutex.Unlock()
+		if err != ErrRoomExists {
+			if err != nil {
+				cluster.Release(code)
+			}
+			return room, err
+		}
+	}
+}
+
+// roomListing is what the lobby lists of a room that changes as it is
+// played.
+type roomListing struct {
+	players  int
+	status   string
+	paused   bool
+	pausedBy string
+}
+
+// publishListing publishes the room's listing as it now stands. It must
+// run on the room's goroutine, or before the room's goroutine starts.
+func (room *GameRoom) publishListing() {
+	room.listing.Store(&roomListing{
+		players:  len(room.GameState.Players),
+		status:   room.GameState.Status,
+		paused:   room.GameState.Paused,
+		pausedBy: room.GameState.PausedBy,
+	})
+}
+
+// handleListRooms serves a page of public rooms, oldest first. The hub lock
+// is only held to copy the room list, and each room's state is read from
+// the listing it last published, so polling never waits on a room.
+func handleListRooms(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultRoomListLimit)
+	if err != nil || limit < 1 {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	if limit > maxRoomListLimit {
+		limit = maxRoomListLimit
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+		return
+	}
+
+	type entry struct {
+		room    *GameRoom
+		summary RoomSummary
+	}
+	hub.Mutex.RLock()
+	entries := make([]entry, 0, len(hub.Rooms))
+	for _, room := range hub.Rooms {
+		if room.Options.Private {
+			continue
+		}
+		entries = append(entries, entry{room: room, summary: RoomSummary{
+			ID:         room.ID,
+			MaxPlayers: room.Options.MaxPlayers,
+			CreatedAt:  room.CreatedAt,
+		}})
+	}
+	hub.Mutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].summary.CreatedAt.Equal(entries[j].summary.CreatedAt) {
+			return entries[i].summary.ID < entries[j].summary.ID
+		}
+		return entries[i].summary.CreatedAt.Before(entries[j].summary.CreatedAt)
+	})
+	total := len(entries)
+	if offset > total {
+		offset = total
+	}
+	entries = entries[offset:]
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	rooms := make([]RoomSummary, 0, len(entries))
+	for _, e := range entries {
+		listing := e.room.listing.Load()
+		e.summary.PlayerCount = listing.players
+		e.summary.Status = listing.status
+		e.summary.Paused = listing.paused
+		e.summary.PausedBy = listing.pausedBy
+		e.summary.Started = e.summary.Status != StatusWaiting
+		rooms = append(rooms, e.summary)
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+// handleRoomState serves a room's STATE snapshot, exactly as a websocket
+// client gets it, for tools that only want to look. A private room wants a
+// room credential or the admin token. The ETag is the snapshot's sequence
+// number, so a poller sending it back in If-None-Match, or as ?since=,
+// gets 304 until something changes. The snapshot is taken on the room's
+// goroutine like any other command, and nothing is changed.
+func handleRoomState(w http.ResponseWriter, r *http.Request) {
+	if routeToOwner(w, r, r.PathValue("id")) {
+		return
+	}
+	hub.Mutex.RLock()
+	room, ok := hub.lookup(r.PathValue("id"))
+	hub.Mutex.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	since, err := queryInt(r, "since", -1)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "since must be a sequence number")
+		return
+	}
+	locale := matchLocale(r.URL.Query().Get("locale"))
+	var (
+		message   *OutboundMessage
+		seq       uint64
+		allowed   bool
+		unchanged bool
+	)
+	open := room.do(func() {
+		if allowed = isAdmin(r) || room.admits(r.URL.Query()); !allowed {
+			return
+		}
+		seq = room.seq
+		etag := `"` + strconv.FormatUint(seq, 10) + `"`
+		if unchanged = since >= 0 && uint64(since) == seq || r.Header.Get("If-None-Match") == etag; !unchanged {
+			message = room.snapshot("", locale, "")
+		}
+	})
+	if !open {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	if !allowed {
+		writeError(w, http.StatusForbidden, "this room is private")
+		return
+	}
+	w.Header().Set("ETag", `"`+strconv.FormatUint(seq, 10)+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	if unchanged {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(message.JSON))
+}
+
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+type StatsResponse struct {
+	Rooms       int `json:"rooms"`
+	MaxRooms    int `json:"maxRooms"`
+	Connections int `json:"connections"`
+	Addresses   int `json:"addresses"`
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	hub.Mutex.RLock()
+	stats := StatsResponse{Rooms: len(hub.Rooms), MaxRooms: hub.config.MaxRooms}
+	hub.Mutex.RUnlock()
+	stats.Connections, stats.Addresses = conns.stats()
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// joinURL builds the websocket URL a client should dial to join roomID,
+// relative to the host the request came in on. For a private room the
+// invite token is included, so the URL can be shared as is.
+func joinURL(r *http.Request, roomID string, invite string) string {
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
+	query := url.Values{"gameId": {roomID}}
+	if invite != "" {
+		query.Set("invite", invite)
+	}
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     r.Host,
+		Path:     "/ws",
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
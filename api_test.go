@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestListRoomsDoesntWaitOnRooms lists rooms while one of them is busy,
+// and checks it is listed as it last broadcast without waiting for it.
+func TestListRoomsDoesntWaitOnRooms(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(nil)
+	ts.createRoom(map[string]interface{}{"private": true})
+	ts.join(code, "ann", nil)
+	room := ts.room(code)
+
+	busy, release := make(chan struct{}), make(chan struct{})
+	go room.do(func() {
+		close(busy)
+		<-release
+	})
+	defer close(release)
+	<-busy
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(ts.srv.URL + "/api/rooms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var rooms []RoomSummary
+	if err := json.NewDecoder(resp.Body).Decode(&rooms); err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].ID != code || rooms[0].PlayerCount != 1 || rooms[0].Status != StatusWaiting {
+		t.Errorf("rooms %+v", rooms)
+	}
+}
+
+// BenchmarkBroadcastWhileListing measures a room broadcasting as fast as
+// it can, alone and while the lobby is polled without pause, so that
+// polling can be seen not to slow it down.
+func BenchmarkBroadcastWhileListing(b *testing.B) {
+	for _, polled := range []bool{false, true} {
+		name := "quiet"
+		if polled {
+			name = "polled"
+		}
+		b.Run(name, func(b *testing.B) {
+			ts := newTestServer(b, nil)
+			for i := 0; i < 50; i++ {
+				ts.createRoom(nil)
+			}
+			code := ts.createRoom(nil)
+			ann := ts.join(code, "ann", nil)
+			go func() {
+				for range ann.events {
+				}
+			}()
+			room := ts.room(code)
+
+			var polls atomic.Int64
+			var wg sync.WaitGroup
+			done := make(chan struct{})
+			if polled {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						handleListRooms(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/rooms?limit=200", nil))
+						polls.Add(1)
+					}
+				}()
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				room.do(func() {
+					SendGameEventToAll(room, "CHAT_MESSAGE", room.ID, map[string]string{"from": "ann", "text": "hi"})
+				})
+			}
+			b.StopTimer()
+			close(done)
+			wg.Wait()
+			if polled {
+				b.ReportMetric(float64(polls.Load())/float64(b.N), "polls/op")
+			}
+		})
+	}
+}
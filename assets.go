@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// PLAYER_SUMMARY asks for what a player has, as game.PlayerAssets works it
+// out, and is answered to the sender alone. Everything in it is on the
+// table for anyone to see, so any player or spectator may ask about any
+// player, at any point in the game. GAME_OVER carries everyone's.
+
+// PlayerSummaryRequest names the player a PLAYER_SUMMARY is about.
+type PlayerSummaryRequest struct {
+	Player string `json:"player"`
+}
+
+// HandlePlayerSummaryEvent answers a PLAYER_SUMMARY request.
+func HandlePlayerSummaryEvent(room *GameRoom, event GameEvent, client *Client) {
+	var payload PlayerSummaryRequest
+	if err := decodePayload(event, &payload); err != nil {
+		room.rejectEvent(client, event, "INVALID_PAYLOAD", "PLAYER_SUMMARY needs a player")
+		return
+	}
+	assets, ok := game.PlayerAssets(room.board, &room.GameState, payload.Player)
+	if !ok {
+		room.rejectEvent(client, event, "UNKNOWN_PLAYER", game.ErrUnknownPlayer.Error())
+		return
+	}
+	data, err := json.Marshal(GameEvent{Event: "PLAYER_SUMMARY", GameID: room.ID, Seq: room.seq, RequestID: event.RequestID, Payload: assets})
+	if err != nil {
+		room.logger().Error("encoding player summary", "err", err)
+		return
+	}
+	client.Send(newOutboundMessage(0, data))
+}
+
+// playerAssets sums up every player in the game, forfeited ones included,
+// richest first. It must run on the room's goroutine.
+func (room *GameRoom) playerAssets() []game.Assets {
+	all := make([]game.Assets, 0, len(room.GameState.Players))
+	for name := range room.GameState.Players {
+		assets, _ := game.PlayerAssets(room.board, &room.GameState, name)
+		all = append(all, assets)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].NetWorth != all[j].NetWorth {
+			return all[i].NetWorth > all[j].NetWorth
+		}
+		return all[i].Player < all[j].Player
+	})
+	return all
+}
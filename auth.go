@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With an accounts system in front of it, the server can take who a player
+// is from a signed JWT instead of the name they type. A connection hands
+// its access token over as ?access_token=, as a Bearer Authorization
+// header, or, since browsers can't set headers on a websocket, as a
+// subprotocol "access_token.<jwt>" next to the encoding it wants. The
+// token's sub is the player's stable ID and its name claim (or
+// preferred_username) the name they play under; a connection that has one
+// rejoins its seat by that ID, and its games count towards that ID's
+// stats. Tokens are checked with -jwt-secret (HS256/384/512), a PEM
+// public key in -jwt-public-key, or the keys served at -jwks-url (RSA,
+// ECDSA and Ed25519). Each key is good for one algorithm only: -jwt-alg's,
+// a JWK's alg, or else the usual one for its type. A token whose header
+// names another is refused, so it can't pick how it is checked. Whether
+// connections without a token may play as guests is up to -allow-guests.
+
+const (
+	// accessTokenProtocol prefixes an access token sent as a subprotocol.
+	accessTokenProtocol = "access_token."
+	// jwtLeeway allows for clocks that don't quite agree with the issuer's.
+	jwtLeeway = 30 * time.Second
+	// jwksRefresh is how long fetched keys are used before they are
+	// fetched again, and jwksRetry how soon an unknown kid may trigger a
+	// fetch after the last one.
+	jwksRefresh = time.Hour
+	jwksRetry   = time.Minute
+)
+
+var (
+	errTokenExpired    = errors.New("access token has expired")
+	errTokenRequired   = errors.New("an access token is required")
+	errKeysUnavailable = errors.New("the keys to check access tokens are unavailable")
+)
+
+// Identity is who a verified access token says is on a connection.
+type Identity struct {
+	// ID is the player's stable ID, as kept with their stats.
+	ID   string
+	Name string
+}
+
+// authenticator verifies access tokens. A nil authenticator accepts none
+// and lets everyone in as a guest.
+type authenticator struct {
+	// keys are -jwt-secret and -jwt-public-key's.
+	keys     []jwtKey
+	jwksURL  string
+	issuer   string
+	audience string
+	http     *http.Client
+
+	// mu guards the keys fetched from the JWKS. fetching is closed when a
+	// fetch under way, which doesn't hold mu, is done.
+	mu       sync.Mutex
+	jwks     map[string]jwtKey
+	fetched  time.Time
+	fetching chan struct{}
+}
+
+// jwtKey is a key tokens are checked with, and the one algorithm they must
+// be signed with for it: a []byte for HMAC, or a public key.
+type jwtKey struct {
+	alg string
+	key interface{}
+}
+
+// jwtAlgs are the algorithms access tokens may be signed with.
+var jwtAlgs = map[string]bool{
+	"HS256": true, "HS384": true, "HS512": true,
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+	"EdDSA": true,
+}
+
+// newAuthenticator returns the authenticator cfg calls for, or nil if it
+// sets no way of checking tokens.
+func newAuthenticator(cfg *Config) (*authenticator, error) {
+	if cfg.JWTSecret == "" && cfg.JWTPublicKey == "" && cfg.JWKSURL == "" {
+		return nil, nil
+	}
+	a := &authenticator{
+		jwksURL:  cfg.JWKSURL,
+		issuer:   cfg.JWTIssuer,
+		audience: cfg.JWTAudience,
+		http:     &http.Client{Timeout: 5 * time.Second},
+	}
+	usedAlg := cfg.JWTAlg == ""
+	if cfg.JWTSecret != "" {
+		key := jwtKey{alg: "HS256", key: []byte(cfg.JWTSecret)}
+		if algFits(cfg.JWTAlg, key.key) {
+			key.alg, usedAlg = cfg.JWTAlg, true
+		}
+		a.keys = append(a.keys, key)
+	}
+	if cfg.JWTPublicKey != "" {
+		data, err := os.ReadFile(cfg.JWTPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		public, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.JWTPublicKey, err)
+		}
+		key := jwtKey{alg: defaultAlg(public), key: public}
+		if algFits(cfg.JWTAlg, public) {
+			key.alg, usedAlg = cfg.JWTAlg, true
+		}
+		if key.alg == "" {
+			return nil, fmt.Errorf("%s: key type isn't supported", cfg.JWTPublicKey)
+		}
+		a.keys = append(a.keys, key)
+	}
+	if !usedAlg {
+		return nil, fmt.Errorf("jwt-alg %s doesn't fit jwt-secret or the key in jwt-public-key", cfg.JWTAlg)
+	}
+	return a, nil
+}
+
+// defaultAlg returns the algorithm a public key of key's type is usually
+// used with, or "" for a type that isn't supported.
+func defaultAlg(key crypto.PublicKey) string {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return "RS256"
+	case *ecdsa.PublicKey:
+		return map[int]string{256: "ES256", 384: "ES384", 521: "ES512"}[key.Curve.Params().BitSize]
+	case ed25519.PublicKey:
+		return "EdDSA"
+	}
+	return ""
+}
+
+// algFits reports whether tokens signed with alg can be checked with key.
+func algFits(alg string, key interface{}) bool {
+	switch key := key.(type) {
+	case []byte:
+		return strings.HasPrefix(alg, "HS") && jwtAlgs[alg]
+	case *rsa.PublicKey:
+		return (strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")) && jwtAlgs[alg]
+	case *ecdsa.PublicKey:
+		return strings.HasPrefix(alg, "ES") && alg == defaultAlg(key)
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+// parsePublicKey reads a PEM public key or certificate.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// accessToken returns the access token r carries, if any.
+func accessToken(r *http.Request) string {
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	for _, protocol := range websocketProtocols(r) {
+		if token, ok := strings.CutPrefix(protocol, accessTokenProtocol); ok {
+			return token
+		}
+	}
+	return ""
+}
+
+func websocketProtocols(r *http.Request) []string {
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(header, ",") {
+			protocols = append(protocols, strings.TrimSpace(p))
+		}
+	}
+	return protocols
+}
+
+// identify returns the identity r's access token verifies as, or nil for
+// a guest. It fails with errTokenRequired if guests aren't allowed,
+// errTokenExpired for an expired token and errKeysUnavailable if the keys
+// couldn't be fetched; anything else is an invalid token.
+func (a *authenticator) identify(r *http.Request, allowGuests bool) (*Identity, error) {
+	token := accessToken(r)
+	if a == nil || token == "" {
+		if a != nil && !allowGuests {
+			return nil, errTokenRequired
+		}
+		return nil, nil
+	}
+	return a.verify(token, time.Now())
+}
+
+// jwtHeader is the part of a JWT's header that matters here.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims are the claims an access token is checked against and read
+// for. Times are in seconds since the epoch.
+type jwtClaims struct {
+	Subject           string   `json:"sub"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Issuer            string   `json:"iss"`
+	Audience          audience `json:"aud"`
+	Expires           *float64 `json:"exp"`
+	NotBefore         *float64 `json:"nbf"`
+}
+
+// audience is a JWT aud claim, which may be a string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+func (a audience) contains(want string) bool {
+	for _, aud := range a {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks token's signature and claims at now and returns who it
+// identifies.
+func (a *authenticator) verify(token string, now time.Time) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("access token is not a JWT")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("access token header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("access token signature isn't base64url")
+	}
+	if err := a.checkSignature(header, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("access token claims: %v", err)
+	}
+	switch {
+	case claims.Expires != nil && now.After(unixTime(*claims.Expires).Add(jwtLeeway)):
+		return nil, errTokenExpired
+	case claims.NotBefore != nil && now.Add(jwtLeeway).Before(unixTime(*claims.NotBefore)):
+		return nil, errors.New("access token isn't valid yet")
+	case a.issuer != "" && claims.Issuer != a.issuer:
+		return nil, errors.New("access token is from another issuer")
+	case a.audience != "" && !claims.Audience.contains(a.audience):
+		return nil, errors.New("access token is for another audience")
+	case claims.Subject == "":
+		return nil, errors.New("access token has no sub")
+	}
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+	if name == "" {
+		name = claims.Subject
+	}
+	return &Identity{ID: playerIDFor(claims.Subject), Name: name}, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("not base64url")
+	}
+	return json.Unmarshal(data, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// playerIDFor turns a token's sub into the ID the player's stats are kept
+// under. A sub that would do as a ?playerId= is used as it is; any other
+// is hashed, so it fits wherever player IDs are stored.
+func playerIDFor(subject string) string {
+	if validatePlayerID(subject) == nil {
+		return subject
+	}
+	sum := sha256.Sum256([]byte(subject))
+	return "sub-" + hex.EncodeToString(sum[:24])
+}
+
+// jwtHashes are the hashes named by the digits at the end of an alg.
+var jwtHashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+// checkSignature checks sig over signed with the key header calls for,
+// which must be for the alg header names.
+func (a *authenticator) checkSignature(header jwtHeader, signed []byte, sig []byte) error {
+	key, err := a.keyFor(header)
+	if err != nil {
+		return err
+	}
+	if header.Alg != key.alg {
+		return fmt.Errorf("access token alg %q isn't the %s its key is for", header.Alg, key.alg)
+	}
+	if !key.verify(signed, sig) {
+		return errors.New("access token signature doesn't match")
+	}
+	return nil
+}
+
+// keyFor returns the key to check a token with header with: the JWKS's
+// for its kid, or else the configured key for its alg.
+func (a *authenticator) keyFor(header jwtHeader) (jwtKey, error) {
+	if header.Kid == "" || a.jwksURL == "" {
+		for _, key := range a.keys {
+			if key.alg == header.Alg {
+				return key, nil
+			}
+		}
+	}
+	if a.jwksURL != "" {
+		return a.jwksKey(header.Kid)
+	}
+	if len(a.keys) == 1 {
+		return a.keys[0], nil
+	}
+	return jwtKey{}, fmt.Errorf("access token alg %q isn't one this server checks", header.Alg)
+}
+
+// verify reports whether sig is key's signature over signed.
+func (key jwtKey) verify(signed []byte, sig []byte) bool {
+	if key.alg == "EdDSA" {
+		public, ok := key.key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(public, signed, sig)
+	}
+	family, hash := key.alg[:2], jwtHashes[key.alg[2:]]
+	if family == "HS" {
+		secret, ok := key.key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch public := key.key.(type) {
+	case *rsa.PublicKey:
+		switch family {
+		case "RS":
+			return rsa.VerifyPKCS1v15(public, hash, digest, sig) == nil
+		case "PS":
+			return rsa.VerifyPSS(public, hash, digest, sig, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		if family == "ES" && len(sig) == 2*size {
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			return ecdsa.Verify(public, digest, r, s)
+		}
+	}
+	return false
+}
+
+// jwksKey returns the JWKS's key for kid, fetching the JWKS again if it is
+// due or kid isn't known. The fetch is made without holding a.mu: while it
+// is under way, tokens with known kids are checked with the keys from
+// before, and those with unknown ones wait for it.
+func (a *authenticator) jwksKey(kid string) (jwtKey, error) {
+	a.mu.Lock()
+	since := time.Since(a.fetched)
+	_, known := a.lookupJWKS(kid)
+	if a.jwks == nil || since > jwksRefresh || !known && since > jwksRetry {
+		if a.fetching == nil {
+			done := make(chan struct{})
+			a.fetching = done
+			a.mu.Unlock()
+			keys, err := a.fetchJWKS()
+			a.mu.Lock()
+			if err != nil {
+				slog.Warn("fetching JWKS", "url", a.jwksURL, "err", err)
+			} else {
+				a.jwks = keys
+			}
+			a.fetched, a.fetching = time.Now(), nil
+			close(done)
+		} else if !known {
+			wait := a.fetching
+			a.mu.Unlock()
+			<-wait
+			a.mu.Lock()
+		}
+	}
+	defer a.mu.Unlock()
+	if a.jwks == nil {
+		return jwtKey{}, errKeysUnavailable
+	}
+	if key, ok := a.lookupJWKS(kid); ok {
+		return key, nil
+	}
+	return jwtKey{}, fmt.Errorf("access token kid %q isn't a known key", kid)
+}
+
+// lookupJWKS returns the fetched key for kid. A token without a kid is
+// checked with the only key, if there is just one. It must be called with
+// a.mu held.
+func (a *authenticator) lookupJWKS(kid string) (jwtKey, bool) {
+	if key, ok := a.jwks[kid]; ok || kid != "" || len(a.jwks) != 1 {
+		return key, ok
+	}
+	for _, key := range a.jwks {
+		return key, true
+	}
+	return jwtKey{}, false
+}
+
+// jwk is a key in a JWKS.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchJWKS fetches the signing keys at -jwks-url, by kid, each for its
+// alg or else the usual one for its type. Keys of a kind it doesn't know,
+// or whose alg doesn't fit them, are left out.
+func (a *authenticator) fetchJWKS() (map[string]jwtKey, error) {
+	resp, err := a.http.Get(a.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]jwtKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		public, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		alg := k.Alg
+		if alg == "" {
+			alg = defaultAlg(public)
+		}
+		if algFits(alg, public) {
+			keys[k.Kid] = jwtKey{alg: alg, key: public}
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	field := func(s string) []byte {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return b
+	}
+	switch k.Kty {
+	case "RSA":
+		n, e := field(k.N), field(k.E)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("bad RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("curve %q isn't supported", k.Crv)
+		}
+		x, y := new(big.Int).SetBytes(field(k.X)), new(big.Int).SetBytes(field(k.Y))
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("bad EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x := field(k.X)
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad OKP key")
+		}
+		return ed25519.PublicKey(bytes.Clone(x)), nil
+	}
+	return nil, fmt.Errorf("key type %q isn't supported", k.Kty)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testKey is a key tokens are signed with in tests, and the alg they are
+// signed with: a []byte for HMAC, or a private key.
+type testKey struct {
+	alg string
+	key interface{}
+}
+
+// testKeys returns a key for every supported alg. RS and PS share an RSA
+// key.
+func testKeys(t *testing.T) []testKey {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []testKey{
+		{"HS256", []byte("a secret of thirty-two bytes....")},
+		{"HS384", []byte("a secret of forty-eight bytes...................")},
+		{"HS512", []byte("a secret of sixty-four bytes....................................")},
+	}
+	for _, alg := range []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"} {
+		keys = append(keys, testKey{alg, rsaKey})
+	}
+	for alg, curve := range map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, testKey{alg, key})
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(keys, testKey{"EdDSA", edKey})
+}
+
+// public returns the key k's tokens are checked with.
+func (k testKey) public() interface{} {
+	if signer, ok := k.key.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return k.key
+}
+
+// signJWT returns a token with claims, signed with key but saying in its
+// header it is signed with alg.
+func signJWT(t *testing.T, key testKey, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	var sig []byte
+	var err error
+	switch priv := key.key.(type) {
+	case []byte:
+		mac := hmac.New(jwtHashes[key.alg[2:]].New, priv)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(priv, []byte(signed))
+	default:
+		hash := jwtHashes[key.alg[2:]]
+		h := hash.New()
+		h.Write([]byte(signed))
+		digest := h.Sum(nil)
+		switch priv := priv.(type) {
+		case *rsa.PrivateKey:
+			if strings.HasPrefix(key.alg, "PS") {
+				sig, err = rsa.SignPSS(rand.Reader, priv, hash, digest, nil)
+			} else {
+				sig, err = rsa.SignPKCS1v15(rand.Reader, priv, hash, digest)
+			}
+		case *ecdsa.PrivateKey:
+			var r, s *big.Int
+			r, s, err = ecdsa.Sign(rand.Reader, priv, digest)
+			size := (priv.Curve.Params().BitSize + 7) / 8
+			sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// testClaims are the claims of a token for ann that is good at now.
+func testClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{"sub": "ann", "exp": now.Add(time.Hour).Unix()}
+}
+
+// writePublicKey writes key to a PEM file and returns its name.
+func writePublicKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// jwkFor returns key as a JWK with kid, for alg.
+func jwkFor(kid, alg string, key interface{}) jwk {
+	enc := base64.RawURLEncoding.EncodeToString
+	k := jwk{Kid: kid, Alg: alg, Use: "sig"}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		k.Kty, k.N, k.E = "RSA", enc(key.N.Bytes()), enc([]byte{1, 0, 1})
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		k.Kty, k.Crv = "EC", key.Curve.Params().Name
+		k.X, k.Y = enc(key.X.FillBytes(make([]byte, size))), enc(key.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		k.Kty, k.Crv, k.X = "OKP", "Ed25519", enc(key)
+	}
+	return k
+}
+
+// jwksServer serves keys as a JWKS, counting the fetches. While block is
+// set, each fetch waits until it is closed.
+type jwksServer struct {
+	*httptest.Server
+	keys    atomic.Pointer[[]jwk]
+	fetches atomic.Int64
+	block   atomic.Pointer[chan struct{}]
+}
+
+func newJWKSServer(t *testing.T, keys ...jwk) *jwksServer {
+	s := &jwksServer{}
+	s.keys.Store(&keys)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		if block := s.block.Load(); block != nil {
+			<-*block
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": *s.keys.Load()})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// TestJWTAlgorithms checks a token signed with each supported alg is taken
+// with the key configured for that alg, whether given in a flag or served
+// in a JWKS, and refused with a key configured for another.
+func TestJWTAlgorithms(t *testing.T) {
+	now := time.Now()
+	keys := testKeys(t)
+	var jwks []jwk
+	for _, key := range keys {
+		if _, ok := key.key.([]byte); !ok {
+			jwks = append(jwks, jwkFor(key.alg, key.alg, key.public()))
+		}
+	}
+	server := newJWKSServer(t, jwks...)
+	fromJWKS, err := newAuthenticator(&Config{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range keys {
+		t.Run(key.alg, func(t *testing.T) {
+			cfg := &Config{JWTAlg: key.alg}
+			if secret, ok := key.key.([]byte); ok {
+				cfg.JWTSecret = string(secret)
+			} else {
+				cfg.JWTPublicKey = writePublicKey(t, key.public())
+			}
+			a, err := newAuthenticator(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id, err := a.verify(signJWT(t, key, key.alg, "", testClaims(now)), now); err != nil || id.Name != "ann" {
+				t.Errorf("configured key: %v, %v", id, err)
+			}
+			if cfg.JWTSecret == "" {
+				if _, err := fromJWKS.verify(signJWT(t, key, key.alg, key.alg, testClaims(now)), now); err != nil {
+					t.Errorf("JWKS key: %v", err)
+				}
+			}
+
+			// The same key, configured for another alg of its kind.
+			other := map[string]string{"HS": "HS", "RS": "PS", "PS": "RS", "ES": "ES", "Ed": "Ed"}[key.alg[:2]]
+			for _, alg := range []string{other + "256", other + "384", other + "512"} {
+				if alg == key.alg || !algFits(alg, key.public()) {
+					continue
+				}
+				cfg.JWTAlg = alg
+				a, err := newAuthenticator(cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := a.verify(signJWT(t, key, key.alg, "", testClaims(now)), now); err == nil {
+					t.Errorf("%s token taken with a key for %s", key.alg, alg)
+				}
+			}
+		})
+	}
+}
+
+// TestJWTAlgConfusion checks a token can't have an RSA public key used as
+// an HMAC secret by saying it is signed with HS256.
+func TestJWTAlgConfusion(t *testing.T) {
+	now := time.Now()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemFile := writePublicKey(t, &rsaKey.PublicKey)
+	pemData, err := os.ReadFile(pemFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	server := newJWKSServer(t, jwkFor("rsa", "", &rsaKey.PublicKey))
+
+	for name, cfg := range map[string]*Config{
+		"public key": {JWTPublicKey: pemFile},
+		"JWKS":       {JWKSURL: server.URL},
+		"both":       {JWTSecret: "a secret of thirty-two bytes....", JWTPublicKey: pemFile},
+	} {
+		a, err := newAuthenticator(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range [][]byte{pemData, der} {
+			token := signJWT(t, testKey{"HS256", secret}, "HS256", "rsa", testClaims(now))
+			if _, err := a.verify(token, now); err == nil {
+				t.Errorf("%s: HS256 token signed with the RSA public key taken", name)
+			}
+		}
+		if _, err := a.verify(signJWT(t, testKey{"RS256", rsaKey}, "RS256", "rsa", testClaims(now)), now); err != nil {
+			t.Errorf("%s: RS256 token: %v", name, err)
+		}
+	}
+
+	if _, err := newAuthenticator(&Config{JWTPublicKey: pemFile, JWTAlg: "HS256"}); err == nil {
+		t.Error("jwt-alg HS256 taken for an RSA key")
+	}
+}
+
+// TestJWTTimes checks exp and nbf are kept to, give or take jwtLeeway.
+func TestJWTTimes(t *testing.T) {
+	key := testKey{"HS256", []byte("a secret of thirty-two bytes....")}
+	a, err := newAuthenticator(&Config{JWTSecret: string(key.key.([]byte))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	for _, tc := range []struct {
+		name   string
+		claims map[string]interface{}
+		err    error
+	}{
+		{"no exp", map[string]interface{}{"sub": "ann"}, nil},
+		{"exp to come", map[string]interface{}{"sub": "ann", "exp": now.Add(time.Minute).Unix()}, nil},
+		{"exp within leeway", map[string]interface{}{"sub": "ann", "exp": now.Add(-10 * time.Second).Unix()}, nil},
+		{"exp past leeway", map[string]interface{}{"sub": "ann", "exp": now.Add(-time.Minute).Unix()}, errTokenExpired},
+		{"nbf within leeway", map[string]interface{}{"sub": "ann", "nbf": now.Add(10 * time.Second).Unix()}, nil},
+		{"nbf past leeway", map[string]interface{}{"sub": "ann", "nbf": now.Add(time.Minute).Unix()}, errors.New("access token isn't valid yet")},
+	} {
+		_, err := a.verify(signJWT(t, key, "HS256", "", tc.claims), now)
+		if (err == nil) != (tc.err == nil) || err != nil && err.Error() != tc.err.Error() {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+// TestJWKSUnknownKid checks a token with a kid the JWKS doesn't have is
+// refused, fetching the JWKS again no more than every jwksRetry, and is
+// taken once the JWKS has its key.
+func TestJWKSUnknownKid(t *testing.T) {
+	now := time.Now()
+	old, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newJWKSServer(t, jwkFor("old", "", &old.PublicKey))
+	a, err := newAuthenticator(&Config{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signJWT(t, testKey{"ES256", rotated}, "ES256", "new", testClaims(now))
+
+	for i := 0; i < 3; i++ {
+		if _, err := a.verify(token, now); err == nil || !strings.Contains(err.Error(), "kid") {
+			t.Errorf("unknown kid: got %v", err)
+		}
+	}
+	if n := server.fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times for an unknown kid within jwksRetry", n)
+	}
+
+	server.keys.Store(&[]jwk{jwkFor("old", "", &old.PublicKey), jwkFor("new", "", &rotated.PublicKey)})
+	a.mu.Lock()
+	a.fetched = a.fetched.Add(-jwksRetry)
+	a.mu.Unlock()
+	if _, err := a.verify(token, now); err != nil {
+		t.Errorf("rotated key: %v", err)
+	}
+	if n := server.fetches.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", n)
+	}
+}
+
+// TestJWKSFetchDoesntBlock checks tokens with a known kid are taken while
+// the JWKS is being fetched again.
+func TestJWKSFetchDoesntBlock(t *testing.T) {
+	now := time.Now()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := testKey{"EdDSA", edKey}
+	server := newJWKSServer(t, jwkFor("ed", "EdDSA", key.public()))
+	a, err := newAuthenticator(&Config{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signJWT(t, key, "EdDSA", "ed", testClaims(now))
+	if _, err := a.verify(token, now); err != nil {
+		t.Fatal(err)
+	}
+
+	block := make(chan struct{})
+	server.block.Store(&block)
+	a.mu.Lock()
+	a.fetched = a.fetched.Add(-jwksRefresh - time.Second)
+	a.mu.Unlock()
+	refetched := make(chan error)
+	go func() {
+		_, err := a.verify(token, now)
+		refetched <- err
+	}()
+	for server.fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	checked := make(chan error, 1)
+	go func() {
+		_, err := a.verify(token, now)
+		checked <- err
+	}()
+	select {
+	case err := <-checked:
+		if err != nil {
+			t.Errorf("during the fetch: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("token with a known kid waited on the JWKS fetch")
+	}
+	close(block)
+	if err := <-refetched; err != nil {
+		t.Errorf("refetching token: %v", err)
+	}
+}
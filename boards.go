@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// boardIDPattern is what a boardId may look like, which also keeps it
+// from naming anything outside -boards-dir.
+var boardIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// BoardDataPayload is the board a room's game is played on. BoardID is
+// empty for the standard board and for one given with the room. Squares
+// keep their names, which identify them in events; Labels gives names in
+// the connection's locale where they differ. Card text is translated in
+// place.
+type BoardDataPayload struct {
+	BoardID string `json:"boardId,omitempty"`
+	*game.Board
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// BoardErrorResponse answers a room created with a board that isn't fit to
+// play on, listing what is wrong with it line by line.
+type BoardErrorResponse struct {
+	Error    string              `json:"error"`
+	Problems []game.BoardProblem `json:"problems"`
+}
+
+// readBoard reads the definition of the board called id from -boards-dir.
+func readBoard(id string) ([]byte, error) {
+	if hub.config.BoardsDir == "" {
+		return nil, errors.New("this server has no boards to pick from")
+	}
+	if !boardIDPattern.MatchString(id) {
+		return nil, errors.New("boardId may only contain letters, digits, - and _")
+	}
+	data, err := os.ReadFile(filepath.Join(hub.config.BoardsDir, id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no board %q", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading board %q: %v", id, err)
+	}
+	return data, nil
+}
+
+// boardData encodes the room's board in locale as a BOARD_DATA message,
+// echoing requestID if it answers a request. It must run on the room's
+// goroutine.
+func (room *GameRoom) boardData(requestID string, locale string) *OutboundMessage {
+	payload := BoardDataPayload{BoardID: room.Options.BoardID, Board: room.board}
+	if locale != defaultLocale {
+		board := *room.board
+		payload.Board = &board
+		for _, sq := range board.Squares {
+			if label := room.translateText(locale, "square.", sq.Name); label != sq.Name {
+				if payload.Labels == nil {
+					payload.Labels = make(map[string]string)
+				}
+				payload.Labels[sq.Name] = label
+			}
+		}
+		board.Decks = make(map[string][]game.Card, len(room.board.Decks))
+		for deck, cards := range room.board.Decks {
+			translated := make([]game.Card, len(cards))
+			for i, card := range cards {
+				translated[i] = game.Card{Text: room.translateText(locale, "card.", card.Text)}
+			}
+			board.Decks[deck] = translated
+		}
+	}
+	data, err := json.Marshal(GameEvent{Event: "BOARD_DATA", GameID: room.ID, RequestID: requestID, Payload: payload})
+	if err != nil {
+		room.logger().Error("encoding board", "err", err)
+	}
+	return newOutboundMessage(0, data)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// BotView is what a Strategy gets to look at. It is a copy, so a strategy
+// can take its time without holding up the room.
+type BotView struct {
+	Player Player
+	Square game.Square
+	Owner  string
+}
+
+// Strategy makes a bot's choices. The room handles everything a bot has
+// no choice about: rolling, moving and ending its turn. There are no
+// trades in the game, so there is nothing to accept or decline.
+type Strategy interface {
+	// BuyProperty reports whether to buy the unowned square the bot has
+	// landed on.
+	BuyProperty(view BotView) bool
+	// PayBail reports whether to pay game.BailCost to leave jail before
+	// rolling.
+	PayBail(view BotView) bool
+}
+
+// strategies are the strategies ADD_BOT can ask for by name.
+var strategies = map[string]func() Strategy{
+	"basic": func() Strategy { return basicStrategy{cashFloor: 200} },
+}
+
+const defaultStrategy = "basic"
+
+// basicStrategy buys whatever it can afford without dropping below
+// cashFloor, and pays bail once it has sat out a turn in jail.
+type basicStrategy struct {
+	cashFloor int
+}
+
+func (s basicStrategy) BuyProperty(view BotView) bool {
+	return view.Player.Balance-view.Square.Price >= s.cashFloor
+}
+
+func (s basicStrategy) PayBail(view BotView) bool {
+	return view.Player.JailTurns >= 1 && view.Player.Balance >= game.BailCost
+}
+
+// decide runs a strategy decision, giving up after botDecisionTimeout. A
+// strategy that times out or panics declines.
+func decide(choice func() bool) bool {
+	result := make(chan bool, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				slog.Error("bot strategy panicked", "panic", r)
+				result <- false
+			}
+		}()
+		result <- choice()
+	}()
+	select {
+	case ok := <-result:
+		return ok
+	case <-time.After(hub.config.BotDecisionTimeout):
+		slog.Warn("bot strategy timed out")
+		return false
+	}
+}
+
+// HandleAddBotEvent seats a bot, playing the strategy named in the payload
+// or the default one. Bots are always ready; the host removes them with
+// KICK_PLAYER like anyone else.
+func HandleAddBotEvent(room *GameRoom, event GameEvent, client *Client) {
+	payload, _ := event.Payload.(map[string]interface{})
+	kind, _ := payload["strategy"].(string)
+	if kind == "" {
+		kind = defaultStrategy
+	}
+	if room.tournament != nil {
+		room.rejectEvent(client, event, "TOURNAMENT_TABLE", "bots can't take a seat at a tournament table")
+		return
+	}
+	newStrategy, ok := strategies[kind]
+	if !ok {
+		room.rejectEvent(client, event, "UNKNOWN_STRATEGY", "no bot strategy called "+kind)
+		return
+	}
+	if room.isFull() {
+		room.rejectEvent(client, event, "ROOM_FULL", "the room has no free seats")
+		return
+	}
+
+	name := ""
+	for i := 1; name == ""; i++ {
+		if _, taken := room.GameState.Players["Bot "+strconv.Itoa(i)]; !taken {
+			name = "Bot " + strconv.Itoa(i)
+		}
+	}
+	room.GameState.Players[name] = &Player{
+		Name:    name,
+		Balance: room.Options.HouseRules.StartingBalance,
+		Ready:   true,
+		Bot:     true,
+	}
+	room.GameState.TurnOrder = append(room.GameState.TurnOrder, name)
+	room.bots[name] = newStrategy()
+	room.logger().Info("bot added", "player", name)
+	SendGameEventToAll(room, "PLAYER_JOINED", room.ID, PlayerJoinedPayload{
+		RosterPayload: room.rosterPayload(name),
+		Seat:          room.seatOf(name),
+	})
+}
+
+// scheduleBotTurn plays the current turn for a bot on its own goroutine.
+// It must run on the room's goroutine.
+func (room *GameRoom) scheduleBotTurn() {
+	go room.playBotTurn(room.GameState.Turn, hub.config.BotTurnDelay)
+}
+
+// playBotTurn plays name's turn one move at a time through the same
+// handlers human events go through: bail, roll, buy, end turn. Each move
+// is a command on the room's goroutine, made around delay after the last,
+// and the bot stops as soon as the turn is no longer its to play or the
+// room closes.
+func (room *GameRoom) playBotTurn(name string, delay time.Duration) {
+	steps := []func(player *Player, strategy Strategy){
+		func(player *Player, strategy Strategy) {
+			if player.JailTurns == 0 || !decide(func() bool { return strategy.PayBail(room.botView(player)) }) {
+				return
+			}
+			room.act(game.PayBail{Player: name})
+		},
+		func(player *Player, strategy Strategy) {
+			room.act(game.RollDice{Player: name})
+		},
+		func(player *Player, strategy Strategy) {
+			if room.GameState.Offer == "" {
+				return
+			}
+			view := room.botView(player)
+			if !decide(func() bool { return strategy.BuyProperty(view) }) {
+				room.act(game.DeclinePurchase{Player: name})
+				return
+			}
+			room.act(game.BuyProperty{Player: name})
+		},
+		func(player *Player, strategy Strategy) {
+			room.act(game.EndTurn{Player: name})
+		},
+	}
+	for _, step := range steps {
+		select {
+		case <-time.After(botDelay(delay)):
+		case <-room.done:
+			return
+		}
+		if !room.botMove(name, step) {
+			return
+		}
+	}
+}
+
+// botMove makes one move for name if it is still a bot whose turn it is.
+func (room *GameRoom) botMove(name string, move func(player *Player, strategy Strategy)) bool {
+	moved := false
+	room.do(func() {
+		player, ok := room.GameState.Players[name]
+		strategy := room.bots[name]
+		if room.GameState.Status != StatusInProgress || room.GameState.Paused || room.GameState.Turn != name || !ok || !player.Bot || strategy == nil {
+			return
+		}
+		room.touch()
+		room.actor = name
+		defer func() { room.actor = "" }()
+		move(player, strategy)
+		moved = true
+	})
+	return moved
+}
+
+// botView copies what a strategy needs to know. It must run on the
+// room's goroutine.
+func (room *GameRoom) botView(player *Player) BotView {
+	square := room.board.SquareAt(player.Position)
+	return BotView{Player: *player, Square: square, Owner: room.GameState.PropertyOwner(square.Name)}
+}
+
+// botDelay is a pause of around d, varied so bots don't move like
+// clockwork.
+func botDelay(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
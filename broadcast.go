@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// historySize is how many recent broadcasts a room keeps so that
+// subscribers can resume from a sequence number after a short drop.
+const historySize = 256
+
+// Subscriber receives every broadcast of a room. Send must not block: a
+// subscriber that can't keep up returns false and is dropped from the room.
+type Subscriber interface {
+	Send(message *OutboundMessage) bool
+}
+
+// Subscribe registers sub for broadcasts and returns the sequence number of
+// the last broadcast it will not receive. It must run on the room's
+// goroutine, which also keeps the sequence in step with the GameState.
+func (room *GameRoom) Subscribe(sub Subscriber) uint64 {
+	room.subscribers[sub] = struct{}{}
+	return room.seq
+}
+
+func (room *GameRoom) Unsubscribe(sub Subscriber) {
+	delete(room.subscribers, sub)
+}
+
+// messagesSince returns the retained broadcasts after seq. ok is false if
+// some of them have already been evicted from the history. It must run on
+// the room's goroutine.
+func (room *GameRoom) messagesSince(seq uint64) (msgs []*OutboundMessage, ok bool) {
+	if seq > room.seq {
+		return nil, false
+	}
+	if room.seq-seq > uint64(len(room.history)) {
+		return nil, false
+	}
+	start := len(room.history) - int(room.seq-seq)
+	return append([]*OutboundMessage(nil), room.history[start:]...), true
+}
+
+// StatePayload is the game state as a STATE event carries it, along with
+// the room's house rules, the recent action log for the client's feed and
+// the latency buckets of connected players. Preferences are the
+// receiving player's own.
+type StatePayload struct {
+	*GameState
+	HouseRules  HouseRules       `json:"houseRules"`
+	ActionLog   []ActionLogEntry `json:"actionLog"`
+	Emotes      []EmotePayload   `json:"emotes,omitempty"`
+	Latency     map[string]int   `json:"latency,omitempty"`
+	Preferences *Preferences     `json:"preferences,omitempty"`
+}
+
+// snapshot encodes the full game state as a STATE event tagged with the
+// current sequence number, echoing requestID if it answers a request. The
+// action log is written in locale, and viewer's preferences are included
+// if they are a player with some. It must run on the room's goroutine.
+func (room *GameRoom) snapshot(requestID string, locale string, viewer string) *OutboundMessage {
+	seq := room.seq
+	payload := StatePayload{
+		GameState:  &room.GameState,
+		HouseRules: room.Options.HouseRules,
+		ActionLog:  make([]ActionLogEntry, len(room.actionLog)),
+		Emotes:     room.emotes,
+		Latency:    room.latencies(),
+	}
+	if prefs, ok := room.preferences[viewer]; ok {
+		payload.Preferences = &prefs
+	}
+	for i, entry := range room.actionLog {
+		if locale != defaultLocale {
+			entry = entry.localize(room, locale).(ActionLogEntry)
+		}
+		payload.ActionLog[i] = entry
+	}
+	message, err := json.Marshal(GameEvent{Event: "STATE", GameID: room.ID, Seq: seq, RequestID: requestID, Payload: payload})
+	if err != nil {
+		room.logger().Error("encoding state", "err", err)
+	}
+	return newOutboundMessage(seq, message)
+}
+
+// SendGameEventToAll broadcasts an event to every subscriber of the room
+// and records it in the room's event log. It must run on the room's
+// goroutine, which numbers the broadcasts in the order it makes them.
+// Clients the message can't be queued for are removed from the room as
+// though they had disconnected. Broadcasts made while handling a client
+// request carry that request's ID as actorRequestId so the sender can
+// recognise the outcome of its action. While an action is being applied,
+// its broadcasts are held for its resolution instead.
+func SendGameEventToAll(room *GameRoom, eventType string, gameID string, payload interface{}) {
+	if room.resolution != nil {
+		room.resolution.steps = append(room.resolution.steps, ResolutionStep{Event: eventType, Payload: payload})
+		return
+	}
+	start := time.Now()
+	event := GameEvent{Event: eventType, GameID: gameID, Seq: room.seq + 1, ActorRequestID: room.actorRequestID, Payload: payload}
+	data, err := json.Marshal(event)
+	if err != nil {
+		// Nothing is sent, so the number is left for the next broadcast.
+		room.logger().Error("encoding broadcast", "event", eventType, "seq", event.Seq, "err", err)
+		return
+	}
+	room.seq++
+	message := newOutboundMessage(room.seq, data)
+	for locale, data := range localized(event, room) {
+		if message.localized == nil {
+			message.localized = make(map[string]*OutboundMessage)
+		}
+		message.localized[locale] = newOutboundMessage(room.seq, data)
+	}
+	room.history = append(room.history, message)
+	if len(room.history) > historySize {
+		room.history = room.history[len(room.history)-historySize:]
+	}
+	var dead []*Client
+	for sub := range room.subscribers {
+		if !sub.Send(message) {
+			delete(room.subscribers, sub)
+			if client, ok := sub.(*Client); ok {
+				dead = append(dead, client)
+			}
+		}
+	}
+	metrics.Observe(metricBroadcastSeconds, since(start))
+	room.logBroadcast(eventType, payload)
+	room.publishListing()
+	webhooks.notify(room, eventType, data)
+	// A client refuses a message only once it is closed or being closed.
+	// Its read loop will notice eventually, but take it out of the room
+	// now; this comes last so that what it announces is numbered after
+	// this broadcast.
+	for _, client := range dead {
+		room.removeClient(client)
+	}
+}
+
+// SendTransientToAll sends an event to every subscriber of the room
+// without numbering it or keeping it for resuming, for events a client
+// that misses them can do without. It must run on the room's goroutine.
+func SendTransientToAll(room *GameRoom, eventType string, payload interface{}) {
+	data, err := json.Marshal(GameEvent{Event: eventType, GameID: room.ID, ActorRequestID: room.actorRequestID, Payload: payload})
+	if err != nil {
+		room.logger().Error("encoding broadcast", "event", eventType, "err", err)
+		return
+	}
+	message := newOutboundMessage(0, data)
+	var dead []*Client
+	for sub := range room.subscribers {
+		if !sub.Send(message) {
+			delete(room.subscribers, sub)
+			if client, ok := sub.(*Client); ok {
+				dead = append(dead, client)
+			}
+		}
+	}
+	for _, client := range dead {
+		room.removeClient(client)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// fakeClient returns a client with no connection behind it. What the room
+// sends it stays queued for the test to read with replies.
+func fakeClient() *Client {
+	return &Client{
+		send:    make(chan *OutboundMessage, sendBufferSize),
+		closing: make(chan []byte, 1),
+		done:    make(chan struct{}),
+		log:     slog.Default(),
+	}
+}
+
+// reply is a message queued for a fakeClient.
+type reply struct {
+	Event     string          `json:"event"`
+	RequestID string          `json:"requestId"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// replies takes the messages queued for c so far.
+func replies(t *testing.T, c *Client) []reply {
+	t.Helper()
+	var got []reply
+	for {
+		select {
+		case message := <-c.send:
+			var r reply
+			if err := json.Unmarshal(message.Encode(EncodingJSON), &r); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, r)
+		default:
+			return got
+		}
+	}
+}
+
+// seat joins client to room's game as name, ready to play. It must run on
+// the room's goroutine.
+func seat(room *GameRoom, client *Client, name string) {
+	room.Players[client] = name
+	room.GameState.Players[name] = &Player{Name: name, Balance: room.Options.HouseRules.StartingBalance, Connected: true, Ready: true}
+	room.GameState.TurnOrder = append(room.GameState.TurnOrder, name)
+	if room.GameState.Host == "" {
+		room.GameState.Host = name
+	}
+	room.issueSession(name)
+}
+
+// TestConcurrentEvents sends events from two players at once, ann's
+// broadcast to the room and bob's answered to bob alone, and checks they
+// all get through without the room locking up.
+func TestConcurrentEvents(t *testing.T) {
+	room := newGameRoom("LOCKUP", defaultRoomOptions())
+	ann, bob := fakeClient(), fakeClient()
+	t.Cleanup(room.abandon)
+	var seq uint64
+	room.do(func() {
+		seat(room, ann, "ann")
+		seat(room, bob, "bob")
+		seq = room.Subscribe(ann)
+	})
+
+	const n = 50
+	var wg sync.WaitGroup
+	for client, event := range map[*Client]GameEvent{
+		ann: {Event: "READY", Payload: map[string]interface{}{"ready": true}},
+		bob: {Event: "STATE_SYNC"},
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				handleGameEvent(room, event, client)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("concurrent events locked up the room")
+	}
+
+	readies, states := 0, 0
+	for _, r := range replies(t, ann) {
+		if r.Event == "READY" {
+			readies++
+		}
+	}
+	for _, r := range replies(t, bob) {
+		if r.Event == "STATE" {
+			states++
+		}
+	}
+	var broadcasts uint64
+	room.do(func() { broadcasts = room.seq - seq })
+	if broadcasts != n || readies != n || states != n {
+		t.Errorf("%d broadcasts and %d reached ann, %d snapshots for bob; want %d of each", broadcasts, readies, states, n)
+	}
+}
+
+// recorder is a Subscriber that keeps what it is sent.
+type recorder struct {
+	messages []*OutboundMessage
+}
+
+func (r *recorder) Send(message *OutboundMessage) bool {
+	r.messages = append(r.messages, message)
+	return true
+}
+
+func TestBroadcastUnencodable(t *testing.T) {
+	room := newGameRoom("TEST", defaultRoomOptions())
+	defer room.abandon()
+	sub := &recorder{}
+	room.do(func() {
+		room.Subscribe(sub)
+		SendGameEventToAll(room, "BROKEN", room.ID, map[string]interface{}{"c": make(chan int)})
+		if len(sub.messages) != 0 || room.seq != 0 || len(room.history) != 0 {
+			t.Errorf("unencodable broadcast went out: %d messages, seq %d", len(sub.messages), room.seq)
+		}
+		SendGameEventToAll(room, "READY", room.ID, PlayerPayload{Player: "ann"})
+		if len(sub.messages) != 1 || sub.messages[0].Seq != 1 {
+			t.Errorf("next broadcast should be seq 1, got %d messages", len(sub.messages))
+		}
+	})
+}
+
+// BenchmarkBroadcastSnapshot broadcasts the full state of a game between
+// six players, each of whom owns a share of the board, and waits for all
+// of them to receive it, with and without permessage-deflate. Besides the
+// time, it reports the bytes each broadcast takes on the wire to all six.
+func BenchmarkBroadcastSnapshot(b *testing.B) {
+	for _, compressed := range []bool{false, true} {
+		name := "plain"
+		if compressed {
+			name = "deflate"
+		}
+		b.Run(name, func(b *testing.B) {
+			ts := newTestServer(b, func(cfg *Config) { cfg.Compression = compressed })
+			code := ts.createRoom(map[string]interface{}{"maxPlayers": 6})
+			clients := ts.startGame(code, "ann", "bob", "cat", "dan", "eve", "fay")
+			room := ts.room(code)
+			var payload StatePayload
+			room.do(func() {
+				names := room.GameState.TurnOrder
+				for i, square := range game.Standard.Squares {
+					if square.Price == 0 {
+						continue
+					}
+					p := room.GameState.Players[names[i%len(names)]]
+					p.Properties = append(p.Properties, square.Name)
+					if i%5 == 0 {
+						p.Mortgaged = append(p.Mortgaged, square.Name)
+					}
+					p.Position = i
+				}
+				payload = StatePayload{GameState: &room.GameState, HouseRules: room.Options.HouseRules, ActionLog: room.actionLog}
+			})
+
+			var received sync.WaitGroup
+			for _, c := range clients {
+				go func() {
+					for e := range c.events {
+						if e.Event == "STATE" {
+							received.Done()
+						}
+					}
+				}()
+			}
+			var wire int64
+			for _, c := range clients {
+				wire -= c.wire.Load()
+			}
+			var size int
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				received.Add(len(clients))
+				room.do(func() {
+					SendGameEventToAll(room, "STATE", room.ID, payload)
+					size = len(room.history[len(room.history)-1].JSON)
+				})
+				received.Wait()
+			}
+			b.StopTimer()
+			for _, c := range clients {
+				wire += c.wire.Load()
+			}
+			b.ReportMetric(float64(size), "json-B")
+			b.ReportMetric(float64(wire)/float64(b.N), "wire-B/op")
+		})
+	}
+}
+
+// TestBroadcastDropsDeadClient kills bob's connection under a broadcast and
+// cat's socket abruptly, and checks each is taken out of the room and
+// announced as disconnected exactly once, though both the broadcaster and
+// the read loop find the connection dead.
+func TestBroadcastDropsDeadClient(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 3})
+	clients := ts.startGame(code, "ann", "bob", "cat")
+	ann, cat := clients[0], clients[2]
+	room := ts.room(code)
+
+	var chatSeq uint64
+	connected := 0
+	room.do(func() {
+		// As if bob's writer had failed: the connection is closed, but
+		// the read loop can't take bob out of the room until this is done.
+		clientFor(room, "bob").Close()
+		chatSeq = room.seq + 1
+		SendGameEventToAll(room, "CHAT_MESSAGE", room.ID, map[string]string{"from": "ann", "text": "anyone there?"})
+		connected = len(room.Players)
+	})
+	if connected != 2 {
+		t.Errorf("%d connected after a broadcast bob couldn't take", connected)
+	}
+
+	tcp := cat.conn.UnderlyingConn().(countingConn).Conn.(*net.TCPConn)
+	tcp.SetLinger(0)
+	tcp.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for connected != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d still connected after cat's socket was killed", connected)
+		}
+		time.Sleep(10 * time.Millisecond)
+		room.do(func() {
+			// Give the broadcaster the chance to find cat gone too.
+			SendGameEventToAll(room, "CHAT_MESSAGE", room.ID, map[string]string{"from": "ann", "text": "hello?"})
+			connected = len(room.Players)
+		})
+	}
+	// Let any second report of either drop reach ann before the test checks.
+	time.Sleep(100 * time.Millisecond)
+
+	seen := make(map[string][]uint64)
+	ann.send("CHAT_MESSAGE", map[string]string{"text": "just me then"})
+	for timeout := time.After(5 * time.Second); ; {
+		var e receivedEvent
+		select {
+		case e = <-ann.events:
+		case <-timeout:
+			t.Fatal("ann's chat didn't come back")
+		}
+		var payload DisconnectedPayload
+		var chat map[string]string
+		if e.Event == "PLAYER_DISCONNECTED" {
+			e.decode(t, &payload)
+			seen[payload.Player] = append(seen[payload.Player], e.Seq)
+		} else if e.Event == "CHAT_MESSAGE" {
+			if e.decode(t, &chat); chat["text"] == "just me then" {
+				break
+			}
+		}
+	}
+	if len(seen["bob"]) != 1 || seen["bob"][0] != chatSeq+1 || len(seen["cat"]) != 1 {
+		t.Errorf("PLAYER_DISCONNECTED for bob at %v, for cat at %v; want bob's once, at %d, and cat's once", seen["bob"], seen["cat"], chatSeq+1)
+	}
+	room.do(func() {
+		for _, name := range []string{"bob", "cat"} {
+			if room.GameState.Players[name].Connected || room.graceTimers[name] == nil {
+				t.Errorf("%s connected %v, grace %v", name, room.GameState.Players[name].Connected, room.graceTimers[name] != nil)
+			}
+		}
+	})
+}
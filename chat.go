@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxChatLength  = 500
+	chatHistoryLen = 100
+)
+
+// HandleChatMessageEvent broadcasts a line of table talk. The sender is
+// always the name bound to the connection; any "player" in the payload is
+// ignored.
+func HandleChatMessageEvent(room *GameRoom, event GameEvent, client *Client) {
+	payload, _ := event.Payload.(map[string]interface{})
+	text, _ := payload["text"].(string)
+	text = strings.TrimSpace(text)
+	if text == "" {
+		room.rejectEvent(client, event, "INVALID_CHAT", "chat message is empty")
+		return
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		room.rejectEvent(client, event, "INVALID_CHAT", "chat message is too long")
+		return
+	}
+
+	from := connName(room, client)
+	now := time.Now()
+	if !room.allowChat(from, now) {
+		room.rejectEvent(client, event, "RATE_LIMITED", "you are sending messages too quickly")
+		return
+	}
+
+	msg := ChatMessage{From: from, Text: text, SentAt: now}
+	room.GameState.Chat = append(room.GameState.Chat, msg)
+	if len(room.GameState.Chat) > chatHistoryLen {
+		room.GameState.Chat = room.GameState.Chat[len(room.GameState.Chat)-chatHistoryLen:]
+	}
+	SendGameEventToAll(room, "CHAT_MESSAGE", room.ID, msg)
+}
+
+// allowChat records a message from name at now and reports whether it is
+// within the flood limit. It must run on the room's goroutine.
+func (room *GameRoom) allowChat(name string, now time.Time) bool {
+	cutoff := now.Add(-hub.config.ChatRateWindow)
+	recent := room.chatTimes[name][:0]
+	for _, t := range room.chatTimes[name] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= hub.config.ChatRateMessages {
+		room.chatTimes[name] = recent
+		return false
+	}
+	room.chatTimes[name] = append(recent, now)
+	return true
+}
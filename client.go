@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sendBufferSize bounds how many outbound messages may queue for a single
+// connection before it is considered stalled and dropped.
+const sendBufferSize = 256
+
+// Client is a websocket connection with its own writer goroutine. gorilla
+// allows only one concurrent writer per connection, so everything bound for
+// the client is queued on send and written by writePump.
+type Client struct {
+	conn        *websocket.Conn
+	encoding    Encoding
+	locale      string
+	connectedAt time.Time
+	send        chan *OutboundMessage
+	// id numbers the connection, and log carries it along with whatever
+	// else is known about who is on the other end.
+	id  uint64
+	log *slog.Logger
+	// identity is who the connection's access token says it is, or nil
+	// for a guest. It is set before the connection joins any room.
+	identity *Identity
+	// limiter is only used by the read loop.
+	limiter *eventLimiter
+	// rooms are the rooms the connection is in, by gameId, and home is
+	// the one it joined on the websocket URL, which gets events that
+	// don't name a room.
+	roomsMu sync.Mutex
+	rooms   map[string]*GameRoom
+	home    string
+	// replay is set on a connection opened with ?replay=, which only
+	// watches a replay and joins no rooms.
+	replay *replaySession
+	// pingID numbers the PINGs sent, pingSent is when the last one still
+	// waiting for its PONG was sent, in Unix nanoseconds, and latency is
+	// the smoothed round trip; see ping and pong.
+	pingID   atomic.Uint64
+	pingSent atomic.Int64
+	latency  atomic.Int64
+
+	closing      chan []byte
+	closeReqOnce sync.Once
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+func newClient(conn *websocket.Conn, encoding Encoding, locale string) *Client {
+	c := &Client{
+		conn:        conn,
+		encoding:    encoding,
+		locale:      locale,
+		connectedAt: time.Now(),
+		send:        make(chan *OutboundMessage, sendBufferSize),
+		closing:     make(chan []byte, 1),
+		done:        make(chan struct{}),
+		id:          connIDs.Add(1),
+		limiter:     newEventLimiter(hub.config, time.Now()),
+		rooms:       make(map[string]*GameRoom),
+	}
+	c.log = slog.With("connId", c.id, "remote", conn.RemoteAddr().String())
+	go c.writePump()
+	return c
+}
+
+// room returns the room the connection is in called id, or its home room
+// if id is empty. Ids match as they do in GameHub.lookup.
+func (c *Client) room(id string) (*GameRoom, bool) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	if id == "" {
+		id = c.home
+	}
+	if room, ok := c.rooms[id]; ok {
+		return room, true
+	}
+	room, ok := c.rooms[strings.ToUpper(id)]
+	return room, ok
+}
+
+// joinedRooms returns the rooms the connection is in.
+func (c *Client) joinedRooms() []*GameRoom {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	rooms := make([]*GameRoom, 0, len(c.rooms))
+	for _, room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+func (c *Client) roomCount() int {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	return len(c.rooms)
+}
+
+// addRoom records that the connection is in room. The first room it joins
+// is its home.
+func (c *Client) addRoom(room *GameRoom) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	if len(c.rooms) == 0 && c.home == "" {
+		c.home = room.ID
+	}
+	c.rooms[room.ID] = room
+}
+
+// dropRoom records that the connection has left room.
+func (c *Client) dropRoom(room *GameRoom) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	if c.rooms[room.ID] == room {
+		delete(c.rooms, room.ID)
+	}
+}
+
+// Send queues message for the client without blocking. If the queue is
+// full the client is closed and Send reports false so the room drops it.
+func (c *Client) Send(message *OutboundMessage) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+		c.log.Warn("send buffer full, dropping connection")
+		c.evict("send buffer full")
+		return false
+	}
+}
+
+// evict drops a client that can't keep up. Send may be called on the
+// room's goroutine, so the close frame is written in the background, under
+// its own short deadline, rather than waiting on a stalled connection.
+// Closing the connection ends the read loop, which takes the client out of
+// the room and starts the player's grace period like any other drop.
+func (c *Client) evict(reason string) {
+	c.closeReqOnce.Do(func() {
+		metrics.Inc(metricSlowClients, "")
+		go func() {
+			c.conn.WriteControl(websocket.CloseMessage, closeMessage(CloseTooSlow, reason), time.Now().Add(closeWriteWait))
+			c.Close()
+		}()
+	})
+}
+
+// Close stops the writer and closes the underlying connection, which in
+// turn ends the read loop. It is safe to call more than once and from any
+// goroutine. The send channel itself is never closed, so a broadcaster
+// racing with Close can't panic.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// CloseWith flushes what is already queued for the client, sends a close
+// frame with code and reason, and then closes the connection. Only the
+// first call's code and reason are used.
+func (c *Client) CloseWith(code int, reason string) {
+	c.closeReqOnce.Do(func() {
+		c.closing <- closeMessage(code, reason)
+		// Don't rely on the pump to get round to it if it is stuck.
+		time.AfterFunc(hub.config.WriteWait+closeWriteWait, c.Close)
+	})
+}
+
+func (c *Client) write(message *OutboundMessage) error {
+	message = message.In(c.locale)
+	pm, err := message.Prepared(c.encoding)
+	if err != nil {
+		return err
+	}
+	c.conn.EnableWriteCompression(len(message.Encode(c.encoding)) >= hub.config.CompressionThreshold)
+	c.conn.SetWriteDeadline(time.Now().Add(hub.config.WriteWait))
+	return c.conn.WritePreparedMessage(pm)
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(hub.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			if err := c.write(message); err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					// The deadline passed with the frame unsent; the
+					// connection is unusable now, so no close frame.
+					c.log.Warn("write timed out, dropping connection")
+					metrics.Inc(metricSlowClients, "")
+				} else {
+					c.log.Warn("sending message", "err", err)
+				}
+				c.Close()
+				return
+			}
+		case frame := <-c.closing:
+			c.flush()
+			c.conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(closeWriteWait))
+			c.Close()
+			return
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(hub.config.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.log.Debug("ping failed", "err", err)
+				c.Close()
+				return
+			}
+			if err := c.ping(); err != nil {
+				c.log.Info("dropping connection", "err", err)
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// flush writes whatever is still queued without waiting for more.
+func (c *Client) flush() {
+	for {
+		select {
+		case message := <-c.send:
+			if err := c.write(message); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
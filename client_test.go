@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestStalledClient has zed stop reading in the middle of a game while the
+// room broadcasts far more than zed's connection can hold, and checks the
+// broadcasts reach bob as quickly at the end as at the start, and that zed
+// is dropped and given the usual grace period.
+func TestStalledClient(t *testing.T) {
+	// Compressing the broadcasts would only make the test slower.
+	ts := newTestServer(t, func(cfg *Config) { cfg.Compression = false })
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 3})
+	ann := ts.join(code, "ann", nil)
+	bob := ts.join(code, "bob", nil)
+	u := "ws" + strings.TrimPrefix(ts.srv.URL, "http") + "/ws?gameId=" + code + "&name=zed"
+	zed, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zed.Close()
+	for _, conn := range []*websocket.Conn{bob.conn, zed} {
+		conn.WriteJSON(map[string]interface{}{"event": "READY", "payload": map[string]bool{"ready": true}})
+	}
+	room := ts.room(code)
+	for ready := false; !ready; {
+		room.do(func() {
+			ready = len(room.GameState.Players) == 3 && room.GameState.Players["bob"].Ready && room.GameState.Players["zed"].Ready
+		})
+		time.Sleep(time.Millisecond)
+	}
+	ann.send("START_GAME", nil)
+	bob.expect("GAME_STARTED")
+
+	text := strings.Repeat("x", 16<<10)
+	received := make(chan time.Time, 1024)
+	go func() {
+		for e := range bob.events {
+			if e.Event == "CHAT_MESSAGE" {
+				received <- time.Now()
+			}
+		}
+	}()
+	// Broadcast until zed has been dropped, and a hundred more after.
+	var latencies []time.Duration
+	dropped := 0
+	for i := 0; dropped == 0 || i < dropped+100; i++ {
+		sent := time.Now()
+		room.do(func() {
+			SendGameEventToAll(room, "CHAT_MESSAGE", room.ID, map[string]string{"from": "ann", "text": text})
+			if dropped == 0 && len(room.Players) == 2 {
+				dropped = i + 1
+			}
+		})
+		select {
+		case at := <-received:
+			latencies = append(latencies, at.Sub(sent))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("broadcast %d didn't reach bob", i)
+		}
+		if i == 5000 {
+			t.Fatal("zed wasn't dropped")
+		}
+	}
+	average := func(ds []time.Duration) time.Duration {
+		var sum time.Duration
+		for _, d := range ds {
+			sum += d
+		}
+		return sum / time.Duration(len(ds))
+	}
+	before, after := average(latencies[:50]), average(latencies[len(latencies)-50:])
+	stalled := average(latencies[dropped-50 : dropped])
+	t.Logf("zed dropped after %d broadcasts; they took %v to reach bob at first, %v just before and %v after", dropped, before, stalled, after)
+	if stalled > 10*before+10*time.Millisecond || after > 10*before+10*time.Millisecond {
+		t.Errorf("broadcasts took %v to reach bob at first, %v while zed fell behind and %v after", before, stalled, after)
+	}
+
+	room.do(func() {
+		if len(room.Players) != 2 || room.GameState.Players["zed"].Connected || room.graceTimers["zed"] == nil {
+			t.Errorf("%d connected, zed connected %v, grace %v", len(room.Players), room.GameState.Players["zed"].Connected, room.graceTimers["zed"] != nil)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Games can be played on a clock: each player has a time bank of
+// RoomOptions.ClockSeconds that runs down only while it is their turn and
+// the game isn't paused, on top of any turn timer. When a player's bank
+// runs out, ClockExpiry decides what happens: with ClockAuto the rest of
+// that turn, and each of their turns after it, is played for them as if
+// the turn had timed out; with ClockForfeit they forfeit.
+const (
+	ClockAuto    = "auto"
+	ClockForfeit = "forfeit"
+)
+
+const (
+	minClockSeconds = 60
+	maxClockSeconds = 4 * 3600
+	// clockUpdateInterval is how often CLOCK_UPDATE is sent while a clock
+	// runs, besides whenever the turn changes.
+	clockUpdateInterval = 15 * time.Second
+)
+
+var (
+	errClockSeconds = errors.New("clockSeconds must be between 60 and 14400")
+	errClockExpiry  = errors.New(`clockExpiry must be "auto" or "forfeit"`)
+)
+
+// ClockUpdatePayload gives every player's time bank in milliseconds.
+// Running names the player whose clock is running, if any, and Deadline
+// is when it runs out.
+type ClockUpdatePayload struct {
+	Running  string           `json:"running,omitempty"`
+	Deadline *time.Time       `json:"deadline,omitempty"`
+	Clocks   map[string]int64 `json:"clocks"`
+}
+
+// onClock reports whether the room's game is played on a clock.
+func (room *GameRoom) onClock() bool {
+	return room.Options.ClockSeconds > 0
+}
+
+// fillClocks gives every player a full time bank as the game starts. It
+// must run on the room's goroutine.
+func (room *GameRoom) fillClocks() {
+	if !room.onClock() {
+		return
+	}
+	bank := (time.Duration(room.Options.ClockSeconds) * time.Second).Milliseconds()
+	for _, p := range room.GameState.Players {
+		p.ClockLeft = bank
+	}
+}
+
+// startClock starts name's clock for their turn. A clock started while the
+// game is paused waits for it to resume. It must run on the room's
+// goroutine, with no clock running.
+func (room *GameRoom) startClock(name string) {
+	player, ok := room.GameState.Players[name]
+	if !room.onClock() || !ok {
+		return
+	}
+	var timer *roomTimer
+	timer = newRoomTimer(time.Duration(player.ClockLeft)*time.Millisecond, func() {
+		room.do(func() { room.clockExpired(name, timer) })
+	})
+	room.clock, room.clockOwner = timer, name
+	if room.GameState.Paused {
+		room.pauseClock()
+		return
+	}
+	deadline := time.Now().Add(timer.remaining)
+	room.GameState.ClockDeadline = &deadline
+}
+
+// stopClock stops the running clock, if any, banking the time it had
+// left. It must run on the room's goroutine.
+func (room *GameRoom) stopClock() {
+	if room.clock == nil {
+		return
+	}
+	room.pauseClock()
+	room.clock, room.clockOwner = nil, ""
+}
+
+// pauseClock freezes the running clock and records what it has left in
+// the player's bank. It must run on the room's goroutine.
+func (room *GameRoom) pauseClock() {
+	if room.clock == nil {
+		return
+	}
+	room.clock.pause()
+	if player, ok := room.GameState.Players[room.clockOwner]; ok {
+		player.ClockLeft = room.clock.remaining.Milliseconds()
+	}
+	room.GameState.ClockDeadline = nil
+	if room.clockUpdates != nil {
+		room.clockUpdates.Stop()
+		room.clockUpdates = nil
+	}
+}
+
+// resumeClock restarts a paused clock. It must run on the room's
+// goroutine.
+func (room *GameRoom) resumeClock() {
+	if room.clock == nil {
+		return
+	}
+	room.clock.resume()
+	deadline := time.Now().Add(room.clock.remaining)
+	room.GameState.ClockDeadline = &deadline
+}
+
+// announceClocks sends CLOCK_UPDATE, and while a clock runs schedules the
+// next one. It must run on the room's goroutine.
+func (room *GameRoom) announceClocks() {
+	if !room.onClock() || room.GameState.Status != StatusInProgress {
+		return
+	}
+	payload := ClockUpdatePayload{Clocks: make(map[string]int64, len(room.GameState.TurnOrder))}
+	for _, name := range room.GameState.TurnOrder {
+		payload.Clocks[name] = room.GameState.Players[name].ClockLeft
+	}
+	if deadline := room.GameState.ClockDeadline; deadline != nil {
+		payload.Running = room.clockOwner
+		payload.Deadline = deadline
+		payload.Clocks[payload.Running] = max(time.Until(*deadline).Milliseconds(), 0)
+	}
+	SendGameEventToAll(room, "CLOCK_UPDATE", room.ID, payload)
+
+	if room.clockUpdates != nil {
+		room.clockUpdates.Stop()
+		room.clockUpdates = nil
+	}
+	if payload.Running != "" {
+		clock := room.clock
+		room.clockUpdates = time.AfterFunc(clockUpdateInterval, func() {
+			room.do(func() {
+				if room.clock == clock && room.GameState.ClockDeadline != nil {
+					room.announceClocks()
+				}
+			})
+		})
+	}
+}
+
+// clockExpired handles name's time bank running out. A clock that was
+// replaced or paused after it fired is ignored. It must run on the room's
+// goroutine.
+func (room *GameRoom) clockExpired(name string, timer *roomTimer) {
+	if room.clock != timer || room.GameState.Paused || room.closed || room.GameState.Status != StatusInProgress || room.GameState.Turn != name {
+		return
+	}
+	room.stopClock()
+	room.GameState.Players[name].ClockLeft = 0
+	room.logger().Info("clock ran out", "player", name)
+	SendGameEventToAll(room, "CLOCK_EXPIRED", room.ID, PlayerPayload{Player: name})
+	if room.Options.ClockExpiry == ClockForfeit {
+		room.forfeitPlayer(name, "out of time")
+		return
+	}
+	room.stopTurnTimer()
+	room.playOutTurn(name)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Close codes sent to clients. The 4xxx codes are application specific;
+// the reason string carries a short human-readable explanation.
+const (
+	// CloseInvalidJoin rejects a join with a missing or invalid gameId or
+	// name, or for a room that doesn't exist.
+	CloseInvalidJoin = websocket.ClosePolicyViolation
+	// CloseTryAgain rejects a join because the server or room is full.
+	CloseTryAgain = websocket.CloseTryAgainLater
+	// CloseRoomClosed ends the connection because its room was torn down
+	// or its game finished.
+	CloseRoomClosed = websocket.CloseNormalClosure
+	// CloseServerShutdown is sent to every connection on shutdown.
+	CloseServerShutdown = websocket.CloseGoingAway
+	// CloseKicked is sent to a player removed by the host or a vote.
+	CloseKicked = 4001
+	// CloseReplaced is sent to a connection superseded by a reconnect of
+	// the same player.
+	CloseReplaced = 4002
+	// CloseNameTaken rejects a join whose name is already in use by a
+	// connected player.
+	CloseNameTaken = 4003
+	// CloseTooSlow drops a connection that stopped keeping up with what
+	// was sent to it.
+	CloseTooSlow = 4004
+	// CloseUnauthorized refuses a connection whose access token is
+	// missing, invalid or expired; the client should get a fresh token
+	// and connect again.
+	CloseUnauthorized = 4005
+	// CloseRateLimited drops a connection that keeps sending events well
+	// past its rate limit.
+	CloseRateLimited = websocket.ClosePolicyViolation
+)
+
+// closeWriteWait bounds how long sending a close frame may take.
+const closeWriteWait = time.Second
+
+// maxCloseReasonLength is the most a close frame's reason may hold.
+const maxCloseReasonLength = 123
+
+func closeMessage(code int, reason string) []byte {
+	if len(reason) > maxCloseReasonLength {
+		reason = reason[:maxCloseReasonLength]
+	}
+	return websocket.FormatCloseMessage(code, reason)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// expectClose skips events until the server closes the connection, and
+// checks it said why with code and a reason containing reason.
+func (c *testClient) expectClose(code int, reason string) {
+	c.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-c.events:
+			if ok {
+				continue
+			}
+			var closeErr *websocket.CloseError
+			if !errors.As(c.closeErr, &closeErr) || closeErr.Code != code || !strings.Contains(closeErr.Text, reason) {
+				c.t.Errorf("%s: connection ended with %v, want close %d %q", c.name, c.closeErr, code, reason)
+			}
+			return
+		case <-timeout:
+			c.t.Fatalf("%s: connection not closed, want close %d", c.name, code)
+		}
+	}
+}
+
+// TestCloseCodes checks connections are closed with the code and reason
+// for each of the ways they can be ended.
+func TestCloseCodes(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(nil)
+	ann := ts.join(code, "ann", nil)
+
+	ts.dial(strings.Repeat("X", maxNameLength+1), "cat", nil).expectClose(CloseInvalidJoin, "gameId")
+	ts.dial("NOSUCH", "cat", nil).expectClose(CloseInvalidJoin, "no room")
+	ts.dial(code, "ann", nil).expectClose(CloseNameTaken, "name is already in use")
+	ts.dial(code, "", url.Values{"token": {"forged"}}).expectClose(CloseInvalidJoin, "session token")
+
+	bob := ts.join(code, "bob", nil)
+	again := ts.join(code, "", url.Values{"token": {bob.token}})
+	bob.expectClose(CloseReplaced, "replaced")
+	ann.send("KICK_PLAYER", map[string]string{"player": "bob"})
+	again.expectClose(CloseKicked, "kicked")
+
+	room := ts.room(code)
+	room.do(func() { hub.closeRoom(room, "test over") })
+	ann.expectClose(CloseRoomClosed, "room closed: test over")
+}
+
+// TestCloseOnShutdown checks the connections in rooms closed while the
+// server shuts down are told it is going away.
+func TestCloseOnShutdown(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(nil)
+	ann := ts.join(code, "ann", nil)
+
+	shuttingDown.Store(true)
+	defer shuttingDown.Store(false)
+	room := ts.room(code)
+	room.do(func() { hub.closeRoom(room, "server shutting down") })
+	ann.expectClose(CloseServerShutdown, "server shutting down")
+}
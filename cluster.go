@@ -0,0 +1,307 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// forwardedHeader marks a request one instance passed to another, so it is
+// never passed on a second time.
+const forwardedHeader = "X-Monopoly-Forwarded"
+
+// Cluster decides which instance hosts each room. Every room is owned by
+// exactly one instance at a time, which handles all of its events; the
+// other instances pass their requests for it on to the owner. Since every
+// connection to a room, socket or stream, ends up on the owner, a room's
+// broadcasts never need to leave it.
+type Cluster interface {
+	// Claim makes this instance the owner of room id unless another one
+	// already is. It returns "" if this instance owns the room, or the
+	// URL of the instance that does.
+	Claim(id string) (owner string, err error)
+	// Release gives up this instance's claim on room id.
+	Release(id string)
+	// Close gives up every room this instance owns.
+	Close()
+}
+
+// cluster is a single instance that owns every room unless -redis-addr is
+// set.
+var cluster Cluster = localCluster{}
+
+type localCluster struct{}
+
+func (localCluster) Claim(string) (string, error) { return "", nil }
+func (localCluster) Release(string)               {}
+func (localCluster) Close()                       {}
+
+// redisCluster keeps room ownership in Redis as leases: a key per room
+// holding the owner's URL with an expiry the owner keeps pushing back.
+// If the owner dies the lease runs out and the next instance to get a
+// request for the room claims it, restoring it from the shared store.
+//
+// An owner that can't renew a lease stops hosting the room before the
+// lease can expire, so two instances never handle the same room at once.
+type redisCluster struct {
+	client *redisClient
+	self   string
+	lease  time.Duration
+
+	mu      sync.Mutex
+	renewed map[string]time.Time
+
+	done chan struct{}
+}
+
+const renewLeaseScript = `if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('pexpire', KEYS[1], ARGV[2]) else return 0 end`
+const releaseLeaseScript = `if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('del', KEYS[1]) else return 0 end`
+
+// newRedisCluster joins the cluster kept in the Redis server at addr as
+// the instance reached at self, taking leases that last lease unrenewed.
+func newRedisCluster(addr, self string, lease time.Duration) *redisCluster {
+	c := &redisCluster{
+		client:  newRedisClient(addr),
+		self:    self,
+		lease:   lease,
+		renewed: make(map[string]time.Time),
+		done:    make(chan struct{}),
+	}
+	go c.renewLeases()
+	return c
+}
+
+func leaseKey(id string) string { return "monopoly:room:" + id + ":owner" }
+
+func (c *redisCluster) leaseMillis() string {
+	return strconv.FormatInt(c.lease.Milliseconds(), 10)
+}
+
+func (c *redisCluster) Claim(id string) (string, error) {
+	reply, err := c.client.Do("SET", leaseKey(id), c.self, "NX", "PX", c.leaseMillis())
+	if err != nil {
+		return "", err
+	}
+	if reply == nil {
+		owner, err := c.client.Do("GET", leaseKey(id))
+		if err != nil {
+			return "", err
+		}
+		if owner, _ := owner.(string); owner != c.self && owner != "" {
+			return owner, nil
+		}
+		if owner == nil {
+			// The lease ran out between the two commands; try again.
+			return c.Claim(id)
+		}
+	}
+	c.mu.Lock()
+	c.renewed[id] = time.Now()
+	c.mu.Unlock()
+	return "", nil
+}
+
+func (c *redisCluster) Release(id string) {
+	c.mu.Lock()
+	delete(c.renewed, id)
+	c.mu.Unlock()
+	if _, err := c.client.Do("EVAL", releaseLeaseScript, "1", leaseKey(id), c.self); err != nil {
+		slog.Error("releasing room lease", "gameId", id, "err", err)
+	}
+}
+
+func (c *redisCluster) Close() {
+	close(c.done)
+	c.mu.Lock()
+	ids := make([]string, 0, len(c.renewed))
+	for id := range c.renewed {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+	for _, id := range ids {
+		c.Release(id)
+	}
+	c.client.Close()
+}
+
+// renewLeases pushes back the expiry of every lease this instance holds,
+// and gives up rooms whose lease was lost or can't be renewed in time,
+// until the cluster is closed.
+func (c *redisCluster) renewLeases() {
+	ticker := time.NewTicker(c.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		ids := make([]string, 0, len(c.renewed))
+		for id := range c.renewed {
+			ids = append(ids, id)
+		}
+		c.mu.Unlock()
+
+		for _, id := range ids {
+			reply, err := c.client.Do("EVAL", renewLeaseScript, "1", leaseKey(id), c.self, c.leaseMillis())
+			c.mu.Lock()
+			last, held := c.renewed[id]
+			lost := held && (reply == int64(0) || (err != nil && time.Since(last) > c.lease*2/3))
+			if err == nil && !lost {
+				c.renewed[id] = time.Now()
+			}
+			if lost {
+				delete(c.renewed, id)
+			}
+			c.mu.Unlock()
+			if lost {
+				slog.Warn("lost the room lease", "gameId", id)
+				handOff(id)
+			}
+		}
+	}
+}
+
+// handOff stops hosting room id here because another instance may take it
+// over. Its state is saved, not deleted, so the new owner can restore it.
+func handOff(id string) {
+	hub.Mutex.RLock()
+	room, ok := hub.lookup(id)
+	hub.Mutex.RUnlock()
+	if !ok {
+		return
+	}
+	room.do(func() {
+		room.handedOff = true
+		hub.closeRoom(room, "room moved to another server")
+	})
+}
+
+// clusterKey is the name a room goes by in the cluster. Generated codes
+// are matched case-insensitively, so all instances use the upper-case form.
+func clusterKey(id string) string {
+	return strings.ToUpper(id)
+}
+
+// instanceURL is the URL other instances reach this one at: -instance-addr
+// as given if it names a scheme, or else over https if this instance
+// serves TLS and http if not.
+func instanceURL(cfg *Config) string {
+	if strings.Contains(cfg.InstanceAddr, "://") {
+		return cfg.InstanceAddr
+	}
+	if cfg.TLSCert != "" {
+		return "https://" + cfg.InstanceAddr
+	}
+	return "http://" + cfg.InstanceAddr
+}
+
+// ownerURL is where requests for a room owned by owner are passed. An
+// owner given as a bare host:port, as instances that predate schemes
+// claimed rooms, is reached over http.
+func ownerURL(owner string) *url.URL {
+	if u, err := url.Parse(owner); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return u
+	}
+	return &url.URL{Scheme: "http", Host: owner}
+}
+
+// routeToOwner makes sure room id is handled by its owner. If this
+// instance owns it, or can claim it, it returns false and the caller
+// serves the request; a room claimed this way is restored from the store
+// if it was saved by a previous owner. Otherwise the request is passed to
+// the owner and routeToOwner returns true.
+func routeToOwner(w http.ResponseWriter, r *http.Request, id string) bool {
+	if id == "" || r.Header.Get(forwardedHeader) != "" {
+		return false
+	}
+	owner, err := claimRoom(id)
+	if err != nil {
+		http.Error(w, "rooms are unavailable right now", http.StatusServiceUnavailable)
+		return true
+	}
+	if owner == "" {
+		return false
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(ownerURL(owner))
+	proxy.FlushInterval = -1
+	r.Header.Set(forwardedHeader, "1")
+	proxy.ServeHTTP(w, r)
+	return true
+}
+
+// claimRoom makes this instance the owner of room id unless another
+// instance already is, in which case it returns that instance's URL.
+// A room claimed this way is restored from the store if it was saved by a
+// previous owner.
+func claimRoom(id string) (string, error) {
+	if _, single := cluster.(localCluster); single {
+		return "", nil
+	}
+	hub.Mutex.RLock()
+	_, local := hub.lookup(id)
+	hub.Mutex.RUnlock()
+	if local {
+		return "", nil
+	}
+	owner, err := cluster.Claim(clusterKey(id))
+	if err != nil {
+		slog.Error("claiming room", "gameId", id, "err", err)
+		return "", err
+	}
+	if owner == "" {
+		adoptRoom(id)
+	}
+	return owner, nil
+}
+
+// ownedElsewhere reports whether room id is hosted by another instance,
+// claiming it for this one if nobody hosts it. Events for a room can't be
+// passed on to another instance the way a request can, so a JOIN_ROOM for
+// one hosted elsewhere is refused.
+func ownedElsewhere(id string) (bool, error) {
+	if id == "" {
+		return false, nil
+	}
+	owner, err := claimRoom(id)
+	return owner != "", err
+}
+
+// adoptRoom restores room id from the store into the hub after this
+// instance claimed it, if it was saved and isn't here already.
+func adoptRoom(id string) {
+	rec, err := store.LoadRoom(id)
+	if err == nil && rec == nil && clusterKey(id) != id {
+		rec, err = store.LoadRoom(clusterKey(id))
+	}
+	if err != nil {
+		slog.Error("loading room", "gameId", id, "err", err)
+		return
+	}
+	if rec == nil || rec.Finished() || rec.SavedAt != nil {
+		return
+	}
+	room, err := restoreRoom(rec)
+	if err != nil {
+		slog.Error("restoring room", "gameId", id, "err", err)
+		return
+	}
+	hub.Mutex.Lock()
+	_, exists := hub.Rooms[room.ID]
+	if !exists {
+		hub.Rooms[room.ID] = room
+	}
+	hub.Mutex.Unlock()
+	if exists {
+		room.abandon()
+		return
+	}
+	room.logger().Info("room adopted")
+}
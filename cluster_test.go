@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// hostedRoom puts a new room under id into the hub for the test.
+func hostedRoom(t *testing.T, id string) *GameRoom {
+	t.Helper()
+	room := newGameRoom(id, defaultRoomOptions())
+	hub.Mutex.Lock()
+	hub.Rooms[id] = room
+	hub.Mutex.Unlock()
+	// A room handed off is saved in the background.
+	t.Cleanup(pendingWrites.Wait)
+	t.Cleanup(func() {
+		hub.Mutex.Lock()
+		if hub.Rooms[id] == room {
+			delete(hub.Rooms, id)
+		}
+		hub.Mutex.Unlock()
+		room.abandon()
+	})
+	return room
+}
+
+// handedOffIn waits for room to be closed and reports whether it was
+// handed off.
+func handedOffIn(t *testing.T, room *GameRoom, wait time.Duration) bool {
+	t.Helper()
+	select {
+	case <-room.done:
+		return room.handedOff
+	case <-time.After(wait):
+		return false
+	}
+}
+
+func TestClusterLease(t *testing.T) {
+	f := newFakeRedis(t)
+	a := newRedisCluster(f.addr(), "http://10.0.0.1:8080", 10*time.Second)
+	b := newRedisCluster(f.addr(), "https://10.0.0.2:8443", 10*time.Second)
+	t.Cleanup(b.Close)
+
+	if owner, err := a.Claim("ROOM"); owner != "" || err != nil {
+		t.Fatalf("first claim: %q, %v", owner, err)
+	}
+	if owner, err := a.Claim("ROOM"); owner != "" || err != nil {
+		t.Errorf("claiming its own room again: %q, %v", owner, err)
+	}
+	if owner, err := b.Claim("ROOM"); owner != "http://10.0.0.1:8080" || err != nil {
+		t.Errorf("second instance's claim: %q, %v", owner, err)
+	}
+	b.Release("ROOM")
+	if owner := f.value(leaseKey("ROOM")); owner != "http://10.0.0.1:8080" {
+		t.Errorf("releasing someone else's room left the lease with %q", owner)
+	}
+
+	a.Close()
+	if owner, err := b.Claim("ROOM"); owner != "" || err != nil {
+		t.Errorf("claim after the owner closed: %q, %v", owner, err)
+	}
+	if owner := f.value(leaseKey("ROOM")); owner != "https://10.0.0.2:8443" {
+		t.Errorf("lease held by %q", owner)
+	}
+}
+
+// TestClusterRenewal checks a lease outlives its expiry while the owner
+// keeps renewing it, and runs out once it stops.
+func TestClusterRenewal(t *testing.T) {
+	const lease = 150 * time.Millisecond
+	f := newFakeRedis(t)
+	a := newRedisCluster(f.addr(), "http://a", lease)
+	b := newRedisCluster(f.addr(), "http://b", lease)
+	t.Cleanup(b.Close)
+
+	if owner, err := a.Claim("ROOM"); owner != "" || err != nil {
+		t.Fatalf("claim: %q, %v", owner, err)
+	}
+	time.Sleep(4 * lease)
+	if owner, err := b.Claim("ROOM"); owner != "http://a" || err != nil {
+		t.Fatalf("claim after %v: %q, %v; the lease wasn't renewed", 4*lease, owner, err)
+	}
+
+	// Stop renewing without releasing, as an instance that died would.
+	close(a.done)
+	time.Sleep(2 * lease)
+	if owner, err := b.Claim("ROOM"); owner != "" || err != nil {
+		t.Errorf("claim after the owner stopped renewing: %q, %v", owner, err)
+	}
+	a.client.Close()
+}
+
+// TestClusterHandoff checks an owner whose lease another instance took
+// stops hosting the room, leaving it saved for the new owner.
+func TestClusterHandoff(t *testing.T) {
+	const lease = 150 * time.Millisecond
+	f := newFakeRedis(t)
+	a := newRedisCluster(f.addr(), "http://a", lease)
+	t.Cleanup(a.Close)
+	room := hostedRoom(t, "HANDOFF")
+
+	if owner, err := a.Claim("HANDOFF"); owner != "" || err != nil {
+		t.Fatalf("claim: %q, %v", owner, err)
+	}
+	f.set(leaseKey("HANDOFF"), "http://b", time.Minute)
+	if !handedOffIn(t, room, 5*time.Second) {
+		t.Fatal("the room wasn't handed off after its lease was lost")
+	}
+	hub.Mutex.RLock()
+	_, hosted := hub.lookup("HANDOFF")
+	hub.Mutex.RUnlock()
+	if hosted {
+		t.Error("the room is still in the hub")
+	}
+	if owner := f.value(leaseKey("HANDOFF")); owner != "http://b" {
+		t.Errorf("handing off touched the new owner's lease: %q", owner)
+	}
+}
+
+// TestClusterHandoffUnreachable checks an owner that can't reach Redis to
+// renew its lease stops hosting the room, and the room can be claimed
+// elsewhere once the lease runs out.
+func TestClusterHandoffUnreachable(t *testing.T) {
+	const lease = 150 * time.Millisecond
+	f := newFakeRedis(t)
+	a := newRedisCluster(f.addr(), "http://a", lease)
+	b := newRedisCluster(f.addr(), "http://b", lease)
+	t.Cleanup(a.Close)
+	t.Cleanup(b.Close)
+	room := hostedRoom(t, "UNREACHABLE")
+
+	if owner, err := a.Claim("UNREACHABLE"); owner != "" || err != nil {
+		t.Fatalf("claim: %q, %v", owner, err)
+	}
+	f.setDown(true)
+	if !handedOffIn(t, room, 5*time.Second) {
+		t.Fatal("the room wasn't handed off while its lease couldn't be renewed")
+	}
+	f.setDown(false)
+	time.Sleep(lease)
+	if owner, err := b.Claim("UNREACHABLE"); owner != "" || err != nil {
+		t.Errorf("claim after the lease ran out: %q, %v", owner, err)
+	}
+}
+
+// TestRouteToOwner checks a request for a room another instance owns is
+// passed on to it, marked as forwarded, whether the lease names the owner
+// by URL or by the bare host:port older instances claimed with.
+func TestRouteToOwner(t *testing.T) {
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + " " + r.Header.Get(forwardedHeader)))
+	}))
+	t.Cleanup(owner.Close)
+	f := newFakeRedis(t)
+	c := newRedisCluster(f.addr(), "http://self", 10*time.Second)
+	saved := cluster
+	cluster = c
+	t.Cleanup(func() {
+		cluster = saved
+		c.Close()
+	})
+
+	for id, addr := range map[string]string{"BYURL": owner.URL, "BYHOST": owner.Listener.Addr().String()} {
+		f.set(leaseKey(id), addr, time.Minute)
+		w := httptest.NewRecorder()
+		if !routeToOwner(w, httptest.NewRequest("GET", "/rooms/"+id+"/events", nil), id) {
+			t.Errorf("%s wasn't passed on to %s", id, addr)
+			continue
+		}
+		if got, want := w.Body.String(), "/rooms/"+id+"/events 1"; w.Code != http.StatusOK || got != want {
+			t.Errorf("%s: %d %q, want %q", id, w.Code, got, want)
+		}
+	}
+
+	forwarded := httptest.NewRequest("GET", "/rooms/BYURL/events", nil)
+	forwarded.Header.Set(forwardedHeader, "1")
+	if routeToOwner(httptest.NewRecorder(), forwarded, "BYURL") {
+		t.Error("a forwarded request was passed on again")
+	}
+}
+
+func TestOwnerURL(t *testing.T) {
+	for owner, want := range map[string]string{
+		"https://10.0.0.2:8443": "https://10.0.0.2:8443",
+		"http://10.0.0.2:8080":  "http://10.0.0.2:8080",
+		"10.0.0.2:8080":         "http://10.0.0.2:8080",
+		"node-2:8080":           "http://node-2:8080",
+	} {
+		if got := ownerURL(owner).String(); got != want {
+			t.Errorf("owner %s reached at %s, want %s", owner, got, want)
+		}
+	}
+}
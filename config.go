@@ -0,0 +1,495 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// Config holds the server's settings. Each is a flag that falls back to an
+// environment variable when it isn't given on the command line; the
+// defaults are what the server always ran with.
+type Config struct {
+	Listen string
+
+	// LogLevel is the least severe level logged, and LogFormat text or
+	// json.
+	LogLevel  string
+	LogFormat string
+
+	// TLSCert and TLSKey, given together, serve HTTPS and wss:// on
+	// Listen. RedirectListen, which needs them, is a plain HTTP address
+	// that redirects to it.
+	TLSCert        string
+	TLSKey         string
+	RedirectListen string
+
+	AllowedOrigins string
+	AllowNoOrigin  bool
+
+	// MaxConnsPerIP caps the websockets open from one address, which is
+	// taken from X-Forwarded-For with TrustProxy.
+	MaxConnsPerIP int
+	TrustProxy    bool
+
+	// ReadHeaderTimeout bounds how long a client may take to send a
+	// request's headers, websocket upgrades included. ReadTimeout bounds
+	// the whole request; 0 is no limit.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteWait         time.Duration
+	PingInterval      time.Duration
+	PongWait          time.Duration
+
+	// Compression negotiates permessage-deflate; frames smaller than
+	// CompressionThreshold bytes are sent uncompressed anyway.
+	Compression          bool
+	CompressionThreshold int
+
+	MaxMessageSize     int64
+	MaxRooms           int
+	MaxPlayers         int
+	MaxRoomConnections int
+
+	// ImplicitRooms creates a room for a websocket that joins a gameId
+	// nobody has created, as the server once did.
+	ImplicitRooms bool
+	// AllowDiceSeed lets rooms be created with a fixed diceSeed.
+	AllowDiceSeed bool
+
+	EmptyRoomGrace  time.Duration
+	RoomIdleTimeout time.Duration
+	ReapInterval    time.Duration
+	TurnTimeout     time.Duration
+
+	// DisconnectGrace is how long a player who drops mid-game has to come
+	// back before they lose their seat.
+	DisconnectGrace time.Duration
+
+	// AbandonAfter and AbandonIdle conclude games whose players have all
+	// been gone that long, or that have seen no move that long; 0 turns
+	// either off. AbandonWinner is AbandonLeader or AbandonNoWinner.
+	AbandonAfter  time.Duration
+	AbandonIdle   time.Duration
+	AbandonWinner string
+
+	StartingBalance int
+
+	// BotTurnDelay is the average pause between a bot's moves, and
+	// BotDecisionTimeout the longest a strategy may think.
+	BotTurnDelay       time.Duration
+	BotDecisionTimeout time.Duration
+
+	// AutoActionDelay is how long the server waits before making a move
+	// a player's preferences ask for.
+	AutoActionDelay time.Duration
+
+	// TournamentNoShow is how long a tournament table waits for its
+	// players before starting without them.
+	TournamentNoShow time.Duration
+
+	// EventRate and EventBurst limit the events each connection may
+	// send, ChatRate and ChatBurst its chat messages, and EmoteRate and
+	// EmoteBurst its emotes. A connection that
+	// goes over the limit RateLimitKick more times than it earns back at
+	// EventRate is dropped.
+	EventRate     float64
+	EventBurst    int
+	ChatRate      float64
+	ChatBurst     int
+	EmoteRate     float64
+	EmoteBurst    int
+	RateLimitKick int
+
+	// ChatRateMessages is how many chat messages a player may send in
+	// any ChatRateWindow, across all their connections.
+	ChatRateMessages int
+	ChatRateWindow   time.Duration
+
+	// VoteKickTimeout is how long a vote-kick stays open, and
+	// VoteKickCooldown how long a player must wait before starting another.
+	VoteKickTimeout  time.Duration
+	VoteKickCooldown time.Duration
+
+	// UndoWindow is how long after a move its player may ask to undo it,
+	// and UndoVoteTimeout how long the vote on it stays open.
+	UndoWindow      time.Duration
+	UndoVoteTimeout time.Duration
+
+	// MortgageTimeout is how long a player who was given mortgaged deeds
+	// has to choose which mortgages to lift before they are all kept.
+	MortgageTimeout time.Duration
+
+	// EventIDWindow is how many eventIds are remembered per player to
+	// spot retried events; 0 turns that off.
+	EventIDWindow int
+
+	Store       string
+	StorePath   string
+	StoreDriver string
+	StoreDSN    string
+	// StoreDebounce is how long changes to a room are gathered before it
+	// is saved.
+	StoreDebounce time.Duration
+	// LogRejected records refused events in the event log too.
+	LogRejected bool
+	// LogMemory is how many of its latest event log entries a room keeps
+	// in memory. Older ones are read back from the store, or are gone if
+	// it keeps nothing.
+	LogMemory int
+
+	// SavedGameRetention is how long a saved game can be resumed, and
+	// GameHistoryRetention how long finished games' summaries are kept
+	// (0 is forever).
+	SavedGameRetention   time.Duration
+	GameHistoryRetention time.Duration
+	// ResumeQuorum is the share of a saved game's players who must be back
+	// before it carries on by itself; RejoinApprovalWindow is how long a
+	// player the host let back in has to rejoin.
+	ResumeQuorum         float64
+	RejoinApprovalWindow time.Duration
+
+	// RedisAddr, if set, runs this instance as one of several sharing
+	// rooms through Redis, which needs a store they all share;
+	// InstanceAddr is where the others reach it, and RoomLease how long a
+	// claim on a room lasts unrenewed.
+	RedisAddr    string
+	InstanceAddr string
+	RoomLease    time.Duration
+
+	// ShutdownCountdown is how long rooms are warned before the server
+	// shuts down, and ShutdownTimeout how long it then waits for saves and
+	// requests to finish.
+	ShutdownCountdown time.Duration
+	ShutdownTimeout   time.Duration
+
+	// BoardsDir holds the board definitions rooms can pick with boardId,
+	// as <id>.json; BoardSize is how many squares a custom board has.
+	BoardsDir string
+	BoardSize int
+
+	// JWTSecret, JWTPublicKey and JWKSURL are ways of checking the access
+	// tokens players identify themselves with; with none of them set,
+	// everyone plays under the name they give. JWTIssuer and JWTAudience,
+	// if set, must match the token's. JWTAlg is the algorithm tokens
+	// checked with the secret or the public key are signed with, whatever
+	// they say. AllowGuests lets connections without a token play anyway.
+	JWTSecret    string
+	JWTPublicKey string
+	JWKSURL      string
+	JWTIssuer    string
+	JWTAudience  string
+	JWTAlg       string
+	AllowGuests  bool
+
+	// MatchWait is how long a queued player waits for the game size they
+	// want before settling for a smaller one; MatchTTL is how long a
+	// ticket lasts without being polled or watched.
+	MatchWait time.Duration
+	MatchTTL  time.Duration
+
+	// WebhookURLs are where game lifecycle events are POSTed, separated
+	// by commas, signed with WebhookSecret. A failed delivery is retried
+	// up to WebhookRetries times.
+	WebhookURLs    string
+	WebhookSecret  string
+	WebhookRetries int
+
+	// AdminToken is the bearer token for the /admin endpoints, which
+	// aren't served without one.
+	AdminToken string
+}
+
+// newConfig returns the default configuration.
+func newConfig() *Config {
+	return &Config{
+		Listen:               ":8080",
+		LogLevel:             "info",
+		LogFormat:            "text",
+		MaxConnsPerIP:        20,
+		Compression:          true,
+		CompressionThreshold: 512,
+		ReapInterval:         time.Minute,
+		DisconnectGrace:      3 * time.Minute,
+		BotTurnDelay:         time.Second,
+		BotDecisionTimeout:   500 * time.Millisecond,
+		ChatRateMessages:     5,
+		ChatRateWindow:       10 * time.Second,
+		VoteKickTimeout:      time.Minute,
+		VoteKickCooldown:     2 * time.Minute,
+		UndoWindow:           15 * time.Second,
+		UndoVoteTimeout:      30 * time.Second,
+		MortgageTimeout:      30 * time.Second,
+		StoreDebounce:        500 * time.Millisecond,
+		LogMemory:            1000,
+		SavedGameRetention:   30 * 24 * time.Hour,
+		GameHistoryRetention: 90 * 24 * time.Hour,
+		ResumeQuorum:         1,
+		RejoinApprovalWindow: 2 * time.Minute,
+		RoomLease:            10 * time.Second,
+		ShutdownCountdown:    10 * time.Second,
+		ShutdownTimeout:      10 * time.Second,
+		AllowedOrigins:       "*",
+		AllowNoOrigin:        true,
+		AllowGuests:          true,
+		ReadHeaderTimeout:    10 * time.Second,
+		WriteWait:            10 * time.Second,
+		PingInterval:         50 * time.Second,
+		PongWait:             60 * time.Second,
+		MaxMessageSize:       4096,
+		MaxRooms:             1000,
+		MaxPlayers:           len(tokens),
+		MaxRoomConnections:   50,
+		EmptyRoomGrace:       2 * time.Minute,
+		RoomIdleTimeout:      2 * time.Hour,
+		TurnTimeout:          90 * time.Second,
+		AbandonAfter:         15 * time.Minute,
+		AbandonIdle:          time.Hour,
+		AbandonWinner:        AbandonNoWinner,
+		StartingBalance:      1500,
+		AutoActionDelay:      time.Second,
+		TournamentNoShow:     10 * time.Minute,
+		EventRate:            10,
+		EventBurst:           20,
+		ChatRate:             0.5,
+		ChatBurst:            5,
+		EmoteRate:            0.5,
+		EmoteBurst:           3,
+		RateLimitKick:        100,
+		EventIDWindow:        100,
+		WebhookRetries:       4,
+		MatchWait:            30 * time.Second,
+		MatchTTL:             time.Minute,
+		BoardSize:            game.DefaultBoardSize,
+		Store:                "sql",
+		StorePath:            "data",
+		StoreDriver:          "sqlite3",
+		StoreDSN:             "monopoly.db",
+	}
+}
+
+// configFlag pairs a flag with the environment variable it falls back to.
+type configFlag struct {
+	name string
+	env  string
+}
+
+// register adds c's flags to fs, with c's values as their defaults, and
+// returns them.
+func (c *Config) register(fs *flag.FlagSet) []configFlag {
+	var flags []configFlag
+	add := func(name, env, usage string, define func(name, usage string)) {
+		define(name, usage+" (env "+env+")")
+		flags = append(flags, configFlag{name, env})
+	}
+	str := func(p *string, name, env, usage string) {
+		add(name, env, usage, func(name, usage string) { fs.StringVar(p, name, *p, usage) })
+	}
+	dur := func(p *time.Duration, name, env, usage string) {
+		add(name, env, usage, func(name, usage string) { fs.DurationVar(p, name, *p, usage) })
+	}
+	num := func(p *int, name, env, usage string) {
+		add(name, env, usage, func(name, usage string) { fs.IntVar(p, name, *p, usage) })
+	}
+	float := func(p *float64, name, env, usage string) {
+		add(name, env, usage, func(name, usage string) { fs.Float64Var(p, name, *p, usage) })
+	}
+	boolean := func(p *bool, name, env, usage string) {
+		add(name, env, usage, func(name, usage string) { fs.BoolVar(p, name, *p, usage) })
+	}
+
+	str(&c.Listen, "listen", "LISTEN_ADDR", "address to serve HTTP and websockets on")
+	str(&c.LogLevel, "log-level", "LOG_LEVEL", "least severe log level shown: debug, info, warn or error")
+	str(&c.LogFormat, "log-format", "LOG_FORMAT", "log output format: text or json")
+	str(&c.TLSCert, "tls-cert", "TLS_CERT_FILE", "PEM certificate chain to serve HTTPS and wss:// with; empty serves plain HTTP")
+	str(&c.TLSKey, "tls-key", "TLS_KEY_FILE", "PEM private key for -tls-cert")
+	str(&c.RedirectListen, "redirect-listen", "REDIRECT_ADDR", "plain HTTP address, such as :80, that redirects to HTTPS; empty for none")
+	str(&c.AllowedOrigins, "allowed-origins", "ALLOWED_ORIGINS", "comma-separated origins allowed to open websockets, or * for any")
+	boolean(&c.AllowNoOrigin, "allow-no-origin", "ALLOW_NO_ORIGIN", "accept websocket requests without an Origin header (native and CLI clients)")
+	num(&c.MaxConnsPerIP, "max-conns-per-ip", "MAX_CONNS_PER_IP", "maximum concurrent websocket connections from one remote address")
+	boolean(&c.TrustProxy, "trust-proxy", "TRUST_PROXY", "take the client address from X-Forwarded-For (only behind a proxy that sets it)")
+	dur(&c.ReadHeaderTimeout, "read-header-timeout", "READ_HEADER_TIMEOUT", "how long a client has to send a request's headers")
+	dur(&c.ReadTimeout, "read-timeout", "READ_TIMEOUT", "how long a client has to send a whole request; 0 for no limit")
+	dur(&c.WriteWait, "write-wait", "WRITE_WAIT", "deadline for writing a single websocket frame")
+	dur(&c.PingInterval, "ping-interval", "PING_INTERVAL", "how often to ping each websocket connection")
+	dur(&c.PongWait, "pong-wait", "PONG_WAIT", "how long to wait for a pong before dropping the connection")
+	boolean(&c.Compression, "compression", "COMPRESSION", "negotiate permessage-deflate with clients that support it")
+	num(&c.CompressionThreshold, "compression-threshold", "COMPRESSION_THRESHOLD", "smallest frame in bytes worth compressing")
+	add("max-message-size", "MAX_MESSAGE_SIZE", "largest inbound websocket message in bytes", func(name, usage string) {
+		fs.Int64Var(&c.MaxMessageSize, name, c.MaxMessageSize, usage)
+	})
+	num(&c.MaxRooms, "max-rooms", "MAX_ROOMS", "maximum number of rooms the hub will hold")
+	num(&c.MaxPlayers, "max-players", "MAX_PLAYERS", "most players a room may seat, and the number rooms seat unless told otherwise")
+	num(&c.MaxRoomConnections, "max-room-connections", "MAX_ROOM_CONNECTIONS", "maximum players plus spectators connected to one room")
+	boolean(&c.ImplicitRooms, "implicit-rooms", "IMPLICIT_ROOMS", "create a room when a websocket joins an unknown gameId (legacy behavior)")
+	boolean(&c.AllowDiceSeed, "allow-dice-seed", "ALLOW_DICE_SEED", "let rooms be created with a fixed diceSeed, for demos and debugging; never in production")
+	dur(&c.EmptyRoomGrace, "empty-room-grace", "EMPTY_ROOM_GRACE", "how long a room with no connections is kept for reconnects")
+	dur(&c.RoomIdleTimeout, "room-idle-timeout", "ROOM_IDLE_TIMEOUT", "remove rooms with no activity for this long, even with connections attached")
+	dur(&c.ReapInterval, "reap-interval", "REAP_INTERVAL", "how often to look for idle rooms")
+	dur(&c.TurnTimeout, "turn-timeout", "TURN_TIMEOUT", "default time a player has to finish their turn")
+	dur(&c.DisconnectGrace, "disconnect-grace", "DISCONNECT_GRACE", "how long a player who drops mid-game has to reconnect before losing their seat")
+	dur(&c.AbandonAfter, "abandon-after", "ABANDON_AFTER", "conclude a game once none of its players has been connected for this long; 0 never")
+	dur(&c.AbandonIdle, "abandon-idle", "ABANDON_IDLE", "conclude a game once no player has made a move for this long; 0 never")
+	str(&c.AbandonWinner, "abandon-winner", "ABANDON_WINNER", "who wins an abandoned game: leader (the richest player) or none")
+	num(&c.StartingBalance, "starting-balance", "STARTING_BALANCE", "money each player starts with unless the room's house rules say otherwise")
+	dur(&c.BotTurnDelay, "bot-turn-delay", "BOT_TURN_DELAY", "average pause between a bot's moves, so people can follow them")
+	dur(&c.BotDecisionTimeout, "bot-decision-timeout", "BOT_DECISION_TIMEOUT", "longest a bot strategy may take to decide; after that it declines")
+	dur(&c.TournamentNoShow, "tournament-no-show", "TOURNAMENT_NO_SHOW", "how long a tournament table waits for its players before starting without them")
+	dur(&c.AutoActionDelay, "auto-action-delay", "AUTO_ACTION_DELAY", "pause before the server makes a move a player's preferences ask for")
+	float(&c.EventRate, "event-rate", "EVENT_RATE", "events per second each connection may send on average")
+	num(&c.EventBurst, "event-burst", "EVENT_BURST", "events a connection may send at once before event-rate applies")
+	float(&c.ChatRate, "chat-rate", "CHAT_RATE", "chat messages per second each connection may send on average")
+	num(&c.ChatBurst, "chat-burst", "CHAT_BURST", "chat messages a connection may send at once before chat-rate applies")
+	float(&c.EmoteRate, "emote-rate", "EMOTE_RATE", "emotes per second each connection may send on average")
+	num(&c.EmoteBurst, "emote-burst", "EMOTE_BURST", "emotes a connection may send at once before emote-rate applies")
+	num(&c.ChatRateMessages, "chat-rate-messages", "CHAT_RATE_MESSAGES", "chat messages a player may send per chat-rate-window")
+	dur(&c.ChatRateWindow, "chat-rate-window", "CHAT_RATE_WINDOW", "window for chat flood control")
+	dur(&c.VoteKickTimeout, "vote-kick-timeout", "VOTE_KICK_TIMEOUT", "how long a vote-kick stays open")
+	dur(&c.VoteKickCooldown, "vote-kick-cooldown", "VOTE_KICK_COOLDOWN", "how long a player must wait between starting vote-kicks")
+	dur(&c.UndoWindow, "undo-window", "UNDO_WINDOW", "how long after a move its player may ask to undo it")
+	dur(&c.UndoVoteTimeout, "undo-vote-timeout", "UNDO_VOTE_TIMEOUT", "how long a vote to undo a move stays open")
+	dur(&c.MortgageTimeout, "mortgage-timeout", "MORTGAGE_TIMEOUT", "how long a player given mortgaged deeds has to choose which mortgages to lift")
+	num(&c.RateLimitKick, "rate-limit-kick", "RATE_LIMIT_KICK", "how far over its rate limit a connection may go before it is disconnected")
+	num(&c.EventIDWindow, "event-id-window", "EVENT_ID_WINDOW", "eventIds remembered per player so retried events aren't applied twice; 0 to turn off")
+	str(&c.Store, "store", "STORE", "where rooms are persisted: memory (not at all), file or sql (SQLite by default)")
+	str(&c.StorePath, "store-path", "STORE_PATH", "directory for -store=file")
+	str(&c.StoreDriver, "store-driver", "STORE_DRIVER", "database/sql driver for -store=sql; it must be linked into the binary")
+	str(&c.StoreDSN, "store-dsn", "STORE_DSN", "data source name for -store=sql")
+	dur(&c.StoreDebounce, "store-debounce", "STORE_DEBOUNCE", "how long to gather changes to a room before saving it")
+	boolean(&c.LogRejected, "log-rejected", "LOG_REJECTED", "also record events rejected with an error in each game's event log")
+	num(&c.LogMemory, "log-memory", "LOG_MEMORY", "how many of each room's latest event log entries to keep in memory")
+	dur(&c.SavedGameRetention, "saved-game-retention", "SAVED_GAME_RETENTION", "how long a saved game can be resumed before it is deleted")
+	dur(&c.GameHistoryRetention, "game-history-retention", "GAME_HISTORY_RETENTION", "how long summaries of finished games are kept; 0 keeps them forever")
+	float(&c.ResumeQuorum, "resume-quorum", "RESUME_QUORUM", "fraction of a saved game's players who must reconnect before it carries on by itself")
+	dur(&c.RejoinApprovalWindow, "rejoin-approval-window", "REJOIN_APPROVAL_WINDOW", "how long a player the host let back in has to rejoin without a session token")
+	str(&c.RedisAddr, "redis-addr", "REDIS_ADDR", "Redis address for running several instances side by side; empty runs a single instance")
+	str(&c.InstanceAddr, "instance-addr", "INSTANCE_ADDR", "host:port other instances use to reach this one (required with -redis-addr), over https with -tls-cert; prefix http:// or https:// to choose")
+	dur(&c.RoomLease, "room-lease", "ROOM_LEASE", "how long an instance's claim on a room lasts without being renewed")
+	str(&c.BoardsDir, "boards-dir", "BOARDS_DIR", "directory of board definitions rooms can pick by boardId; empty for none")
+	num(&c.BoardSize, "board-size", "BOARD_SIZE", "number of squares a custom board must have")
+	str(&c.JWTSecret, "jwt-secret", "JWT_SECRET", "secret for access tokens signed with HS256, HS384 or HS512")
+	str(&c.JWTPublicKey, "jwt-public-key", "JWT_PUBLIC_KEY", "PEM file with the public key access tokens are signed with")
+	str(&c.JWKSURL, "jwks-url", "JWKS_URL", "URL of the JWKS with the keys access tokens are signed with")
+	str(&c.JWTIssuer, "jwt-issuer", "JWT_ISSUER", "iss access tokens must have; empty for any")
+	str(&c.JWTAudience, "jwt-audience", "JWT_AUDIENCE", "aud access tokens must include; empty for any")
+	str(&c.JWTAlg, "jwt-alg", "JWT_ALG", "algorithm access tokens checked with jwt-secret or jwt-public-key are signed with; by default HS256 for the secret and the usual one for the key's type")
+	boolean(&c.AllowGuests, "allow-guests", "ALLOW_GUESTS", "let connections without an access token play under a name of their choosing")
+	dur(&c.MatchWait, "match-wait", "MATCH_WAIT", "how long a quick match player waits for a full game before a smaller one will do")
+	dur(&c.MatchTTL, "match-ttl", "MATCH_TTL", "how long a quick match ticket lasts without being polled or watched")
+	str(&c.WebhookURLs, "webhook-urls", "WEBHOOK_URLS", "comma-separated URLs to POST game started, game over and forfeit events to")
+	str(&c.WebhookSecret, "webhook-secret", "WEBHOOK_SECRET", "key for the HMAC-SHA256 signature sent with webhooks")
+	num(&c.WebhookRetries, "webhook-retries", "WEBHOOK_RETRIES", "how many times a failed webhook delivery is retried")
+	str(&c.AdminToken, "admin-token", "ADMIN_TOKEN", "bearer token for the /admin endpoints; empty disables them")
+	dur(&c.ShutdownCountdown, "shutdown-countdown", "SHUTDOWN_COUNTDOWN", "how long rooms are warned before the server shuts down")
+	dur(&c.ShutdownTimeout, "shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long to wait for state to be saved and requests to finish once rooms are closed")
+	return flags
+}
+
+// loadConfig registers the configuration's flags on fs, parses args, then
+// fills in anything not given there from the environment. The result is
+// validated.
+func loadConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := newConfig()
+	flags := c.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, f := range flags {
+		v, ok := os.LookupEnv(f.env)
+		if !ok || given[f.name] {
+			continue
+		}
+		if err := fs.Set(f.name, v); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", f.env, v, err)
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// validate rejects settings the server can't run with.
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Listen != "", "listen must not be empty")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log-level must be debug, info, warn or error")
+	check(c.LogFormat == "text" || c.LogFormat == "json", "log-format must be text or json")
+	check((c.TLSCert == "") == (c.TLSKey == ""), "tls-cert and tls-key must be given together")
+	check(c.RedirectListen == "" || c.TLSCert != "", "redirect-listen needs tls-cert and tls-key")
+	check(c.RedirectListen == "" || c.RedirectListen != c.Listen, "redirect-listen must differ from listen")
+	check(c.ReadHeaderTimeout > 0, "read-header-timeout must be positive")
+	check(c.ReadTimeout >= 0, "read-timeout must not be negative")
+	check(c.WriteWait > 0, "write-wait must be positive")
+	check(c.PingInterval > 0 && c.PingInterval < c.PongWait, "ping-interval must be positive and shorter than pong-wait")
+	check(c.MaxConnsPerIP > 0, "max-conns-per-ip must be positive")
+	check(c.CompressionThreshold >= 0, "compression-threshold must not be negative")
+	check(c.MaxMessageSize > 0, "max-message-size must be positive")
+	check(c.MaxRooms > 0, "max-rooms must be positive")
+	check(c.MaxPlayers >= defaultMinPlayers && c.MaxPlayers <= len(tokens), "max-players must be between %d and %d", defaultMinPlayers, len(tokens))
+	check(c.MaxRoomConnections >= c.MaxPlayers, "max-room-connections must be at least max-players")
+	check(c.EmptyRoomGrace > 0, "empty-room-grace must be positive")
+	check(c.RoomIdleTimeout > 0, "room-idle-timeout must be positive")
+	check(c.ReapInterval > 0, "reap-interval must be positive")
+	check(c.TurnTimeout > 0, "turn-timeout must be positive")
+	check(c.DisconnectGrace > 0, "disconnect-grace must be positive")
+	check(c.AbandonAfter >= 0 && c.AbandonIdle >= 0, "abandon-after and abandon-idle must not be negative")
+	check(c.AbandonWinner == AbandonLeader || c.AbandonWinner == AbandonNoWinner, "abandon-winner must be leader or none")
+	check(c.StartingBalance >= 1 && c.StartingBalance <= maxStartingBalance, "starting-balance must be between 1 and %d", maxStartingBalance)
+	check(c.AutoActionDelay >= 0, "auto-action-delay must not be negative")
+	check(c.BotTurnDelay >= 0 && c.BotDecisionTimeout > 0, "bot-turn-delay must not be negative and bot-decision-timeout must be positive")
+	check(c.TournamentNoShow > 0, "tournament-no-show must be positive")
+	check(c.EventRate > 0 && c.EventBurst >= 1, "event-rate must be positive and event-burst at least 1")
+	check(c.ChatRate > 0 && c.ChatBurst >= 1, "chat-rate must be positive and chat-burst at least 1")
+	check(c.EmoteRate > 0 && c.EmoteBurst >= 1, "emote-rate must be positive and emote-burst at least 1")
+	check(c.RateLimitKick >= 1, "rate-limit-kick must be at least 1")
+	check(c.ChatRateMessages >= 1 && c.ChatRateWindow > 0, "chat-rate-messages must be at least 1 and chat-rate-window positive")
+	check(c.VoteKickTimeout > 0 && c.VoteKickCooldown >= 0, "vote-kick-timeout must be positive and vote-kick-cooldown not negative")
+	check(c.UndoWindow > 0 && c.UndoVoteTimeout > 0, "undo-window and undo-vote-timeout must be positive")
+	check(c.MortgageTimeout > 0, "mortgage-timeout must be positive")
+	check(c.EventIDWindow >= 0, "event-id-window must not be negative")
+	check(c.BoardSize >= 4, "board-size must be at least 4")
+	check(c.JWTAlg == "" || jwtAlgs[c.JWTAlg], "jwt-alg %q isn't supported", c.JWTAlg)
+	check(c.AllowGuests || c.JWTSecret != "" || c.JWTPublicKey != "" || c.JWKSURL != "", "allow-guests can only be turned off with jwt-secret, jwt-public-key or jwks-url")
+	check(c.MatchWait > 0 && c.MatchTTL > 0, "match-wait and match-ttl must be positive")
+	check(c.WebhookRetries >= 0, "webhook-retries must not be negative")
+	for _, u := range strings.Split(c.WebhookURLs, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			parsed, err := url.Parse(u)
+			check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "", "webhook-urls: %q is not an http or https URL", u)
+		}
+	}
+	check(c.Store == "memory" || c.Store == "file" || c.Store == "sql", "store must be memory, file or sql")
+	check(c.StoreDebounce > 0, "store-debounce must be positive")
+	check(c.LogMemory > 0, "log-memory must be positive")
+	check(c.SavedGameRetention > 0 && c.GameHistoryRetention >= 0, "saved-game-retention must be positive and game-history-retention not negative")
+	check(c.ResumeQuorum >= 0 && c.ResumeQuorum <= 1, "resume-quorum must be between 0 and 1")
+	check(c.RejoinApprovalWindow > 0, "rejoin-approval-window must be positive")
+	check(c.RedisAddr == "" || c.InstanceAddr != "", "instance-addr is required with redis-addr")
+	if strings.Contains(c.InstanceAddr, "://") {
+		parsed, err := url.Parse(c.InstanceAddr)
+		check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "", "instance-addr: %q is not host:port or an http or https URL", c.InstanceAddr)
+	}
+	// Each instance restores the rooms it takes over from the store, so
+	// they must all see the same one: not memory, files or SQLite, which
+	// live on one machine.
+	check(c.RedisAddr == "" || (c.Store == "sql" && c.StoreDriver != "sqlite3"), "redis-addr needs a store every instance shares: -store=sql with a database server's -store-driver, not SQLite")
+	check(c.RoomLease > 0, "room-lease must be positive")
+	check(c.ShutdownCountdown >= 0 && c.ShutdownTimeout > 0, "shutdown-countdown must not be negative and shutdown-timeout must be positive")
+	return errors.Join(errs...)
+}
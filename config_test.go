@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseConfig(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return loadConfig(fs, args)
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg, err := parseConfig(t)
+	if err != nil {
+		t.Fatalf("defaults don't validate: %v", err)
+	}
+	if cfg.Listen != ":8080" || cfg.StartingBalance != 1500 || cfg.DisconnectGrace != 3*time.Minute || !cfg.Compression {
+		t.Errorf("defaults changed: %+v", cfg)
+	}
+}
+
+func TestConfigEnvironmentFallback(t *testing.T) {
+	t.Setenv("DISCONNECT_GRACE", "5s")
+	t.Setenv("TRUST_PROXY", "true")
+	t.Setenv("STARTING_BALANCE", "2000")
+	cfg, err := parseConfig(t, "-starting-balance=3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DisconnectGrace != 5*time.Second || !cfg.TrustProxy {
+		t.Errorf("environment not used: grace %v, trust proxy %v", cfg.DisconnectGrace, cfg.TrustProxy)
+	}
+	if cfg.StartingBalance != 3000 {
+		t.Errorf("flag should win over the environment, got balance %d", cfg.StartingBalance)
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-log-format=xml"}, "log-format"},
+		{[]string{"-redis-addr=localhost:6379"}, "instance-addr"},
+		{[]string{"-redis-addr=localhost:6379", "-instance-addr=10.0.0.2:8080"}, "store every instance shares"},
+		{[]string{"-redis-addr=localhost:6379", "-instance-addr=10.0.0.2:8080", "-store=memory"}, "store every instance shares"},
+		{[]string{"-instance-addr=ftp://10.0.0.2"}, "instance-addr"},
+		{[]string{"-resume-quorum=2"}, "resume-quorum"},
+		{[]string{"-disconnect-grace=0"}, "disconnect-grace"},
+		{[]string{"-tls-cert=cert.pem"}, "tls-key"},
+		{[]string{"-jwt-alg=none"}, "jwt-alg"},
+	} {
+		_, err := parseConfig(t, tc.args...)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: got error %v, want one about %s", tc.args, err, tc.want)
+		}
+	}
+	t.Setenv("CHAT_RATE_WINDOW", "soon")
+	if _, err := parseConfig(t); err == nil || !strings.Contains(err.Error(), "CHAT_RATE_WINDOW") {
+		t.Errorf("bad environment value: got %v", err)
+	}
+}
+
+func TestConfigInstanceURL(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "http://10.0.0.2:8080"},
+		{[]string{"-tls-cert=cert.pem", "-tls-key=key.pem"}, "https://10.0.0.2:8080"},
+		{[]string{"-instance-addr=http://10.0.0.2:8080", "-tls-cert=cert.pem", "-tls-key=key.pem"}, "http://10.0.0.2:8080"},
+	} {
+		args := append([]string{"-redis-addr=localhost:6379", "-instance-addr=10.0.0.2:8080", "-store-driver=postgres"}, tc.args...)
+		cfg, err := parseConfig(t, args...)
+		if err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		if got := instanceURL(cfg); got != tc.want {
+			t.Errorf("%v: instance URL %s, want %s", tc.args, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "encoding/json"
+
+// Clients may tag an event with an eventId so that retrying it is safe:
+// the room remembers the last Config.EventIDWindow eventIds each player
+// sent and how each was answered, and a repeat is answered the same way
+// again instead of being applied a second time. The memory belongs to the
+// player's session, so it survives a reconnect with their token but not a
+// new session. Spectators' eventIds aren't tracked.
+
+type DuplicatePayload struct {
+	EventID string `json:"eventId"`
+}
+
+// eventOutcomes is what a player's recent eventIds were answered with:
+// nil for an event that was handled, otherwise the error it was refused
+// with. order keeps them oldest first.
+type eventOutcomes struct {
+	outcomes map[string]*ErrorPayload
+	order    []string
+}
+
+// answerDuplicate reports whether event repeats one name has already
+// sent, answering it if so. It must run on the room's goroutine.
+func (room *GameRoom) answerDuplicate(client *Client, name string, event GameEvent) bool {
+	seen := room.eventIDs[name]
+	if event.EventID == "" || seen == nil {
+		return false
+	}
+	outcome, ok := seen.outcomes[event.EventID]
+	if !ok {
+		return false
+	}
+	room.clientLog(client).Debug("duplicate event", "event", event.Event, "eventId", event.EventID)
+	metrics.Inc(metricDuplicateEvents, eventLabel(event.Event))
+	if outcome != nil {
+		SendError(client, room.ID, event.RequestID, outcome.Code, outcome.Message)
+		return true
+	}
+	data, _ := json.Marshal(GameEvent{Event: "DUPLICATE", GameID: room.ID, RequestID: event.RequestID, Payload: DuplicatePayload{EventID: event.EventID}})
+	client.Send(newOutboundMessage(0, data))
+	return true
+}
+
+// rememberEvent records how event from name was answered, forgetting the
+// oldest eventId once there are more than the window allows. It must run
+// on the room's goroutine.
+func (room *GameRoom) rememberEvent(name string, event GameEvent, outcome *ErrorPayload) {
+	window := hub.config.EventIDWindow
+	if event.EventID == "" || window == 0 {
+		return
+	}
+	seen := room.eventIDs[name]
+	if seen == nil {
+		seen = &eventOutcomes{outcomes: make(map[string]*ErrorPayload)}
+		room.eventIDs[name] = seen
+	}
+	seen.outcomes[event.EventID] = outcome
+	seen.order = append(seen.order, event.EventID)
+	for len(seen.order) > window {
+		delete(seen.outcomes, seen.order[0])
+		seen.order = seen.order[1:]
+	}
+}
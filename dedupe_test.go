@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// replyTo returns the reply to requestID among c's queued messages.
+func replyTo(t *testing.T, c *Client, requestID string) reply {
+	t.Helper()
+	for _, r := range replies(t, c) {
+		if r.RequestID == requestID {
+			return r
+		}
+	}
+	t.Fatalf("no reply to %s", requestID)
+	return reply{}
+}
+
+// dedupeGame starts a game between ann and bob in which ann's first roll
+// offers ann a property.
+func dedupeGame(t *testing.T) (room *GameRoom, ann, bob *Client) {
+	t.Helper()
+	for seed := int64(1); seed < 100; seed++ {
+		opts := defaultRoomOptions()
+		opts.DiceSeed = &seed
+		room, ann, bob = newGameRoom("DEDUPE", opts), fakeClient(), fakeClient()
+		room.do(func() {
+			seat(room, ann, "ann")
+			seat(room, bob, "bob")
+		})
+		handleGameEvent(room, GameEvent{Event: "START_GAME"}, ann)
+		handleGameEvent(room, GameEvent{Event: "ROLL_DICE"}, ann)
+		offered := false
+		room.do(func() { offered = room.GameState.Offer != "" })
+		if offered {
+			t.Cleanup(room.abandon)
+			replies(t, ann)
+			return room, ann, bob
+		}
+		room.abandon()
+	}
+	t.Fatal("no dice seed offers ann a property")
+	return nil, nil, nil
+}
+
+// TestDuplicateEvents has ann send the same purchase three times, and again
+// after reconnecting, and bob repeat a refused roll, and checks the purchase
+// is made once and each repeat is answered as the original was until it
+// falls out of the window.
+func TestDuplicateEvents(t *testing.T) {
+	hub.Mutex.Lock()
+	cfg := *hub.config
+	cfg.EventIDWindow = 3
+	saved := hub.config
+	hub.config = &cfg
+	hub.Mutex.Unlock()
+	t.Cleanup(func() {
+		hub.Mutex.Lock()
+		hub.config = saved
+		hub.Mutex.Unlock()
+	})
+	room, ann, bob := dedupeGame(t)
+	balance := func() (balance, properties int) {
+		room.do(func() {
+			ann := room.GameState.Players["ann"]
+			balance, properties = ann.Balance, len(ann.Properties)
+		})
+		return balance, properties
+	}
+	start, _ := balance()
+
+	for _, requestID := range []string{"r1", "r2", "r3"} {
+		handleGameEvent(room, GameEvent{Event: "BUY_PROPERTY", EventID: "buy-1", RequestID: requestID}, ann)
+	}
+	var duplicates []string
+	for _, r := range replies(t, ann) {
+		if r.Event == "DUPLICATE" {
+			var payload DuplicatePayload
+			json.Unmarshal(r.Payload, &payload)
+			duplicates = append(duplicates, r.RequestID+" "+payload.EventID)
+		}
+	}
+	if len(duplicates) != 2 || duplicates[0] != "r2 buy-1" || duplicates[1] != "r3 buy-1" {
+		t.Errorf("DUPLICATE for %q", duplicates)
+	}
+	bought, properties := balance()
+	if bought >= start || properties != 1 {
+		t.Fatalf("ann has %d of %d and %d properties after buying", bought, start, properties)
+	}
+
+	handleGameEvent(room, GameEvent{Event: "ROLL_DICE", EventID: "roll-1", RequestID: "b1"}, bob)
+	first := replyTo(t, bob, "b1")
+	handleGameEvent(room, GameEvent{Event: "ROLL_DICE", EventID: "roll-1", RequestID: "b2"}, bob)
+	again := replyTo(t, bob, "b2")
+	if first.Event != "ERROR" || again.Event != "ERROR" || string(again.Payload) != string(first.Payload) {
+		t.Errorf("bob's roll refused with %s %s, then %s %s", first.Event, first.Payload, again.Event, again.Payload)
+	}
+
+	// The eventIds are kept across a reconnect with the session token.
+	back := fakeClient()
+	room.do(func() {
+		delete(room.Players, ann)
+		room.Players[back] = "ann"
+	})
+	handleGameEvent(room, GameEvent{Event: "BUY_PROPERTY", EventID: "buy-1", RequestID: "r4"}, back)
+	if r := replyTo(t, back, "r4"); r.Event != "DUPLICATE" {
+		t.Errorf("after reconnecting, the purchase was answered with %s", r.Event)
+	}
+
+	// Three more push buy-1 out of the window, so it is applied again,
+	// and refused now there is nothing on offer.
+	for _, eventID := range []string{"c1", "c2", "c3"} {
+		handleGameEvent(room, GameEvent{Event: "STATE_SYNC", EventID: eventID, RequestID: eventID}, back)
+	}
+	replies(t, back)
+	handleGameEvent(room, GameEvent{Event: "BUY_PROPERTY", EventID: "buy-1", RequestID: "r5"}, back)
+	if r := replyTo(t, back, "r5"); r.Event != "ERROR" {
+		t.Errorf("the purchase out of the window was answered with %s", r.Event)
+	}
+	if now, properties := balance(); now != bought || properties != 1 {
+		t.Errorf("ann has %d and %d properties; want %d and 1", now, properties, bought)
+	}
+}
+
+// TestDuplicateEventsNewSession checks an eventId from a session that has
+// ended isn't taken for a repeat in the next.
+func TestDuplicateEventsNewSession(t *testing.T) {
+	room, _, bob := dedupeGame(t)
+	handleGameEvent(room, GameEvent{Event: "STATE_SYNC", EventID: "sync-1", RequestID: "b1"}, bob)
+	if r := replyTo(t, bob, "b1"); r.Event != "STATE" {
+		t.Fatalf("STATE_SYNC answered with %s", r.Event)
+	}
+	handleGameEvent(room, GameEvent{Event: "STATE_SYNC", EventID: "sync-1", RequestID: "b2"}, bob)
+	if r := replyTo(t, bob, "b2"); r.Event != "DUPLICATE" {
+		t.Errorf("repeated STATE_SYNC answered with %s", r.Event)
+	}
+
+	room.do(func() {
+		room.revokeSession("bob")
+		room.issueSession("bob")
+	})
+	handleGameEvent(room, GameEvent{Event: "STATE_SYNC", EventID: "sync-1", RequestID: "b3"}, bob)
+	if r := replyTo(t, bob, "b3"); r.Event != "STATE" {
+		t.Errorf("STATE_SYNC in a new session answered with %s", r.Event)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// DICE_STATS asks how the game's dice have rolled so far, for anyone who
+// suspects them: the spread of totals against what fair dice would give,
+// the doubles and every roll, per player. It is answered to the sender
+// alone, and any player or spectator may ask. GAME_OVER carries the
+// totals without the rolls, and replays carry the rolls.
+
+// DiceStatsSummary is the game's dice statistics with what fair dice would
+// have given: Expected[i] is the average number of rolls coming to i+2,
+// and ChiSquare how far the totals are from it.
+type DiceStatsSummary struct {
+	*game.DiceStats
+	Expected  [11]float64 `json:"expected"`
+	ChiSquare float64     `json:"chiSquare"`
+}
+
+// DiceStatsPayload answers DICE_STATS. History lists each player's rolls,
+// oldest first.
+type DiceStatsPayload struct {
+	DiceStatsSummary
+	History map[string][]game.DiceRoll `json:"history"`
+}
+
+// diceStatsSummary sums up the room's rolls. It must run on the room's
+// goroutine.
+func (room *GameRoom) diceStatsSummary() DiceStatsSummary {
+	stats := room.GameState.DiceStats
+	if stats == nil {
+		stats = &game.DiceStats{Players: map[string]*game.PlayerDiceStats{}}
+	}
+	return DiceStatsSummary{DiceStats: stats, Expected: stats.Expected(), ChiSquare: stats.ChiSquare()}
+}
+
+// HandleDiceStatsEvent answers a DICE_STATS request.
+func HandleDiceStatsEvent(room *GameRoom, event GameEvent, client *Client) {
+	payload := DiceStatsPayload{DiceStatsSummary: room.diceStatsSummary(), History: make(map[string][]game.DiceRoll)}
+	for name := range payload.Players {
+		payload.History[name] = room.GameState.RollHistory(name)
+	}
+	data, err := json.Marshal(GameEvent{Event: "DICE_STATS", GameID: room.ID, Seq: room.seq, RequestID: event.RequestID, Payload: payload})
+	if err != nil {
+		room.logger().Error("encoding dice stats", "err", err)
+		return
+	}
+	client.Send(newOutboundMessage(0, data))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// What happens to a disconnected player's turns while their grace period
+// runs, set per room with RoomOptions.DisconnectTurns.
+//
+// With DisconnectSkip the turn order passes over them: if it was their
+// turn when they dropped it moves to the next seat straight away, and
+// later rotations skip them until they reconnect. With DisconnectWait the
+// turn stays where it is, so if it was theirs the game waits for them.
+//
+// With RoomOptions.AutoPause, a player dropping on their own turn pauses
+// the game instead, grace clock included, until they return or the host
+// resumes it.
+//
+// Either way, when the grace period ends without a reconnect the player
+// is forfeited, or handed to a bot if RoomOptions.BotTakeover is set.
+const (
+	DisconnectSkip = "skip"
+	DisconnectWait = "wait"
+)
+
+var errDisconnectTurns = errors.New(`disconnectTurns must be "skip" or "wait"`)
+
+type DisconnectedPayload struct {
+	RosterPayload
+	TurnPolicy  string    `json:"turnPolicy"`
+	ReconnectBy time.Time `json:"reconnectBy"`
+}
+
+// removeClient takes a connection that has gone away out of the room and
+// deals with whoever was on it: a spectator's departure is announced, and a
+// player with no other connection is marked disconnected, losing their
+// seat before the game starts or starting their grace period during it.
+// Both the read loop and a broadcast that fails to reach the client call
+// it, so it does nothing for a connection that has already been removed.
+// It must run on the room's goroutine.
+func (room *GameRoom) removeClient(client *Client) {
+	room.Unsubscribe(client)
+	log := room.clientLog(client)
+	if name, ok := room.Spectators[client]; ok {
+		delete(room.Spectators, client)
+		if room.eliminated[client] {
+			delete(room.eliminated, client)
+			room.GameState.Players[name].Connected = false
+		}
+		log.Info("spectator disconnected")
+		SendGameEventToAll(room, "SPECTATOR_LEFT", room.ID, SpectatorsPayload{Spectators: len(room.Spectators)})
+	} else if name, ok := room.Players[client]; ok {
+		delete(room.Players, client)
+		if clientFor(room, name) == nil {
+			// Nobody has taken over this player with a reconnect.
+			log.Info("player disconnected")
+			room.playerDisconnected(name)
+		}
+	} else {
+		return
+	}
+	if room.connectionCount() == 0 {
+		room.scheduleEmptyCheck()
+	}
+}
+
+// playerDisconnected handles name's last connection going away. It must
+// run on the room's goroutine.
+func (room *GameRoom) playerDisconnected(name string) {
+	if room.GameState.Status == StatusWaiting {
+		// Free the seat so an absent player can't hold up the start.
+		delete(room.GameState.Players, name)
+		delete(room.playerIDs, name)
+		room.removeSeat(name)
+		room.revokeSession(name)
+		SendGameEventToAll(room, "PLAYER_LEFT", room.ID, room.rosterPayload(name))
+	} else if player, ok := room.GameState.Players[name]; ok {
+		player.Connected = false
+		if room.GameState.Status == StatusInProgress && !player.Forfeited {
+			room.startGrace(name)
+		}
+		room.checkAbandoned()
+	}
+	if room.GameState.Host == name {
+		room.promoteHost(name)
+	}
+}
+
+// startGrace starts name's reconnect window. It must run on the room's
+// goroutine.
+func (room *GameRoom) startGrace(name string) {
+	room.cancelGrace(name)
+	room.leaveKickVote(name, "player disconnected")
+	room.leaveUndoVote(name)
+	deadline := time.Now().Add(hub.config.DisconnectGrace)
+	var timer *roomTimer
+	timer = newRoomTimer(hub.config.DisconnectGrace, func() {
+		room.do(func() { room.graceExpired(name, timer) })
+	})
+	room.graceTimers[name] = timer
+	SendGameEventToAll(room, "PLAYER_DISCONNECTED", room.ID, DisconnectedPayload{
+		RosterPayload: room.rosterPayload(name),
+		TurnPolicy:    room.Options.DisconnectTurns,
+		ReconnectBy:   deadline,
+	})
+	if room.GameState.Turn == name {
+		room.stopTurnTimer()
+	}
+	switch {
+	case room.GameState.Paused:
+		// The clock doesn't run while the game is paused.
+		timer.pause()
+	case room.GameState.Turn == name && room.Options.AutoPause:
+		room.pause(name, true)
+	case room.GameState.Turn == name && room.Options.DisconnectTurns == DisconnectSkip:
+		room.setTurn(room.nextSeat(name))
+	}
+}
+
+// cancelGrace stops name's reconnect window, if one is running. It must
+// run on the room's goroutine.
+func (room *GameRoom) cancelGrace(name string) {
+	if timer, ok := room.graceTimers[name]; ok {
+		timer.stop()
+		delete(room.graceTimers, name)
+	}
+}
+
+// cancelAllGrace stops every reconnect window in the room. It must run on
+// the room's goroutine.
+func (room *GameRoom) cancelAllGrace() {
+	for name := range room.graceTimers {
+		room.cancelGrace(name)
+	}
+}
+
+// graceExpired forfeits name, or hands their seat to a bot, unless they
+// came back or the game is already over. A timer that was stopped or
+// paused after it had already fired is ignored. It must run on the
+// room's goroutine.
+func (room *GameRoom) graceExpired(name string, timer *roomTimer) {
+	if room.graceTimers[name] != timer || room.GameState.Paused {
+		return
+	}
+	delete(room.graceTimers, name)
+	player, ok := room.GameState.Players[name]
+	if room.closed || room.GameState.Status != StatusInProgress || !ok || player.Connected || player.Forfeited {
+		return
+	}
+	if room.Options.BotTakeover {
+		player.Bot = true
+		room.bots[name] = strategies[defaultStrategy]()
+		room.logger().Info("bot took over", "player", name)
+		SendGameEventToAll(room, "BOT_TAKEOVER", room.ID, PlayerPayload{Player: name})
+		room.logAction("botTakeover", map[string]interface{}{"player": name})
+		if room.GameState.Turn == name {
+			room.scheduleBotTurn()
+		}
+		return
+	}
+	// Their session outlives the seat so they can come back to watch.
+	room.forfeitPlayer(name, "disconnected")
+}
+
+// nextSeat returns who plays after from, passing over absent players in a
+// room that skips them. It must run on the room's goroutine.
+func (room *GameRoom) nextSeat(from string) string {
+	return game.NextSeat(&room.GameState, from, room.Options.DisconnectTurns == DisconnectSkip)
+}
+
+// setTurn passes the turn to next and announces it. It must run on the
+// room's goroutine.
+func (room *GameRoom) setTurn(next string) {
+	game.PassTurn(&room.GameState, next)
+	room.turnPassed(next)
+}
+
+// turnPassed announces that it is next's turn, starts their clock and
+// lets a bot play if next is one. It must run on the room's goroutine.
+func (room *GameRoom) turnPassed(next string) {
+	SendGameEventToAll(room, "END_TURN", room.ID, map[string]string{"nextTurn": next, "phase": room.GameState.Phase})
+	room.startTurnTimer()
+	room.sendAvailableActions()
+	if player, ok := room.GameState.Players[next]; ok && player.Bot {
+		room.scheduleBotTurn()
+	}
+}
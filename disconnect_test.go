@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// disconnectGame starts a game between ann, bob and cat in a room with
+// options, and returns it with ann, whose turn it is, and bob.
+func disconnectGame(t *testing.T, ts *testServer, options map[string]interface{}) (*GameRoom, *testClient, *testClient) {
+	t.Helper()
+	options["minPlayers"] = 3
+	code := ts.createRoom(options)
+	clients := ts.startGame(code, "ann", "bob", "cat")
+	room := ts.room(code)
+	room.do(func() {
+		if room.GameState.Turn != "ann" {
+			t.Fatalf("%s's turn first", room.GameState.Turn)
+		}
+	})
+	return room, clients[0], clients[1]
+}
+
+// turnAndGrace returns whose turn it is and who has a grace period running.
+func turnAndGrace(room *GameRoom) (turn string, grace map[string]bool) {
+	grace = make(map[string]bool)
+	room.do(func() {
+		turn = room.GameState.Turn
+		for name := range room.graceTimers {
+			grace[name] = true
+		}
+	})
+	return turn, grace
+}
+
+func TestDisconnectSkipsTurn(t *testing.T) {
+	ts := newTestServer(t, nil)
+	room, ann, bob := disconnectGame(t, ts, map[string]interface{}{"disconnectTurns": DisconnectSkip})
+
+	ann.conn.Close()
+	bob.expect("PLAYER_DISCONNECTED")
+	bob.expectWhere("END_TURN", func(e receivedEvent) bool {
+		var payload map[string]string
+		e.decode(t, &payload)
+		return payload["nextTurn"] == "bob"
+	})
+	if turn, grace := turnAndGrace(room); turn != "bob" || !grace["ann"] {
+		t.Errorf("%s's turn, grace %v", turn, grace)
+	}
+}
+
+func TestDisconnectWaitsAndReconnects(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.DisconnectGrace = 200 * time.Millisecond })
+	room, ann, bob := disconnectGame(t, ts, map[string]interface{}{"disconnectTurns": DisconnectWait})
+
+	ann.conn.Close()
+	bob.expect("PLAYER_DISCONNECTED")
+	if turn, grace := turnAndGrace(room); turn != "ann" || !grace["ann"] {
+		t.Errorf("%s's turn, grace %v", turn, grace)
+	}
+	back := ts.join(room.ID, "", url.Values{"token": {ann.token}})
+	if back.name != "ann" {
+		t.Fatalf("reconnected as %s", back.name)
+	}
+	// Wait out what would have been the grace period.
+	time.Sleep(300 * time.Millisecond)
+	if turn, grace := turnAndGrace(room); turn != "ann" || len(grace) != 0 {
+		t.Errorf("%s's turn, grace %v", turn, grace)
+	}
+	room.do(func() {
+		if ann := room.GameState.Players["ann"]; !ann.Connected || ann.Forfeited {
+			t.Errorf("ann connected %v, forfeited %v", ann.Connected, ann.Forfeited)
+		}
+	})
+	back.send("ROLL_DICE", nil)
+	bob.expectStep("ROLL_DICE")
+}
+
+func TestDisconnectForfeits(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.DisconnectGrace = 50 * time.Millisecond })
+	room, ann, bob := disconnectGame(t, ts, map[string]interface{}{"disconnectTurns": DisconnectWait})
+
+	ann.conn.Close()
+	bob.expect("PLAYER_FORFEITED")
+	room.do(func() {
+		if ann := room.GameState.Players["ann"]; !ann.Forfeited || len(ann.Properties) != 0 {
+			t.Errorf("ann forfeited %v, owns %v", ann.Forfeited, ann.Properties)
+		}
+		for _, name := range room.GameState.TurnOrder {
+			if name == "ann" {
+				t.Errorf("ann still in the turn order %v", room.GameState.TurnOrder)
+			}
+		}
+		if room.GameState.Turn != "bob" {
+			t.Errorf("%s's turn after ann forfeited", room.GameState.Turn)
+		}
+	})
+}
+
+func TestDisconnectBotTakeover(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.DisconnectGrace = 50 * time.Millisecond })
+	room, ann, bob := disconnectGame(t, ts, map[string]interface{}{"disconnectTurns": DisconnectWait, "botTakeover": true})
+
+	ann.conn.Close()
+	bob.expect("BOT_TAKEOVER")
+	// The bot plays the turn ann left waiting.
+	bob.expectStep("ROLL_DICE")
+	room.do(func() {
+		if ann := room.GameState.Players["ann"]; !ann.Bot || ann.Forfeited {
+			t.Errorf("ann bot %v, forfeited %v", ann.Bot, ann.Forfeited)
+		}
+	})
+}
+
+func TestDisconnectGraceAfterGameOver(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.DisconnectGrace = 100 * time.Millisecond })
+	room, ann, bob := disconnectGame(t, ts, map[string]interface{}{"disconnectTurns": DisconnectWait})
+
+	ann.conn.Close()
+	bob.expect("PLAYER_DISCONNECTED")
+	room.do(func() { room.finishGame("bob") })
+	bob.expect("GAME_OVER")
+	time.Sleep(200 * time.Millisecond)
+	room.do(func() {
+		if ann := room.GameState.Players["ann"]; ann.Forfeited || ann.Bot || len(room.graceTimers) != 0 {
+			t.Errorf("ann forfeited %v after the game ended, grace %v", ann.Forfeited, room.graceTimers)
+		}
+	})
+}
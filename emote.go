@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Emotes are quick reactions, such as a thumbs up when someone lands on
+// your hotel. They carry one of a fixed set of identifiers, so they can't
+// be used as a second chat channel, and may point at a player or a
+// square. Unlike other broadcasts they are not numbered or kept for
+// resuming: a client that missed one can do without it. The room keeps the
+// last few for STATE snapshots, and the event log records them so replays
+// can show them, or leave them out.
+
+// emotes are the emote identifiers clients may send.
+var emotes = map[string]bool{
+	"thumbs_up": true, "thumbs_down": true, "clap": true, "laugh": true,
+	"cry": true, "angry": true, "shocked": true, "party": true,
+	"dice": true, "money": true, "hotel": true, "fire": true,
+}
+
+// recentEmotes is how many emotes a room keeps for snapshots.
+const recentEmotes = 5
+
+// EmotePayload is an emote as it is sent and broadcast. From is always the
+// name bound to the sending connection. Target names a player and Square
+// is a board index, both optional.
+type EmotePayload struct {
+	From   string    `json:"from"`
+	Emote  string    `json:"emote"`
+	Target string    `json:"target,omitempty"`
+	Square *int      `json:"square,omitempty"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+// HandleEmoteEvent broadcasts a reaction from a player or spectator.
+// Connections are limited to -emote-rate by the read loop.
+func HandleEmoteEvent(room *GameRoom, event GameEvent, client *Client) {
+	var payload EmotePayload
+	if err := decodePayload(event, &payload); err != nil || !emotes[payload.Emote] {
+		room.rejectEvent(client, event, "INVALID_EMOTE", "unknown emote")
+		return
+	}
+	if _, ok := room.GameState.Players[payload.Target]; payload.Target != "" && !ok {
+		room.rejectEvent(client, event, "INVALID_EMOTE", "no such player")
+		return
+	}
+	if sq := payload.Square; sq != nil && (*sq < 0 || *sq >= len(room.board.Squares)) {
+		room.rejectEvent(client, event, "INVALID_EMOTE", "no such square")
+		return
+	}
+	payload.From = connName(room, client)
+	payload.SentAt = time.Now()
+
+	room.emotes = append(room.emotes, payload)
+	if len(room.emotes) > recentEmotes {
+		room.emotes = room.emotes[len(room.emotes)-recentEmotes:]
+	}
+	SendTransientToAll(room, "EMOTE", payload)
+	data, _ := json.Marshal(payload)
+	room.appendLog(LogEntry{
+		Seq:       room.seq,
+		At:        payload.SentAt,
+		Actor:     payload.From,
+		Event:     "EMOTE",
+		Payload:   data,
+		Transient: true,
+	})
+}
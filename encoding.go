@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Encoding is the wire format negotiated for a websocket connection.
+type Encoding int
+
+const (
+	EncodingJSON Encoding = iota
+	EncodingMsgpack
+)
+
+// negotiateEncoding picks the connection's encoding from the negotiated
+// subprotocol, falling back to the "encoding" query parameter.
+func negotiateEncoding(subprotocol, query string) Encoding {
+	if subprotocol == "msgpack" || query == "msgpack" {
+		return EncodingMsgpack
+	}
+	return EncodingJSON
+}
+
+func (e Encoding) frameType() int {
+	if e == EncodingMsgpack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// OutboundMessage is one encoded event. It is encoded to JSON up front and
+// to MessagePack at most once, the first time a MessagePack connection
+// asks for it, so a broadcast costs one encode per format in use rather
+// than one per connection. The same goes for the framed (and, where
+// negotiated, compressed) form, which is shared via a PreparedMessage.
+type OutboundMessage struct {
+	Seq  uint64
+	JSON []byte
+
+	msgpackOnce sync.Once
+	msgpack     []byte
+
+	preparedOnce [2]sync.Once
+	prepared     [2]*websocket.PreparedMessage
+
+	// localized holds the message in other locales, for events with text
+	// to translate.
+	localized map[string]*OutboundMessage
+}
+
+func newOutboundMessage(seq uint64, data []byte) *OutboundMessage {
+	return &OutboundMessage{Seq: seq, JSON: data}
+}
+
+// In returns the message as it is sent to connections in locale.
+func (m *OutboundMessage) In(locale string) *OutboundMessage {
+	if l, ok := m.localized[locale]; ok {
+		return l
+	}
+	return m
+}
+
+func (m *OutboundMessage) Encode(e Encoding) []byte {
+	if e != EncodingMsgpack {
+		return m.JSON
+	}
+	m.msgpackOnce.Do(func() {
+		data, err := jsonToMsgpack(m.JSON)
+		if err != nil {
+			slog.Error("encoding msgpack", "seq", m.Seq, "err", err)
+		}
+		m.msgpack = data
+	})
+	return m.msgpack
+}
+
+// Prepared returns the message framed for connections using e.
+func (m *OutboundMessage) Prepared(e Encoding) (*websocket.PreparedMessage, error) {
+	var err error
+	m.preparedOnce[e].Do(func() {
+		m.prepared[e], err = websocket.NewPreparedMessage(e.frameType(), m.Encode(e))
+	})
+	if m.prepared[e] == nil && err == nil {
+		err = errors.New("message could not be prepared")
+	}
+	return m.prepared[e], err
+}
+
+// jsonToMsgpack re-encodes a JSON document as MessagePack. Going through
+// the JSON form guarantees both encodings carry exactly the same structure,
+// field names and omissions included.
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// msgpackToJSON is the inverse of jsonToMsgpack, used to decode inbound
+// binary frames with the same struct tags as JSON frames.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+	v, err := readMsgpack(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	return json.Marshal(v)
+}
+
+func writeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			buf.Write([]byte{0xd9, byte(n)})
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xda)
+			binary.Write(buf, binary.BigEndian, uint16(n))
+		default:
+			buf.WriteByte(0xdb)
+			binary.Write(buf, binary.BigEndian, uint32(n))
+		}
+		buf.WriteString(v)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(v), 0x90, 0xdc, 0xdd)
+		for _, elem := range v {
+			if err := writeMsgpack(buf, elem); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeMsgpackHeader(buf, len(v), 0x80, 0xde, 0xdf)
+		for _, k := range keys {
+			writeMsgpack(buf, k)
+			if err := writeMsgpack(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+	return nil
+}
+
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix, b16, b32 byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(b32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.Write([]byte{0xd0, byte(i)})
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// maxMsgpackDepth stops hostile input from recursing without bound.
+const maxMsgpackDepth = 32
+
+var errMsgpackTruncated = errors.New("msgpack: truncated input")
+
+func readMsgpack(r *bytes.Reader, depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("msgpack: nesting too deep")
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, errMsgpackTruncated
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		return readMsgpackString(r, int(b&0x1f))
+	case b&0xf0 == 0x90:
+		return readMsgpackArray(r, int(b&0x0f), depth)
+	case b&0xf0 == 0x80:
+		return readMsgpackMap(r, int(b&0x0f), depth)
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readMsgpackUint(r, 1<<(b-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := readMsgpackUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, nil
+	case 0xca:
+		n, err := readMsgpackUint(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 0xcb:
+		n, err := readMsgpackUint(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(n), nil
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		size := 1
+		switch b {
+		case 0xda, 0xc5:
+			size = 2
+		case 0xdb, 0xc6:
+			size = 4
+		}
+		n, err := readMsgpackUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, int(n))
+	case 0xdc, 0xdd:
+		n, err := readMsgpackUint(r, 2<<(b-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, int(n), depth)
+	case 0xde, 0xdf:
+		n, err := readMsgpackUint(r, 2<<(b-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type byte 0x%02x", b)
+}
+
+func readMsgpackUint(r *bytes.Reader, size int) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:size]); err != nil {
+		return 0, errMsgpackTruncated
+	}
+	var n uint64
+	for _, c := range b[:size] {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func readMsgpackString(r *bytes.Reader, n int) (string, error) {
+	if n > r.Len() {
+		return "", errMsgpackTruncated
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return string(b), nil
+}
+
+func readMsgpackArray(r *bytes.Reader, n int, depth int) ([]interface{}, error) {
+	if n > r.Len() {
+		return nil, errMsgpackTruncated
+	}
+	arr := make([]interface{}, n)
+	for i := range arr {
+		v, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+func readMsgpackMap(r *bytes.Reader, n int, depth int) (map[string]interface{}, error) {
+	if n > r.Len() {
+		return nil, errMsgpackTruncated
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errors.New("msgpack: map keys must be strings")
+		}
+		v, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
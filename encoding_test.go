@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMsgpackRoundTrip encodes values to MessagePack the way broadcasts
+// are, decodes them the way inbound frames are, and checks they come back
+// as the same JSON, and so as the same structures.
+func TestMsgpackRoundTrip(t *testing.T) {
+	rec := testRecord("ROOM01")
+	many := make(map[string]interface{})
+	var long []interface{}
+	for i := 0; i < 40; i++ {
+		many[strings.Repeat("k", i+1)] = i
+		long = append(long, -i)
+	}
+	for name, tc := range map[string]struct {
+		v   interface{}
+		out interface{}
+	}{
+		"record": {rec, &RoomRecord{}},
+		"state":  {rec.GameState, &GameState{}},
+		"summary": {GameSummary{ID: rec.ID, Winner: "ann", Players: []PlayerSummary{{Name: "ann", NetWorth: 2000, Place: 1}, {Name: "bob", Place: 2}}, StartedAt: rec.CreatedAt, FinishedAt: rec.CreatedAt.Add(time.Hour), Turns: 4},
+			&GameSummary{}},
+		"values": {map[string]interface{}{
+			"null":    nil,
+			"bools":   []interface{}{true, false},
+			"strings": []interface{}{"", "ünïcødé ✓", strings.Repeat("a", 31), strings.Repeat("b", 32), strings.Repeat("c", 255), strings.Repeat("d", 256), strings.Repeat("e", 70000)},
+			"ints":    []interface{}{0, 127, 128, 255, 256, 65535, 65536, -1, -32, -33, -128, -129, -32768, -32769, int64(math.MaxInt64), int64(math.MinInt64), 1 << 40},
+			"floats":  []interface{}{0.5, -1e-7, 1e21, math.MaxFloat64},
+			"empty":   map[string]interface{}{},
+			"many":    many,
+			"long":    long,
+			"nested":  []interface{}{[]interface{}{map[string]interface{}{"a": []interface{}{}}}},
+		}, &map[string]interface{}{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			want, err := json.Marshal(tc.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packed := newOutboundMessage(1, want).Encode(EncodingMsgpack)
+			if len(packed) == 0 {
+				t.Fatal("nothing encoded")
+			}
+			data, err := msgpackToJSON(packed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(data, tc.out); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(tc.out)
+			if _, generic := tc.out.(*map[string]interface{}); generic {
+				// Keys come back sorted and numbers as float64s either way.
+				var wantV interface{}
+				json.Unmarshal(want, &wantV)
+				want, _ = json.Marshal(wantV)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got\n%.2000s\nwant\n%.2000s", got, want)
+			}
+		})
+	}
+}
+
+// TestMsgpackBadInput checks truncated, nested too deeply and otherwise
+// bad MessagePack is refused rather than decoded.
+func TestMsgpackBadInput(t *testing.T) {
+	valid, err := jsonToMsgpack([]byte(`{"event":"CHAT_MESSAGE","payload":{"text":"hello there","n":[1,2,300,70000]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(valid); i++ {
+		if _, err := msgpackToJSON(valid[:i]); err == nil {
+			t.Errorf("%d of %d bytes decoded", i, len(valid))
+		}
+	}
+	deep := append(bytes.Repeat([]byte{0x91}, maxMsgpackDepth+2), 0xc0)
+	for name, data := range map[string][]byte{
+		"trailing":   append(append([]byte{}, valid...), 0xc0),
+		"deep":       deep,
+		"int key":    {0x81, 0x01, 0xc0},
+		"huge array": {0xdd, 0xff, 0xff, 0xff, 0xff},
+		"ext":        {0xd4, 0x01, 0x00},
+	} {
+		if _, err := msgpackToJSON(data); err == nil {
+			t.Errorf("%s decoded", name)
+		}
+	}
+}
+
+// TestMsgpackConnection has ann connect with MessagePack and bob with
+// JSON, and checks each gets the same broadcasts in their own format.
+func TestMsgpackConnection(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(nil)
+	ann := ts.join(code, "ann", url.Values{"encoding": {"msgpack"}})
+	bob := ts.join(code, "bob", nil)
+	ann.send("CHAT_MESSAGE", map[string]string{"text": "hi bob"})
+
+	if joined := ann.expect("PLAYER_JOINED"); !joined.binary {
+		t.Error("ann's PLAYER_JOINED came in a text frame")
+	}
+	a, b := ann.expect("CHAT_MESSAGE"), bob.expect("CHAT_MESSAGE")
+	if !a.binary || b.binary {
+		t.Errorf("ann's frame binary %v, bob's %v", a.binary, b.binary)
+	}
+	var aPayload, bPayload interface{}
+	a.decode(t, &aPayload)
+	b.decode(t, &bPayload)
+	if a.Seq != b.Seq || !reflect.DeepEqual(aPayload, bPayload) {
+		t.Errorf("ann got %d %v, bob %d %v", a.Seq, aPayload, b.Seq, bPayload)
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+const (
+	defaultLogPageLimit = 100
+	maxLogPageLimit     = 1000
+)
+
+var errRoomClosed = errors.New("room closed")
+
+// LogEntry is one line of a game's event log. Every broadcast is logged
+// with the change it made to the game state since the previous entry, so
+// replaying the entries in order rebuilds the state. Actions are the moves
+// the rules engine made for the entry: a replay makes them again, and the
+// diff holds only the rest of the change, which the server made around
+// them, such as starting the next turn's timer. Rejected entries record
+// an event that was refused; they change nothing and carry the sequence
+// number of the last broadcast before them, as do transient entries, which
+// record an event sent without a number of its own, such as an emote.
+type LogEntry struct {
+	Seq       uint64                     `json:"seq"`
+	At        time.Time                  `json:"at"`
+	Actor     string                     `json:"actor,omitempty"`
+	Event     string                     `json:"event"`
+	Payload   json.RawMessage            `json:"payload,omitempty"`
+	Actions   []LoggedAction             `json:"actions,omitempty"`
+	Diff      map[string]json.RawMessage `json:"diff,omitempty"`
+	Removed   []string                   `json:"removed,omitempty"`
+	Rejected  bool                       `json:"rejected,omitempty"`
+	Transient bool                       `json:"transient,omitempty"`
+	Error     *ErrorPayload              `json:"error,omitempty"`
+}
+
+// LoggedAction is a game.Action as the event log records it. Action is
+// the event that asks for it, and Rolls how many times the game's dice had
+// rolled before it, so that a replay rolls them the same.
+type LoggedAction struct {
+	Action     string   `json:"action"`
+	Player     string   `json:"player"`
+	Property   string   `json:"property,omitempty"`
+	Unmortgage []string `json:"unmortgage,omitempty"`
+	Rolls      int      `json:"rolls"`
+}
+
+// actionChooseMortgages logs a game.ChooseMortgages, which isn't one of
+// the turn's actions.
+const actionChooseMortgages = "MORTGAGE_TRANSFER_CHOICE"
+
+// loggedAction records action, made after rolls rolls of the dice.
+func loggedAction(action game.Action, rolls int) LoggedAction {
+	logged := LoggedAction{Rolls: rolls}
+	switch a := action.(type) {
+	case game.RollDice:
+		logged.Action, logged.Player = game.ActionRollDice, a.Player
+	case game.PayBail:
+		logged.Action, logged.Player = game.ActionPayBail, a.Player
+	case game.BuyProperty:
+		logged.Action, logged.Player, logged.Property = game.ActionBuyProperty, a.Player, a.Property
+	case game.DeclinePurchase:
+		logged.Action, logged.Player = game.ActionDeclinePurchase, a.Player
+	case game.Unmortgage:
+		logged.Action, logged.Player, logged.Property = game.ActionUnmortgage, a.Player, a.Property
+	case game.EndTurn:
+		logged.Action, logged.Player = game.ActionEndTurn, a.Player
+	case game.ChooseMortgages:
+		logged.Action, logged.Player, logged.Unmortgage = actionChooseMortgages, a.Player, a.Unmortgage
+	}
+	return logged
+}
+
+// gameAction returns the action a records, or nil if it names none.
+func (a LoggedAction) gameAction() game.Action {
+	switch a.Action {
+	case game.ActionRollDice:
+		return game.RollDice{Player: a.Player}
+	case game.ActionPayBail:
+		return game.PayBail{Player: a.Player}
+	case game.ActionBuyProperty:
+		return game.BuyProperty{Player: a.Player, Property: a.Property}
+	case game.ActionDeclinePurchase:
+		return game.DeclinePurchase{Player: a.Player}
+	case game.ActionUnmortgage:
+		return game.Unmortgage{Player: a.Player, Property: a.Property}
+	case game.ActionEndTurn:
+		return game.EndTurn{Player: a.Player}
+	case actionChooseMortgages:
+		return game.ChooseMortgages{Player: a.Player, Unmortgage: a.Unmortgage}
+	}
+	return nil
+}
+
+type EventLogResponse struct {
+	GameID  string     `json:"gameId"`
+	Entries []LogEntry `json:"entries"`
+}
+
+// logBroadcast appends the broadcast just made to the room's log. It must
+// run on the room's goroutine.
+func (room *GameRoom) logBroadcast(eventType string, payload interface{}) {
+	if r, ok := payload.(ResolutionPayload); ok {
+		// The entry's own diff records the change.
+		r.Diff, r.Removed = nil, nil
+		payload = r
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		room.logger().Error("encoding logged payload", "event", eventType, "err", err)
+	}
+	diff, removed, flat := room.stateDiff(room.replayBase())
+	room.logState = flat
+	room.appendLog(LogEntry{
+		Seq:     room.seq,
+		At:      time.Now(),
+		Actor:   room.actor,
+		Event:   eventType,
+		Payload: data,
+		Actions: room.logActions,
+		Diff:    diff,
+		Removed: removed,
+	})
+	room.logActions, room.logEngine = nil, nil
+}
+
+// flatState returns the game state flattened as flattenJSON does. It must
+// run on the room's goroutine.
+func (room *GameRoom) flatState() map[string]json.RawMessage {
+	state, err := json.Marshal(&room.GameState)
+	if err != nil {
+		room.logger().Error("encoding logged state", "err", err)
+	}
+	return flattenJSON(state)
+}
+
+// stateDiff returns the change made to the game state since base, along
+// with the state flattened as it is now. It must run on the room's
+// goroutine.
+func (room *GameRoom) stateDiff(base map[string]json.RawMessage) (diff map[string]json.RawMessage, removed []string, flat map[string]json.RawMessage) {
+	flat = room.flatState()
+	diff, removed = diffFlat(base, flat)
+	return diff, removed, flat
+}
+
+// replayBase returns the flattened state a replay of the log reaches
+// before the diff of the next entry: the state as of the last entry,
+// with the change made by the actions applied since. It must run on the
+// room's goroutine.
+func (room *GameRoom) replayBase() map[string]json.RawMessage {
+	if room.logEngine == nil {
+		return room.logState
+	}
+	base := make(map[string]json.RawMessage, len(room.logState)+len(room.logEngine))
+	for path, value := range room.logState {
+		base[path] = value
+	}
+	for path, value := range room.logEngine {
+		if value == nil {
+			delete(base, path)
+		} else {
+			base[path] = value
+		}
+	}
+	return base
+}
+
+// diffFlat returns the paths whose values differ from from to to, and
+// those from has and to hasn't.
+func diffFlat(from, to map[string]json.RawMessage) (diff map[string]json.RawMessage, removed []string) {
+	diff = make(map[string]json.RawMessage)
+	for path, value := range to {
+		if old, ok := from[path]; !ok || string(old) != string(value) {
+			diff[path] = value
+		}
+	}
+	for path := range from {
+		if _, ok := to[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(removed)
+	return diff, removed
+}
+
+// logRejection records an event refused with an error, if -log-rejected
+// is set. It must run on the room's goroutine.
+func (room *GameRoom) logRejection(actor string, event GameEvent, code string, message string) {
+	if !hub.config.LogRejected {
+		return
+	}
+	data, _ := json.Marshal(event.Payload)
+	room.appendLog(LogEntry{
+		Seq:      room.seq,
+		At:       time.Now(),
+		Actor:    actor,
+		Event:    event.Event,
+		Payload:  data,
+		Rejected: true,
+		Error:    &ErrorPayload{Code: code, Message: message},
+	})
+}
+
+// appendLog adds entry to the log and queues it for the store. It must run
+// on the room's goroutine.
+func (room *GameRoom) appendLog(entry LogEntry) {
+	room.log = append(room.log, entry)
+	if _, none := store.(memoryStore); !none {
+		room.pendingLog = append(room.pendingLog, entry)
+		room.markDirty()
+	}
+	room.trimLog()
+}
+
+// trimLog drops the oldest entries from the log in memory beyond
+// -log-memory, once they have been taken for the store. It must run on
+// the room's goroutine.
+func (room *GameRoom) trimLog() {
+	drop := min(len(room.log)-hub.config.LogMemory, len(room.log)-len(room.pendingLog))
+	if drop > 0 {
+		room.log = room.log[drop:]
+		room.logSpilled += drop
+	}
+}
+
+// eventLog returns the room's whole event log, reading the entries dropped
+// from memory back from the store. complete is false if some of them are
+// gone, as they are with a store that keeps nothing. It returns
+// errRoomClosed if the room has closed, and must not be called on the
+// room's goroutine.
+func (room *GameRoom) eventLog() (entries []LogEntry, complete bool, err error) {
+	// Every entry taken for the store has been written while saveMu is
+	// held.
+	room.saveMu.Lock()
+	defer room.saveMu.Unlock()
+	spilled := 0
+	if !room.do(func() {
+		entries = append([]LogEntry(nil), room.log...)
+		spilled = room.logSpilled
+	}) {
+		return nil, false, errRoomClosed
+	}
+	if spilled == 0 {
+		return entries, true, nil
+	}
+	stored, err := store.LoadLog(room.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(stored) < spilled {
+		return append(stored, entries...), false, nil
+	}
+	return append(stored[:spilled:spilled], entries...), true, nil
+}
+
+// takePendingLog returns the entries not yet written to the store. It must
+// run on the room's goroutine.
+func (room *GameRoom) takePendingLog() []LogEntry {
+	pending := room.pendingLog
+	room.pendingLog = nil
+	return pending
+}
+
+// rejectEvent refuses event with an error sent to client, logging it if
+// rejected events are being logged.
+func (room *GameRoom) rejectEvent(client *Client, event GameEvent, code string, message string) {
+	room.clientLog(client).Debug("event rejected", "event", event.Event, "requestId", event.RequestID, "code", code, "message", message)
+	metrics.Inc(metricEventsRejected, code)
+	room.logRejection(connName(room, client), event, code, message)
+	room.outcome = &ErrorPayload{Code: code, Message: message}
+	SendError(client, room.ID, event.RequestID, code, message)
+}
+
+// flattenJSON turns a JSON document into JSON-pointer paths and values.
+// Objects are walked into; anything else, arrays included, is a value. An
+// empty object is kept as a value so it still exists after a replay.
+func flattenJSON(data []byte) map[string]json.RawMessage {
+	flat := make(map[string]json.RawMessage)
+	var walk func(path string, raw json.RawMessage)
+	walk = func(path string, raw json.RawMessage) {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil || obj == nil || len(obj) == 0 {
+			flat[path] = raw
+			return
+		}
+		for key, value := range obj {
+			walk(path+"/"+escapePointer(key), value)
+		}
+	}
+	walk("", data)
+	return flat
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+func escapePointer(key string) string { return pointerEscaper.Replace(key) }
+
+// logRules are what replaying a game's log through the rules engine takes
+// besides the log: the board, the seed of the dice, and whether absent
+// players' turns are skipped.
+type logRules struct {
+	board      *game.Board
+	seed       int64
+	skipAbsent bool
+}
+
+// newLogRules returns the rules of a game played with opts and dice
+// seeded with seed.
+func newLogRules(opts RoomOptions, seed int64) logRules {
+	board, _ := opts.gameBoard()
+	return logRules{board: board, seed: seed, skipAbsent: opts.DisconnectTurns == DisconnectSkip}
+}
+
+// engine returns the rules engine with the dice as they were after rolls
+// rolls.
+func (r logRules) engine(rolls int) *game.Engine {
+	return &game.Engine{Board: r.board, Dice: game.NewSeededRoller(r.seed, rolls), SkipAbsent: r.skipAbsent}
+}
+
+// logReplay rebuilds a game's state from its event log one entry at a
+// time, keeping it flattened as the log's diffs are.
+type logReplay struct {
+	rules logRules
+	flat  map[string]json.RawMessage
+}
+
+func newLogReplay(rules logRules) *logReplay {
+	return &logReplay{rules: rules, flat: make(map[string]json.RawMessage)}
+}
+
+// apply replays entry: its actions through the rules engine, then its
+// diff. It returns the change the entry made as a whole.
+func (r *logReplay) apply(entry LogEntry) (diff map[string]json.RawMessage, removed []string, err error) {
+	if entry.Rejected {
+		return nil, nil, nil
+	}
+	if len(entry.Actions) == 0 {
+		for _, path := range entry.Removed {
+			delete(r.flat, path)
+		}
+		for path, value := range entry.Diff {
+			r.flat[path] = value
+		}
+		return entry.Diff, entry.Removed, nil
+	}
+	state, err := r.state()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, logged := range entry.Actions {
+		action := logged.gameAction()
+		if action == nil {
+			return nil, nil, fmt.Errorf("seq %d: unknown action %q", entry.Seq, logged.Action)
+		}
+		if _, err := r.rules.engine(logged.Rolls).Apply(&state, action); err != nil {
+			return nil, nil, fmt.Errorf("seq %d: replaying %s for %s: %w", entry.Seq, logged.Action, logged.Player, err)
+		}
+	}
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return nil, nil, err
+	}
+	before := r.flat
+	r.flat = flattenJSON(data)
+	for _, path := range entry.Removed {
+		delete(r.flat, path)
+	}
+	for path, value := range entry.Diff {
+		r.flat[path] = value
+	}
+	diff, removed = diffFlat(before, r.flat)
+	return diff, removed, nil
+}
+
+// state returns the game as replayed so far.
+func (r *logReplay) state() (GameState, error) {
+	root := make(map[string]interface{})
+	paths := make([]string, 0, len(r.flat))
+	for path := range r.flat {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		var value interface{}
+		if err := json.Unmarshal(r.flat[path], &value); err != nil {
+			return GameState{}, err
+		}
+		if err := setPointer(root, path, value); err != nil {
+			return GameState{}, err
+		}
+	}
+	var state GameState
+	data, err := json.Marshal(root)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// replayLog rebuilds a game state by replaying entries, in order, from an
+// empty document.
+func replayLog(entries []LogEntry, rules logRules) (GameState, error) {
+	r := newLogReplay(rules)
+	for _, entry := range entries {
+		if _, _, err := r.apply(entry); err != nil {
+			return GameState{}, err
+		}
+	}
+	return r.state()
+}
+
+func splitPointer(path string) []string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, p := range parts {
+		parts[i] = pointerUnescaper.Replace(p)
+	}
+	return parts
+}
+
+func setPointer(root map[string]interface{}, path string, value interface{}) error {
+	if path == "" {
+		return errors.New("can't replace the whole document")
+	}
+	parts := splitPointer(path)
+	node := root
+	for _, key := range parts[:len(parts)-1] {
+		child, ok := node[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			node[key] = child
+		}
+		node = child
+	}
+	last := parts[len(parts)-1]
+	if obj, ok := value.(map[string]interface{}); ok && len(obj) == 0 {
+		// An empty object only marks that the key exists; don't wipe
+		// children set by the same entry.
+		if _, exists := node[last].(map[string]interface{}); exists {
+			return nil
+		}
+	}
+	node[last] = value
+	return nil
+}
+
+// handleGameEvents serves a page of a game's event log, oldest first. With
+// since, only entries after that sequence number are included.
+func handleGameEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	limit, err := queryInt(r, "limit", defaultLogPageLimit)
+	if err != nil || limit < 1 {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	if limit > maxLogPageLimit {
+		limit = maxLogPageLimit
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+		return
+	}
+	since, err := queryInt(r, "since", 0)
+	if err != nil || since < 0 {
+		writeError(w, http.StatusBadRequest, "since must be a non-negative integer")
+		return
+	}
+
+	var entries []LogEntry
+	hub.Mutex.RLock()
+	room, live := hub.lookup(id)
+	hub.Mutex.RUnlock()
+	allowed := true
+	if live {
+		live = room.do(func() { allowed = room.admits(r.URL.Query()) })
+	}
+	if !allowed {
+		writeError(w, http.StatusForbidden, "this room is private")
+		return
+	}
+	if live {
+		// A room that closed in the meantime has its log in the store.
+		entries, _, err = room.eventLog()
+		if err == errRoomClosed {
+			live = false
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, "couldn't load the event log")
+			return
+		}
+	}
+	if live {
+		id = room.ID
+	} else if entries, err = store.LoadLog(id); err != nil {
+		writeError(w, http.StatusInternalServerError, "couldn't load the event log")
+		return
+	}
+	if entries == nil && !live {
+		writeError(w, http.StatusNotFound, "game not found")
+		return
+	}
+
+	filtered := entries[:0:0]
+	for _, e := range entries {
+		if e.Seq > uint64(since) {
+			filtered = append(filtered, e)
+		}
+	}
+	total := len(filtered)
+	if offset > total {
+		offset = total
+	}
+	filtered = filtered[offset:]
+	if len(filtered) > limit {
+		filtered = filtered[:limit]
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, EventLogResponse{GameID: id, Entries: filtered})
+}
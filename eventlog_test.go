@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestReplayReproducesGame plays a game between ann and two bots to the
+// end, keeping only the latest few entries of its log in memory, and
+// checks that replaying the whole log through the rules engine rebuilds
+// the game exactly as it finished.
+func TestReplayReproducesGame(t *testing.T) {
+	// The store's directory has to outlive the rooms saved to it.
+	dir := t.TempDir()
+	ts := newTestServer(t, func(cfg *Config) { cfg.LogMemory = 10 })
+	fs, err := newFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = fs
+	code := ts.createRoom(map[string]interface{}{"houseRules": map[string]int{"startingBalance": 300}, "diceSeed": 7})
+	ann := ts.join(code, "ann", nil)
+	for i := 0; i < 2; i++ {
+		ann.send("ADD_BOT", nil)
+		ann.expect("PLAYER_JOINED")
+	}
+	ann.send("START_GAME", nil)
+	ann.expect("GAME_STARTED")
+
+	if _, _, err := ann.playOut(30 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	room := ts.room(code)
+	room.save()
+	var want []byte
+	var rules logRules
+	var spilled int
+	room.do(func() {
+		want, _ = json.Marshal(&room.GameState)
+		rules = newLogRules(room.Options, room.GameState.DiceSeed)
+		spilled = room.logSpilled
+	})
+	entries, complete, err := room.eventLog()
+	if err != nil || !complete {
+		t.Fatalf("event log: complete %v, %v", complete, err)
+	}
+	if spilled == 0 {
+		t.Errorf("the whole log of %d entries was kept in memory", len(entries))
+	}
+	actions := 0
+	for _, entry := range entries {
+		actions += len(entry.Actions)
+	}
+	if actions == 0 {
+		t.Fatal("no actions logged")
+	}
+
+	state, err := replayLog(entries, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := json.Marshal(&state); string(got) != string(want) {
+		t.Errorf("replayed %d entries with %d actions to\n%s\nwant\n%s", len(entries), actions, got, want)
+	}
+}
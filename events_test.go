@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// panicPayload panics when it is encoded, as a handler with a bug might.
+type panicPayload struct{}
+
+func (panicPayload) MarshalJSON() ([]byte, error) {
+	panic("boom")
+}
+
+// malformedPayloads are payloads of every wrong shape, and objects whose
+// fields handlers read have the wrong types.
+var malformedPayloads = []interface{}{
+	nil,
+	"ROLL_DICE",
+	7,
+	[]interface{}{"ann", 12},
+	map[string]interface{}{},
+	map[string]interface{}{"player": 12, "diceRoll": "six", "property": []int{1}, "text": 5, "emote": false},
+	map[string]interface{}{"player": nil, "diceRoll": -1e300, "property": map[string]int{"x": 1}, "unmortgage": "Boardwalk"},
+	map[string]interface{}{"ready": "yes", "token": 3, "vote": "maybe", "startingBalance": "lots", "locale": []string{"en"}},
+}
+
+// TestMalformedPayloads has bob send every event the room handles with
+// each malformed payload, and checks none of them upsets the server, and
+// that ann and bob can play their turns afterwards.
+func TestMalformedPayloads(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 3})
+	clients := ts.startGame(code, "ann", "bob", "cat")
+	ann, bob, cat := clients[0], clients[1], clients[2]
+
+	events := []string{
+		"READY", "START_GAME", "SET_HOUSE_RULES", "SELECT_TOKEN", "ADD_BOT", "KICK_PLAYER",
+		"TRANSFER_HOST", "PAUSE_GAME", "RESUME_GAME", "VOTE_KICK", "SAVE_GAME", "APPROVE_REJOIN",
+		"VOTE", "ROLL_DICE", "BUY_PROPERTY", "DECLINE_PURCHASE", "PAY_BAIL", "UNMORTGAGE",
+		"MORTGAGE_TRANSFER_CHOICE", "END_TURN", "CHAT_MESSAGE", "EMOTE", "STATE_SYNC",
+		"BOARD_DATA", "PLAYER_SUMMARY", "DICE_STATS", "UNDO_VOTE", "SET_PREFERENCES", "NO_SUCH_EVENT",
+	}
+	for _, event := range events {
+		for _, payload := range malformedPayloads {
+			bob.send(event, payload)
+		}
+	}
+	// Events are handled in order, so once this is answered all the rest
+	// have been.
+	bob.send("STATE_SYNC", nil)
+	for {
+		e := <-bob.events
+		if e.Event == "ERROR" && bytes.Contains(e.Payload, []byte("INTERNAL_ERROR")) {
+			t.Fatalf("handler panicked: %s", e.Payload)
+		}
+		if e.Event == "STATE" {
+			break
+		}
+	}
+
+	ann.playTurn()
+	bob.playTurn()
+	cat.actions()
+}
+
+// TestPanicRecovered has a handler panic on the room's goroutine, and
+// checks the sender is told and the room carries on.
+func TestPanicRecovered(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(nil)
+	ann := ts.join(code, "ann", nil)
+	bob := ts.join(code, "bob", nil)
+	room := ts.room(code)
+
+	var client *Client
+	room.do(func() {
+		for c, name := range room.Players {
+			if name == "bob" {
+				client = c
+			}
+		}
+	})
+	handleGameEvent(room, GameEvent{Event: "EMOTE", GameID: code, RequestID: "r1", Payload: panicPayload{}}, client)
+	bob.expectError("INTERNAL_ERROR")
+
+	bob.send("CHAT_MESSAGE", map[string]string{"text": "still here"})
+	ann.expectWhere("CHAT_MESSAGE", func(e receivedEvent) bool { return bytes.Contains(e.Payload, []byte("still here")) })
+	room.do(func() {
+		if room.resolution != nil || room.actor != "" || room.actorRequestID != "" {
+			t.Errorf("left mid-event: resolution %v, actor %q, request %q", room.resolution, room.actor, room.actorRequestID)
+		}
+	})
+}
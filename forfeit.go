@@ -0,0 +1,133 @@
+package main
+
+import "github.com/zishan044/monopoly-backend/game"
+
+type ForfeitPayload struct {
+	Player string `json:"player"`
+	Reason string `json:"reason"`
+}
+
+// EliminatedPayload names a player knocked out of the game and where
+// they finished.
+type EliminatedPayload struct {
+	Player string `json:"player"`
+	Place  int    `json:"place"`
+	Reason string `json:"reason"`
+}
+
+// GameOverPayload names the winner and how many turns the game lasted,
+// and sums up what every player ended with, richest first. An abandoned
+// game may have no winner.
+type GameOverPayload struct {
+	Winner    string           `json:"winner"`
+	Turns     int              `json:"turns"`
+	Abandoned bool             `json:"abandoned,omitempty"`
+	Summaries []game.Assets    `json:"summaries"`
+	DiceStats DiceStatsSummary `json:"diceStats"`
+}
+
+// forfeitPlayer takes name out of the game: their properties go back to the
+// bank, they leave the turn order (passing the turn on if it was theirs),
+// and the game ends if only one player is left. The Player entry is kept,
+// marked forfeited with the place they finished in, and their connection
+// stays open as a spectator's. It must run on the room's goroutine.
+func (room *GameRoom) forfeitPlayer(name string, reason string) {
+	hadTurn := room.GameState.Turn == name
+	next := game.Eliminate(&room.GameState, name, room.Options.DisconnectTurns == DisconnectSkip)
+	room.logger().Info("player forfeited", "player", name, "reason", reason)
+	SendGameEventToAll(room, "PLAYER_FORFEITED", room.ID, ForfeitPayload{Player: name, Reason: reason})
+	room.playerOut(name, reason)
+	room.logAction("forfeit", map[string]interface{}{"player": name, "reason": reason})
+	if hadTurn && next != "" {
+		room.setTurn(next)
+	} else if hadTurn {
+		room.GameState.Turn = next
+	}
+	room.checkLastPlayer()
+}
+
+// playerOut does what the room does for a player the game has just
+// eliminated, for reason: their connection becomes a spectator's, what
+// they were part of is dropped, and the host passes on if it was them.
+// It must run on the room's goroutine.
+func (room *GameRoom) playerOut(name string, reason string) {
+	room.makeSpectator(name)
+	room.cancelGrace(name)
+	room.leaveKickVote(name, "player left the game")
+	room.clearUndo("player left the game")
+	SendGameEventToAll(room, "PLAYER_ELIMINATED", room.ID, EliminatedPayload{Player: name, Place: room.GameState.Players[name].Place, Reason: reason})
+	if room.GameState.Host == name {
+		room.promoteHost(name)
+	}
+}
+
+// checkLastPlayer ends the game if only one player is left in it. It must
+// run on the room's goroutine.
+func (room *GameRoom) checkLastPlayer() {
+	if room.GameState.Status == StatusInProgress && len(room.GameState.TurnOrder) == 1 {
+		room.finishGame(room.GameState.TurnOrder[0])
+	}
+}
+
+// makeSpectator moves name's connection, if they have one, from their
+// seat to the spectators. It must run on the room's goroutine.
+func (room *GameRoom) makeSpectator(name string) {
+	client := clientFor(room, name)
+	if client == nil {
+		return
+	}
+	delete(room.Players, client)
+	room.Spectators[client] = name
+	room.eliminated[client] = true
+	SendGameEventToAll(room, "SPECTATOR_JOINED", room.ID, SpectatorsPayload{Spectators: len(room.Spectators)})
+}
+
+// eliminatedClient returns the spectator connection name kept after
+// being knocked out of the game, or nil if they have none. It must run on
+// the room's goroutine.
+func eliminatedClient(room *GameRoom, name string) *Client {
+	for client := range room.eliminated {
+		if room.Spectators[client] == name {
+			return client
+		}
+	}
+	return nil
+}
+
+// placeSurvivors gives the winner first place and everyone still seated
+// the places after it, richest first. Players already out keep the place
+// they were eliminated in. It must run on the room's goroutine.
+func (room *GameRoom) placeSurvivors(winner string) {
+	place := 1
+	if player, ok := room.GameState.Players[winner]; ok {
+		player.Place = place
+		place++
+	}
+	for _, name := range room.seatedByWorth() {
+		if name != winner {
+			room.GameState.Players[name].Place = place
+			place++
+		}
+	}
+}
+
+// finishGame ends the game with winner. It must run on the room's
+// goroutine.
+func (room *GameRoom) finishGame(winner string) {
+	room.placeSurvivors(winner)
+	room.GameState.Status = StatusFinished
+	room.GameState.Turn = ""
+	room.GameState.Phase = ""
+	room.cancelAllGrace()
+	room.stopTurnTimer()
+	room.cancelKickVote("game over")
+	room.clearUndo("game over")
+	room.stopAutoAction()
+	room.cancelAbandon()
+	room.logger().Info("game over", "winner", winner, "abandoned", room.GameState.Abandoned)
+	SendGameEventToAll(room, "GAME_OVER", room.ID, GameOverPayload{Winner: winner, Turns: room.GameState.Turns, Abandoned: room.GameState.Abandoned, Summaries: room.playerAssets(), DiceStats: room.diceStatsSummary()})
+	room.logAction("gameOver", map[string]interface{}{"player": winner})
+	room.saveSummary(winner)
+	room.reportTournamentResult(winner)
+	metrics.Inc(metricGamesFinished, "")
+}
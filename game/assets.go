@@ -0,0 +1,138 @@
+package game
+
+// Asset summaries group railroads and utilities, which have no colour of
+// their own, as groupRailroads and groupUtilities, and properties a board
+// leaves out of any group as groupOther.
+const (
+	groupRailroads = "railroads"
+	groupUtilities = "utilities"
+	groupOther     = "other"
+)
+
+// PropertyAssets is one square a player owns. Rent is what landing on it
+// costs as things stand; a utility's rent depends on the roll, so for one
+// RentMultiple is what the roll is multiplied by instead; a mortgaged
+// square charges neither. MortgageValue is what mortgaging it raises. The
+// game has no buildings yet, so Houses is always zero; it is there so
+// clients don't have to change when it does.
+type PropertyAssets struct {
+	Name          string `json:"name"`
+	Price         int    `json:"price"`
+	Houses        int    `json:"houses"`
+	Mortgaged     bool   `json:"mortgaged"`
+	MortgageValue int    `json:"mortgageValue"`
+	Rent          int    `json:"rent,omitempty"`
+	RentMultiple  int    `json:"rentMultiple,omitempty"`
+}
+
+// GroupAssets are a player's properties in one colour group, or their
+// railroads or utilities. Complete is set if they own the whole group.
+type GroupAssets struct {
+	Group      string           `json:"group"`
+	Complete   bool             `json:"complete"`
+	Properties []PropertyAssets `json:"properties"`
+}
+
+// Assets sums up what a player has: their cash, their properties grouped
+// in board order, what mortgaging everything not yet mortgaged would
+// raise, the rent they would collect if someone landed on each of their
+// squares once (leaving out utilities, whose rent depends on the roll),
+// and their net worth.
+type Assets struct {
+	Player        string        `json:"player"`
+	Cash          int           `json:"cash"`
+	Groups        []GroupAssets `json:"groups"`
+	MortgageValue int           `json:"mortgageValue"`
+	Rent          int           `json:"rent"`
+	NetWorth      int           `json:"netWorth"`
+}
+
+// NetWorth is what player would have if they sold everything on board
+// back to the bank at face value, less what their mortgages raised.
+// Rankings, summaries and asset summaries all use it, so they agree.
+func NetWorth(board *Board, player *Player) int {
+	worth := player.Balance
+	for _, prop := range player.Properties {
+		price, _ := board.PropertyPrice(prop)
+		worth += price
+		if player.IsMortgaged(prop) {
+			worth -= MortgageValue(price)
+		}
+	}
+	return worth
+}
+
+// PlayerAssets sums up what name has in a game on board, or returns false
+// if there is no such player.
+func PlayerAssets(board *Board, state *GameState, name string) (Assets, bool) {
+	player, ok := state.Players[name]
+	if !ok {
+		return Assets{}, false
+	}
+	assets := Assets{Player: name, Cash: player.Balance, Groups: []GroupAssets{}, NetWorth: NetWorth(board, player)}
+	owned := make(map[string]bool, len(player.Properties))
+	for _, prop := range player.Properties {
+		owned[prop] = true
+	}
+	// Count what each group has and how much of it the player owns.
+	size, held := make(map[string]int), make(map[string]int)
+	for _, sq := range board.Squares {
+		if group := assetGroup(sq); group != "" {
+			size[group]++
+			if owned[sq.Name] {
+				held[group]++
+			}
+		}
+	}
+	index := make(map[string]int)
+	for _, sq := range board.Squares {
+		group := assetGroup(sq)
+		if group == "" || !owned[sq.Name] {
+			continue
+		}
+		i, ok := index[group]
+		if !ok {
+			i = len(assets.Groups)
+			index[group] = i
+			assets.Groups = append(assets.Groups, GroupAssets{Group: group, Complete: group != groupOther && held[group] == size[group]})
+		}
+		prop := PropertyAssets{Name: sq.Name, Price: sq.Price, Mortgaged: player.IsMortgaged(sq.Name), MortgageValue: MortgageValue(sq.Price)}
+		switch {
+		case prop.Mortgaged:
+		case sq.Type == SquareUtility:
+			prop.RentMultiple = Rent(board, player, sq, 1)
+		default:
+			prop.Rent = Rent(board, player, sq, 0)
+		}
+		assets.Groups[i].Properties = append(assets.Groups[i].Properties, prop)
+		if !prop.Mortgaged {
+			assets.MortgageValue += prop.MortgageValue
+		}
+		assets.Rent += prop.Rent
+	}
+	return assets, true
+}
+
+// assetGroup returns the group sq is summed up under, or "" if it can't be
+// owned.
+func assetGroup(sq Square) string {
+	switch {
+	case sq.Price == 0:
+		return ""
+	case sq.Type == SquareRailroad:
+		return groupRailroads
+	case sq.Type == SquareUtility:
+		return groupUtilities
+	case sq.Group == "":
+		return groupOther
+	}
+	return sq.Group
+}
+
+// rentAt returns rent[i], or the last rent there is if i is past the end.
+func rentAt(rent []int, i int) int {
+	if len(rent) == 0 {
+		return 0
+	}
+	return rent[min(max(i, 0), len(rent)-1)]
+}
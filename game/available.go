@@ -0,0 +1,77 @@
+package game
+
+// Names of the actions a player can be offered, as clients send them.
+const (
+	ActionRollDice        = "ROLL_DICE"
+	ActionPayBail         = "PAY_BAIL"
+	ActionBuyProperty     = "BUY_PROPERTY"
+	ActionDeclinePurchase = "DECLINE_PURCHASE"
+	ActionUnmortgage      = "UNMORTGAGE"
+	ActionEndTurn         = "END_TURN"
+)
+
+// Available is an action a player may take now, with what a client needs
+// to offer it: the property and its price for BUY_PROPERTY and
+// DECLINE_PURCHASE, the cost of bail for PAY_BAIL, and the deed and the
+// cost of lifting its mortgage for UNMORTGAGE.
+type Available struct {
+	Action   string `json:"action"`
+	Property string `json:"property,omitempty"`
+	Price    int    `json:"price,omitempty"`
+	Cost     int    `json:"cost,omitempty"`
+}
+
+// AvailableActions lists what name may do now in a game on board. It is
+// empty unless it is their turn in a game in progress. What they may do
+// depends on the phase of the turn: awaiting the roll they may roll, or
+// pay bail if they are in jail and can afford it; with a property on
+// offer they must buy it or decline it; and once that is settled they
+// may end their turn. Before the roll and at the end of the turn they may
+// also lift any mortgage they can afford to. Apply allows exactly these
+// actions.
+func AvailableActions(board *Board, state *GameState, name string) []Available {
+	player, ok := state.Players[name]
+	if !ok || player.Forfeited || state.Status != StatusInProgress || state.Turn != name {
+		return nil
+	}
+	switch state.Phase {
+	case PhaseAwaitingRoll:
+		actions := []Available{{Action: ActionRollDice}}
+		if player.JailTurns > 0 && player.Balance >= BailCost {
+			actions = append(actions, Available{Action: ActionPayBail, Cost: BailCost})
+		}
+		return append(actions, unmortgageable(board, player)...)
+	case PhaseAwaitingPurchase:
+		price, _ := board.PropertyPrice(state.Offer)
+		return []Available{
+			{Action: ActionBuyProperty, Property: state.Offer, Price: price},
+			{Action: ActionDeclinePurchase, Property: state.Offer, Price: price},
+		}
+	case PhaseAwaitingEnd:
+		return append([]Available{{Action: ActionEndTurn}}, unmortgageable(board, player)...)
+	}
+	return nil
+}
+
+// unmortgageable lists the mortgages player can afford to lift, in board
+// order.
+func unmortgageable(board *Board, player *Player) []Available {
+	var actions []Available
+	for _, sq := range board.Squares {
+		if cost := UnmortgageCost(sq.Price); player.IsMortgaged(sq.Name) && cost <= player.Balance {
+			actions = append(actions, Available{Action: ActionUnmortgage, Property: sq.Name, Cost: cost})
+		}
+	}
+	return actions
+}
+
+// available returns the entry for action in name's available actions, for
+// property if it isn't empty.
+func available(board *Board, state *GameState, name string, action string, property string) (Available, bool) {
+	for _, a := range AvailableActions(board, state, name) {
+		if a.Action == action && (property == "" || a.Property == property) {
+			return a, true
+		}
+	}
+	return Available{}, false
+}
@@ -0,0 +1,131 @@
+package game
+
+// Square types.
+const (
+	SquareGo          = "go"
+	SquareProperty    = "property"
+	SquareRailroad    = "railroad"
+	SquareUtility     = "utility"
+	SquareTax         = "tax"
+	SquareChance      = "chance"
+	SquareChest       = "chest"
+	SquareJail        = "jail"
+	SquareGoToJail    = "goToJail"
+	SquareFreeParking = "freeParking"
+)
+
+// Square is one space on the board. Price is zero for squares that can't
+// be bought. Rent is what landing on an owned square costs: for a
+// property, the bare rent and then the rent with one to four houses and
+// with a hotel; for railroads, the rent by how many the owner has; for
+// utilities, the multiple of the roll by how many the owner has. Amount
+// is what a tax square charges.
+type Square struct {
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Price     int    `json:"price,omitempty"`
+	Rent      []int  `json:"rent,omitempty"`
+	Group     string `json:"group,omitempty"`
+	HouseCost int    `json:"houseCost,omitempty"`
+	Amount    int    `json:"amount,omitempty"`
+}
+
+// Card is a card in a Chance or Community Chest deck.
+type Card struct {
+	Text string `json:"text"`
+}
+
+// Board is a board a game is played on, starting from GO. Decks, keyed by
+// SquareChance or SquareChest, are the cards for those squares.
+// Translations give square names and card text in other languages, by
+// locale and then the text as it is written on the board.
+type Board struct {
+	Name         string                       `json:"name,omitempty"`
+	Squares      []Square                     `json:"squares"`
+	Decks        map[string][]Card            `json:"decks,omitempty"`
+	Translations map[string]map[string]string `json:"translations,omitempty"`
+}
+
+// Standard is the standard 40-square board.
+var Standard = &Board{
+	Name: "Standard",
+	Squares: []Square{
+		{Type: SquareGo, Name: "Go"},
+		{Type: SquareProperty, Name: "Mediterranean Avenue", Price: 60, Group: "brown", HouseCost: 50, Rent: []int{2, 10, 30, 90, 160, 250}},
+		{Type: SquareChest, Name: "Community Chest"},
+		{Type: SquareProperty, Name: "Baltic Avenue", Price: 60, Group: "brown", HouseCost: 50, Rent: []int{4, 20, 60, 180, 320, 450}},
+		{Type: SquareTax, Name: "Income Tax", Amount: 200},
+		{Type: SquareRailroad, Name: "Reading Railroad", Price: 200, Rent: railroadRent},
+		{Type: SquareProperty, Name: "Oriental Avenue", Price: 100, Group: "lightBlue", HouseCost: 50, Rent: []int{6, 30, 90, 270, 400, 550}},
+		{Type: SquareChance, Name: "Chance"},
+		{Type: SquareProperty, Name: "Vermont Avenue", Price: 100, Group: "lightBlue", HouseCost: 50, Rent: []int{6, 30, 90, 270, 400, 550}},
+		{Type: SquareProperty, Name: "Connecticut Avenue", Price: 120, Group: "lightBlue", HouseCost: 50, Rent: []int{8, 40, 100, 300, 450, 600}},
+		{Type: SquareJail, Name: "Jail"},
+		{Type: SquareProperty, Name: "St. Charles Place", Price: 140, Group: "pink", HouseCost: 100, Rent: []int{10, 50, 150, 450, 625, 750}},
+		{Type: SquareUtility, Name: "Electric Company", Price: 150, Rent: utilityRent},
+		{Type: SquareProperty, Name: "States Avenue", Price: 140, Group: "pink", HouseCost: 100, Rent: []int{10, 50, 150, 450, 625, 750}},
+		{Type: SquareProperty, Name: "Virginia Avenue", Price: 160, Group: "pink", HouseCost: 100, Rent: []int{12, 60, 180, 500, 700, 900}},
+		{Type: SquareRailroad, Name: "Pennsylvania Railroad", Price: 200, Rent: railroadRent},
+		{Type: SquareProperty, Name: "St. James Place", Price: 180, Group: "orange", HouseCost: 100, Rent: []int{14, 70, 200, 550, 750, 950}},
+		{Type: SquareChest, Name: "Community Chest"},
+		{Type: SquareProperty, Name: "Tennessee Avenue", Price: 180, Group: "orange", HouseCost: 100, Rent: []int{14, 70, 200, 550, 750, 950}},
+		{Type: SquareProperty, Name: "New York Avenue", Price: 200, Group: "orange", HouseCost: 100, Rent: []int{16, 80, 220, 600, 800, 1000}},
+		{Type: SquareFreeParking, Name: "Free Parking"},
+		{Type: SquareProperty, Name: "Kentucky Avenue", Price: 220, Group: "red", HouseCost: 150, Rent: []int{18, 90, 250, 700, 875, 1050}},
+		{Type: SquareChance, Name: "Chance"},
+		{Type: SquareProperty, Name: "Indiana Avenue", Price: 220, Group: "red", HouseCost: 150, Rent: []int{18, 90, 250, 700, 875, 1050}},
+		{Type: SquareProperty, Name: "Illinois Avenue", Price: 240, Group: "red", HouseCost: 150, Rent: []int{20, 100, 300, 750, 925, 1100}},
+		{Type: SquareRailroad, Name: "B. & O. Railroad", Price: 200, Rent: railroadRent},
+		{Type: SquareProperty, Name: "Atlantic Avenue", Price: 260, Group: "yellow", HouseCost: 150, Rent: []int{22, 110, 330, 800, 975, 1150}},
+		{Type: SquareProperty, Name: "Ventnor Avenue", Price: 260, Group: "yellow", HouseCost: 150, Rent: []int{22, 110, 330, 800, 975, 1150}},
+		{Type: SquareUtility, Name: "Water Works", Price: 150, Rent: utilityRent},
+		{Type: SquareProperty, Name: "Marvin Gardens", Price: 280, Group: "yellow", HouseCost: 150, Rent: []int{24, 120, 360, 850, 1025, 1200}},
+		{Type: SquareGoToJail, Name: "Go To Jail"},
+		{Type: SquareProperty, Name: "Pacific Avenue", Price: 300, Group: "green", HouseCost: 200, Rent: []int{26, 130, 390, 900, 1100, 1275}},
+		{Type: SquareProperty, Name: "North Carolina Avenue", Price: 300, Group: "green", HouseCost: 200, Rent: []int{26, 130, 390, 900, 1100, 1275}},
+		{Type: SquareChest, Name: "Community Chest"},
+		{Type: SquareProperty, Name: "Pennsylvania Avenue", Price: 320, Group: "green", HouseCost: 200, Rent: []int{28, 150, 450, 1000, 1200, 1400}},
+		{Type: SquareRailroad, Name: "Short Line", Price: 200, Rent: railroadRent},
+		{Type: SquareChance, Name: "Chance"},
+		{Type: SquareProperty, Name: "Park Place", Price: 350, Group: "darkBlue", HouseCost: 200, Rent: []int{35, 175, 500, 1100, 1300, 1500}},
+		{Type: SquareTax, Name: "Luxury Tax", Amount: 100},
+		{Type: SquareProperty, Name: "Boardwalk", Price: 400, Group: "darkBlue", HouseCost: 200, Rent: []int{50, 200, 600, 1400, 1700, 2000}},
+	},
+}
+
+var (
+	railroadRent = []int{25, 50, 100, 200}
+	utilityRent  = []int{4, 10}
+)
+
+// BailCost is what getting out of jail early costs.
+const BailCost = 50
+
+// SquareAt returns the square a player at position is standing on.
+// Positions keep counting up past GO, so they wrap around the board.
+func (b *Board) SquareAt(position int) Square {
+	return b.Squares[position%len(b.Squares)]
+}
+
+// PropertyPrice returns what the square called name costs, or false if
+// no square by that name can be bought.
+func (b *Board) PropertyPrice(name string) (int, bool) {
+	for _, sq := range b.Squares {
+		if sq.Name == name && sq.Price > 0 {
+			return sq.Price, true
+		}
+	}
+	return 0, false
+}
+
+// PropertyOwner returns who owns the property called name, if anyone.
+func (s *GameState) PropertyOwner(name string) string {
+	for owner, p := range s.Players {
+		for _, prop := range p.Properties {
+			if prop == name {
+				return owner
+			}
+		}
+	}
+	return ""
+}
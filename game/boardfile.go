@@ -0,0 +1,247 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultBoardSize is how many squares a board has unless a server says
+// otherwise.
+const DefaultBoardSize = 40
+
+// BoardProblem is something wrong with a board definition. Line is the
+// line of the definition it was found on, or zero if it isn't about any
+// one line.
+type BoardProblem struct {
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// BoardError lists everything wrong with a board definition.
+type BoardError struct {
+	Problems []BoardProblem
+}
+
+func (e *BoardError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Message
+		if p.Line > 0 {
+			msgs[i] = fmt.Sprintf("line %d: %s", p.Line, p.Message)
+		}
+	}
+	return "invalid board: " + strings.Join(msgs, "; ")
+}
+
+// ParseBoard decodes a board definition and checks that a game can be
+// played on it: it has size squares with GO first, one jail and one go to
+// jail, every square is complete for its type, names of squares that can
+// be bought are unique, and each colour group has at least two properties
+// sharing a house cost. Decks, if given, must belong to card squares on
+// the board, and translations must be of text on the board. Anything
+// wrong is reported as a *BoardError.
+func ParseBoard(data []byte, size int) (*Board, error) {
+	var b Board
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		line := 0
+		var syntax *json.SyntaxError
+		var typ *json.UnmarshalTypeError
+		if errors.As(err, &syntax) {
+			// The offset is just past the character that was wrong.
+			line = lineAt(data, max(syntax.Offset-1, 0))
+		} else if errors.As(err, &typ) {
+			line = lineAt(data, typ.Offset)
+		}
+		return nil, &BoardError{Problems: []BoardProblem{{Line: line, Message: err.Error()}}}
+	}
+	if problems := b.check(size, squareLines(data)); len(problems) > 0 {
+		return nil, &BoardError{Problems: problems}
+	}
+	return &b, nil
+}
+
+// check returns what is wrong with b. lines holds the line each square
+// starts on, as far as it is known.
+func (b *Board) check(size int, lines []int) []BoardProblem {
+	var problems []BoardProblem
+	report := func(i int, format string, args ...interface{}) {
+		p := BoardProblem{Message: fmt.Sprintf(format, args...)}
+		if i >= 0 {
+			p.Message = fmt.Sprintf("squares[%d]: %s", i, p.Message)
+			if i < len(lines) {
+				p.Line = lines[i]
+			}
+		}
+		problems = append(problems, p)
+	}
+
+	if len(b.Squares) != size {
+		report(-1, "a board has %d squares, not %d", size, len(b.Squares))
+	}
+	if len(b.Squares) > 0 && b.Squares[0].Type != SquareGo {
+		report(0, "the first square must be GO")
+	}
+	count := make(map[string]int)
+	names := make(map[string]int)
+	groups := make(map[string][]int)
+	for i, sq := range b.Squares {
+		count[sq.Type]++
+		if sq.Name == "" {
+			report(i, "name is required")
+		}
+		switch sq.Type {
+		case SquareProperty, SquareRailroad, SquareUtility:
+			if sq.Price <= 0 {
+				report(i, "a %s needs a price", sq.Type)
+			}
+			if len(sq.Rent) == 0 {
+				report(i, "a %s needs a rent table", sq.Type)
+			}
+			for _, rent := range sq.Rent {
+				if rent <= 0 {
+					report(i, "rents must be positive")
+					break
+				}
+			}
+			if first, ok := names[sq.Name]; ok && sq.Name != "" {
+				report(i, "%q is already squares[%d]; squares that can be bought need unique names", sq.Name, first)
+			} else {
+				names[sq.Name] = i
+			}
+		case SquareGo, SquareTax, SquareChance, SquareChest, SquareJail, SquareGoToJail, SquareFreeParking:
+			if sq.Price != 0 || len(sq.Rent) != 0 {
+				report(i, "a %s square can't be bought", sq.Type)
+			}
+		default:
+			report(i, "unknown square type %q", sq.Type)
+			continue
+		}
+		if sq.Type == SquareProperty {
+			if len(sq.Rent) != 6 {
+				report(i, "a property's rent table has 6 entries: bare, 1 to 4 houses, and a hotel")
+			}
+			if sq.Group == "" {
+				report(i, "a property needs a colour group")
+			} else {
+				groups[sq.Group] = append(groups[sq.Group], i)
+			}
+			if sq.HouseCost <= 0 {
+				report(i, "a property needs a house cost")
+			}
+		} else if sq.Group != "" || sq.HouseCost != 0 {
+			report(i, "only properties have a colour group and house cost")
+		}
+		if (sq.Type == SquareTax) != (sq.Amount > 0) {
+			if sq.Type == SquareTax {
+				report(i, "a tax square needs an amount")
+			} else {
+				report(i, "only tax squares have an amount")
+			}
+		}
+	}
+	if count[SquareGo] != 1 {
+		report(-1, "a board has one GO square, not %d", count[SquareGo])
+	}
+	if count[SquareJail] != 1 || count[SquareGoToJail] != 1 {
+		report(-1, "a board has one jail and one go to jail square, not %d and %d", count[SquareJail], count[SquareGoToJail])
+	}
+	for group, members := range groups {
+		if len(members) < 2 {
+			report(members[0], "colour group %q has only one property", group)
+			continue
+		}
+		for _, i := range members[1:] {
+			if b.Squares[i].HouseCost != b.Squares[members[0]].HouseCost {
+				report(i, "house cost differs from the rest of colour group %q", group)
+			}
+		}
+	}
+	text := make(map[string]bool)
+	for _, sq := range b.Squares {
+		text[sq.Name] = true
+	}
+	for _, cards := range b.Decks {
+		for _, card := range cards {
+			text[card.Text] = true
+		}
+	}
+	for locale, translations := range b.Translations {
+		for from, to := range translations {
+			if !text[from] {
+				report(-1, "translations.%s: %q isn't a square or card on the board", locale, from)
+			} else if to == "" {
+				report(-1, "translations.%s: the translation of %q is empty", locale, from)
+			}
+		}
+	}
+	for deck, cards := range b.Decks {
+		if deck != SquareChance && deck != SquareChest {
+			report(-1, "decks are %q and %q, not %q", SquareChance, SquareChest, deck)
+			continue
+		}
+		if count[deck] == 0 {
+			report(-1, "there is a %s deck but no %s square", deck, deck)
+		}
+		if len(cards) == 0 {
+			report(-1, "the %s deck is empty", deck)
+		}
+		for j, card := range cards {
+			if card.Text == "" {
+				report(-1, "decks.%s[%d]: text is required", deck, j)
+			}
+		}
+	}
+	return problems
+}
+
+// squareLines returns the line each element of the definition's squares
+// array starts on.
+func squareLines(data []byte) []int {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if key != "squares" {
+			var skip json.RawMessage
+			if dec.Decode(&skip) != nil {
+				return nil
+			}
+			continue
+		}
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			return nil
+		}
+		var lines []int
+		for dec.More() {
+			lines = append(lines, lineAt(data, dec.InputOffset()))
+			var skip json.RawMessage
+			if dec.Decode(&skip) != nil {
+				break
+			}
+		}
+		return lines
+	}
+	return nil
+}
+
+// lineAt returns the line of the first token at or after offset.
+func lineAt(data []byte, offset int64) int {
+	i := int(offset)
+	for i < len(data) && strings.IndexByte(" \t\r\n,:", data[i]) >= 0 {
+		i++
+	}
+	if i > len(data) {
+		i = len(data)
+	}
+	return bytes.Count(data[:i], []byte("\n")) + 1
+}
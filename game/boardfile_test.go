@@ -0,0 +1,151 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// boardJSON writes squares as a definition with each square on a line of
+// its own, so squares[i] is on line i+3.
+func boardJSON(t *testing.T, squares []Square, extra string) []byte {
+	t.Helper()
+	lines := make([]string, len(squares))
+	for i, sq := range squares {
+		data, err := json.Marshal(sq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines[i] = string(data)
+	}
+	return []byte("{" + extra + "\n\"squares\": [\n" + strings.Join(lines, ",\n") + "\n]\n}")
+}
+
+// standardSquares returns a copy of the standard board's squares, changed
+// by edit.
+func standardSquares(edit func([]Square) []Square) []Square {
+	squares := append([]Square(nil), Standard.Squares...)
+	return edit(squares)
+}
+
+func TestParseBoard(t *testing.T) {
+	board, err := ParseBoard(boardJSON(t, Standard.Squares, ""), DefaultBoardSize)
+	if err != nil {
+		t.Fatalf("the standard board doesn't parse: %v", err)
+	}
+	if len(board.Squares) != DefaultBoardSize || board.Squares[39].Name != "Boardwalk" {
+		t.Errorf("parsed %d squares, the last %+v", len(board.Squares), board.Squares[len(board.Squares)-1])
+	}
+}
+
+func TestParseBoardErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		size int
+		// want is a problem the board must be reported with, on line.
+		want string
+		line int
+	}{
+		{
+			name: "bad square type",
+			data: boardJSON(t, standardSquares(func(s []Square) []Square { s[12].Type = "casino"; return s }), ""),
+			want: `squares[12]: unknown square type "casino"`, line: 15,
+		},
+		{
+			name: "missing price",
+			data: boardJSON(t, standardSquares(func(s []Square) []Square { s[1].Price = 0; return s }), ""),
+			want: "squares[1]: a property needs a price", line: 4,
+		},
+		{
+			name: "railroad without rent",
+			data: boardJSON(t, standardSquares(func(s []Square) []Square { s[5].Rent = nil; return s }), ""),
+			want: "squares[5]: a railroad needs a rent table", line: 8,
+		},
+		{
+			name: "short rent table",
+			data: boardJSON(t, standardSquares(func(s []Square) []Square { s[3].Rent = []int{4, 20}; return s }), ""),
+			want: "squares[3]: a property's rent table has 6 entries", line: 6,
+		},
+		{
+			name: "too few squares",
+			data: boardJSON(t, standardSquares(func(s []Square) []Square { return s[:39] }), ""),
+			want: "a board has 40 squares, not 39",
+		},
+		{
+			name: "wrong size for the server",
+			data: boardJSON(t, Standard.Squares, ""),
+			size: 36,
+			want: "a board has 36 squares, not 40",
+		},
+		{
+			name: "GO not first",
+			data: boardJSON(t, standardSquares(func(s []Square) []Square { s[0], s[2] = s[2], s[0]; return s }), ""),
+			want: "squares[0]: the first square must be GO", line: 3,
+		},
+		{
+			name: "two jails",
+			data: boardJSON(t, standardSquares(func(s []Square) []Square { s[20] = Square{Type: SquareJail, Name: "Jail"}; return s }), ""),
+			want: "one jail and one go to jail square, not 2 and 1",
+		},
+		{
+			name: "tax without an amount",
+			data: boardJSON(t, standardSquares(func(s []Square) []Square { s[4].Amount = 0; return s }), ""),
+			want: "squares[4]: a tax square needs an amount", line: 7,
+		},
+		{
+			name: "lone colour group",
+			data: boardJSON(t, standardSquares(func(s []Square) []Square { s[3].Group = "purple"; return s }), ""),
+			want: `colour group "brown" has only one property`, line: 4,
+		},
+		{
+			name: "duplicate name",
+			data: boardJSON(t, standardSquares(func(s []Square) []Square { s[3].Name = s[1].Name; return s }), ""),
+			want: `squares[3]: "Mediterranean Avenue" is already squares[1]`, line: 6,
+		},
+		{
+			name: "unknown deck",
+			data: boardJSON(t, Standard.Squares, `"decks": {"bonus": [{"text": "Free money"}]},`),
+			want: `not "bonus"`,
+		},
+		{
+			name: "unknown field",
+			data: boardJSON(t, Standard.Squares, `"rules": {},`),
+			want: `unknown field "rules"`,
+		},
+		{
+			name: "syntax error",
+			data: []byte("{\n\"squares\": [\n{\"type\": }\n]\n}"),
+			want: "invalid character", line: 3,
+		},
+		{
+			name: "wrong type",
+			data: []byte("{\n\"squares\": [\n{\"type\": \"property\", \"price\": \"cheap\"}\n]\n}"),
+			want: "cannot unmarshal string", line: 3,
+		},
+	} {
+		size := tc.size
+		if size == 0 {
+			size = DefaultBoardSize
+		}
+		_, err := ParseBoard(tc.data, size)
+		var boardErr *BoardError
+		if !errors.As(err, &boardErr) {
+			t.Errorf("%s: got %v, want a *BoardError", tc.name, err)
+			continue
+		}
+		found := false
+		for _, p := range boardErr.Problems {
+			if strings.Contains(p.Message, tc.want) {
+				found = true
+				if p.Line != tc.line {
+					t.Errorf("%s: %q reported on line %d, want %d", tc.name, p.Message, p.Line, tc.line)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: problems %+v, want one about %s", tc.name, boardErr.Problems, tc.want)
+		}
+	}
+}
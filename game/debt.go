@@ -0,0 +1,78 @@
+package game
+
+// A player who owes more than they have mortgages what they can to pay
+// it; see mortgage.go. If that isn't enough they go bankrupt: whatever
+// cash they have goes to whoever they owe, and so do their properties,
+// mortgages and all, if that is another player. If they owe the bank,
+// their properties go back to it free of mortgages. A bankrupt player is
+// out of the game.
+
+// collect has debtor pay up to amount to creditor, or to the bank if
+// creditor is empty, mortgaging their deeds first if they are short. It
+// returns what they paid, and Mortgaged if they mortgaged anything. A
+// debtor who paid less than amount can't pay it.
+func (e *Engine) collect(state *GameState, debtor *Player, creditor string, amount int) (int, []Effect) {
+	effects := e.raise(debtor, amount)
+	paid := min(amount, max(debtor.Balance, 0))
+	debtor.Balance -= paid
+	if to, ok := state.Players[creditor]; ok {
+		to.Balance += paid
+	}
+	return paid, effects
+}
+
+// bankrupt puts debtor out of the game, owing creditor more than they
+// have, and returns what that did: Bankrupted, and TurnPassed if it was
+// their turn and anyone is left to take it. A creditor who took over
+// mortgaged deeds, and is still playing someone, then pays the interest on
+// them as takeMortgaged does.
+func (e *Engine) bankrupt(state *GameState, debtor *Player, creditor string) []Effect {
+	deeds, mortgaged := debtor.Properties, debtor.Mortgaged
+	debtor.Properties, debtor.Mortgaged = nil, nil
+	to, ok := state.Players[creditor]
+	if ok {
+		to.Properties = append(to.Properties, deeds...)
+		to.Mortgaged = append(to.Mortgaged, mortgaged...)
+		to.BankruptciesInflicted++
+	}
+	hadTurn := state.Turn == debtor.Name
+	next := Eliminate(state, debtor.Name, e.SkipAbsent)
+	effects := []Effect{Bankrupted{Player: debtor.Name, Creditor: creditor, Properties: deeds, Place: debtor.Place}}
+	if hadTurn && next != "" {
+		PassTurn(state, next)
+		effects = append(effects, TurnPassed{Next: next})
+	}
+	if ok && len(mortgaged) > 0 && len(state.TurnOrder) > 1 {
+		effects = append(effects, e.takeMortgaged(state, to, mortgaged)...)
+	}
+	return effects
+}
+
+// Eliminate takes name out of the game: anything they still own goes back
+// to the bank free of mortgages, any choice they owed over mortgages is
+// dropped, they are marked forfeited with the place they finished in, and
+// they leave the turn order. It returns who plays after them, or ""
+// if they were the last but one and the game is over. Passing the turn,
+// if it was theirs, is up to the caller.
+func Eliminate(state *GameState, name string, skipAbsent bool) string {
+	player := state.Players[name]
+	player.Properties, player.Mortgaged = nil, nil
+	state.dropMortgageChoice(name)
+	player.Forfeited = true
+	player.Place = len(state.TurnOrder)
+	next := ""
+	if len(state.TurnOrder) > 2 {
+		next = NextSeat(state, name, skipAbsent)
+	}
+	order := state.TurnOrder[:0]
+	for _, n := range state.TurnOrder {
+		if n != name {
+			order = append(order, n)
+		}
+	}
+	state.TurnOrder = order
+	if state.Turn == name {
+		state.Offer = ""
+	}
+	return next
+}
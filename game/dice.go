@@ -0,0 +1,43 @@
+package game
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+)
+
+// Roller rolls a pair of dice.
+type Roller interface {
+	Roll() (int, int)
+}
+
+// SeededRoller rolls dice from a math/rand source, so the same seed always
+// gives the same rolls in the same order.
+type SeededRoller struct {
+	rand *rand.Rand
+}
+
+// NewSeededRoller returns a roller seeded with seed that has already made
+// skip rolls, so a restored game carries on with the rolls it would have
+// had.
+func NewSeededRoller(seed int64, skip int) *SeededRoller {
+	r := &SeededRoller{rand: rand.New(rand.NewSource(seed))}
+	for i := 0; i < skip; i++ {
+		r.Roll()
+	}
+	return r
+}
+
+func (r *SeededRoller) Roll() (int, int) {
+	return r.rand.Intn(6) + 1, r.rand.Intn(6) + 1
+}
+
+// NewSeed returns a seed for a new game's dice. It comes from crypto/rand,
+// so nobody can work it out from when the game was created.
+func NewSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
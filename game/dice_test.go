@@ -0,0 +1,56 @@
+package game
+
+import (
+	"math"
+	"testing"
+)
+
+// TestSeededRollerIsFair rolls a fixed seed's dice many times and checks
+// the totals pass DiceStats' chi-square test, and doubles come up about a
+// sixth of the time.
+func TestSeededRollerIsFair(t *testing.T) {
+	const rolls = 36000
+	state := &GameState{}
+	dice := NewSeededRoller(20240601, 0)
+	for i := 0; i < rolls; i++ {
+		d1, d2 := dice.Roll()
+		if d1 < 1 || d1 > 6 || d2 < 1 || d2 > 6 {
+			t.Fatalf("roll %d came up %d and %d", i, d1, d2)
+		}
+		state.recordRoll([]string{"ann", "bob"}[i%2], d1, d2)
+	}
+
+	stats := state.DiceStats
+	if stats.Rolls != rolls || stats.Players["ann"].Rolls != rolls/2 || stats.Players["bob"].Rolls != rolls/2 {
+		t.Fatalf("counted %d rolls, %d for ann and %d for bob", stats.Rolls, stats.Players["ann"].Rolls, stats.Players["bob"].Rolls)
+	}
+	if chi := stats.ChiSquare(); chi > 23.2 {
+		t.Errorf("chi-square %.1f over %d rolls, totals %v, expected %v", chi, rolls, stats.Totals, stats.Expected())
+	}
+	// The doubles are binomial: a sixth of the rolls, give or take three
+	// standard deviations.
+	p := 1.0 / 6
+	if spread := 3 * math.Sqrt(rolls*p*(1-p)); math.Abs(float64(stats.Doubles)-rolls*p) > spread {
+		t.Errorf("%d doubles in %d rolls", stats.Doubles, rolls)
+	}
+	if n := len(state.RollHistory("ann")); n != rolls/2 {
+		t.Errorf("ann's history has %d rolls", n)
+	}
+}
+
+// TestSeededRollerSkip checks a roller that skips rolls carries on where
+// one with the same seed that made them would.
+func TestSeededRollerSkip(t *testing.T) {
+	dice := NewSeededRoller(7, 0)
+	for i := 0; i < 5; i++ {
+		dice.Roll()
+	}
+	skipped := NewSeededRoller(7, 5)
+	for i := 0; i < 20; i++ {
+		a1, a2 := dice.Roll()
+		b1, b2 := skipped.Roll()
+		if a1 != b1 || a2 != b2 {
+			t.Fatalf("roll %d: %d %d, skipped roller %d %d", i+5, a1, a2, b1, b2)
+		}
+	}
+}
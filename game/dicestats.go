@@ -0,0 +1,92 @@
+package game
+
+// Dice statistics cover the rolls the game's own dice make. A roll a
+// player reports for themselves isn't the server's, so it is left out.
+
+// diceWays counts the ways two dice make each total, 2 to 12, out of 36.
+var diceWays = [11]int{1, 2, 3, 4, 5, 6, 5, 4, 3, 2, 1}
+
+// DiceStats sums up a game's rolls. Totals[i] counts the rolls that came
+// to i+2.
+type DiceStats struct {
+	Rolls   int                         `json:"rolls"`
+	Doubles int                         `json:"doubles"`
+	Totals  [11]int                     `json:"totals"`
+	Players map[string]*PlayerDiceStats `json:"players"`
+}
+
+// PlayerDiceStats sums up one player's rolls.
+type PlayerDiceStats struct {
+	Rolls   int     `json:"rolls"`
+	Doubles int     `json:"doubles"`
+	Totals  [11]int `json:"totals"`
+}
+
+// DiceRoll is one roll of the game's dice: who it was for, on which turn,
+// and how each die came up.
+type DiceRoll struct {
+	Player string `json:"player"`
+	Turn   int    `json:"turn"`
+	Dice   [2]int `json:"dice"`
+}
+
+// recordRoll counts a roll of d1 and d2 for player.
+func (state *GameState) recordRoll(player string, d1, d2 int) {
+	if state.DiceStats == nil {
+		state.DiceStats = &DiceStats{}
+	}
+	stats := state.DiceStats
+	if stats.Players == nil {
+		stats.Players = make(map[string]*PlayerDiceStats)
+	}
+	p := stats.Players[player]
+	if p == nil {
+		p = &PlayerDiceStats{}
+		stats.Players[player] = p
+	}
+	stats.Rolls++
+	p.Rolls++
+	stats.Totals[d1+d2-2]++
+	p.Totals[d1+d2-2]++
+	if d1 == d2 {
+		stats.Doubles++
+		p.Doubles++
+	}
+	state.DiceHistory = append(state.DiceHistory, DiceRoll{Player: player, Turn: state.Turns, Dice: [2]int{d1, d2}})
+}
+
+// Expected returns how many rolls would come to each total, on average,
+// over as many rolls as s counts.
+func (s *DiceStats) Expected() [11]float64 {
+	var expected [11]float64
+	for i, ways := range diceWays {
+		expected[i] = float64(s.Rolls) * float64(ways) / 36
+	}
+	return expected
+}
+
+// ChiSquare measures how far the totals are from what fair dice would
+// give. With ten degrees of freedom fair dice score over 23.2 one time in
+// a hundred; it means little until there have been a few dozen rolls.
+func (s *DiceStats) ChiSquare() float64 {
+	if s.Rolls == 0 {
+		return 0
+	}
+	chi := 0.0
+	for i, expected := range s.Expected() {
+		diff := float64(s.Totals[i]) - expected
+		chi += diff * diff / expected
+	}
+	return chi
+}
+
+// RollHistory returns player's rolls, oldest first.
+func (state *GameState) RollHistory(player string) []DiceRoll {
+	var rolls []DiceRoll
+	for _, roll := range state.DiceHistory {
+		if roll.Player == player {
+			rolls = append(rolls, roll)
+		}
+	}
+	return rolls
+}
@@ -0,0 +1,387 @@
+// Package game holds the rules of the game, apart from how it is played
+// over the network. An Engine applies a player's Action to a GameState and
+// reports what happened as Effects; the server decodes actions from client
+// messages and turns effects into broadcasts.
+package game
+
+import "errors"
+
+// Action is a move made in the game: RollDice, PayBail, BuyProperty,
+// DeclinePurchase, Unmortgage, EndTurn or ChooseMortgages.
+type Action interface {
+	action()
+}
+
+// RollDice rolls the game's dice for Player and moves them by the total.
+type RollDice struct {
+	Player string
+}
+
+// PayBail gets Player out of jail for BailCost before they roll.
+type PayBail struct {
+	Player string
+}
+
+// BuyProperty buys the property on offer for Player. Property, if given,
+// must be the one on offer.
+type BuyProperty struct {
+	Player   string
+	Property string
+}
+
+// DeclinePurchase turns down the property on offer.
+type DeclinePurchase struct {
+	Player string
+}
+
+// Unmortgage lifts the mortgage on Player's deed to Property for
+// UnmortgageCost.
+type Unmortgage struct {
+	Player   string
+	Property string
+}
+
+// EndTurn passes the turn on from Player to the next seat.
+type EndTurn struct {
+	Player string
+}
+
+// ChooseMortgages makes the choice Player owes over mortgaged deeds that
+// came to them, lifting the mortgages on Unmortgage and keeping the rest.
+// Unlike the other actions it doesn't have to be Player's turn.
+type ChooseMortgages struct {
+	Player     string
+	Unmortgage []string
+}
+
+func (RollDice) action()        {}
+func (PayBail) action()         {}
+func (BuyProperty) action()     {}
+func (DeclinePurchase) action() {}
+func (Unmortgage) action()      {}
+func (EndTurn) action()         {}
+func (ChooseMortgages) action() {}
+
+// Effect is something an action did to the game: DiceRolled, RentPaid,
+// TaxPaid, SentToJail, LeftJail, StayedInJail, Mortgaged, Bankrupted,
+// MortgagesTransferred, MortgageChoiceOwed, MortgagesChosen, BailPaid,
+// PropertyBought, PurchaseDeclined, Unmortgaged or TurnPassed.
+type Effect interface {
+	effect()
+}
+
+// DiceRolled reports that Player rolled Roll and moved to Position. Dice
+// are how the game's dice came up.
+type DiceRolled struct {
+	Player   string
+	Roll     int
+	Dice     [2]int
+	Position int
+}
+
+// RentPaid reports that Player paid Owner Amount in rent for landing on
+// Property. Amount is what they actually paid, which is everything they
+// had if it falls short of the rent and Bankrupted follows.
+type RentPaid struct {
+	Player   string
+	Owner    string
+	Property string
+	Amount   int
+}
+
+// TaxPaid reports that Player paid Amount in tax for landing on Square.
+// As with rent, Amount falls short of the tax if Bankrupted follows.
+type TaxPaid struct {
+	Player string
+	Square string
+	Amount int
+}
+
+// SentToJail reports that Player was sent to jail, at Position.
+type SentToJail struct {
+	Player   string
+	Position int
+}
+
+// LeftJail reports that Player rolled doubles and left jail.
+type LeftJail struct {
+	Player string
+}
+
+// StayedInJail reports that Player didn't roll doubles and stays in jail
+// for TurnsLeft more turns at most.
+type StayedInJail struct {
+	Player    string
+	TurnsLeft int
+}
+
+// Bankrupted reports that Player couldn't pay what they owed Creditor, or
+// the bank if Creditor is empty, and is out of the game in Place.
+// Properties are the deeds Creditor took over from them, or that went
+// back to the bank.
+type Bankrupted struct {
+	Player     string
+	Creditor   string
+	Properties []string
+	Place      int
+}
+
+// Mortgaged reports that Player mortgaged Properties, raising Raised, to
+// pay a debt.
+type Mortgaged struct {
+	Player     string
+	Properties []string
+	Raised     int
+}
+
+// MortgagesTransferred reports that Player was given the mortgaged
+// Properties and paid Interest on them. If that falls short of what they
+// owed, Bankrupted follows.
+type MortgagesTransferred struct {
+	Player     string
+	Properties []string
+	Interest   int
+}
+
+// MortgageChoiceOwed reports that Player owes the choice of which
+// mortgages on Properties to lift; see MortgageChoice.
+type MortgageChoiceOwed struct {
+	Player     string
+	Properties []string
+}
+
+// MortgagesChosen reports that Player lifted the mortgages on Unmortgaged,
+// paying Paid, and kept those on Kept.
+type MortgagesChosen struct {
+	Player      string
+	Unmortgaged []string
+	Kept        []string
+	Paid        int
+}
+
+// BailPaid reports that Player paid Cost to get out of jail, before
+// rolling or because they had no turns in jail left.
+type BailPaid struct {
+	Player string
+	Cost   int
+}
+
+// PropertyBought reports that Player bought Property for Price.
+type PropertyBought struct {
+	Player   string
+	Property string
+	Price    int
+}
+
+// PurchaseDeclined reports that Player turned down Property.
+type PurchaseDeclined struct {
+	Player   string
+	Property string
+}
+
+// Unmortgaged reports that Player lifted the mortgage on Property for
+// Cost.
+type Unmortgaged struct {
+	Player   string
+	Property string
+	Cost     int
+}
+
+// TurnPassed reports that it is now Next's turn.
+type TurnPassed struct {
+	Next string
+}
+
+func (DiceRolled) effect()           {}
+func (RentPaid) effect()             {}
+func (TaxPaid) effect()              {}
+func (SentToJail) effect()           {}
+func (LeftJail) effect()             {}
+func (StayedInJail) effect()         {}
+func (Mortgaged) effect()            {}
+func (Bankrupted) effect()           {}
+func (MortgagesTransferred) effect() {}
+func (MortgageChoiceOwed) effect()   {}
+func (MortgagesChosen) effect()      {}
+func (BailPaid) effect()             {}
+func (PropertyBought) effect()       {}
+func (PurchaseDeclined) effect()     {}
+func (Unmortgaged) effect()          {}
+func (TurnPassed) effect()           {}
+
+// Errors Apply returns for actions the rules don't allow.
+var (
+	ErrUnknownPlayer = errors.New("no such player in the game")
+	ErrUnknownAction = errors.New("unknown action")
+	ErrNotYourTurn   = errors.New("it isn't your turn")
+	ErrNotAvailable  = errors.New("that can't be done now")
+)
+
+// Engine applies actions to a game.
+type Engine struct {
+	// Board is the board the game is played on.
+	Board *Board
+	// Dice rolls for every player. Given dice that roll the same, the same
+	// state and actions always have the same outcome.
+	Dice Roller
+	// SkipAbsent passes the turn over players who have disconnected and
+	// aren't being played by a bot.
+	SkipAbsent bool
+}
+
+// Apply carries out action on state and returns what it did. If the rules
+// don't allow the action, state is left as it was and the error says why.
+// An action is allowed only if AvailableActions lists it, except for
+// ChooseMortgages, which is allowed while its player owes the choice.
+func (e *Engine) Apply(state *GameState, action Action) ([]Effect, error) {
+	var name, kind, property string
+	switch a := action.(type) {
+	case RollDice:
+		name, kind = a.Player, ActionRollDice
+	case PayBail:
+		name, kind = a.Player, ActionPayBail
+	case BuyProperty:
+		name, kind, property = a.Player, ActionBuyProperty, a.Property
+	case DeclinePurchase:
+		name, kind = a.Player, ActionDeclinePurchase
+	case Unmortgage:
+		if a.Property == "" {
+			return nil, ErrNotAvailable
+		}
+		name, kind, property = a.Player, ActionUnmortgage, a.Property
+	case EndTurn:
+		name, kind = a.Player, ActionEndTurn
+	case ChooseMortgages:
+		return e.chooseMortgages(state, a)
+	default:
+		return nil, ErrUnknownAction
+	}
+	player, ok := state.Players[name]
+	if !ok {
+		return nil, ErrUnknownPlayer
+	}
+	if state.Turn != name {
+		return nil, ErrNotYourTurn
+	}
+	offered, ok := available(e.Board, state, name, kind, property)
+	if !ok {
+		return nil, ErrNotAvailable
+	}
+	var effects []Effect
+	switch action.(type) {
+	case RollDice:
+		effects = e.rollDice(state, player)
+	case PayBail:
+		player.Balance -= offered.Cost
+		player.JailTurns = 0
+		effects = []Effect{BailPaid{Player: name, Cost: offered.Cost}}
+	case BuyProperty:
+		player.Balance -= offered.Price
+		player.Properties = append(player.Properties, offered.Property)
+		state.Offer = ""
+		effects = []Effect{PropertyBought{Player: name, Property: offered.Property, Price: offered.Price}}
+	case DeclinePurchase:
+		state.Offer = ""
+		effects = []Effect{PurchaseDeclined{Player: name, Property: offered.Property}}
+	case Unmortgage:
+		player.Balance -= offered.Cost
+		player.lift(offered.Property)
+		effects = []Effect{Unmortgaged{Player: name, Property: offered.Property, Cost: offered.Cost}}
+	case EndTurn:
+		next := NextSeat(state, name, e.SkipAbsent)
+		PassTurn(state, next)
+		return []Effect{TurnPassed{Next: next}}, nil
+	}
+	for _, effect := range effects {
+		state.advance(effect)
+	}
+	return effects, nil
+}
+
+// rollDice rolls for player and moves them, unless they are in jail and
+// stay there. The square they land on is put up for sale if it is free
+// and they can afford it; if someone else owns it they pay the rent, and
+// if it is a tax they pay that.
+func (e *Engine) rollDice(state *GameState, player *Player) []Effect {
+	d1, d2 := e.Dice.Roll()
+	roll, dice := d1+d2, [2]int{d1, d2}
+	state.DiceRolls++
+	state.recordRoll(player.Name, d1, d2)
+	state.Rolled = true
+	if player.JailTurns > 0 {
+		return e.rollInJail(state, player, dice)
+	}
+	player.Position += roll
+	effects := []Effect{DiceRolled{Player: player.Name, Roll: roll, Dice: dice, Position: player.Position}}
+	return append(effects, e.land(state, player, roll)...)
+}
+
+// land settles the square player has just moved to with a roll of roll.
+func (e *Engine) land(state *GameState, player *Player, roll int) []Effect {
+	square := e.Board.SquareAt(player.Position)
+	switch square.Type {
+	case SquareTax:
+		return e.payTax(state, player, square)
+	case SquareGoToJail:
+		return e.sendToJail(player)
+	}
+	if square.Price == 0 {
+		return nil
+	}
+	switch owner := state.PropertyOwner(square.Name); owner {
+	case "":
+		if square.Price <= player.Balance {
+			state.Offer = square.Name
+		}
+	case player.Name:
+	default:
+		if state.Players[owner].IsMortgaged(square.Name) {
+			return nil
+		}
+		return e.payRent(state, player, owner, square, roll)
+	}
+	return nil
+}
+
+// payTax has player pay the tax on sq to the bank, going bankrupt if they
+// can't.
+func (e *Engine) payTax(state *GameState, player *Player, sq Square) []Effect {
+	paid, effects := e.collect(state, player, "", sq.Amount)
+	effects = append(effects, TaxPaid{Player: player.Name, Square: sq.Name, Amount: paid})
+	if paid < sq.Amount {
+		effects = append(effects, e.bankrupt(state, player, "")...)
+	}
+	return effects
+}
+
+// NextSeat returns who plays after from, which must still be seated. With
+// skipAbsent, players who have disconnected are passed over unless a bot
+// plays for them; if every other seat would be skipped the turn goes to
+// the next seat anyway, so the game never loops.
+func NextSeat(state *GameState, from string, skipAbsent bool) string {
+	order := state.TurnOrder
+	start := 0
+	for i, n := range order {
+		if n == from {
+			start = i
+			break
+		}
+	}
+	for step := 1; step < len(order); step++ {
+		n := order[(start+step)%len(order)]
+		if player := state.Players[n]; !skipAbsent || player.Connected || player.Bot {
+			return n
+		}
+	}
+	return order[(start+1)%len(order)]
+}
+
+// PassTurn makes it next's turn. Anything left on offer to the last
+// player goes unsold.
+func PassTurn(state *GameState, next string) {
+	state.Turn = next
+	state.Rolled = false
+	state.Offer = ""
+	state.Turns++
+	state.advance(TurnPassed{Next: next})
+}
@@ -0,0 +1,616 @@
+package game
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// loadedDice rolls the pairs it holds, in order.
+type loadedDice [][2]int
+
+func (d *loadedDice) Roll() (int, int) {
+	pair := (*d)[0]
+	*d = (*d)[1:]
+	return pair[0], pair[1]
+}
+
+// newGame returns a game in progress between ann, bob and cat on the
+// standard board, at the start of ann's turn.
+func newGame() *GameState {
+	state := &GameState{
+		Status:    StatusInProgress,
+		Players:   make(map[string]*Player),
+		TurnOrder: []string{"ann", "bob", "cat"},
+		Turn:      "ann",
+		Phase:     PhaseAwaitingRoll,
+		Turns:     1,
+	}
+	for _, name := range state.TurnOrder {
+		state.Players[name] = &Player{Name: name, Balance: 1500, Connected: true}
+	}
+	return state
+}
+
+// rolled moves ann's turn on past a roll to position, with offer on offer.
+func rolled(position int, offer string) func(*GameState) {
+	return func(state *GameState) {
+		state.Players["ann"].Position = position
+		state.Rolled = true
+		state.Offer = offer
+		state.Phase = InferPhase(state)
+	}
+}
+
+func TestEngineApply(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		setup  func(*GameState)
+		dice   loadedDice
+		skip   bool
+		action Action
+		want   []Effect
+		err    error
+		check  func(t *testing.T, state *GameState)
+	}{
+		{
+			name:   "roll onto a property for sale",
+			dice:   loadedDice{{2, 4}},
+			action: RollDice{Player: "ann"},
+			want:   []Effect{DiceRolled{Player: "ann", Roll: 6, Dice: [2]int{2, 4}, Position: 6}},
+			check: func(t *testing.T, state *GameState) {
+				if state.Phase != PhaseAwaitingPurchase || state.Offer != "Oriental Avenue" || !state.Rolled {
+					t.Errorf("phase %s, offer %q", state.Phase, state.Offer)
+				}
+				if state.DiceRolls != 1 || state.DiceStats == nil || len(state.DiceHistory) != 1 {
+					t.Errorf("roll not recorded: %d rolls", state.DiceRolls)
+				}
+			},
+		},
+		{
+			name:   "roll onto a square that isn't for sale",
+			dice:   loadedDice{{1, 1}},
+			action: RollDice{Player: "ann"},
+			want:   []Effect{DiceRolled{Player: "ann", Roll: 2, Dice: [2]int{1, 1}, Position: 2}},
+			check: func(t *testing.T, state *GameState) {
+				if state.Phase != PhaseAwaitingEnd || state.Offer != "" {
+					t.Errorf("phase %s, offer %q", state.Phase, state.Offer)
+				}
+			},
+		},
+		{
+			name:   "roll onto a property that can't be afforded",
+			setup:  func(state *GameState) { state.Players["ann"].Balance = 50 },
+			dice:   loadedDice{{3, 3}},
+			action: RollDice{Player: "ann"},
+			want:   []Effect{DiceRolled{Player: "ann", Roll: 6, Dice: [2]int{3, 3}, Position: 6}},
+			check: func(t *testing.T, state *GameState) {
+				if state.Phase != PhaseAwaitingEnd {
+					t.Errorf("phase %s", state.Phase)
+				}
+			},
+		},
+		{
+			name:   "roll past the end of the board",
+			setup:  func(state *GameState) { state.Players["ann"].Position = 38 },
+			dice:   loadedDice{{2, 3}},
+			action: RollDice{Player: "ann"},
+			want:   []Effect{DiceRolled{Player: "ann", Roll: 5, Dice: [2]int{2, 3}, Position: 43}},
+			check: func(t *testing.T, state *GameState) {
+				if state.Offer != "Baltic Avenue" {
+					t.Errorf("offer %q", state.Offer)
+				}
+			},
+		},
+		{
+			name:   "roll onto someone else's property",
+			setup:  func(state *GameState) { state.Players["bob"].Properties = []string{"Oriental Avenue"} },
+			dice:   loadedDice{{2, 4}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 6, Dice: [2]int{2, 4}, Position: 6},
+				RentPaid{Player: "ann", Owner: "bob", Property: "Oriental Avenue", Amount: 6},
+			},
+			check: func(t *testing.T, state *GameState) {
+				if ann, bob := state.Players["ann"], state.Players["bob"]; ann.Balance != 1494 || bob.Balance != 1506 || bob.RentCollected != 6 {
+					t.Errorf("ann has %d, bob %d and collected %d", ann.Balance, bob.Balance, bob.RentCollected)
+				}
+				if state.Phase != PhaseAwaitingEnd || state.Offer != "" {
+					t.Errorf("phase %s, offer %q", state.Phase, state.Offer)
+				}
+			},
+		},
+		{
+			name: "roll onto a complete colour group",
+			setup: func(state *GameState) {
+				state.Players["bob"].Properties = []string{"Baltic Avenue", "Mediterranean Avenue"}
+			},
+			dice:   loadedDice{{1, 2}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 3, Dice: [2]int{1, 2}, Position: 3},
+				RentPaid{Player: "ann", Owner: "bob", Property: "Baltic Avenue", Amount: 8},
+			},
+		},
+		{
+			name: "roll onto a railroad",
+			setup: func(state *GameState) {
+				state.Players["bob"].Properties = []string{"Reading Railroad", "B. & O. Railroad", "Short Line"}
+			},
+			dice:   loadedDice{{1, 4}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 5, Dice: [2]int{1, 4}, Position: 5},
+				RentPaid{Player: "ann", Owner: "bob", Property: "Reading Railroad", Amount: 100},
+			},
+		},
+		{
+			name: "roll onto a utility",
+			setup: func(state *GameState) {
+				state.Players["ann"].Position = 6
+				state.Players["bob"].Properties = []string{"Electric Company"}
+			},
+			dice:   loadedDice{{3, 3}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 6, Dice: [2]int{3, 3}, Position: 12},
+				RentPaid{Player: "ann", Owner: "bob", Property: "Electric Company", Amount: 24},
+			},
+		},
+		{
+			name:   "roll onto your own property",
+			setup:  func(state *GameState) { state.Players["ann"].Properties = []string{"Oriental Avenue"} },
+			dice:   loadedDice{{2, 4}},
+			action: RollDice{Player: "ann"},
+			want:   []Effect{DiceRolled{Player: "ann", Roll: 6, Dice: [2]int{2, 4}, Position: 6}},
+		},
+		{
+			name: "go bankrupt paying rent",
+			setup: func(state *GameState) {
+				state.Players["ann"].Balance = 4
+				state.Players["ann"].Properties = []string{"Baltic Avenue"}
+				state.Players["ann"].Mortgaged = []string{"Baltic Avenue"}
+				state.Players["bob"].Properties = []string{"Oriental Avenue"}
+			},
+			dice:   loadedDice{{2, 4}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 6, Dice: [2]int{2, 4}, Position: 6},
+				RentPaid{Player: "ann", Owner: "bob", Property: "Oriental Avenue", Amount: 4},
+				Bankrupted{Player: "ann", Creditor: "bob", Properties: []string{"Baltic Avenue"}, Place: 3},
+				TurnPassed{Next: "bob"},
+				MortgagesTransferred{Player: "bob", Properties: []string{"Baltic Avenue"}, Interest: 3},
+				MortgageChoiceOwed{Player: "bob", Properties: []string{"Baltic Avenue"}},
+			},
+			check: func(t *testing.T, state *GameState) {
+				ann, bob := state.Players["ann"], state.Players["bob"]
+				if !ann.Forfeited || ann.Balance != 0 || len(ann.Properties) != 0 || len(ann.Mortgaged) != 0 {
+					t.Errorf("ann still has %d and %v", ann.Balance, ann.Properties)
+				}
+				if bob.Balance != 1501 || !reflect.DeepEqual(bob.Properties, []string{"Oriental Avenue", "Baltic Avenue"}) || bob.BankruptciesInflicted != 1 {
+					t.Errorf("bob has %d and %v", bob.Balance, bob.Properties)
+				}
+				if !bob.IsMortgaged("Baltic Avenue") {
+					t.Error("Baltic Avenue came to bob unmortgaged")
+				}
+				if !reflect.DeepEqual(state.TurnOrder, []string{"bob", "cat"}) || state.Turn != "bob" || state.Phase != PhaseAwaitingRoll {
+					t.Errorf("turn order %v, turn %s, phase %s", state.TurnOrder, state.Turn, state.Phase)
+				}
+			},
+		},
+		{
+			name: "go bankrupt to the last player",
+			setup: func(state *GameState) {
+				state.Players["cat"].Forfeited = true
+				state.TurnOrder = []string{"ann", "bob"}
+				state.Players["ann"].Balance = 0
+				state.Players["bob"].Properties = []string{"Oriental Avenue"}
+			},
+			dice:   loadedDice{{2, 4}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 6, Dice: [2]int{2, 4}, Position: 6},
+				RentPaid{Player: "ann", Owner: "bob", Property: "Oriental Avenue", Amount: 0},
+				Bankrupted{Player: "ann", Creditor: "bob", Place: 2},
+			},
+			check: func(t *testing.T, state *GameState) {
+				if !reflect.DeepEqual(state.TurnOrder, []string{"bob"}) {
+					t.Errorf("turn order %v", state.TurnOrder)
+				}
+			},
+		},
+		{
+			name:   "pay tax",
+			dice:   loadedDice{{1, 3}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 4, Dice: [2]int{1, 3}, Position: 4},
+				TaxPaid{Player: "ann", Square: "Income Tax", Amount: 200},
+			},
+			check: func(t *testing.T, state *GameState) {
+				if ann := state.Players["ann"]; ann.Balance != 1300 {
+					t.Errorf("ann has %d", ann.Balance)
+				}
+			},
+		},
+		{
+			name: "go bankrupt paying tax",
+			setup: func(state *GameState) {
+				state.Players["ann"].Balance = 150
+				state.Players["ann"].Properties = []string{"Baltic Avenue"}
+			},
+			dice:   loadedDice{{1, 3}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 4, Dice: [2]int{1, 3}, Position: 4},
+				Mortgaged{Player: "ann", Properties: []string{"Baltic Avenue"}, Raised: 30},
+				TaxPaid{Player: "ann", Square: "Income Tax", Amount: 180},
+				Bankrupted{Player: "ann", Properties: []string{"Baltic Avenue"}, Place: 3},
+				TurnPassed{Next: "bob"},
+			},
+			check: func(t *testing.T, state *GameState) {
+				if owner := state.PropertyOwner("Baltic Avenue"); owner != "" {
+					t.Errorf("%s has ann's deed, not the bank", owner)
+				}
+			},
+		},
+		{
+			name: "mortgage to pay rent",
+			setup: func(state *GameState) {
+				state.Players["ann"].Balance = 0
+				state.Players["ann"].Properties = []string{"Reading Railroad", "Baltic Avenue", "Mediterranean Avenue"}
+				state.Players["bob"].Properties = []string{"Oriental Avenue"}
+			},
+			dice:   loadedDice{{2, 4}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 6, Dice: [2]int{2, 4}, Position: 6},
+				Mortgaged{Player: "ann", Properties: []string{"Mediterranean Avenue"}, Raised: 30},
+				RentPaid{Player: "ann", Owner: "bob", Property: "Oriental Avenue", Amount: 6},
+			},
+			check: func(t *testing.T, state *GameState) {
+				if ann := state.Players["ann"]; ann.Balance != 24 || !reflect.DeepEqual(ann.Mortgaged, []string{"Mediterranean Avenue"}) {
+					t.Errorf("ann has %d and mortgaged %v", ann.Balance, ann.Mortgaged)
+				}
+			},
+		},
+		{
+			name: "land on a mortgaged property",
+			setup: func(state *GameState) {
+				state.Players["bob"].Properties = []string{"Oriental Avenue"}
+				state.Players["bob"].Mortgaged = []string{"Oriental Avenue"}
+			},
+			dice:   loadedDice{{2, 4}},
+			action: RollDice{Player: "ann"},
+			want:   []Effect{DiceRolled{Player: "ann", Roll: 6, Dice: [2]int{2, 4}, Position: 6}},
+			check: func(t *testing.T, state *GameState) {
+				if ann := state.Players["ann"]; ann.Balance != 1500 {
+					t.Errorf("ann has %d", ann.Balance)
+				}
+			},
+		},
+		{
+			name: "go bankrupt to a player who can't pay the interest",
+			setup: func(state *GameState) {
+				railroads := []string{"Reading Railroad", "Pennsylvania Railroad", "B. & O. Railroad", "Short Line"}
+				state.Players["ann"].Position, state.Players["ann"].Balance = 39, 0
+				state.Players["ann"].Properties = railroads
+				state.Players["ann"].Mortgaged = append([]string(nil), railroads...)
+				state.Players["bob"].Balance = 0
+				state.Players["bob"].Properties = []string{"Mediterranean Avenue"}
+			},
+			dice:   loadedDice{{1, 1}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 2, Dice: [2]int{1, 1}, Position: 41},
+				RentPaid{Player: "ann", Owner: "bob", Property: "Mediterranean Avenue", Amount: 0},
+				Bankrupted{Player: "ann", Creditor: "bob", Properties: []string{"Reading Railroad", "Pennsylvania Railroad", "B. & O. Railroad", "Short Line"}, Place: 3},
+				TurnPassed{Next: "bob"},
+				Mortgaged{Player: "bob", Properties: []string{"Mediterranean Avenue"}, Raised: 30},
+				MortgagesTransferred{Player: "bob", Properties: []string{"Reading Railroad", "Pennsylvania Railroad", "B. & O. Railroad", "Short Line"}, Interest: 30},
+				Bankrupted{Player: "bob", Properties: []string{"Mediterranean Avenue", "Reading Railroad", "Pennsylvania Railroad", "B. & O. Railroad", "Short Line"}, Place: 2},
+			},
+			check: func(t *testing.T, state *GameState) {
+				if !reflect.DeepEqual(state.TurnOrder, []string{"cat"}) || state.MortgageChoices != nil {
+					t.Errorf("turn order %v, choices %v", state.TurnOrder, state.MortgageChoices)
+				}
+				for _, name := range []string{"ann", "bob"} {
+					if p := state.Players[name]; len(p.Properties) != 0 || len(p.Mortgaged) != 0 {
+						t.Errorf("%s still has %v, mortgaged %v", name, p.Properties, p.Mortgaged)
+					}
+				}
+			},
+		},
+		{
+			name: "unmortgage",
+			setup: func(state *GameState) {
+				state.Players["ann"].Properties = []string{"Baltic Avenue"}
+				state.Players["ann"].Mortgaged = []string{"Baltic Avenue"}
+			},
+			action: Unmortgage{Player: "ann", Property: "Baltic Avenue"},
+			want:   []Effect{Unmortgaged{Player: "ann", Property: "Baltic Avenue", Cost: 33}},
+			check: func(t *testing.T, state *GameState) {
+				if ann := state.Players["ann"]; ann.Balance != 1467 || ann.Mortgaged != nil || state.Phase != PhaseAwaitingRoll {
+					t.Errorf("ann has %d, mortgaged %v; phase %s", ann.Balance, ann.Mortgaged, state.Phase)
+				}
+			},
+		},
+		{
+			name: "unmortgage without the money",
+			setup: func(state *GameState) {
+				state.Players["ann"].Balance = 32
+				state.Players["ann"].Properties = []string{"Baltic Avenue"}
+				state.Players["ann"].Mortgaged = []string{"Baltic Avenue"}
+			},
+			action: Unmortgage{Player: "ann", Property: "Baltic Avenue"},
+			err:    ErrNotAvailable,
+		},
+		{
+			name:   "unmortgage a property that isn't mortgaged",
+			setup:  func(state *GameState) { state.Players["ann"].Properties = []string{"Baltic Avenue"} },
+			action: Unmortgage{Player: "ann", Property: "Baltic Avenue"},
+			err:    ErrNotAvailable,
+		},
+		{
+			name:   "choose mortgages without a choice owed",
+			action: ChooseMortgages{Player: "bob"},
+			err:    ErrNotAvailable,
+		},
+		{
+			name:   "go to jail",
+			setup:  func(state *GameState) { state.Players["ann"].Position = 65 },
+			dice:   loadedDice{{2, 3}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 5, Dice: [2]int{2, 3}, Position: 70},
+				SentToJail{Player: "ann", Position: 50},
+			},
+			check: func(t *testing.T, state *GameState) {
+				if ann := state.Players["ann"]; ann.Position != 50 || ann.JailTurns != JailTurns {
+					t.Errorf("ann at %d with %d turns in jail", ann.Position, ann.JailTurns)
+				}
+				if state.Phase != PhaseAwaitingEnd {
+					t.Errorf("phase %s", state.Phase)
+				}
+			},
+		},
+		{
+			name: "stay in jail",
+			setup: func(state *GameState) {
+				state.Players["ann"].Position, state.Players["ann"].JailTurns = 10, 3
+			},
+			dice:   loadedDice{{2, 3}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 5, Dice: [2]int{2, 3}, Position: 10},
+				StayedInJail{Player: "ann", TurnsLeft: 2},
+			},
+			check: func(t *testing.T, state *GameState) {
+				if state.Phase != PhaseAwaitingEnd {
+					t.Errorf("phase %s", state.Phase)
+				}
+			},
+		},
+		{
+			name: "roll doubles out of jail",
+			setup: func(state *GameState) {
+				state.Players["ann"].Position, state.Players["ann"].JailTurns = 10, 2
+			},
+			dice:   loadedDice{{1, 1}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 2, Dice: [2]int{1, 1}, Position: 12},
+				LeftJail{Player: "ann"},
+			},
+			check: func(t *testing.T, state *GameState) {
+				if ann := state.Players["ann"]; ann.JailTurns != 0 || state.Offer != "Electric Company" {
+					t.Errorf("ann has %d turns in jail, offer %q", ann.JailTurns, state.Offer)
+				}
+			},
+		},
+		{
+			name: "pay bail after the last turn in jail",
+			setup: func(state *GameState) {
+				state.Players["ann"].Position, state.Players["ann"].JailTurns = 10, 1
+			},
+			dice:   loadedDice{{1, 2}},
+			action: RollDice{Player: "ann"},
+			want: []Effect{
+				DiceRolled{Player: "ann", Roll: 3, Dice: [2]int{1, 2}, Position: 13},
+				BailPaid{Player: "ann", Cost: BailCost},
+			},
+			check: func(t *testing.T, state *GameState) {
+				if ann := state.Players["ann"]; ann.JailTurns != 0 || ann.Balance != 1500-BailCost {
+					t.Errorf("ann has %d turns in jail and %d", ann.JailTurns, ann.Balance)
+				}
+			},
+		},
+		{
+			name:   "roll twice",
+			setup:  rolled(2, ""),
+			action: RollDice{Player: "ann"},
+			err:    ErrNotAvailable,
+		},
+		{
+			name:   "buy",
+			setup:  rolled(6, "Oriental Avenue"),
+			action: BuyProperty{Player: "ann"},
+			want:   []Effect{PropertyBought{Player: "ann", Property: "Oriental Avenue", Price: 100}},
+			check: func(t *testing.T, state *GameState) {
+				ann := state.Players["ann"]
+				if ann.Balance != 1400 || !reflect.DeepEqual(ann.Properties, []string{"Oriental Avenue"}) {
+					t.Errorf("ann has %d and %v", ann.Balance, ann.Properties)
+				}
+				if state.Phase != PhaseAwaitingEnd || state.Offer != "" {
+					t.Errorf("phase %s, offer %q", state.Phase, state.Offer)
+				}
+			},
+		},
+		{
+			name:   "buy naming the property",
+			setup:  rolled(6, "Oriental Avenue"),
+			action: BuyProperty{Player: "ann", Property: "Oriental Avenue"},
+			want:   []Effect{PropertyBought{Player: "ann", Property: "Oriental Avenue", Price: 100}},
+		},
+		{
+			name:   "buy another property",
+			setup:  rolled(6, "Oriental Avenue"),
+			action: BuyProperty{Player: "ann", Property: "Boardwalk"},
+			err:    ErrNotAvailable,
+		},
+		{
+			name:   "buy with nothing on offer",
+			setup:  rolled(2, ""),
+			action: BuyProperty{Player: "ann"},
+			err:    ErrNotAvailable,
+		},
+		{
+			name:   "decline",
+			setup:  rolled(6, "Oriental Avenue"),
+			action: DeclinePurchase{Player: "ann"},
+			want:   []Effect{PurchaseDeclined{Player: "ann", Property: "Oriental Avenue"}},
+			check: func(t *testing.T, state *GameState) {
+				if state.Phase != PhaseAwaitingEnd || state.Offer != "" || len(state.Players["ann"].Properties) != 0 {
+					t.Errorf("phase %s, offer %q", state.Phase, state.Offer)
+				}
+			},
+		},
+		{
+			name:   "end the turn with a property on offer",
+			setup:  rolled(6, "Oriental Avenue"),
+			action: EndTurn{Player: "ann"},
+			err:    ErrNotAvailable,
+		},
+		{
+			name:   "pay bail",
+			setup:  func(state *GameState) { state.Players["ann"].JailTurns = 2 },
+			action: PayBail{Player: "ann"},
+			want:   []Effect{BailPaid{Player: "ann", Cost: BailCost}},
+			check: func(t *testing.T, state *GameState) {
+				ann := state.Players["ann"]
+				if ann.JailTurns != 0 || ann.Balance != 1500-BailCost || state.Phase != PhaseAwaitingRoll {
+					t.Errorf("ann has %d jail turns and %d, phase %s", ann.JailTurns, ann.Balance, state.Phase)
+				}
+			},
+		},
+		{
+			name:   "pay bail out of jail",
+			action: PayBail{Player: "ann"},
+			err:    ErrNotAvailable,
+		},
+		{
+			name: "pay bail without the money",
+			setup: func(state *GameState) {
+				state.Players["ann"].JailTurns = 1
+				state.Players["ann"].Balance = BailCost - 1
+			},
+			action: PayBail{Player: "ann"},
+			err:    ErrNotAvailable,
+		},
+		{
+			name:   "end the turn",
+			setup:  rolled(2, ""),
+			action: EndTurn{Player: "ann"},
+			want:   []Effect{TurnPassed{Next: "bob"}},
+			check: func(t *testing.T, state *GameState) {
+				if state.Turn != "bob" || state.Phase != PhaseAwaitingRoll || state.Rolled || state.Turns != 2 {
+					t.Errorf("turn %s, phase %s, turns %d", state.Turn, state.Phase, state.Turns)
+				}
+			},
+		},
+		{
+			name: "end the turn past an absent player",
+			setup: func(state *GameState) {
+				rolled(2, "")(state)
+				state.Players["bob"].Connected = false
+			},
+			skip:   true,
+			action: EndTurn{Player: "ann"},
+			want:   []Effect{TurnPassed{Next: "cat"}},
+		},
+		{
+			name: "end the turn to an absent player's bot",
+			setup: func(state *GameState) {
+				rolled(2, "")(state)
+				state.Players["bob"].Connected = false
+				state.Players["bob"].Bot = true
+			},
+			skip:   true,
+			action: EndTurn{Player: "ann"},
+			want:   []Effect{TurnPassed{Next: "bob"}},
+		},
+		{
+			name:   "end the turn before rolling",
+			action: EndTurn{Player: "ann"},
+			err:    ErrNotAvailable,
+		},
+		{
+			name:   "out of turn",
+			action: RollDice{Player: "bob"},
+			err:    ErrNotYourTurn,
+		},
+		{
+			name:   "unknown player",
+			action: RollDice{Player: "dan"},
+			err:    ErrUnknownPlayer,
+		},
+		{
+			name:   "unknown action",
+			action: nil,
+			err:    ErrUnknownAction,
+		},
+		{
+			name:   "game not in progress",
+			setup:  func(state *GameState) { state.Status = StatusFinished },
+			action: RollDice{Player: "ann"},
+			err:    ErrNotAvailable,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			state := newGame()
+			if tc.setup != nil {
+				tc.setup(state)
+			}
+			before, _ := json.Marshal(state)
+			dice := tc.dice
+			engine := &Engine{Board: Standard, Dice: &dice, SkipAbsent: tc.skip}
+			effects, err := engine.Apply(state, tc.action)
+			if err != tc.err {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if err != nil {
+				if after, _ := json.Marshal(state); string(after) != string(before) {
+					t.Errorf("refused action changed the state:\n%s\n%s", before, after)
+				}
+				return
+			}
+			if !reflect.DeepEqual(effects, tc.want) {
+				t.Errorf("effects %+v, want %+v", effects, tc.want)
+			}
+			if tc.check != nil {
+				tc.check(t, state)
+			}
+		})
+	}
+}
+
+// TestEngineTurn plays a turn through every phase.
+func TestEngineTurn(t *testing.T) {
+	state := newGame()
+	dice := loadedDice{{4, 5}}
+	engine := &Engine{Board: Standard, Dice: &dice}
+	phases := []string{state.Phase}
+	for _, action := range []Action{RollDice{Player: "ann"}, BuyProperty{Player: "ann"}, EndTurn{Player: "ann"}} {
+		if _, err := engine.Apply(state, action); err != nil {
+			t.Fatalf("%T: %v", action, err)
+		}
+		phases = append(phases, state.Phase)
+	}
+	want := []string{PhaseAwaitingRoll, PhaseAwaitingPurchase, PhaseAwaitingEnd, PhaseAwaitingRoll}
+	if !reflect.DeepEqual(phases, want) {
+		t.Errorf("phases %v, want %v", phases, want)
+	}
+	if state.PropertyOwner("Connecticut Avenue") != "ann" || state.Turn != "bob" {
+		t.Errorf("owner %q, turn %s", state.PropertyOwner("Connecticut Avenue"), state.Turn)
+	}
+}
@@ -0,0 +1,53 @@
+package game
+
+// A player who lands on go to jail is sent to jail and their turn is over.
+// In jail, instead of moving they roll for doubles: doubles let them out
+// and they move by the roll; otherwise they stay, for at most JailTurns
+// turns, after which they must pay the bail and move. They may pay the
+// bail before rolling on any turn to get out at once.
+
+// JailTurns is how many turns a player sent to jail can stay there.
+const JailTurns = 3
+
+// jailPosition returns the position of the jail on the lap a player at
+// position is on.
+func (b *Board) jailPosition(position int) int {
+	for i, sq := range b.Squares {
+		if sq.Type == SquareJail {
+			return position - position%len(b.Squares) + i
+		}
+	}
+	return position
+}
+
+// sendToJail puts player in jail.
+func (e *Engine) sendToJail(player *Player) []Effect {
+	player.Position = e.Board.jailPosition(player.Position)
+	player.JailTurns = JailTurns
+	return []Effect{SentToJail{Player: player.Name, Position: player.Position}}
+}
+
+// rollInJail settles a roll of dice by player, who is in jail.
+func (e *Engine) rollInJail(state *GameState, player *Player, dice [2]int) []Effect {
+	roll := dice[0] + dice[1]
+	player.JailTurns--
+	if dice[0] != dice[1] && player.JailTurns > 0 {
+		return []Effect{
+			DiceRolled{Player: player.Name, Roll: roll, Dice: dice, Position: player.Position},
+			StayedInJail{Player: player.Name, TurnsLeft: player.JailTurns},
+		}
+	}
+	player.JailTurns = 0
+	player.Position += roll
+	effects := []Effect{DiceRolled{Player: player.Name, Roll: roll, Dice: dice, Position: player.Position}}
+	if dice[0] == dice[1] {
+		effects = append(effects, LeftJail{Player: player.Name})
+	} else {
+		paid, raised := e.collect(state, player, "", BailCost)
+		effects = append(append(effects, raised...), BailPaid{Player: player.Name, Cost: paid})
+		if paid < BailCost {
+			return append(effects, e.bankrupt(state, player, "")...)
+		}
+	}
+	return append(effects, e.land(state, player, roll)...)
+}
@@ -0,0 +1,179 @@
+package game
+
+import "time"
+
+// A player who owes more than they have mortgages their deeds to the bank,
+// in board order, until they can pay. A mortgage raises half the deed's
+// price, and a mortgaged square charges no rent. Lifting a mortgage costs
+// what it raised plus 10% interest, and its owner may do so before they
+// roll or once their turn's square is settled.
+//
+// Mortgaged deeds that change hands stay mortgaged. Their new owner pays
+// the bank the 10% interest on them at once, raising it as they would any
+// debt, and then owes a choice: which of the mortgages to lift at once for
+// just what they raised. Any they keep they can lift later, paying the
+// interest again. A choice that isn't made in time keeps them all.
+
+// MortgageValue is what mortgaging a deed that costs price raises.
+func MortgageValue(price int) int {
+	return price / 2
+}
+
+// mortgageInterest is the interest on a mortgage that raised value.
+func mortgageInterest(value int) int {
+	return value / 10
+}
+
+// UnmortgageCost is what lifting the mortgage on a deed that costs price
+// costs in the normal run of things.
+func UnmortgageCost(price int) int {
+	value := MortgageValue(price)
+	return value + mortgageInterest(value)
+}
+
+// IsMortgaged reports whether the player's deed to property is mortgaged.
+func (p *Player) IsMortgaged(property string) bool {
+	return contains(p.Mortgaged, property)
+}
+
+// lift takes property off the player's mortgaged deeds.
+func (p *Player) lift(property string) {
+	kept := p.Mortgaged[:0]
+	for _, m := range p.Mortgaged {
+		if m != property {
+			kept = append(kept, m)
+		}
+	}
+	p.Mortgaged = kept
+	if len(kept) == 0 {
+		p.Mortgaged = nil
+	}
+}
+
+// MortgageChoice is the choice Player owes over the mortgaged Properties
+// that came to them: which to lift now for what they raised. Deadline is
+// when the server will make it for them, keeping them all.
+type MortgageChoice struct {
+	Player     string     `json:"player"`
+	Properties []string   `json:"properties"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+}
+
+// PendingMortgageChoice returns the choice name owes over mortgaged
+// deeds, or false if they owe none.
+func (s *GameState) PendingMortgageChoice(name string) (MortgageChoice, bool) {
+	for _, c := range s.MortgageChoices {
+		if c.Player == name {
+			return c, true
+		}
+	}
+	return MortgageChoice{}, false
+}
+
+// dropMortgageChoice forgets the choice name owed, if any.
+func (s *GameState) dropMortgageChoice(name string) {
+	choices := s.MortgageChoices[:0]
+	for _, c := range s.MortgageChoices {
+		if c.Player != name {
+			choices = append(choices, c)
+		}
+	}
+	s.MortgageChoices = choices
+	if len(choices) == 0 {
+		s.MortgageChoices = nil
+	}
+}
+
+// raise mortgages debtor's deeds, in board order, until they have amount
+// or have nothing left to mortgage. It returns Mortgaged if they mortgaged
+// anything.
+func (e *Engine) raise(debtor *Player, amount int) []Effect {
+	var mortgaged []string
+	raised := 0
+	for _, sq := range e.Board.Squares {
+		if debtor.Balance >= amount {
+			break
+		}
+		if sq.Price == 0 || !contains(debtor.Properties, sq.Name) || debtor.IsMortgaged(sq.Name) {
+			continue
+		}
+		value := MortgageValue(sq.Price)
+		debtor.Balance += value
+		debtor.Mortgaged = append(debtor.Mortgaged, sq.Name)
+		mortgaged = append(mortgaged, sq.Name)
+		raised += value
+	}
+	if mortgaged == nil {
+		return nil
+	}
+	return []Effect{Mortgaged{Player: debtor.Name, Properties: mortgaged, Raised: raised}}
+}
+
+// takeMortgaged charges player the interest on the mortgaged deeds they
+// have just been given and, if they can pay it, leaves them owing the
+// choice of which to lift.
+func (e *Engine) takeMortgaged(state *GameState, player *Player, deeds []string) []Effect {
+	interest := 0
+	for _, deed := range deeds {
+		price, _ := e.Board.PropertyPrice(deed)
+		interest += mortgageInterest(MortgageValue(price))
+	}
+	paid, effects := e.collect(state, player, "", interest)
+	effects = append(effects, MortgagesTransferred{Player: player.Name, Properties: deeds, Interest: paid})
+	if paid < interest {
+		return append(effects, e.bankrupt(state, player, "")...)
+	}
+	state.MortgageChoices = append(state.MortgageChoices, MortgageChoice{Player: player.Name, Properties: deeds})
+	return append(effects, MortgageChoiceOwed{Player: player.Name, Properties: deeds})
+}
+
+// chooseMortgages makes the choice a.Player owes over mortgaged deeds
+// that came to them, lifting the mortgages on a.Unmortgage for what they
+// raised. Each must be one of the deeds in the choice, and the player
+// must be able to pay for all of them.
+func (e *Engine) chooseMortgages(state *GameState, a ChooseMortgages) ([]Effect, error) {
+	player, ok := state.Players[a.Player]
+	if !ok {
+		return nil, ErrUnknownPlayer
+	}
+	choice, ok := state.PendingMortgageChoice(a.Player)
+	if !ok || state.Status != StatusInProgress {
+		return nil, ErrNotAvailable
+	}
+	lift := make(map[string]bool, len(a.Unmortgage))
+	cost := 0
+	for _, deed := range a.Unmortgage {
+		price, _ := e.Board.PropertyPrice(deed)
+		if lift[deed] || !contains(choice.Properties, deed) || !player.IsMortgaged(deed) {
+			return nil, ErrNotAvailable
+		}
+		lift[deed] = true
+		cost += MortgageValue(price)
+	}
+	if cost > player.Balance {
+		return nil, ErrNotAvailable
+	}
+	chosen := MortgagesChosen{Player: a.Player, Paid: cost}
+	for _, deed := range choice.Properties {
+		switch {
+		case lift[deed]:
+			player.lift(deed)
+			chosen.Unmortgaged = append(chosen.Unmortgaged, deed)
+		case player.IsMortgaged(deed):
+			chosen.Kept = append(chosen.Kept, deed)
+		}
+	}
+	player.Balance -= cost
+	state.dropMortgageChoice(a.Player)
+	return []Effect{chosen}, nil
+}
+
+// contains reports whether list has s in it.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
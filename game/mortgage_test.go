@@ -0,0 +1,85 @@
+package game
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMortgageTransfer bankrupts a player with several mortgaged deeds to
+// another and has the new owner choose which mortgages to lift.
+func TestMortgageTransfer(t *testing.T) {
+	state := newGame()
+	ann, bob := state.Players["ann"], state.Players["bob"]
+	ann.Position, ann.Balance = 33, 0
+	ann.Properties = []string{"Mediterranean Avenue", "Baltic Avenue", "Reading Railroad"}
+	ann.Mortgaged = []string{"Reading Railroad"}
+	bob.Properties = []string{"Park Place", "Boardwalk"}
+	dice := loadedDice{{3, 3}}
+	engine := &Engine{Board: Standard, Dice: &dice}
+
+	effects, err := engine.Apply(state, RollDice{Player: "ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deeds := []string{"Reading Railroad", "Mediterranean Avenue", "Baltic Avenue"}
+	want := []Effect{
+		DiceRolled{Player: "ann", Roll: 6, Dice: [2]int{3, 3}, Position: 39},
+		Mortgaged{Player: "ann", Properties: []string{"Mediterranean Avenue", "Baltic Avenue"}, Raised: 60},
+		RentPaid{Player: "ann", Owner: "bob", Property: "Boardwalk", Amount: 60},
+		Bankrupted{Player: "ann", Creditor: "bob", Properties: []string{"Mediterranean Avenue", "Baltic Avenue", "Reading Railroad"}, Place: 3},
+		TurnPassed{Next: "bob"},
+		MortgagesTransferred{Player: "bob", Properties: deeds, Interest: 16},
+		MortgageChoiceOwed{Player: "bob", Properties: deeds},
+	}
+	if !reflect.DeepEqual(effects, want) {
+		t.Fatalf("effects %+v\nwant %+v", effects, want)
+	}
+	if bob.Balance != 1500+60-16 || !reflect.DeepEqual(bob.Mortgaged, deeds) {
+		t.Errorf("bob has %d, mortgaged %v", bob.Balance, bob.Mortgaged)
+	}
+
+	// The deeds' mortgages and the choice are part of the state clients
+	// see, and of what an undo puts back.
+	data, _ := json.Marshal(state)
+	for _, field := range []string{`"mortgaged":["Reading Railroad","Mediterranean Avenue","Baltic Avenue"]`, `"mortgageChoices":[{"player":"bob"`} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("state has no %s: %s", field, data)
+		}
+	}
+	snapshot := TakeSnapshot(state)
+
+	for _, refused := range []ChooseMortgages{
+		{Player: "cat"},
+		{Player: "bob", Unmortgage: []string{"Boardwalk"}},
+		{Player: "bob", Unmortgage: []string{"Baltic Avenue", "Baltic Avenue"}},
+	} {
+		if _, err := engine.Apply(state, refused); err != ErrNotAvailable {
+			t.Errorf("%+v: got %v", refused, err)
+		}
+	}
+	effects, err = engine.Apply(state, ChooseMortgages{Player: "bob", Unmortgage: []string{"Baltic Avenue", "Reading Railroad"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chosen := MortgagesChosen{Player: "bob", Unmortgaged: []string{"Reading Railroad", "Baltic Avenue"}, Kept: []string{"Mediterranean Avenue"}, Paid: 130}
+	if !reflect.DeepEqual(effects, []Effect{chosen}) {
+		t.Errorf("effects %+v, want %+v", effects, chosen)
+	}
+	if bob.Balance != 1544-130 || !reflect.DeepEqual(bob.Mortgaged, []string{"Mediterranean Avenue"}) || state.MortgageChoices != nil {
+		t.Errorf("bob has %d, mortgaged %v; choices %v", bob.Balance, bob.Mortgaged, state.MortgageChoices)
+	}
+
+	// Bob kept Mediterranean Avenue mortgaged, so lifting it now costs the
+	// interest again.
+	actions := AvailableActions(Standard, state, "bob")
+	if want := (Available{Action: ActionUnmortgage, Property: "Mediterranean Avenue", Cost: 33}); len(actions) != 2 || actions[1] != want {
+		t.Errorf("bob may %+v", actions)
+	}
+
+	snapshot.Restore(state)
+	if !reflect.DeepEqual(bob.Mortgaged, deeds) || len(state.MortgageChoices) != 1 {
+		t.Errorf("restored mortgaged %v, choices %v", bob.Mortgaged, state.MortgageChoices)
+	}
+}
@@ -0,0 +1,62 @@
+package game
+
+// Phases of a turn. A turn starts awaiting the roll (or bail, for a player
+// in jail); the roll moves the player and settles the square they land on
+// at once, leaving them either with a property on offer, which they must
+// buy or decline, or free to end their turn. Outside a game in progress
+// the phase is empty.
+const (
+	PhaseAwaitingRoll     = "AWAITING_ROLL"
+	PhaseAwaitingPurchase = "AWAITING_PURCHASE"
+	PhaseAwaitingEnd      = "AWAITING_END"
+)
+
+// phaseActions are the actions that may be taken in each phase. Whether
+// one is actually available also depends on the player: bail, for one,
+// is only offered to a player in jail who can pay it.
+var phaseActions = map[string][]string{
+	PhaseAwaitingRoll:     {ActionRollDice, ActionPayBail, ActionUnmortgage},
+	PhaseAwaitingPurchase: {ActionBuyProperty, ActionDeclinePurchase},
+	PhaseAwaitingEnd:      {ActionEndTurn, ActionUnmortgage},
+}
+
+// AllowedIn reports whether action may be taken in phase.
+func AllowedIn(phase string, action string) bool {
+	for _, a := range phaseActions[phase] {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// advance moves state on to the phase effect leads to. Phases change only
+// through effects.
+func (state *GameState) advance(effect Effect) {
+	switch effect.(type) {
+	case DiceRolled:
+		if state.Offer != "" {
+			state.Phase = PhaseAwaitingPurchase
+		} else {
+			state.Phase = PhaseAwaitingEnd
+		}
+	case PropertyBought, PurchaseDeclined:
+		state.Phase = PhaseAwaitingEnd
+	case TurnPassed:
+		state.Phase = PhaseAwaitingRoll
+	}
+}
+
+// InferPhase works out the phase of a game in progress from the rest of
+// its state, for games saved before phases were kept.
+func InferPhase(state *GameState) string {
+	switch {
+	case state.Status != StatusInProgress:
+		return ""
+	case !state.Rolled:
+		return PhaseAwaitingRoll
+	case state.Offer != "":
+		return PhaseAwaitingPurchase
+	}
+	return PhaseAwaitingEnd
+}
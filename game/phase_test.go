@@ -0,0 +1,43 @@
+package game
+
+import "testing"
+
+func TestAllowedIn(t *testing.T) {
+	actions := []string{ActionRollDice, ActionPayBail, ActionBuyProperty, ActionDeclinePurchase, ActionUnmortgage, ActionEndTurn}
+	for phase, allowed := range map[string][]string{
+		PhaseAwaitingRoll:     {ActionRollDice, ActionPayBail, ActionUnmortgage},
+		PhaseAwaitingPurchase: {ActionBuyProperty, ActionDeclinePurchase},
+		PhaseAwaitingEnd:      {ActionUnmortgage, ActionEndTurn},
+		"":                    nil,
+	} {
+		want := make(map[string]bool)
+		for _, action := range allowed {
+			want[action] = true
+		}
+		for _, action := range actions {
+			if got := AllowedIn(phase, action); got != want[action] {
+				t.Errorf("%s allowed in %q: %v, want %v", action, phase, got, want[action])
+			}
+		}
+	}
+}
+
+func TestInferPhase(t *testing.T) {
+	for _, tc := range []struct {
+		status string
+		rolled bool
+		offer  string
+		want   string
+	}{
+		{StatusWaiting, false, "", ""},
+		{StatusFinished, true, "", ""},
+		{StatusInProgress, false, "", PhaseAwaitingRoll},
+		{StatusInProgress, true, "Oriental Avenue", PhaseAwaitingPurchase},
+		{StatusInProgress, true, "", PhaseAwaitingEnd},
+	} {
+		state := &GameState{Status: tc.status, Rolled: tc.rolled, Offer: tc.offer}
+		if got := InferPhase(state); got != tc.want {
+			t.Errorf("%s, rolled %v, offer %q: phase %q, want %q", tc.status, tc.rolled, tc.offer, got, tc.want)
+		}
+	}
+}
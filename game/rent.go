@@ -0,0 +1,47 @@
+package game
+
+// Rent returns what landing on sq costs a player who rolled roll, when
+// owner owns it. A property's bare rent is doubled if owner has its whole
+// colour group; railroads charge by how many the owner has, and utilities
+// a multiple of the roll by how many the owner has.
+func Rent(board *Board, owner *Player, sq Square, roll int) int {
+	group := assetGroup(sq)
+	owned := make(map[string]bool, len(owner.Properties))
+	for _, prop := range owner.Properties {
+		owned[prop] = true
+	}
+	size, held := 0, 0
+	for _, other := range board.Squares {
+		if assetGroup(other) == group {
+			size++
+			if owned[other.Name] {
+				held++
+			}
+		}
+	}
+	switch sq.Type {
+	case SquareRailroad:
+		return rentAt(sq.Rent, held-1)
+	case SquareUtility:
+		return rentAt(sq.Rent, held-1) * roll
+	}
+	rent := rentAt(sq.Rent, 0)
+	if group != groupOther && held == size {
+		rent *= 2
+	}
+	return rent
+}
+
+// payRent has player pay the rent on sq, which owner owns, for a roll of
+// roll, going bankrupt if they can't.
+func (e *Engine) payRent(state *GameState, player *Player, owner string, sq Square, roll int) []Effect {
+	landlord := state.Players[owner]
+	rent := Rent(e.Board, landlord, sq, roll)
+	paid, effects := e.collect(state, player, owner, rent)
+	landlord.RentCollected += paid
+	effects = append(effects, RentPaid{Player: player.Name, Owner: owner, Property: sq.Name, Amount: paid})
+	if paid < rent {
+		effects = append(effects, e.bankrupt(state, player, owner)...)
+	}
+	return effects
+}
@@ -0,0 +1,89 @@
+package game
+
+import "time"
+
+// Game statuses.
+const (
+	StatusWaiting    = "WAITING"
+	StatusInProgress = "IN_PROGRESS"
+	StatusFinished   = "FINISHED"
+)
+
+type Player struct {
+	Name       string   `json:"name"`
+	Balance    int      `json:"balance"`
+	Position   int      `json:"position"`
+	Properties []string `json:"properties"`
+	JailTurns  int      `json:"jailTurns"`
+	Ready      bool     `json:"ready"`
+	Forfeited  bool     `json:"forfeited"`
+	Token      string   `json:"token"`
+	Connected  bool     `json:"connected"`
+	Bot        bool     `json:"bot"`
+	// Mortgaged are those of Properties that are mortgaged.
+	Mortgaged []string `json:"mortgaged,omitempty"`
+	// RentCollected and BankruptciesInflicted feed the player's
+	// cross-game stats.
+	RentCollected         int `json:"rentCollected,omitempty"`
+	BankruptciesInflicted int `json:"bankruptciesInflicted,omitempty"`
+	// ClockLeft is what is left of the player's time bank, in
+	// milliseconds, in games played on a clock. While their clock runs it
+	// holds what was left when it started; see GameState.ClockDeadline.
+	ClockLeft int64 `json:"clockLeftMs,omitempty"`
+	// Place is where the player finished: 1 for the winner, and for a
+	// player eliminated from the game, one more than the players still
+	// in it at the time.
+	Place int `json:"place,omitempty"`
+}
+
+type GameState struct {
+	Status    string             `json:"status"`
+	Host      string             `json:"host"`
+	Players   map[string]*Player `json:"players"`
+	TurnOrder []string           `json:"turnOrder"`
+	Turn      string             `json:"turn"`
+	// Phase is where the current turn is; see phase.go.
+	Phase  string `json:"phase,omitempty"`
+	Rolled bool   `json:"rolled"`
+	// Offer is the property the current player landed on and can afford.
+	// Their turn can't end until they buy it or decline it.
+	Offer string `json:"offer,omitempty"`
+	// MortgageChoices are the choices players owe over mortgaged deeds
+	// that have come to them; see mortgage.go.
+	MortgageChoices []MortgageChoice `json:"mortgageChoices,omitempty"`
+	// TurnDeadline is when the current turn times out. While the game is
+	// paused it is unset and TurnTimeLeft holds what remains instead.
+	TurnDeadline *time.Time `json:"turnDeadline,omitempty"`
+	TurnTimeLeft int64      `json:"turnTimeLeftMs,omitempty"`
+	// ClockDeadline is when the time bank of the player whose clock is
+	// running runs out. It is unset while no clock runs.
+	ClockDeadline *time.Time    `json:"clockDeadline,omitempty"`
+	Paused        bool          `json:"paused"`
+	PausedBy      string        `json:"pausedBy,omitempty"`
+	Chat          []ChatMessage `json:"chat"`
+	// StartedAt is when the game started, and Turns how many turns have
+	// been played since.
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	Turns     int        `json:"turns,omitempty"`
+	// Abandoned marks a finished game that was concluded because its
+	// players had gone, rather than played out.
+	Abandoned bool `json:"abandoned,omitempty"`
+	// DiceSeed seeds the game's dice, and DiceRolls counts the rolls made
+	// with them, which is enough to carry on or replay the game. They are
+	// kept from clients, who could otherwise predict the dice; the server
+	// persists them alongside the state.
+	DiceSeed  int64 `json:"-"`
+	DiceRolls int   `json:"-"`
+	// DiceStats sums up the rolls made with the game's dice, and
+	// DiceHistory lists them, oldest first. The history would grow every
+	// STATE and every logged change, so it is left out of the JSON; the
+	// server persists it alongside the state and serves it on request.
+	DiceStats   *DiceStats `json:"diceStats,omitempty"`
+	DiceHistory []DiceRoll `json:"-"`
+}
+
+type ChatMessage struct {
+	From   string    `json:"from"`
+	Text   string    `json:"text"`
+	SentAt time.Time `json:"sentAt"`
+}
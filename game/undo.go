@@ -0,0 +1,75 @@
+package game
+
+// Snapshot is the part of a game an action can change, kept from before
+// the action so that it can be taken back. Everything else, such as who
+// is connected, the chat and the clocks, carries on as it is.
+type Snapshot struct {
+	Turn    string
+	Phase   string
+	Rolled  bool
+	Offer   string
+	Turns   int
+	Players map[string]PlayerSnapshot
+	// MortgageChoices are the choices owed over mortgages.
+	MortgageChoices []MortgageChoice
+}
+
+// PlayerSnapshot is the part of a player an action can change.
+type PlayerSnapshot struct {
+	Balance    int
+	Position   int
+	Properties []string
+	Mortgaged  []string
+	JailTurns  int
+}
+
+// TakeSnapshot records what an action on state could change.
+func TakeSnapshot(state *GameState) Snapshot {
+	s := Snapshot{
+		Turn:    state.Turn,
+		Phase:   state.Phase,
+		Rolled:  state.Rolled,
+		Offer:   state.Offer,
+		Turns:   state.Turns,
+		Players: make(map[string]PlayerSnapshot, len(state.Players)),
+		// A choice's properties are never changed in place, only
+		// replaced, so they can be shared.
+		MortgageChoices: append([]MortgageChoice(nil), state.MortgageChoices...),
+	}
+	for name, p := range state.Players {
+		s.Players[name] = PlayerSnapshot{
+			Balance:    p.Balance,
+			Position:   p.Position,
+			Properties: append([]string(nil), p.Properties...),
+			Mortgaged:  append([]string(nil), p.Mortgaged...),
+			JailTurns:  p.JailTurns,
+		}
+	}
+	return s
+}
+
+// Restore puts state back as it was when s was taken. Players who have
+// joined since are left alone.
+func (s Snapshot) Restore(state *GameState) {
+	state.Turn, state.Phase, state.Rolled, state.Offer, state.Turns = s.Turn, s.Phase, s.Rolled, s.Offer, s.Turns
+	state.MortgageChoices = append([]MortgageChoice(nil), s.MortgageChoices...)
+	for name, ps := range s.Players {
+		if p, ok := state.Players[name]; ok {
+			p.Balance, p.Position, p.JailTurns = ps.Balance, ps.Position, ps.JailTurns
+			p.Properties = append([]string(nil), ps.Properties...)
+			p.Mortgaged = append([]string(nil), ps.Mortgaged...)
+		}
+	}
+}
+
+// Reversible reports whether an action that had effects can be taken
+// back. A roll can't: the dice have been seen, and taking it back would
+// let the player roll again.
+func Reversible(effects []Effect) bool {
+	for _, effect := range effects {
+		if _, ok := effect.(DiceRolled); ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// version is the build version, set with -ldflags "-X main.version=...".
+// Without it the module version or VCS revision Go recorded is used.
+var version = ""
+
+// startedAt is when the process started, for uptime.
+var startedAt = time.Now()
+
+// listening is set once the server is accepting connections.
+var listening atomic.Bool
+
+const readinessTimeout = 2 * time.Second
+
+// pinger is implemented by backends that can be checked for readiness.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type HealthResponse struct {
+	Status        string `json:"status"`
+	UptimeSeconds int64  `json:"uptimeSeconds"`
+	Rooms         int    `json:"rooms"`
+	Version       string `json:"version"`
+	// Checks holds the outcome of each backend checked for readiness,
+	// "ok" or the error.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// buildVersion returns version, or failing that what Go recorded about the
+// build.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return info.Main.Version
+}
+
+// healthResponse fills in the parts of a health response common to both
+// probes. It only read-locks the hub, and never waits on a room.
+func healthResponse(status string) HealthResponse {
+	hub.Mutex.RLock()
+	rooms := len(hub.Rooms)
+	hub.Mutex.RUnlock()
+	return HealthResponse{
+		Status:        status,
+		UptimeSeconds: int64(time.Since(startedAt) / time.Second),
+		Rooms:         rooms,
+		Version:       buildVersion(),
+	}
+}
+
+// handleHealthz answers 200 for as long as the process is up.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse("ok"))
+}
+
+// handleReadyz answers 200 once the server is accepting websocket upgrades
+// and its store and cluster backends, if any, can be reached; otherwise,
+// and from the start of shutdown on, it answers 503 so the load balancer
+// sends traffic elsewhere.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	var status string
+	switch {
+	case shuttingDown.Load():
+		status = "shutting down"
+	case !listening.Load():
+		status = "starting"
+	}
+	checks := make(map[string]string)
+	if status == "" {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		for name, backend := range map[string]interface{}{"store": store, "cluster": cluster} {
+			p, ok := backend.(pinger)
+			if !ok {
+				continue
+			}
+			checks[name] = "ok"
+			if err := p.Ping(ctx); err != nil {
+				checks[name] = err.Error()
+				status = "unavailable"
+			}
+		}
+	}
+	code := http.StatusServiceUnavailable
+	if status == "" {
+		status, code = "ok", http.StatusOK
+	}
+	resp := healthResponse(status)
+	if len(checks) > 0 {
+		resp.Checks = checks
+	}
+	writeJSON(w, code, resp)
+}
+
+// Ping checks that the store's directory is still there.
+func (s *fileStore) Ping(context.Context) error {
+	_, err := os.Stat(s.dir)
+	return err
+}
+
+// Ping checks that the database can be reached.
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Ping checks that Redis can be reached. The client has its own timeout,
+// so ctx isn't used.
+func (c *redisCluster) Ping(context.Context) error {
+	_, err := c.client.Do("PING")
+	return err
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+const (
+	defaultGameListLimit = 20
+	maxGameListLimit     = 100
+)
+
+// GameSummary is what is kept of a game once it is over. It is worked out
+// when the game finishes, from the final state.
+type GameSummary struct {
+	ID         string          `json:"id"`
+	Winner     string          `json:"winner"`
+	Players    []PlayerSummary `json:"players"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt time.Time       `json:"finishedAt"`
+	// DurationSeconds is how long the game lasted, from start to finish.
+	DurationSeconds int64 `json:"durationSeconds"`
+	Turns           int   `json:"turns"`
+	// Bankruptcies counts the players who dropped out before the end.
+	Bankruptcies int `json:"bankruptcies"`
+	// Abandoned marks a game concluded because its players had gone; its
+	// winner, if any, was the richest player at the time.
+	Abandoned bool `json:"abandoned,omitempty"`
+}
+
+// PlayerSummary is one player's standing at the end of a game. NetWorth
+// is their balance plus what their properties cost, and Place is where
+// they finished.
+type PlayerSummary struct {
+	Name       string `json:"name"`
+	PlayerID   string `json:"playerId,omitempty"`
+	Balance    int    `json:"balance"`
+	NetWorth   int    `json:"netWorth"`
+	Properties int    `json:"properties"`
+	Forfeited  bool   `json:"forfeited,omitempty"`
+	Bot        bool   `json:"bot,omitempty"`
+	Place      int    `json:"place,omitempty"`
+
+	RentCollected         int `json:"rentCollected"`
+	BankruptciesInflicted int `json:"bankruptciesInflicted"`
+}
+
+type GameListResponse struct {
+	Games []*GameSummary `json:"games"`
+}
+
+func (sum *GameSummary) hasPlayer(name string) bool {
+	for _, p := range sum.Players {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// summarize works out the summary of the room's finished game. It must
+// run on the room's goroutine.
+func (room *GameRoom) summarize(winner string) *GameSummary {
+	now := time.Now()
+	sum := &GameSummary{
+		ID:         room.ID,
+		Winner:     winner,
+		StartedAt:  room.CreatedAt,
+		FinishedAt: now,
+		Turns:      room.GameState.Turns,
+		Abandoned:  room.GameState.Abandoned,
+	}
+	if room.GameState.StartedAt != nil {
+		sum.StartedAt = *room.GameState.StartedAt
+	}
+	sum.DurationSeconds = int64(now.Sub(sum.StartedAt) / time.Second)
+	for _, p := range room.GameState.Players {
+		sum.Players = append(sum.Players, PlayerSummary{
+			Name:       p.Name,
+			PlayerID:   room.playerIDs[p.Name],
+			Balance:    p.Balance,
+			NetWorth:   game.NetWorth(room.board, p),
+			Properties: len(p.Properties),
+			Forfeited:  p.Forfeited,
+			Bot:        p.Bot,
+			Place:      p.Place,
+
+			RentCollected:         p.RentCollected,
+			BankruptciesInflicted: p.BankruptciesInflicted,
+		})
+		if p.Forfeited {
+			sum.Bankruptcies++
+		}
+	}
+	// Richest first.
+	sort.Slice(sum.Players, func(i, j int) bool {
+		a, b := sum.Players[i], sum.Players[j]
+		if a.NetWorth != b.NetWorth {
+			return a.NetWorth > b.NetWorth
+		}
+		return a.Name < b.Name
+	})
+	return sum
+}
+
+// saveSummary records the summary of the room's finished game in the
+// store. It must run on the room's goroutine, though the write doesn't.
+func (room *GameRoom) saveSummary(winner string) {
+	sum := room.summarize(winner)
+	pendingWrites.Add(1)
+	go func() {
+		defer pendingWrites.Done()
+		if err := store.SaveSummary(sum); err != nil {
+			slog.Error("saving game summary", "gameId", sum.ID, "err", err)
+		}
+	}()
+}
+
+// handleListGames serves a page of finished games, newest first. With
+// player, only games they played in are listed. It reads from the store
+// alone and never touches a room.
+func handleListGames(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultGameListLimit)
+	if err != nil || limit < 1 {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	if limit > maxGameListLimit {
+		limit = maxGameListLimit
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+		return
+	}
+	games, total, err := store.ListSummaries(r.URL.Query().Get("player"), limit, offset)
+	if err != nil {
+		slog.Error("listing games", "err", err)
+		writeError(w, http.StatusInternalServerError, "couldn't load games")
+		return
+	}
+	if games == nil {
+		games = []*GameSummary{}
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, GameListResponse{Games: games})
+}
+
+// handleGetGame serves the summary of one finished game.
+func handleGetGame(w http.ResponseWriter, r *http.Request) {
+	sum, err := store.LoadSummary(r.PathValue("id"))
+	if err != nil {
+		slog.Error("loading game", "gameId", r.PathValue("id"), "err", err)
+		writeError(w, http.StatusInternalServerError, "couldn't load the game")
+		return
+	}
+	if sum == nil {
+		writeError(w, http.StatusNotFound, "game not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, sum)
+}
+
+// expireGameHistory periodically deletes summaries older than
+// -game-history-retention.
+func expireGameHistory() {
+	if hub.config.GameHistoryRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-background.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := store.DeleteSummaries(time.Now().Add(-hub.config.GameHistoryRetention)); err != nil {
+			slog.Error("expiring game history", "err", err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+// hostEvents may only be sent by the room's host.
+var hostEvents = map[string]bool{
+	"START_GAME":      true,
+	"SET_HOUSE_RULES": true,
+	"KICK_PLAYER":     true,
+	"TRANSFER_HOST":   true,
+	"PAUSE_GAME":      true,
+	"RESUME_GAME":     true,
+	"ADD_BOT":         true,
+	"SAVE_GAME":       true,
+	"APPROVE_REJOIN":  true,
+}
+
+type HostChangedPayload struct {
+	Host string `json:"host"`
+}
+
+// clientFor returns the player connection bound to name, if any. It
+// must run on the room's goroutine.
+func clientFor(room *GameRoom, name string) *Client {
+	for client, n := range room.Players {
+		if n == name {
+			return client
+		}
+	}
+	return nil
+}
+
+// promoteHost hands the host role to the longest-connected player other
+// than leaving and announces it. It must run on the room's goroutine.
+func (room *GameRoom) promoteHost(leaving string) {
+	var next *Client
+	for client, name := range room.Players {
+		if name == leaving {
+			continue
+		}
+		if next == nil || client.connectedAt.Before(next.connectedAt) {
+			next = client
+		}
+	}
+	host := ""
+	if next != nil {
+		host = room.Players[next]
+	}
+
+	room.GameState.Host = host
+	room.logger().Info("host changed", "player", host)
+	SendGameEventToAll(room, "HOST_CHANGED", room.ID, HostChangedPayload{Host: host})
+}
+
+// HandleTransferHostEvent gives the host role to another connected player.
+func HandleTransferHostEvent(room *GameRoom, event GameEvent, client *Client) {
+	payload, _ := event.Payload.(map[string]interface{})
+	target, _ := payload["player"].(string)
+	if target == room.GameState.Host {
+		return
+	}
+	if clientFor(room, target) == nil {
+		room.rejectEvent(client, event, "UNKNOWN_PLAYER", "no connected player named "+target)
+		return
+	}
+	room.GameState.Host = target
+	SendGameEventToAll(room, "HOST_CHANGED", room.ID, HostChangedPayload{Host: target})
+}
+
+// HandleKickPlayerEvent removes a player from the room. Before the game
+// starts their seat is simply freed; during a game they forfeit, so their
+// assets and turn are handled like any other forfeit.
+func HandleKickPlayerEvent(room *GameRoom, event GameEvent, client *Client) {
+	payload, _ := event.Payload.(map[string]interface{})
+	target, _ := payload["player"].(string)
+	player, ok := room.GameState.Players[target]
+	if !ok || target == room.GameState.Host {
+		room.rejectEvent(client, event, "INVALID_KICK", "can't kick "+target)
+		return
+	}
+
+	SendGameEventToAll(room, "PLAYER_KICKED", room.ID, map[string]string{"player": target})
+	// Forfeiting leaves the connection watching the game, so find it
+	// first; a player already out may be watching already.
+	conn := clientFor(room, target)
+	if conn == nil {
+		conn = eliminatedClient(room, target)
+	}
+	switch room.GameState.Status {
+	case StatusWaiting:
+		delete(room.GameState.Players, target)
+		room.removeSeat(target)
+		room.revokeSession(target)
+		delete(room.bots, target)
+		delete(room.playerIDs, target)
+		delete(room.preferences, target)
+	case StatusInProgress:
+		if !player.Forfeited {
+			room.forfeitPlayer(target, "kicked")
+		}
+		room.revokeSession(target)
+		delete(room.preferences, target)
+	}
+	room.publishListing()
+	if conn != nil {
+		conn.part(room, CloseKicked, "kicked by the host")
+	}
+}
+
+// HandleSetHouseRulesEvent replaces the room's house rules while it is
+// still in the lobby.
+func HandleSetHouseRulesEvent(room *GameRoom, event GameEvent, client *Client) {
+	opts := room.Options
+	if err := decodePayload(event, &opts.HouseRules); err != nil {
+		room.rejectEvent(client, event, "INVALID_HOUSE_RULES", err.Error())
+		return
+	}
+	if err := opts.Validate(); err != nil {
+		room.rejectEvent(client, event, "INVALID_HOUSE_RULES", err.Error())
+		return
+	}
+	room.Options.HouseRules = opts.HouseRules
+	for _, p := range room.GameState.Players {
+		p.Balance = opts.HouseRules.StartingBalance
+	}
+	SendGameEventToAll(room, "HOUSE_RULES_CHANGED", room.ID, room.Options.HouseRules)
+}
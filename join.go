@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// A connection can be in several rooms at once, say playing in one game
+// while spectating another. The gameId on the websocket URL joins the
+// first room; JOIN_ROOM and LEAVE_ROOM add and drop others over the same
+// connection. Every event a client sends goes to the room its gameId
+// names, or to the room it joined on the URL if gameId is left out, and
+// everything the server sends carries the gameId of the room it is from.
+// The connection is a player in some rooms and a spectator in others,
+// each decided when it joined that room.
+
+// maxClientRooms is how many rooms one connection may be in at a time.
+const maxClientRooms = 8
+
+// JoinRoomPayload is a JOIN_ROOM event's payload. It carries what the
+// websocket URL does for the room joined with the connection; the room
+// itself is the event's gameId.
+type JoinRoomPayload struct {
+	Name     string `json:"name"`
+	Role     string `json:"role,omitempty"`
+	Token    string `json:"token,omitempty"`
+	PlayerID string `json:"playerId,omitempty"`
+	Invite   string `json:"invite,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// query returns p as the parameters of a websocket URL.
+func (p JoinRoomPayload) query(gameID string) url.Values {
+	query := url.Values{"gameId": {gameID}}
+	for key, value := range map[string]string{"name": p.Name, "role": p.Role, "token": p.Token, "playerId": p.PlayerID, "invite": p.Invite, "password": p.Password} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	return query
+}
+
+// RoomLeftPayload tells a connection it is no longer in the room the
+// event names, while it stays open for its other rooms.
+type RoomLeftPayload struct {
+	Reason string `json:"reason"`
+}
+
+// joinRefusal is why a connection wasn't let into a room: the ERROR code
+// and message it is sent, and the close code for a connection that came
+// to join only that room.
+type joinRefusal struct {
+	code, message string
+	closeCode     int
+}
+
+// joinRoom puts client in the room query names, as a player or spectator
+// as query asks, and sends it the room's state. It is called from the
+// client's read loop, for the room on the websocket URL and for each
+// JOIN_ROOM.
+func joinRoom(client *Client, query url.Values) (room *GameRoom, refusal *joinRefusal) {
+	defer func() {
+		if refusal != nil {
+			metrics.Inc(metricJoinsRejected, refusal.code)
+		}
+	}()
+	roomID := query.Get("gameId")
+	playerName := query.Get("name")
+	spectator := query.Get("role") == "spectator"
+	if spectator && playerName == "" {
+		playerName = "spectator"
+	}
+	if roomID == "" || len(roomID) > maxNameLength {
+		return nil, &joinRefusal{"INVALID_JOIN", "a valid gameId is required", CloseInvalidJoin}
+	}
+	playerID := query.Get("playerId")
+	if err := validatePlayerID(playerID); err != nil {
+		return nil, &joinRefusal{"INVALID_PLAYER_ID", err.Error(), CloseInvalidJoin}
+	}
+	identity := client.identity
+	if identity != nil && !spectator {
+		// Who a verified player is comes from their token, not the URL.
+		playerName, playerID = identity.Name, identity.ID
+	} else if hub.auth != nil {
+		// Only verified players are counted in the stats.
+		playerID = ""
+	}
+	var err error
+	if playerName != "" || query.Get("token") == "" || spectator {
+		// A session token says who the player is without a name.
+		if playerName, err = validateName(playerName); err != nil {
+			return nil, &joinRefusal{"INVALID_NAME", err.Error(), CloseInvalidJoin}
+		}
+	}
+	if _, in := client.room(roomID); in {
+		return nil, &joinRefusal{"ALREADY_IN_ROOM", "this connection is already in that room", CloseInvalidJoin}
+	}
+	if client.roomCount() >= maxClientRooms {
+		return nil, &joinRefusal{"TOO_MANY_ROOMS", "this connection is in as many rooms as it may be", CloseInvalidJoin}
+	}
+
+	if query.Get("token") != "" {
+		// A session token may belong to a saved game; bring it back. If
+		// it doesn't, the join fails below as usual.
+		resumeSavedGame(roomID, query)
+	}
+	hub.Mutex.Lock()
+	room, exists := hub.lookup(roomID)
+	if !exists && hub.config.ImplicitRooms {
+		room, err = hub.CreateRoom(roomID, defaultRoomOptions())
+		exists = err == nil
+	}
+	hub.Mutex.Unlock()
+	if !exists {
+		if err == ErrHubFull {
+			return nil, &joinRefusal{"HUB_FULL", "the server is not accepting new rooms", CloseTryAgain}
+		}
+		return nil, &joinRefusal{"ROOM_NOT_FOUND", "no room with this gameId", CloseInvalidJoin}
+	}
+
+	refuse := func(code string, message string, closeCode int) {
+		refusal = &joinRefusal{code, message, closeCode}
+	}
+	reconnect := false
+	joined := room.do(func() {
+		if !room.admits(query) {
+			refuse("FORBIDDEN", "this room is private; an invite or password is required", CloseInvalidJoin)
+			return
+		}
+		if room.connectionCount() >= hub.config.MaxRoomConnections {
+			refuse("ROOM_CROWDED", "the room has too many connections", CloseTryAgain)
+			return
+		}
+		rejoined := false
+		var replaced *Client
+		if token := query.Get("token"); token != "" && !spectator {
+			name, ok := room.sessions[token]
+			if !ok {
+				refuse("INVALID_TOKEN", "unknown or expired session token", CloseInvalidJoin)
+				return
+			}
+			if identity != nil && room.playerIDs[name] != identity.ID {
+				refuse("INVALID_TOKEN", "that session token belongs to someone else", CloseInvalidJoin)
+				return
+			}
+			playerName = name
+			reconnect = true
+			replaced = clientFor(room, name)
+		} else if name := room.playerWithID(playerID); identity != nil && !spectator && name != "" {
+			// A verified player takes back their seat without a session
+			// token, from wherever they connect.
+			playerName = name
+			reconnect = true
+			replaced = clientFor(room, name)
+		} else if !spectator {
+			if player, taken := room.GameState.Players[playerName]; taken && !player.Connected && room.takeRejoinApproval(playerName) {
+				// The host let this player back in without their token.
+				reconnect, rejoined = true, true
+			} else if taken && room.GameState.Status == StatusInProgress && !player.Connected && !player.Forfeited {
+				room.requestRejoin(playerName)
+				refuse("REJOIN_REQUESTED", "that player is away; the host has been asked to let you back in", CloseTryAgain)
+				return
+			} else if taken {
+				refuse("NAME_TAKEN", "that name is already in use in this room", CloseNameTaken)
+				return
+			}
+		}
+		if !spectator && !reconnect {
+			if room.GameState.Status != StatusWaiting {
+				refuse("GAME_STARTED", "the game has already started; join as a spectator", CloseInvalidJoin)
+				return
+			}
+			if room.isFull() {
+				refuse("ROOM_FULL", "the room has no free seats", CloseTryAgain)
+				return
+			}
+			if room.tournament != nil && !room.tournament.seated(playerName) {
+				refuse("NOT_A_PARTICIPANT", "this is a tournament table; only the players seated at it may join", CloseInvalidJoin)
+				return
+			}
+			if playerID != "" && room.playerIDTaken(playerID, playerName) {
+				refuse("PLAYER_ID_TAKEN", "that playerId is already in this room", CloseInvalidJoin)
+				return
+			}
+		}
+		// A player knocked out of the game comes back as a spectator.
+		out := reconnect && room.GameState.Players[playerName].Forfeited
+		if out && replaced == nil {
+			replaced = eliminatedClient(room, playerName)
+		}
+		client.addRoom(room)
+		spectators := 0
+		if spectator || out {
+			delete(room.Spectators, replaced)
+			delete(room.eliminated, replaced)
+			room.Spectators[client] = playerName
+			if out {
+				room.eliminated[client] = true
+			}
+			spectators = len(room.Spectators)
+		} else {
+			delete(room.Players, replaced)
+			room.Players[client] = playerName
+		}
+		if replaced != nil {
+			replaced.part(room, CloseReplaced, "replaced by a new connection")
+		}
+		token := ""
+		if !spectator && !reconnect {
+			room.GameState.Players[playerName] = &Player{Name: playerName, Balance: room.Options.HouseRules.StartingBalance, Position: 0}
+			room.GameState.TurnOrder = append(room.GameState.TurnOrder, playerName)
+			token = room.issueSession(playerName)
+			if playerID != "" {
+				room.playerIDs[playerName] = playerID
+			}
+		} else if rejoined {
+			room.revokeSession(playerName)
+			token = room.issueSession(playerName)
+		} else if reconnect {
+			token = room.sessionToken(playerName)
+		}
+		if !spectator {
+			room.cancelGrace(playerName)
+			room.GameState.Players[playerName].Connected = true
+			room.GameState.Players[playerName].Bot = false
+			delete(room.bots, playerName)
+			if room.GameState.Host == "" && !out {
+				room.GameState.Host = playerName
+			}
+		}
+		room.cancelEmptyCheck()
+		room.touch()
+		room.Subscribe(client)
+		client.Send(room.snapshot("", client.locale, room.Players[client]))
+		client.Send(room.boardData("", client.locale))
+		if !spectator {
+			data, _ := json.Marshal(GameEvent{Event: "WELCOME", GameID: room.ID, Payload: WelcomePayload{Player: playerName, Token: token}})
+			client.Send(newOutboundMessage(0, data))
+		}
+		if reconnect {
+			SendGameEventToAll(room, "PLAYER_RECONNECTED", room.ID, room.rosterPayload(playerName))
+			if out {
+				SendGameEventToAll(room, "SPECTATOR_JOINED", room.ID, SpectatorsPayload{Spectators: spectators})
+			}
+			if room.autoPaused && room.GameState.PausedBy == playerName {
+				room.resume(playerName)
+			}
+			if room.GameState.Turn == playerName && room.turnTimer == nil {
+				// Their clock stopped when they dropped; they get a fresh turn.
+				room.startTurnTimer()
+			}
+			if room.GameState.Turn == playerName {
+				room.sendAvailableActions()
+			}
+			room.checkResumeQuorum()
+			room.checkAbandoned()
+		} else if !spectator {
+			SendGameEventToAll(room, "PLAYER_JOINED", room.ID, PlayerJoinedPayload{
+				RosterPayload: room.rosterPayload(playerName),
+				Token:         room.GameState.Players[playerName].Token,
+				Seat:          room.seatOf(playerName),
+			})
+		} else {
+			SendGameEventToAll(room, "SPECTATOR_JOINED", room.ID, SpectatorsPayload{Spectators: spectators})
+		}
+	})
+	if !joined {
+		refuse("ROOM_NOT_FOUND", "no room with this gameId", CloseInvalidJoin)
+	}
+	if refusal != nil {
+		return nil, refusal
+	}
+
+	log := client.log.With("gameId", room.ID, "player", playerName)
+	if spectator {
+		log.Info("spectator joined")
+	} else if reconnect {
+		log.Info("player reconnected")
+	} else {
+		log.Info("player joined")
+	}
+	return room, nil
+}
+
+// HandleJoinRoomEvent joins the connection to the room named by the
+// event's gameId. A refusal is answered with an ERROR and leaves the
+// connection open for the rooms it is already in.
+func HandleJoinRoomEvent(client *Client, event GameEvent) {
+	metrics.Inc(metricEventsReceived, eventLabel(event.Event))
+	var payload JoinRoomPayload
+	if err := decodePayload(event, &payload); err != nil {
+		SendError(client, event.GameID, event.RequestID, "INVALID_PAYLOAD", "JOIN_ROOM needs a name or a role")
+		return
+	}
+	if elsewhere, err := ownedElsewhere(event.GameID); err != nil {
+		SendError(client, event.GameID, event.RequestID, "ROOMS_UNAVAILABLE", "rooms are unavailable right now")
+		return
+	} else if elsewhere {
+		SendError(client, event.GameID, event.RequestID, "ROOM_ELSEWHERE", "that room is on another server; open a connection to it instead")
+		return
+	}
+	if _, refusal := joinRoom(client, payload.query(event.GameID)); refusal != nil {
+		SendError(client, event.GameID, event.RequestID, refusal.code, refusal.message)
+	}
+}
+
+// HandleLeaveRoomEvent takes the connection out of the room named by the
+// event's gameId, as if it had disconnected from that room alone.
+func HandleLeaveRoomEvent(client *Client, event GameEvent) {
+	metrics.Inc(metricEventsReceived, eventLabel(event.Event))
+	room, ok := client.room(event.GameID)
+	if !ok {
+		SendError(client, event.GameID, event.RequestID, "NOT_IN_ROOM", "this connection isn't in that room")
+		return
+	}
+	room.do(func() { room.removeClient(client) })
+	client.dropRoom(room)
+	data, _ := json.Marshal(GameEvent{Event: "ROOM_LEFT", GameID: room.ID, RequestID: event.RequestID, Payload: RoomLeftPayload{Reason: "left"}})
+	client.Send(newOutboundMessage(0, data))
+}
+
+// part ends client's membership of room for a reason of the room's own:
+// it is closing, or has kicked or replaced them. A connection in no other
+// room is closed with code and reason, as it always was; one that is
+// stays open and is sent ROOM_LEFT instead. It must run on the room's
+// goroutine.
+func (c *Client) part(room *GameRoom, code int, reason string) {
+	if c.roomCount() <= 1 {
+		// The read loop takes it out of the room once it has closed.
+		c.CloseWith(code, reason)
+		return
+	}
+	c.dropRoom(room)
+	if !room.closed {
+		room.removeClient(c)
+	}
+	data, _ := json.Marshal(GameEvent{Event: "ROOM_LEFT", GameID: room.ID, Payload: RoomLeftPayload{Reason: reason}})
+	c.Send(newOutboundMessage(0, data))
+}
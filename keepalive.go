@@ -0,0 +1,18 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// keepAlive arms the read deadline on conn and extends it on every pong.
+// Pings are sent by the client's write pump; a missed pong surfaces as a
+// read error in the caller's read loop, which then runs the normal
+// disconnect cleanup.
+func keepAlive(conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(hub.config.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(hub.config.PongWait))
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Besides websocket pings, which browsers answer without telling the page,
+// each connection is sent a PING event every -ping-interval for the client
+// to echo back as PONG with the same payload. The round trip gives a
+// smoothed latency for the connection, which roster broadcasts and STATE
+// show rounded up to a bucket, so players can tell a stuck turn from a
+// lagging player, and which /metrics and /admin/rooms expose in full. A
+// PONG keeps the connection alive just as a websocket pong does, and a
+// client that has answered PING before is dropped once one goes
+// unanswered for -pong-wait. Clients that never answer are only kept
+// alive by websocket pongs.
+
+// latencySmoothing is the weight a new round trip gets in the smoothed
+// latency.
+const latencySmoothing = 0.2
+
+// latencyBuckets are the latencies, in milliseconds, shown to the room.
+// A latency is shown as the first bucket it doesn't exceed, or the last.
+var latencyBuckets = []int{50, 100, 200, 350, 500, 1000, 2000}
+
+var errPingUnanswered = errors.New("PING went unanswered")
+
+// PingPayload identifies a PING, and the PONG answering it. SentAt is
+// when it was sent, in Unix milliseconds, for the client's own use.
+type PingPayload struct {
+	ID     uint64 `json:"id"`
+	SentAt int64  `json:"sentAt"`
+}
+
+// ping sends a PING unless one is still waiting for its PONG. It fails if
+// a client that answers PINGs has left one unanswered for -pong-wait. It
+// runs on the write pump.
+func (c *Client) ping() error {
+	now := time.Now()
+	if sent := c.pingSent.Load(); sent != 0 && c.latency.Load() != 0 {
+		if now.Sub(time.Unix(0, sent)) > hub.config.PongWait {
+			return errPingUnanswered
+		}
+		return nil
+	}
+	id := c.pingID.Add(1)
+	c.pingSent.Store(now.UnixNano())
+	data, err := json.Marshal(GameEvent{Event: "PING", Payload: PingPayload{ID: id, SentAt: now.UnixMilli()}})
+	if err != nil {
+		return err
+	}
+	return c.write(newOutboundMessage(0, data))
+}
+
+// pong takes the round trip from a PONG answering the last PING, and
+// extends the read deadline. Stale or made-up PONGs are ignored. It runs
+// on the read loop.
+func (c *Client) pong(event GameEvent) {
+	var payload PingPayload
+	if err := decodePayload(event, &payload); err != nil || payload.ID != c.pingID.Load() {
+		return
+	}
+	sent := c.pingSent.Swap(0)
+	if sent == 0 {
+		return
+	}
+	rtt := time.Since(time.Unix(0, sent))
+	metrics.Observe(metricLatencySeconds, rtt.Seconds())
+	smoothed := time.Duration(c.latency.Load())
+	if smoothed == 0 {
+		smoothed = rtt
+	} else {
+		smoothed += time.Duration(latencySmoothing * float64(rtt-smoothed))
+	}
+	c.latency.Store(int64(max(smoothed, 1)))
+	c.conn.SetReadDeadline(time.Now().Add(hub.config.PongWait))
+}
+
+// latencyMs returns the connection's smoothed latency in milliseconds, or
+// 0 if it hasn't been measured.
+func (c *Client) latencyMs() float64 {
+	return float64(c.latency.Load()) / float64(time.Millisecond)
+}
+
+// latencyBucket rounds a latency in milliseconds up to a bucket, leaving 0
+// for unmeasured.
+func latencyBucket(ms float64) int {
+	if ms == 0 {
+		return 0
+	}
+	for _, bucket := range latencyBuckets {
+		if ms <= float64(bucket) {
+			return bucket
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+// playerLatency returns name's latency bucket, or 0 if they aren't
+// connected or haven't been measured. It must run on the room's
+// goroutine.
+func (room *GameRoom) playerLatency(name string) int {
+	if client := clientFor(room, name); client != nil {
+		return latencyBucket(client.latencyMs())
+	}
+	return 0
+}
+
+// latencies returns the latency bucket of every connected player who has
+// been measured. It must run on the room's goroutine.
+func (room *GameRoom) latencies() map[string]int {
+	var latencies map[string]int
+	for client, name := range room.Players {
+		if bucket := latencyBucket(client.latencyMs()); bucket != 0 {
+			if latencies == nil {
+				latencies = make(map[string]int)
+			}
+			latencies[name] = bucket
+		}
+	}
+	return latencies
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+)
+
+const (
+	defaultLeaderboardLimit = 20
+	maxLeaderboardLimit     = 100
+	maxPlayerIDLength       = 64
+)
+
+// PlayerStats adds up a player's finished games. Only players who join
+// with a persistent ?playerId=, or with an access token, are counted; Name
+// is the name they last played under.
+type PlayerStats struct {
+	ID                    string `json:"id"`
+	Name                  string `json:"name"`
+	GamesPlayed           int    `json:"gamesPlayed"`
+	Wins                  int    `json:"wins"`
+	RentCollected         int    `json:"rentCollected"`
+	BankruptciesInflicted int    `json:"bankruptciesInflicted"`
+	// TotalNetWorth sums the player's final net worth over all their
+	// games; AverageNetWorth is worked out from it when served.
+	TotalNetWorth   int `json:"-"`
+	AverageNetWorth int `json:"averageNetWorth"`
+}
+
+type LeaderboardResponse struct {
+	Sort    string         `json:"sort"`
+	Players []*PlayerStats `json:"players"`
+}
+
+// leaderboardSorts are the orders the leaderboard can be served in, most
+// first.
+var leaderboardSorts = map[string]func(s *PlayerStats) int{
+	"wins":                  func(s *PlayerStats) int { return s.Wins },
+	"gamesPlayed":           func(s *PlayerStats) int { return s.GamesPlayed },
+	"rentCollected":         func(s *PlayerStats) int { return s.RentCollected },
+	"bankruptciesInflicted": func(s *PlayerStats) int { return s.BankruptciesInflicted },
+	"averageNetWorth":       func(s *PlayerStats) int { return s.average() },
+}
+
+func (s *PlayerStats) average() int {
+	if s.GamesPlayed == 0 {
+		return 0
+	}
+	return s.TotalNetWorth / s.GamesPlayed
+}
+
+// add counts one more game into s.
+func (s *PlayerStats) add(sum *GameSummary, p PlayerSummary) {
+	s.Name = p.Name
+	s.GamesPlayed++
+	if sum.Winner == p.Name {
+		s.Wins++
+	}
+	s.RentCollected += p.RentCollected
+	s.BankruptciesInflicted += p.BankruptciesInflicted
+	s.TotalNetWorth += p.NetWorth
+}
+
+// rankPlayers sorts stats for the leaderboard by key, most first.
+func rankPlayers(stats []*PlayerStats, key string) {
+	by := leaderboardSorts[key]
+	sort.Slice(stats, func(i, j int) bool {
+		if a, b := by(stats[i]), by(stats[j]); a != b {
+			return a > b
+		}
+		return stats[i].ID < stats[j].ID
+	})
+}
+
+// validatePlayerID checks a persistent player ID given when joining. It is
+// chosen by the client, so it is kept to URL-safe characters.
+func validatePlayerID(id string) error {
+	if len(id) > maxPlayerIDLength {
+		return errors.New("playerId is too long")
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return errors.New("playerId may only contain letters, digits, - and _")
+		}
+	}
+	return nil
+}
+
+// playerWithID returns the player who joined the room with id, if anyone
+// did. It must run on the room's goroutine.
+func (room *GameRoom) playerWithID(id string) string {
+	if id == "" {
+		return ""
+	}
+	for name, other := range room.playerIDs {
+		if other == id {
+			return name
+		}
+	}
+	return ""
+}
+
+// playerIDTaken reports whether someone other than name already joined the
+// room with id. It must run on the room's goroutine.
+func (room *GameRoom) playerIDTaken(id string, name string) bool {
+	for n, other := range room.playerIDs {
+		if other == id && n != name {
+			return true
+		}
+	}
+	return false
+}
+
+// handleLeaderboard serves the top players by ?sort=, wins by default.
+func handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("sort")
+	if key == "" {
+		key = "wins"
+	}
+	if _, ok := leaderboardSorts[key]; !ok {
+		writeError(w, http.StatusBadRequest, "unknown sort "+key)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultLeaderboardLimit)
+	if err != nil || limit < 1 {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	if limit > maxLeaderboardLimit {
+		limit = maxLeaderboardLimit
+	}
+	players, err := store.Leaderboard(key, limit)
+	if err != nil {
+		slog.Error("loading leaderboard", "err", err)
+		writeError(w, http.StatusInternalServerError, "couldn't load the leaderboard")
+		return
+	}
+	if players == nil {
+		players = []*PlayerStats{}
+	}
+	for _, p := range players {
+		p.AverageNetWorth = p.average()
+	}
+	writeJSON(w, http.StatusOK, LeaderboardResponse{Sort: key, Players: players})
+}
+
+// handlePlayerStats serves one player's totals.
+func handlePlayerStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := store.LoadPlayerStats(r.PathValue("id"))
+	if err != nil {
+		slog.Error("loading player stats", "playerId", r.PathValue("id"), "err", err)
+		writeError(w, http.StatusInternalServerError, "couldn't load the player's stats")
+		return
+	}
+	if stats == nil {
+		writeError(w, http.StatusNotFound, "player not found")
+		return
+	}
+	stats.AverageNetWorth = stats.average()
+	writeJSON(w, http.StatusOK, stats)
+}
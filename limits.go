@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// connLimiter counts open websocket connections per remote address.
+type connLimiter struct {
+	mu     sync.Mutex
+	counts map[string]int
+	total  int
+}
+
+var conns = connLimiter{counts: make(map[string]int)}
+
+// acquire takes a connection slot for ip, or reports false if it already
+// has as many as allowed. Every successful acquire must be paired with a
+// release.
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts[ip] >= hub.config.MaxConnsPerIP {
+		return false
+	}
+	l.counts[ip]++
+	l.total++
+	return true
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.counts[ip]--; l.counts[ip] <= 0 {
+		delete(l.counts, ip)
+	}
+}
+
+// stats returns the number of open connections and of distinct addresses
+// they come from.
+func (l *connLimiter) stats() (total int, addresses int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total, len(l.counts)
+}
+
+// clientIP returns the address a request came from. With -trust-proxy the
+// last address in X-Forwarded-For is used: that is the one our proxy
+// appended, and anything before it may have been made up by the client.
+func clientIP(r *http.Request) string {
+	if hub.config.TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
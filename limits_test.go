@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestOversizedMessage has cat send a 10MB frame in the middle of a game,
+// and checks cat's connection is closed as too big while ann and bob play
+// on as if nothing had happened.
+func TestOversizedMessage(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 3})
+	clients := ts.startGame(code, "ann", "bob", "cat")
+	ann, bob, cat := clients[0], clients[1], clients[2]
+	room := ts.room(code)
+	players := func() map[string]string {
+		records := make(map[string]string)
+		room.do(func() {
+			for name, p := range room.GameState.Players {
+				p := *p
+				p.Connected = false
+				records[name] = string(mustMarshal(t, &p))
+			}
+		})
+		return records
+	}
+	before := players()
+
+	// The server stops reading partway through, so the write may fail.
+	go cat.conn.WriteMessage(websocket.TextMessage, bytes.Repeat([]byte("x"), 10<<20))
+	// The websocket library sends the close frame itself, without a reason.
+	cat.expectClose(websocket.CloseMessageTooBig, "")
+
+	ann.send("CHAT_MESSAGE", map[string]string{"text": "still here?"})
+	bob.expectWhere("CHAT_MESSAGE", func(e receivedEvent) bool { return bytes.Contains(e.Payload, []byte("still here?")) })
+	bob.send("CHAT_MESSAGE", map[string]string{"text": "yes"})
+	ann.expectWhere("CHAT_MESSAGE", func(e receivedEvent) bool { return bytes.Contains(e.Payload, []byte(`"yes"`)) })
+	var connected int
+	room.do(func() { connected = len(room.Players) })
+	if connected != 2 {
+		t.Errorf("%d players connected, want ann and bob", connected)
+	}
+	if after := players(); !reflect.DeepEqual(after, before) {
+		t.Errorf("players went from\n%v\nto\n%v", before, after)
+	}
+}
+
+// TestPayloadLimits checks strings longer than any a real client sends
+// are refused before they get anywhere near the game state.
+func TestPayloadLimits(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(nil)
+	ts.dial(code, strings.Repeat("n", maxNameLength+1), nil).expectClose(CloseInvalidJoin, "")
+	ann := ts.join(code, "ann", nil)
+	room := ts.room(code)
+	var seq uint64
+	room.do(func() { seq = room.seq })
+
+	ann.send("BUY_PROPERTY", map[string]string{"property": strings.Repeat("p", maxPropertyNameLength+1)})
+	ann.expectError("INVALID_PAYLOAD")
+	ann.send("KICK_PLAYER", map[string]string{"player": strings.Repeat("n", maxNameLength+1)})
+	ann.expectError("INVALID_PAYLOAD")
+	ann.send("CHAT_MESSAGE", map[string]string{"text": strings.Repeat("c", maxChatLength+1)})
+	ann.expectError("INVALID_CHAT")
+
+	room.do(func() {
+		if len(room.GameState.Players) != 1 || room.seq != seq {
+			t.Errorf("players %v, %d broadcasts since ann joined", room.GameState.Players, room.seq-seq)
+		}
+	})
+}
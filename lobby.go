@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// Room statuses.
+const (
+	StatusWaiting    = game.StatusWaiting
+	StatusInProgress = game.StatusInProgress
+	StatusFinished   = game.StatusFinished
+)
+
+// lobbyEvents may only be sent while the room is waiting to start; every
+// other game event is only accepted once it has.
+var lobbyEvents = map[string]bool{
+	"READY":           true,
+	"START_GAME":      true,
+	"SET_HOUSE_RULES": true,
+	"SELECT_TOKEN":    true,
+	"ADD_BOT":         true,
+}
+
+// tokens are the playing pieces players choose between, in the order they
+// are handed out to players who don't pick one.
+var tokens = []string{
+	"TOP_HAT", "THIMBLE", "IRON", "BOOT", "BATTLESHIP", "RACE_CAR", "DOG", "WHEELBARROW",
+}
+
+type TokenPayload struct {
+	Player string `json:"player"`
+	Token  string `json:"token"`
+}
+
+// tokenOwner returns who holds token in the room, if anyone.
+func (room *GameRoom) tokenOwner(token string) string {
+	for name, p := range room.GameState.Players {
+		if p.Token == token {
+			return name
+		}
+	}
+	return ""
+}
+
+// HandleSelectTokenEvent gives the sender the token they asked for, if
+// nobody else has it. Events are handled one at a time on the room's
+// goroutine, so of two players racing for a token the first one handled wins
+// and the other gets TOKEN_TAKEN.
+func HandleSelectTokenEvent(room *GameRoom, event GameEvent, client *Client) {
+	payload, _ := event.Payload.(map[string]interface{})
+	token, _ := payload["token"].(string)
+	name := connName(room, client)
+
+	known := false
+	for _, t := range tokens {
+		known = known || t == token
+	}
+	if !known {
+		room.rejectEvent(client, event, "UNKNOWN_TOKEN", "unknown token "+token)
+		return
+	}
+	if owner := room.tokenOwner(token); owner != "" && owner != name {
+		room.rejectEvent(client, event, "TOKEN_TAKEN", owner+" already has "+token)
+		return
+	}
+	room.GameState.Players[name].Token = token
+	SendGameEventToAll(room, "TOKEN_SELECTED", room.ID, TokenPayload{Player: name, Token: token})
+}
+
+// assignTokens gives every player without a token the first free one, in
+// seat order. There are as many tokens as seats, so nobody goes without.
+func (room *GameRoom) assignTokens() {
+	for _, name := range room.GameState.TurnOrder {
+		player := room.GameState.Players[name]
+		if player.Token != "" {
+			continue
+		}
+		for _, t := range tokens {
+			if room.tokenOwner(t) == "" {
+				player.Token = t
+				break
+			}
+		}
+	}
+}
+
+// HandleReadyEvent marks the sender ready (or not, with "ready": false).
+func HandleReadyEvent(room *GameRoom, event GameEvent, client *Client) {
+	payload, _ := event.Payload.(map[string]interface{})
+	ready := true
+	if v, ok := payload["ready"].(bool); ok {
+		ready = v
+	}
+	name := connName(room, client)
+	room.GameState.Players[name].Ready = ready
+	SendGameEventToAll(room, "READY", room.ID, map[string]interface{}{"player": name, "ready": ready})
+}
+
+// HandleStartGameEvent starts the game once at least the room's minimum
+// number of players are ready, the host counting as ready by starting it.
+// Players who aren't ready are left out and watch as spectators, except at
+// a tournament table, which only starts with everyone seated at it ready.
+func HandleStartGameEvent(room *GameRoom, event GameEvent, client *Client) {
+	host := room.GameState.Host
+	ready := 0
+	for _, name := range room.GameState.TurnOrder {
+		if room.GameState.Players[name].Ready || name == host {
+			ready++
+		} else if room.tournament != nil {
+			room.rejectEvent(client, event, "PLAYERS_NOT_READY", name+" is not ready")
+			return
+		}
+	}
+	if ready < room.Options.MinPlayers {
+		room.rejectEvent(client, event, "NOT_ENOUGH_PLAYERS", fmt.Sprintf("%d players must be ready to start", room.Options.MinPlayers))
+		return
+	}
+	room.GameState.Players[host].Ready = true
+	room.benchUnready()
+	room.startGame()
+}
+
+// benchUnready takes the players who aren't ready out of the room's game
+// before it starts. Their connections stay on as spectators. It must run
+// on the room's goroutine.
+func (room *GameRoom) benchUnready() {
+	for _, name := range append([]string(nil), room.GameState.TurnOrder...) {
+		if room.GameState.Players[name].Ready {
+			continue
+		}
+		conn := clientFor(room, name)
+		delete(room.GameState.Players, name)
+		delete(room.playerIDs, name)
+		delete(room.preferences, name)
+		room.removeSeat(name)
+		room.revokeSession(name)
+		room.logger().Info("player left out of the game", "player", name)
+		SendGameEventToAll(room, "PLAYER_LEFT", room.ID, room.rosterPayload(name))
+		if conn != nil {
+			delete(room.Players, conn)
+			room.Spectators[conn] = name
+			SendGameEventToAll(room, "SPECTATOR_JOINED", room.ID, SpectatorsPayload{Spectators: len(room.Spectators)})
+		}
+	}
+}
+
+// startGame starts the game with the players seated, who must be enough
+// and all ready. Seat order becomes the turn order. It must run on the
+// room's goroutine.
+func (room *GameRoom) startGame() {
+	room.assignTokens()
+	room.GameState.Status = StatusInProgress
+	room.GameState.Turn = room.GameState.TurnOrder[0]
+	room.GameState.Rolled = false
+	room.GameState.Phase = game.PhaseAwaitingRoll
+	now := time.Now()
+	room.GameState.StartedAt = &now
+	room.GameState.Turns = 1
+	room.lastMove = now
+	room.fillClocks()
+	SendGameEventToAll(room, "GAME_STARTED", room.ID, &room.GameState)
+	metrics.Inc(metricGamesStarted, "")
+	room.startTurnTimer()
+	room.sendAvailableActions()
+}
+
+// removeSeat takes name out of the turn order.
+func (room *GameRoom) removeSeat(name string) {
+	order := room.GameState.TurnOrder[:0]
+	for _, n := range room.GameState.TurnOrder {
+		if n != name {
+			order = append(order, n)
+		}
+	}
+	room.GameState.TurnOrder = order
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStartGameWithEnoughReady(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 2})
+	host := ts.join(code, "ann", nil)
+	bob := ts.join(code, "bob", nil)
+	cat := ts.join(code, "cat", nil)
+
+	host.send("START_GAME", nil)
+	host.expectError("NOT_ENOUGH_PLAYERS")
+
+	bob.send("READY", map[string]bool{"ready": true})
+	bob.expect("READY")
+	host.send("START_GAME", nil)
+	var started struct {
+		TurnOrder []string `json:"turnOrder"`
+	}
+	host.expect("GAME_STARTED").decode(t, &started)
+	cat.expect("GAME_STARTED")
+	if len(started.TurnOrder) != 2 {
+		t.Errorf("turn order %v, want ann and bob", started.TurnOrder)
+	}
+
+	room := ts.room(code)
+	room.do(func() {
+		if _, seated := room.GameState.Players["cat"]; seated {
+			t.Error("cat was seated without being ready")
+		}
+		if len(room.Spectators) != 1 {
+			t.Errorf("%d spectators, want cat", len(room.Spectators))
+		}
+	})
+	cat.send("ROLL_DICE", nil)
+	cat.expectError("SPECTATOR")
+}
+
+// TestKickDropsPreferences checks a player kicked from the lobby leaves
+// no preferences behind for whoever takes their name next.
+func TestKickDropsPreferences(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(nil)
+	host := ts.join(code, "ann", nil)
+	bob := ts.join(code, "bob", nil)
+	bob.send("SET_PREFERENCES", Preferences{AutoRoll: true})
+	bob.expect("PREFERENCES")
+
+	host.send("KICK_PLAYER", map[string]string{"player": "bob"})
+	bob.expectClose(CloseKicked, "kicked")
+	room := ts.room(code)
+	room.do(func() {
+		if prefs, ok := room.preferences["bob"]; ok {
+			t.Errorf("bob's preferences %+v outlasted the kick", prefs)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// Connections pick a locale with ?locale= when they join, and the text
+// the server writes for people to read is rendered in it: action log
+// lines, square names and card text. Keys, codes, indices and template
+// parameters stay the same whatever the locale, and anything a catalog
+// lacks falls back to English.
+
+const defaultLocale = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogs holds each locale's messages by key, from locales/<locale>.json.
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := make(map[string]map[string]string, len(entries))
+	for _, e := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic("locales/" + e.Name() + ": " + err.Error())
+		}
+		catalogs[strings.TrimSuffix(e.Name(), ".json")] = messages
+	}
+	return catalogs
+}
+
+// matchLocale returns the catalog locale to use for a requested one such
+// as "de" or "de-AT", or the default if there is none for it.
+func matchLocale(requested string) string {
+	tag := strings.ToLower(strings.ReplaceAll(requested, "_", "-"))
+	for tag != "" {
+		if _, ok := catalogs[tag]; ok {
+			return tag
+		}
+		i := strings.LastIndexByte(tag, '-')
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	return defaultLocale
+}
+
+// message returns key's text in locale, falling back to English, and
+// false if neither has it.
+func message(locale string, key string) (string, bool) {
+	if text, ok := catalogs[locale][key]; ok {
+		return text, true
+	}
+	text, ok := catalogs[defaultLocale][key]
+	return text, ok
+}
+
+// translateText returns board text, such as a square name or a card, in
+// locale: the board's own translation if it ships one, then the server's
+// catalog under prefix, then the text as it is.
+func (room *GameRoom) translateText(locale string, prefix string, text string) string {
+	if translated, ok := room.board.Translations[locale][text]; ok {
+		return translated
+	}
+	if translated, ok := catalogs[locale][prefix+text]; ok {
+		return translated
+	}
+	return text
+}
+
+// localizable is a broadcast payload with text in it for people to read.
+// localize returns the payload with that text in locale. It must run on
+// the room's goroutine.
+type localizable interface {
+	localize(room *GameRoom, locale string) interface{}
+}
+
+// localized encodes a broadcast in every locale other than the default,
+// for payloads that have text to translate.
+func localized(event GameEvent, room *GameRoom) map[string][]byte {
+	payload, ok := event.Payload.(localizable)
+	if !ok {
+		return nil
+	}
+	variants := make(map[string][]byte, len(catalogs)-1)
+	for locale := range catalogs {
+		if locale == defaultLocale {
+			continue
+		}
+		event.Payload = payload.localize(room, locale)
+		data, err := json.Marshal(event)
+		if err != nil {
+			room.logger().Error("encoding localized broadcast", "event", event.Event, "locale", locale, "err", err)
+			continue
+		}
+		variants[locale] = data
+	}
+	return variants
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+)
+
+// connIDs numbers connections so every line about one can be found.
+var connIDs atomic.Uint64
+
+// setupLogging makes the default slog logger follow cfg's log level and
+// format, which validate has checked.
+func setupLogging(cfg *Config) {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.LogLevel))
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// logger returns a logger for lines about the room.
+func (room *GameRoom) logger() *slog.Logger {
+	return slog.With("gameId", room.ID)
+}
+
+// clientLog returns client's logger with the room and who the client is
+// in it, since a connection can be in more than one room. It must run on
+// the room's goroutine.
+func (room *GameRoom) clientLog(client *Client) *slog.Logger {
+	return client.log.With("gameId", room.ID, "player", connName(room, client))
+}
@@ -1,164 +1,769 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// maxRequestIDLength caps the opaque request and event IDs clients may
+// attach.
+const maxRequestIDLength = 64
+
+// Caps on client-supplied strings that end up in GameState, so that even a
+// message at the size limit can't inflate it much.
+const (
+	maxNameLength         = 32
+	maxPropertyNameLength = 64
 )
 
 type GameEvent struct {
-	Event   string      `json:"event"`
-	GameID  string      `json:"gameId"`
-	Payload interface{} `json:"payload"`
+	Event     string `json:"event"`
+	GameID    string `json:"gameId"`
+	Seq       uint64 `json:"seq,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	// EventID lets a client retry an event without it being applied
+	// twice; see answerDuplicate.
+	EventID        string      `json:"eventId,omitempty"`
+	ActorRequestID string      `json:"actorRequestId,omitempty"`
+	Payload        interface{} `json:"payload"`
 }
 
-type Player struct {
-	Name       string   `json:"name"`
-	Balance    int      `json:"balance"`
-	Position   int      `json:"position"`
-	Properties []string `json:"properties"`
-	JailTurns  int      `json:"jailTurns"`
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
-type GameState struct {
-	Players map[string]*Player
-	Turn    string
+type SpectatorsPayload struct {
+	Spectators int `json:"spectators"`
 }
 
+// The game's state lives in package game with its rules; the server uses
+// it under the names it always had.
+type (
+	GameState   = game.GameState
+	Player      = game.Player
+	ChatMessage = game.ChatMessage
+)
+
 type GameRoom struct {
 	ID        string
-	Players   map[*websocket.Conn]string
-	GameState GameState
-	Mutex     sync.RWMutex
+	Options   RoomOptions
+	CreatedAt time.Time
+	// Players and Spectators map the room's connections to the names
+	// they joined under.
+	Players    map[*Client]string
+	Spectators map[*Client]string
+	GameState  GameState
+
+	// eliminated marks the spectator connections of players who were
+	// knocked out of the game; see forfeitPlayer.
+	eliminated map[*Client]bool
+
+	// dice rolls for the room's game, from GameState.DiceSeed, and board
+	// is the board it is played on.
+	dice  game.Roller
+	board *game.Board
+
+	// commands carries work to the room's goroutine, which alone touches
+	// GameState, the connections and the rest of the room's data; see do.
+	commands chan func()
+
+	subscribers map[Subscriber]struct{}
+	seq         uint64
+	history     []*OutboundMessage
+	actionLog   []ActionLogEntry
+	saveTimer   *time.Timer
+	log         []LogEntry
+	pendingLog  []LogEntry
+	logState    map[string]json.RawMessage
+	// logActions are the actions the rules engine applied since the last
+	// logged entry, and logEngine the change they made to the flattened
+	// state, a nil value marking a path they removed; see apply.
+	// logSpilled counts the oldest entries dropped from log.
+	logActions []LoggedAction
+	logEngine  map[string]json.RawMessage
+	logSpilled int
+
+	// saveMu orders writes of this room to the store.
+	saveMu sync.Mutex
+
+	// listing is what the lobby lists of the room, published again with
+	// every broadcast so that listing rooms never waits on one.
+	listing atomic.Pointer[roomListing]
+
+	chatTimes map[string][]time.Time
+
+	// actorRequestID is the requestId of the event being handled, if any.
+	// It is set and cleared by dispatchGameEvent.
+	actorRequestID string
+	// actor is the player whose action is being handled, if any, for the
+	// event log.
+	actor string
+	// outcome is the error the event being handled was refused with, if
+	// it was. eventIDs remembers the outcomes of players' recent events.
+	outcome  *ErrorPayload
+	eventIDs map[string]*eventOutcomes
+	// resolution collects the broadcasts of the action being applied; see
+	// beginResolution.
+	resolution *resolution
+	// undo is the last move, if its player may still take it back, and
+	// undoVote the open request to do so.
+	undo     *undoPoint
+	undoVote *undoVote
+	// preferences are the players' auto-action settings, and autoTimer
+	// makes the current player's next automatic move. autoActing is set
+	// while it is being made.
+	preferences map[string]Preferences
+	autoTimer   *time.Timer
+	autoActing  bool
+	// tournament is the tournament table the room was set up for, if any.
+	tournament *tournamentSeat
+
+	sessions    map[string]string
+	inviteToken string
+	password    string
+	graceTimers map[string]*roomTimer
+	autoPaused  bool
+	turnTimer   *roomTimer
+	// emotes are the room's most recent emotes.
+	emotes []EmotePayload
+	// clock is the time bank of clockOwner, whose turn it is, and
+	// clockUpdates sends the next CLOCK_UPDATE while it runs.
+	clock        *roomTimer
+	clockOwner   string
+	clockUpdates *time.Timer
+	bots         map[string]Strategy
+
+	// mortgageTimers keep every mortgage on the deeds a player was given
+	// if they don't choose which to lift in time.
+	mortgageTimers map[string]*roomTimer
+
+	kickVote      *kickVote
+	voteCooldowns map[string]time.Time
+
+	lastActivity time.Time
+	// lastMove is when a player last made a move, and abandonTimer
+	// concludes the game if none of its players come back.
+	lastMove     time.Time
+	abandonTimer *time.Timer
+	emptyTimer   *time.Timer
+	closed       bool
+	// done is closed along with the room; after that its goroutine takes
+	// no more commands.
+	done chan struct{}
+	// handedOff is set when the room is closed because another instance
+	// may take it over.
+	handedOff bool
+
+	// savedAt is when the game was saved with SAVE_GAME; it stays set
+	// while a resumed game waits for its players.
+	savedAt         time.Time
+	awaitingPlayers bool
+	rejoinApprovals map[string]time.Time
+	rejoinRequests  map[string]time.Time
+
+	// playerIDs maps player names to the persistent IDs they joined with,
+	// for those who gave one.
+	playerIDs map[string]string
 }
 
+// GameHub holds every room. Its Mutex guards Rooms only; what is inside a
+// room belongs to the room's goroutine, which may take the hub lock but is
+// never waited on by anyone holding it. config, upgrader and auth are set
+// once at startup and never change after.
 type GameHub struct {
 	Rooms map[string]*GameRoom
 	Mutex sync.RWMutex
+
+	config   *Config
+	upgrader *websocket.Upgrader
+	auth     *authenticator
 }
 
-var hub = GameHub{Rooms: make(map[string]*GameRoom)}
+var hub = GameHub{Rooms: make(map[string]*GameRoom), config: newConfig()}
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// newUpgrader returns the websocket upgrader cfg calls for.
+func newUpgrader(cfg *Config) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		Subprotocols:      []string{"json", "msgpack"},
+		EnableCompression: cfg.Compression,
+		CheckOrigin:       newOriginPolicy(cfg.AllowedOrigins, cfg.AllowNoOrigin).check,
+	}
 }
 
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		fmt.Println("WebSocket upgrade failed:", err)
+	if refuseWhileShuttingDown(w) {
 		return
 	}
-	roomID := r.URL.Query().Get("gameId")
-	playerName := r.URL.Query().Get("name")
-	if roomID == "" || playerName == "" {
-		conn.Close()
+	ip := clientIP(r)
+	if !conns.acquire(ip) {
+		http.Error(w, "too many connections from your address", http.StatusTooManyRequests)
+		return
+	}
+	defer conns.release(ip)
+	if routeToOwner(w, r, r.URL.Query().Get("gameId")) {
 		return
 	}
 
-	hub.Mutex.Lock()
-	room, exists := hub.Rooms[roomID]
-	if !exists {
-		room = &GameRoom{
-			ID:      roomID,
-			Players: make(map[*websocket.Conn]string),
-			GameState: GameState{
-				Players: make(map[string]*Player),
-			},
+	conn, err := hub.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Debug("websocket upgrade failed", "remote", ip, "err", err)
+		metrics.Inc(metricUpgradeFailures, "")
+		return
+	}
+	conn.SetReadLimit(hub.config.MaxMessageSize)
+	query := r.URL.Query()
+	client := newClient(conn, negotiateEncoding(conn.Subprotocol(), query.Get("encoding")), matchLocale(query.Get("locale")))
+	client.log = slog.With("connId", client.id, "remote", ip)
+	identity, err := hub.auth.identify(r, hub.config.AllowGuests)
+	if err != nil {
+		code, closeCode := "TOKEN_INVALID", CloseUnauthorized
+		switch {
+		case errors.Is(err, errTokenExpired):
+			code = "TOKEN_EXPIRED"
+		case errors.Is(err, errTokenRequired):
+			code = "TOKEN_REQUIRED"
+		case errors.Is(err, errKeysUnavailable):
+			code, closeCode = "AUTH_UNAVAILABLE", CloseTryAgain
+		}
+		client.log.Info("access token refused", "code", code, "err", err)
+		metrics.Inc(metricJoinsRejected, code)
+		SendError(client, query.Get("gameId"), "", code, err.Error())
+		client.CloseWith(closeCode, err.Error())
+		<-client.done
+		return
+	}
+	if identity != nil {
+		client.identity = identity
+		client.log = client.log.With("user", identity.ID)
+	}
+	if id := query.Get("replay"); id != "" {
+		replay, err := loadReplay(id, query)
+		if err == nil && query.Get("gameId") != "" {
+			err = errors.New("give either gameId or replay, not both")
+		}
+		if err != nil {
+			code := "REPLAY_UNAVAILABLE"
+			switch err {
+			case errReplayNotFound:
+				code = "GAME_NOT_FOUND"
+			case errReplayForbidden:
+				code = "FORBIDDEN"
+			case errReplayUnfinished:
+				code = "GAME_NOT_FINISHED"
+			}
+			client.log.Info("replay refused", "gameId", id, "code", code, "err", err)
+			SendError(client, id, "", code, err.Error())
+			client.CloseWith(CloseInvalidJoin, err.Error())
+			<-client.done
+			return
+		}
+		client.replay = startReplay(client, replay)
+	} else if query.Get("gameId") != "" {
+		// The room on the URL is joined as if by JOIN_ROOM, except that
+		// a connection that can't join it is closed.
+		if _, refusal := joinRoom(client, query); refusal != nil {
+			SendError(client, query.Get("gameId"), "", refusal.code, refusal.message)
+			client.CloseWith(refusal.closeCode, refusal.message)
+			<-client.done
+			return
 		}
-		hub.Rooms[roomID] = room
 	}
-	room.Players[conn] = playerName
-	room.GameState.Players[playerName] = &Player{Name: playerName, Balance: 1500, Position: 0}
-	hub.Mutex.Unlock()
 
-	fmt.Println("Player joined:", playerName)
+	keepAlive(conn)
 
 	defer func() {
-		hub.Mutex.Lock()
-		delete(room.Players, conn)
-		hub.Mutex.Unlock()
-		conn.Close()
-		fmt.Println("Player disconnected:", playerName)
+		client.CloseWith(websocket.CloseNormalClosure, "")
+		for _, room := range client.joinedRooms() {
+			left := room.do(func() { room.removeClient(client) })
+			if !left {
+				client.log.Info("disconnected from closed room", "gameId", room.ID)
+			}
+		}
 	}()
 
 	for {
-		_, msg, err := conn.ReadMessage()
+		messageType, msg, err := conn.ReadMessage()
+		if err == websocket.ErrReadLimit {
+			client.log.Warn("message too big")
+			metrics.Inc(metricInvalidMessages, "too_big")
+			client.CloseWith(websocket.CloseMessageTooBig, "message too big")
+			break
+		}
 		if err != nil {
-			fmt.Println("Read error:", err)
+			client.log.Debug("read error", "err", err)
 			break
 		}
+		if messageType == websocket.BinaryMessage {
+			if msg, err = msgpackToJSON(msg); err != nil {
+				client.log.Warn("invalid msgpack", "err", err)
+				metrics.Inc(metricInvalidMessages, "msgpack")
+				continue
+			}
+		}
 		var event GameEvent
 		if err := json.Unmarshal(msg, &event); err != nil {
-			fmt.Println("Invalid JSON format:", err)
+			client.log.Warn("invalid JSON", "err", err)
+			metrics.Inc(metricInvalidMessages, "json")
 			continue
 		}
-		handleGameEvent(room, event, conn)
+		if drop, disconnect := rateLimit(client, event); disconnect {
+			break
+		} else if drop {
+			continue
+		}
+		if len(event.RequestID) > maxRequestIDLength {
+			SendError(client, event.GameID, "", "INVALID_REQUEST_ID", "requestId is too long")
+			continue
+		}
+		if len(event.EventID) > maxRequestIDLength {
+			SendError(client, event.GameID, event.RequestID, "INVALID_EVENT_ID", "eventId is too long")
+			continue
+		}
+		if event.Event == "PONG" {
+			metrics.Inc(metricEventsReceived, event.Event)
+			client.pong(event)
+			continue
+		}
+		if client.replay != nil {
+			client.replay.handle(event)
+			continue
+		}
+		switch event.Event {
+		case "JOIN_ROOM":
+			HandleJoinRoomEvent(client, event)
+		case "LEAVE_ROOM":
+			HandleLeaveRoomEvent(client, event)
+		case "WATCH_MATCH":
+			HandleWatchMatchEvent(client, event)
+		default:
+			room, ok := client.room(event.GameID)
+			if !ok {
+				SendError(client, event.GameID, event.RequestID, "NOT_IN_ROOM", "this connection isn't in that room")
+				continue
+			}
+			handleGameEvent(room, event, client)
+		}
 	}
 }
 
-func handleGameEvent(room *GameRoom, event GameEvent, conn *websocket.Conn) {
-	room.Mutex.Lock()
-	defer room.Mutex.Unlock()
+// handleGameEvent passes event from client's read loop to the room's
+// goroutine and waits for it to be handled, so a busy room slows down the
+// connections feeding it.
+func handleGameEvent(room *GameRoom, event GameEvent, client *Client) {
+	room.do(func() { dispatchGameEvent(room, event, client) })
+}
+
+// dispatchGameEvent checks event and passes it on to applyGameEvent, unless
+// it repeats an eventId the player has already sent, in which case it is
+// answered as before. It runs on the room's goroutine.
+func dispatchGameEvent(room *GameRoom, event GameEvent, client *Client) {
+	defer room.recoverEvent(client, event)
+
+	if err := checkPayloadLimits(event); err != nil {
+		room.rejectEvent(client, event, "INVALID_PAYLOAD", err.Error())
+		return
+	}
+
+	if room.eliminated[client] && !spectatorEvents[event.Event] {
+		room.rejectEvent(client, event, "ELIMINATED", "you are out of the game and can only watch")
+		return
+	}
+	if isSpectator(room, client) && !spectatorEvents[event.Event] {
+		room.rejectEvent(client, event, "SPECTATOR", "spectators can't take game actions")
+		return
+	}
+
+	room.clientLog(client).Debug("event received", "event", event.Event, "requestId", event.RequestID)
+	metrics.Inc(metricEventsReceived, eventLabel(event.Event))
+	name, player := room.Players[client]
+	if player && room.answerDuplicate(client, name, event) {
+		return
+	}
+	room.actorRequestID = event.RequestID
+	room.actor = connName(room, client)
+	room.outcome = nil
+	defer func() { room.actorRequestID, room.actor, room.outcome = "", "", nil }()
+	room.touch()
+
+	applyGameEvent(room, event, client)
+	if gameEvents[event.Event] && room.outcome == nil {
+		room.lastMove = time.Now()
+	}
+	if player {
+		room.rememberEvent(name, event, room.outcome)
+	}
+}
+
+// applyGameEvent checks event against the state of the game and hands it
+// to its handler. It runs on the room's goroutine.
+func applyGameEvent(room *GameRoom, event GameEvent, client *Client) {
+
+	if gameEvents[event.Event] && room.GameState.Status != StatusInProgress {
+		room.rejectEvent(client, event, "GAME_NOT_STARTED", "the game hasn't started")
+		return
+	}
+	if lobbyEvents[event.Event] && room.GameState.Status != StatusWaiting {
+		room.rejectEvent(client, event, "GAME_ALREADY_STARTED", "the game has already started")
+		return
+	}
+	if hostEvents[event.Event] && connName(room, client) != room.GameState.Host {
+		room.rejectEvent(client, event, "NOT_HOST", "only the host can do that")
+		return
+	}
+	if pausedEvents[event.Event] && room.GameState.Paused {
+		room.rejectEvent(client, event, "GAME_PAUSED", "the game is paused")
+		return
+	}
+	if turnActions[event.Event] && !game.AllowedIn(room.GameState.Phase, event.Event) {
+		room.rejectEvent(client, event, "WRONG_PHASE", event.Event+" can't be sent while the turn is "+phaseNames[room.GameState.Phase])
+		return
+	}
 
 	switch event.Event {
+	case "READY":
+		HandleReadyEvent(room, event, client)
+	case "START_GAME":
+		HandleStartGameEvent(room, event, client)
+	case "SET_HOUSE_RULES":
+		HandleSetHouseRulesEvent(room, event, client)
+	case "SELECT_TOKEN":
+		HandleSelectTokenEvent(room, event, client)
+	case "ADD_BOT":
+		HandleAddBotEvent(room, event, client)
+	case "KICK_PLAYER":
+		HandleKickPlayerEvent(room, event, client)
+	case "TRANSFER_HOST":
+		HandleTransferHostEvent(room, event, client)
+	case "PAUSE_GAME":
+		HandlePauseGameEvent(room, event, client)
+	case "RESUME_GAME":
+		HandleResumeGameEvent(room, event, client)
+	case "VOTE_KICK":
+		HandleVoteKickEvent(room, event, client)
+	case "SAVE_GAME":
+		HandleSaveGameEvent(room, event, client)
+	case "APPROVE_REJOIN":
+		HandleApproveRejoinEvent(room, event, client)
+	case "VOTE":
+		HandleVoteEvent(room, event, client)
 	case "ROLL_DICE":
-		HandleRollDiceEvent(room, event)
+		HandleRollDiceEvent(room, event, client)
 	case "BUY_PROPERTY":
-		HandleBuyPropertyEvent(room, event)
+		HandleBuyPropertyEvent(room, event, client)
+	case "DECLINE_PURCHASE":
+		HandleDeclinePurchaseEvent(room, event, client)
+	case "PAY_BAIL":
+		HandlePayBailEvent(room, event, client)
+	case "UNMORTGAGE":
+		HandleUnmortgageEvent(room, event, client)
+	case "MORTGAGE_TRANSFER_CHOICE":
+		HandleMortgageTransferChoiceEvent(room, event, client)
 	case "END_TURN":
-		HandleEndTurnEvent(room, event)
+		HandleEndTurnEvent(room, event, client)
+	case "CHAT_MESSAGE":
+		HandleChatMessageEvent(room, event, client)
+	case "EMOTE":
+		HandleEmoteEvent(room, event, client)
+	case "STATE_SYNC":
+		client.Send(room.snapshot(event.RequestID, client.locale, room.Players[client]))
+	case "BOARD_DATA":
+		client.Send(room.boardData(event.RequestID, client.locale))
+	case "PLAYER_SUMMARY":
+		HandlePlayerSummaryEvent(room, event, client)
+	case "DICE_STATS":
+		HandleDiceStatsEvent(room, event, client)
+	case "UNDO_REQUEST":
+		HandleUndoRequestEvent(room, event, client)
+	case "UNDO_VOTE":
+		HandleUndoVoteEvent(room, event, client)
+	case "SET_PREFERENCES":
+		HandleSetPreferencesEvent(room, event, client)
 	default:
-		fmt.Println("Unknown event:", event.Event)
+		room.clientLog(client).Warn("unknown event", "event", event.Event)
+		room.rejectEvent(client, event, "UNKNOWN_EVENT", "unknown event "+event.Event)
 	}
 }
 
-func HandleRollDiceEvent(room *GameRoom, event GameEvent) {
-	payload, _ := event.Payload.(map[string]interface{})
-	playerName := payload["player"].(string)
-	roll := int(payload["diceRoll"].(float64))
+// recoverEvent stops a handler that panics from taking the server down
+// with it. The room's goroutine carries on with its next command; only the
+// sender hears about it.
+func (room *GameRoom) recoverEvent(client *Client, event GameEvent) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	room.clientLog(client).Error("event handler panicked", "event", event.Event, "requestId", event.RequestID, "payload", event.Payload, "panic", r, "stack", string(debug.Stack()))
+	metrics.Inc(metricEventPanics, eventLabel(event.Event))
+	SendError(client, room.ID, event.RequestID, "INTERNAL_ERROR", "the server couldn't handle "+event.Event)
+}
 
-	room.GameState.Players[playerName].Position += roll
-	SendGameEventToAll(room, "ROLL_DICE", event.GameID, payload)
+var errNoPayload = errors.New("missing payload")
+
+// validateName trims a player or spectator name and checks it is fit to be
+// used as a map key and shown to other clients.
+func validateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name is required")
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return "", errors.New("name is too long")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return "", errors.New("name contains invalid characters")
+		}
+	}
+	return name, nil
+}
+
+// decodePayload decodes an event's payload into v using v's JSON tags.
+func decodePayload(event GameEvent, v interface{}) error {
+	if event.Payload == nil {
+		return errNoPayload
+	}
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
 }
 
-func HandleBuyPropertyEvent(room *GameRoom, event GameEvent) {
+// checkPayloadLimits rejects payload strings longer than anything a
+// legitimate client sends. Chat text is checked by its own handler.
+func checkPayloadLimits(event GameEvent) error {
 	payload, _ := event.Payload.(map[string]interface{})
-	playerName := payload["player"].(string)
-	propertyName := payload["property"].(string)
+	if name, ok := payload["player"].(string); ok && len(name) > maxNameLength {
+		return errors.New("player name is too long")
+	}
+	if property, ok := payload["property"].(string); ok && len(property) > maxPropertyNameLength {
+		return errors.New("property name is too long")
+	}
+	return nil
+}
 
-	player := room.GameState.Players[playerName]
-	player.Properties = append(player.Properties, propertyName)
-	SendGameEventToAll(room, "BUY_PROPERTY", event.GameID, payload)
+// gameEvents are only accepted while a game is in progress.
+var gameEvents = map[string]bool{
+	"ROLL_DICE":                true,
+	"BUY_PROPERTY":             true,
+	"DECLINE_PURCHASE":         true,
+	"PAY_BAIL":                 true,
+	"UNMORTGAGE":               true,
+	"MORTGAGE_TRANSFER_CHOICE": true,
+	"END_TURN":                 true,
+	"PAUSE_GAME":               true,
+	"RESUME_GAME":              true,
+	"VOTE_KICK":                true,
+	"VOTE":                     true,
+	"SAVE_GAME":                true,
+	"APPROVE_REJOIN":           true,
 }
 
-func HandleEndTurnEvent(room *GameRoom, event GameEvent) {
-	// Rotate turn among players
-	for name := range room.GameState.Players {
-		if name != room.GameState.Turn {
-			room.GameState.Turn = name
-			break
-		}
+// spectatorEvents are the events a spectator connection may send.
+var spectatorEvents = map[string]bool{
+	"CHAT_MESSAGE":   true,
+	"EMOTE":          true,
+	"STATE_SYNC":     true,
+	"BOARD_DATA":     true,
+	"PLAYER_SUMMARY": true,
+	"DICE_STATS":     true,
+}
+
+// connName returns the player or spectator name bound to client. It
+// must run on the room's goroutine.
+func connName(room *GameRoom, client *Client) string {
+	if name, ok := room.Players[client]; ok {
+		return name
 	}
-	SendGameEventToAll(room, "END_TURN", event.GameID, map[string]string{"nextTurn": room.GameState.Turn})
+	return room.Spectators[client]
+}
+
+// isSpectator reports whether client is watching rather than playing. It
+// must run on the room's goroutine.
+func isSpectator(room *GameRoom, client *Client) bool {
+	_, ok := room.Spectators[client]
+	return ok
+}
+
+// HandleRollDiceEvent rolls the room's dice for the sender and moves them.
+func HandleRollDiceEvent(room *GameRoom, event GameEvent, client *Client) {
+	room.actFor(client, event, game.RollDice{Player: connName(room, client)})
 }
 
-func SendGameEventToAll(room *GameRoom, eventType string, gameID string, payload interface{}) {
-	message, _ := json.Marshal(GameEvent{Event: eventType, GameID: gameID, Payload: payload})
-	room.Mutex.RLock()
-	defer room.Mutex.RUnlock()
-	for conn := range room.Players {
-		if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			fmt.Println("Error sending message:", err)
+// HandleBuyPropertyEvent buys the property on offer for the sender. The
+// property named in the payload, if there is one, must be the one on
+// offer.
+func HandleBuyPropertyEvent(room *GameRoom, event GameEvent, client *Client) {
+	var payload struct {
+		Property string `json:"property"`
+	}
+	if event.Payload != nil {
+		if err := decodePayload(event, &payload); err != nil {
+			room.rejectEvent(client, event, "INVALID_PAYLOAD", err.Error())
+			return
 		}
 	}
+	room.actFor(client, event, game.BuyProperty{Player: connName(room, client), Property: payload.Property})
+}
+
+// HandleDeclinePurchaseEvent turns down the property on offer to the
+// sender.
+func HandleDeclinePurchaseEvent(room *GameRoom, event GameEvent, client *Client) {
+	room.actFor(client, event, game.DeclinePurchase{Player: connName(room, client)})
+}
+
+// HandlePayBailEvent gets the sender out of jail before they roll.
+func HandlePayBailEvent(room *GameRoom, event GameEvent, client *Client) {
+	room.actFor(client, event, game.PayBail{Player: connName(room, client)})
+}
+
+// HandleEndTurnEvent ends the sender's turn, passing it on in seat order.
+func HandleEndTurnEvent(room *GameRoom, event GameEvent, client *Client) {
+	room.actFor(client, event, game.EndTurn{Player: connName(room, client)})
+}
+
+func errorMessage(gameID string, requestID string, code string, message string) []byte {
+	data, _ := json.Marshal(GameEvent{Event: "ERROR", GameID: gameID, RequestID: requestID, Payload: ErrorPayload{Code: code, Message: message}})
+	return data
+}
+
+// SendError reports a problem to a single client, echoing the requestId of
+// the event that caused it.
+func SendError(client *Client, gameID string, requestID string, code string, message string) {
+	client.Send(newOutboundMessage(0, errorMessage(gameID, requestID, code, message)))
+}
+
+// routes returns the server's HTTP handlers. The admin endpoints are only
+// served with an admin token.
+func routes(cfg *Config) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", handleWebSocket)
+	mux.HandleFunc("/api/rooms", handleRooms)
+	mux.HandleFunc("GET /api/rooms/{id}/events", handleRoomEvents)
+	mux.HandleFunc("GET /api/rooms/{id}/state", handleRoomState)
+	mux.HandleFunc("GET /api/stats", handleStats)
+	mux.HandleFunc("GET /api/games/{id}/events", handleGameEvents)
+	mux.HandleFunc("POST /api/rooms/{id}/resume", handleResumeRoom)
+	mux.HandleFunc("GET /api/games", handleListGames)
+	mux.HandleFunc("GET /api/games/{id}", handleGetGame)
+	mux.HandleFunc("GET /api/games/{id}/replay", handleGameReplay)
+	mux.HandleFunc("GET /api/leaderboard", handleLeaderboard)
+	mux.HandleFunc("GET /api/players/{id}/stats", handlePlayerStats)
+	mux.HandleFunc("POST /api/matchmaking/join", handleMatchmakingJoin)
+	mux.HandleFunc("GET /api/matchmaking/{ticket}", handleMatchmakingTicket)
+	mux.HandleFunc("DELETE /api/matchmaking/{ticket}", handleMatchmakingLeave)
+	mux.HandleFunc("POST /api/tournaments", handleCreateTournament)
+	mux.HandleFunc("GET /api/tournaments/{id}", handleGetTournament)
+	mux.HandleFunc("GET /api/tournaments/{id}/standings", handleTournamentStandings)
+	mux.HandleFunc("POST /api/tournaments/{id}/rounds/{round}/replay", handleReplayTournamentRound)
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	if cfg.AdminToken != "" {
+		mux.HandleFunc("GET /admin/rooms", requireAdmin(handleAdminRooms))
+		mux.HandleFunc("GET /admin/rooms/{id}/state", requireAdmin(handleAdminRoomState))
+		mux.HandleFunc("DELETE /admin/rooms/{id}", requireAdmin(handleAdminCloseRoom))
+	}
+	return mux
 }
 
 func main() {
-	http.HandleFunc("/ws", handleWebSocket)
-	fmt.Println("WebSocket server started on ws://localhost:8080/ws")
-	http.ListenAndServe(":8080", nil)
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+	setupLogging(cfg)
+	hub.config = cfg
+	hub.upgrader = newUpgrader(cfg)
+	if hub.auth, err = newAuthenticator(cfg); err != nil {
+		slog.Error("setting up access tokens", "err", err)
+		os.Exit(2)
+	}
+	webhooks = newWebhookSender(cfg)
+	if store, err = openStore(cfg); err != nil {
+		slog.Error("opening store", "err", err)
+		os.Exit(1)
+	}
+	if cfg.RedisAddr != "" {
+		// Rooms are restored by whichever instance claims them first.
+		cluster = newRedisCluster(cfg.RedisAddr, instanceURL(cfg), cfg.RoomLease)
+	} else if err := restoreRooms(); err != nil {
+		slog.Error("restoring rooms", "err", err)
+		os.Exit(1)
+	} else if err := restoreTournaments(); err != nil {
+		slog.Error("restoring tournaments", "err", err)
+		os.Exit(1)
+	}
+	go reapIdleRooms()
+	go matches.run()
+	if _, ok := store.(memoryStore); !ok {
+		go expireSavedGames()
+		go expireGameHistory()
+	}
+	server := &http.Server{Addr: cfg.Listen, Handler: routes(cfg), ReadHeaderTimeout: cfg.ReadHeaderTimeout, ReadTimeout: cfg.ReadTimeout}
+	if server.TLSConfig, err = tlsConfig(cfg); err != nil {
+		slog.Error("loading TLS certificate", "err", err)
+		os.Exit(2)
+	}
+	servers := []*http.Server{server}
+	if cfg.RedirectListen != "" {
+		servers = append(servers, redirectServer(cfg))
+	}
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-signals.Done()
+		stop()
+		// A second signal cuts the countdown short.
+		hurry, _ := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		shutdown(hurry, servers...)
+	}()
+	listeners := make([]net.Listener, len(servers))
+	for i, srv := range servers {
+		if listeners[i], err = net.Listen("tcp", srv.Addr); err != nil {
+			slog.Error("listening", "err", err)
+			os.Exit(1)
+		}
+	}
+	listening.Store(true)
+	slog.Info("server started", "addr", listeners[0].Addr().String(), "tls", server.TLSConfig != nil)
+	if len(servers) > 1 {
+		slog.Info("redirecting to HTTPS", "addr", listeners[1].Addr().String())
+		go func() {
+			if err := servers[1].Serve(listeners[1]); err != http.ErrServerClosed {
+				slog.Error("serving redirects", "err", err)
+			}
+		}()
+	}
+	if server.TLSConfig != nil {
+		err = server.ServeTLS(listeners[0], "", "")
+	} else {
+		err = server.Serve(listeners[0])
+	}
+	if err != http.ErrServerClosed {
+		slog.Error("serving", "err", err)
+		os.Exit(1)
+	}
+	<-shutdownDone
 }
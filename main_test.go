@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// The tests here run the server end to end: rooms are made over the HTTP
+// API and played over real websockets, since a Client needs a connection
+// to write to.
+
+// testConfig is the default configuration with the limits that would get
+// in the way of a scripted game lifted.
+func testConfig() *Config {
+	cfg := newConfig()
+	cfg.MaxConnsPerIP = 1000
+	cfg.EventRate, cfg.EventBurst = 1000, 1000
+	cfg.ChatRate, cfg.ChatBurst = 1000, 1000
+	cfg.ChatRateMessages = 1000
+	cfg.AllowDiceSeed = true
+	cfg.BotTurnDelay = time.Millisecond
+	return cfg
+}
+
+// testServer is a server running on a fresh hub.
+type testServer struct {
+	t        testing.TB
+	srv      *httptest.Server
+	handlers sync.WaitGroup
+	mu       sync.Mutex
+	all      []*testClient
+}
+
+// newTestServer starts a server with testConfig, changed by configure if
+// it isn't nil. Its rooms are closed when the test ends.
+func newTestServer(t testing.TB, configure func(*Config)) *testServer {
+	t.Helper()
+	cfg := testConfig()
+	if configure != nil {
+		configure(cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("test config: %v", err)
+	}
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.Mutex.Lock()
+	hub.Rooms = make(map[string]*GameRoom)
+	hub.config, hub.upgrader, hub.auth = cfg, newUpgrader(cfg), auth
+	hub.Mutex.Unlock()
+	store = memoryStore{}
+	ts := &testServer{t: t}
+	mux := routes(cfg)
+	ts.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Websocket handlers outlive the requests httptest waits for.
+		ts.handlers.Add(1)
+		defer ts.handlers.Done()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.close)
+	return ts
+}
+
+// close closes the server's rooms and connections and waits for
+// everything still using the hub to finish, so the next test can replace
+// it.
+func (ts *testServer) close() {
+	hub.Mutex.Lock()
+	rooms := make([]*GameRoom, 0, len(hub.Rooms))
+	for _, room := range hub.Rooms {
+		rooms = append(rooms, room)
+	}
+	hub.Mutex.Unlock()
+	var clients []*Client
+	for _, room := range rooms {
+		room.do(func() {
+			for client := range room.Players {
+				clients = append(clients, client)
+			}
+			for client := range room.Spectators {
+				clients = append(clients, client)
+			}
+			hub.closeRoom(room, "test over")
+		})
+	}
+	ts.mu.Lock()
+	for _, c := range ts.all {
+		c.conn.Close()
+	}
+	ts.mu.Unlock()
+	ts.handlers.Wait()
+	for _, client := range clients {
+		select {
+		case <-client.done:
+		case <-time.After(5 * time.Second):
+			ts.t.Error("a connection didn't close")
+		}
+	}
+	pendingWrites.Wait()
+	ts.srv.Close()
+}
+
+// createRoom creates a room with opts over the API and returns its code.
+func (ts *testServer) createRoom(opts map[string]interface{}) string {
+	ts.t.Helper()
+	body, _ := json.Marshal(opts)
+	resp, err := http.Post(ts.srv.URL+"/api/rooms", "application/json", bytes.NewReader(body))
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var created CreateRoomResponse
+	if resp.StatusCode != http.StatusCreated {
+		ts.t.Fatalf("creating room: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		ts.t.Fatal(err)
+	}
+	return created.Code
+}
+
+// room returns the hub's room with code.
+func (ts *testServer) room(code string) *GameRoom {
+	ts.t.Helper()
+	hub.Mutex.Lock()
+	defer hub.Mutex.Unlock()
+	room, ok := hub.Rooms[code]
+	if !ok {
+		ts.t.Fatalf("no room %s", code)
+	}
+	return room
+}
+
+// receivedEvent is an event as a test client read it.
+type receivedEvent struct {
+	Event   string          `json:"event"`
+	Seq     uint64          `json:"seq"`
+	Payload json.RawMessage `json:"payload"`
+
+	// binary is set for an event that came in a MessagePack frame.
+	binary bool
+}
+
+// decode unmarshals the event's payload into v.
+func (e receivedEvent) decode(t testing.TB, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		t.Fatalf("decoding %s: %v", e.Event, err)
+	}
+}
+
+// testClient is a websocket connection to a testServer.
+type testClient struct {
+	t      testing.TB
+	name   string
+	conn   *websocket.Conn
+	events chan receivedEvent
+	token  string
+	// wire counts the bytes read off the connection.
+	wire *atomic.Int64
+	// closeErr is why the connection ended, once events is closed.
+	closeErr error
+}
+
+// join connects to room code with the given query parameters, as name
+// unless query names someone else, and waits for its welcome.
+func (ts *testServer) join(code, name string, query url.Values) *testClient {
+	ts.t.Helper()
+	c := ts.dial(code, name, query)
+	if query.Get("role") != "spectator" {
+		var welcome WelcomePayload
+		c.expect("WELCOME").decode(ts.t, &welcome)
+		c.name, c.token = welcome.Player, welcome.Token
+	}
+	return c
+}
+
+// dial connects to room code without waiting for anything.
+func (ts *testServer) dial(code, name string, query url.Values) *testClient {
+	ts.t.Helper()
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("gameId", code)
+	if name != "" {
+		query.Set("name", name)
+	}
+	u := "ws" + strings.TrimPrefix(ts.srv.URL, "http") + "/ws?" + query.Encode()
+	wire := new(atomic.Int64)
+	dialer := &websocket.Dialer{
+		EnableCompression: true,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			return countingConn{conn, wire}, err
+		},
+	}
+	conn, _, err := dialer.Dial(u, nil)
+	if err != nil {
+		ts.t.Fatalf("dialing %s: %v", u, err)
+	}
+	c := &testClient{t: ts.t, name: name, conn: conn, events: make(chan receivedEvent, 1024), wire: wire}
+	ts.mu.Lock()
+	ts.all = append(ts.all, c)
+	ts.mu.Unlock()
+	go func() {
+		defer close(c.events)
+		for {
+			frame, data, err := conn.ReadMessage()
+			if err != nil {
+				c.closeErr = err
+				return
+			}
+			if frame == websocket.BinaryMessage {
+				if data, err = msgpackToJSON(data); err != nil {
+					continue
+				}
+			}
+			event := receivedEvent{binary: frame == websocket.BinaryMessage}
+			if json.Unmarshal(data, &event) == nil {
+				c.events <- event
+			}
+		}
+	}()
+	return c
+}
+
+// countingConn counts the bytes read from a connection into read.
+type countingConn struct {
+	net.Conn
+	read *atomic.Int64
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// send sends event to the client's room with payload.
+func (c *testClient) send(event string, payload interface{}) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(map[string]interface{}{"event": event, "payload": payload}); err != nil {
+		c.t.Fatalf("%s sending %s: %v", c.name, event, err)
+	}
+}
+
+// expect skips events until one named event arrives, and fails the test if
+// none does in time.
+func (c *testClient) expect(event string) receivedEvent {
+	c.t.Helper()
+	return c.expectWhere(event, func(receivedEvent) bool { return true })
+}
+
+// expectWhere skips events until one named event that match accepts.
+func (c *testClient) expectWhere(event string, match func(receivedEvent) bool) receivedEvent {
+	c.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-c.events:
+			if !ok {
+				c.t.Fatalf("%s: connection closed waiting for %s", c.name, event)
+			}
+			if e.Event == event && match(e) {
+				return e
+			}
+		case <-timeout:
+			c.t.Fatalf("%s: no %s", c.name, event)
+		}
+	}
+}
+
+// expectStep skips events until a RESOLUTION with a step named event
+// arrives, and returns that step.
+func (c *testClient) expectStep(event string) receivedEvent {
+	c.t.Helper()
+	var step receivedEvent
+	c.expectWhere("RESOLUTION", func(e receivedEvent) bool {
+		var resolution struct {
+			Steps []receivedEvent `json:"steps"`
+		}
+		e.decode(c.t, &resolution)
+		for _, s := range resolution.Steps {
+			if s.Event == event {
+				step = s
+				return true
+			}
+		}
+		return false
+	})
+	return step
+}
+
+// expectError waits for an ERROR with code.
+func (c *testClient) expectError(code string) {
+	c.t.Helper()
+	c.expectWhere("ERROR", func(e receivedEvent) bool {
+		var payload ErrorPayload
+		e.decode(c.t, &payload)
+		return payload.Code == code
+	})
+}
+
+// startGame joins a player for each name to room code, readies them and
+// has the first, the host, start the game.
+func (ts *testServer) startGame(code string, names ...string) []*testClient {
+	ts.t.Helper()
+	clients := make([]*testClient, len(names))
+	for i, name := range names {
+		clients[i] = ts.join(code, name, nil)
+	}
+	for _, c := range clients[1:] {
+		c.send("READY", map[string]bool{"ready": true})
+		clients[0].expectWhere("READY", func(e receivedEvent) bool { return bytes.Contains(e.Payload, []byte(`"`+c.name+`"`)) })
+	}
+	clients[0].send("START_GAME", nil)
+	for _, c := range clients {
+		c.expect("GAME_STARTED")
+	}
+	return clients
+}
+
+// seedWhere returns a dice seed whose first rolls rolls, as totals
+// of two dice, satisfy ok.
+func seedWhere(t *testing.T, rolls int, ok func(totals []int) bool) int64 {
+	t.Helper()
+	for seed := int64(1); seed < 100000; seed++ {
+		dice := game.NewSeededRoller(seed, 0)
+		totals := make([]int, rolls)
+		for i := range totals {
+			d1, d2 := dice.Roll()
+			totals[i] = d1 + d2
+		}
+		if ok(totals) {
+			return seed
+		}
+	}
+	t.Fatal("no dice seed found")
+	return 0
+}
+
+// forSale reports whether the square a roll of total from GO lands on can
+// be bought.
+func forSale(total int) bool {
+	return game.Standard.SquareAt(total).Price > 0
+}
+
+// actions waits for the client's next AVAILABLE_ACTIONS and returns the
+// actions in it.
+func (c *testClient) actions() []string {
+	c.t.Helper()
+	var payload AvailableActionsPayload
+	c.expect("AVAILABLE_ACTIONS").decode(c.t, &payload)
+	names := make([]string, len(payload.Actions))
+	for i, a := range payload.Actions {
+		names[i] = a.Action
+	}
+	return names
+}
+
+// playTurn waits for the client's turn, then rolls, declines anything
+// offered and ends the turn.
+func (c *testClient) playTurn() {
+	c.t.Helper()
+	if actions := c.actions(); actions[0] != game.ActionRollDice {
+		c.t.Fatalf("%s may %v at the start of their turn", c.name, actions)
+	}
+	c.send("ROLL_DICE", nil)
+	if actions := c.actions(); actions[0] == game.ActionBuyProperty {
+		c.send("DECLINE_PURCHASE", nil)
+		c.actions()
+	}
+	c.send("END_TURN", nil)
+}
+
+// playOut plays the client's turns until the game ends, buying whatever is
+// on offer and keeping every mortgage it is given, and returns the winner
+// and the sequence number of every broadcast it saw, in order. Unlike the
+// other helpers it reports failure as an error, so that several clients
+// can play out a game at once on goroutines of their own.
+func (c *testClient) playOut(timeout time.Duration) (winner string, seqs []uint64, err error) {
+	send := func(event string, payload interface{}) error {
+		return c.conn.WriteJSON(map[string]interface{}{"event": event, "payload": payload})
+	}
+	deadline := time.After(timeout)
+	for {
+		var e receivedEvent
+		select {
+		case event, ok := <-c.events:
+			if !ok {
+				return "", seqs, fmt.Errorf("%s: connection closed during the game", c.name)
+			}
+			e = event
+		case <-deadline:
+			return "", seqs, fmt.Errorf("%s: the game didn't finish", c.name)
+		}
+		if e.Seq > 0 && (len(seqs) == 0 || e.Seq > seqs[len(seqs)-1]) {
+			seqs = append(seqs, e.Seq)
+		}
+		steps := []receivedEvent{e}
+		if e.Event == "RESOLUTION" {
+			var resolution struct {
+				Steps []receivedEvent `json:"steps"`
+			}
+			if err := json.Unmarshal(e.Payload, &resolution); err != nil {
+				return "", seqs, err
+			}
+			steps = resolution.Steps
+		}
+		for _, step := range steps {
+			switch step.Event {
+			case "GAME_OVER":
+				var over GameOverPayload
+				err := json.Unmarshal(step.Payload, &over)
+				return over.Winner, seqs, err
+			case "MORTGAGE_TRANSFER_CHOICE":
+				var choice MortgageChoicePayload
+				if json.Unmarshal(step.Payload, &choice) == nil && choice.Player == c.name {
+					if err := send("MORTGAGE_TRANSFER_CHOICE", nil); err != nil {
+						return "", seqs, err
+					}
+				}
+			case "AVAILABLE_ACTIONS":
+				var payload AvailableActionsPayload
+				if err := json.Unmarshal(step.Payload, &payload); err != nil {
+					return "", seqs, err
+				}
+				offered := make(map[string]bool)
+				for _, a := range payload.Actions {
+					offered[a.Action] = true
+				}
+				for _, action := range []string{game.ActionBuyProperty, game.ActionRollDice, game.ActionEndTurn} {
+					if offered[action] {
+						if err := send(action, nil); err != nil {
+							return "", seqs, err
+						}
+						break
+					}
+				}
+			}
+		}
+	}
+}
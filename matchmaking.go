@@ -0,0 +1,479 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quick match puts players who don't know each other into games. A player
+// joins the queue with POST /api/matchmaking/join, saying how many players
+// they would play with, and gets a ticket. The matcher starts a game as
+// soon as the longest-waiting player can have the biggest game they asked
+// for, or, once they have waited -match-wait, the biggest game that can be
+// had with at least as many as they asked for. The players are seated in
+// a new private room with default rules, and each ticket then holds the
+// room's gameId and a session token to join it with.
+//
+// A ticket is followed by polling GET /api/matchmaking/{ticket}, which
+// can wait for the match with ?wait=, or over a websocket with
+// WATCH_MATCH, which is answered with MATCH_FOUND. A ticket that is
+// neither polled nor watched for -match-ttl is dropped, and
+// DELETE /api/matchmaking/{ticket} leaves the queue. The queue belongs to
+// the instance the player reaches.
+
+const (
+	// defaultMatchSize is the most players a game is matched for unless
+	// the player says otherwise.
+	defaultMatchSize = 4
+	// maxMatchQueue bounds how many players may wait at once.
+	maxMatchQueue = 10000
+	// maxMatchPoll is the longest a poll waits for a match.
+	maxMatchPoll = 30 * time.Second
+	// matchInterval is how often the queue is looked over for players who
+	// have waited long enough and tickets that have gone stale.
+	matchInterval = time.Second
+)
+
+// Ticket statuses.
+const (
+	MatchQueued  = "queued"
+	MatchMatched = "matched"
+)
+
+// MatchmakingRequest joins the queue. Name is ignored for a player with
+// an access token, who plays under its name.
+type MatchmakingRequest struct {
+	Name       string `json:"name"`
+	MinPlayers int    `json:"minPlayers,omitempty"`
+	MaxPlayers int    `json:"maxPlayers,omitempty"`
+}
+
+// MatchTicket is a player's place in the queue and, once they are matched,
+// the game they were put in: its gameId, their name and session token in
+// it, and how many players it seats. WSURL is only given over HTTP.
+type MatchTicket struct {
+	Ticket  string `json:"ticket"`
+	Status  string `json:"status"`
+	GameID  string `json:"gameId,omitempty"`
+	Player  string `json:"player,omitempty"`
+	Token   string `json:"token,omitempty"`
+	Players int    `json:"players,omitempty"`
+	WSURL   string `json:"wsUrl,omitempty"`
+}
+
+// WatchMatchPayload is a WATCH_MATCH event's payload.
+type WatchMatchPayload struct {
+	Ticket string `json:"ticket"`
+}
+
+var (
+	errMatchQueueFull = errors.New("too many players are waiting; try again shortly")
+	errMatchSize      = errors.New("minPlayers and maxPlayers must be within the players a game can seat, minPlayers no more than maxPlayers")
+)
+
+// matchEntry is one player's ticket. Everything in it is guarded by the
+// matchmaker's lock.
+type matchEntry struct {
+	ticket   string
+	name     string
+	playerID string
+	min, max int
+	queuedAt time.Time
+	// seen is when the player last polled or watched the ticket.
+	seen     time.Time
+	watchers []*Client
+	// result is set once the player is matched, and done closed.
+	result *MatchTicket
+	done   chan struct{}
+}
+
+// live reports whether someone is still waiting on e at now.
+func (e *matchEntry) live(now time.Time) bool {
+	if now.Sub(e.seen) < hub.config.MatchTTL {
+		return true
+	}
+	for _, c := range e.watchers {
+		select {
+		case <-c.done:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// matchmaker holds the queue. Joins, polls and leaves only touch it under
+// the lock; one goroutine, run, does all the matching, so no player can be
+// put in two games.
+type matchmaker struct {
+	mu      sync.Mutex
+	queue   []*matchEntry
+	tickets map[string]*matchEntry
+	wake    chan struct{}
+}
+
+var matches = &matchmaker{tickets: make(map[string]*matchEntry), wake: make(chan struct{}, 1)}
+
+// join queues a player and returns their ticket. A player with an
+// identity who is already waiting gets the ticket they have.
+func (m *matchmaker) join(name string, playerID string, min int, max int) (*matchEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if playerID != "" {
+		for _, e := range m.queue {
+			if e.playerID == playerID {
+				e.seen = time.Now()
+				return e, nil
+			}
+		}
+	}
+	if len(m.queue) >= maxMatchQueue {
+		return nil, errMatchQueueFull
+	}
+	now := time.Now()
+	e := &matchEntry{
+		ticket:   newSessionToken(),
+		name:     name,
+		playerID: playerID,
+		min:      min,
+		max:      max,
+		queuedAt: now,
+		seen:     now,
+		done:     make(chan struct{}),
+	}
+	m.queue = append(m.queue, e)
+	m.tickets[e.ticket] = e
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	return e, nil
+}
+
+// ticket returns the ticket and what it says now, marking it seen.
+func (m *matchmaker) ticket(id string) (*matchEntry, MatchTicket, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.tickets[id]
+	if !ok {
+		return nil, MatchTicket{}, false
+	}
+	e.seen = time.Now()
+	return e, e.status(), true
+}
+
+// status returns what e says now. The caller must hold the lock.
+func (e *matchEntry) status() MatchTicket {
+	if e.result != nil {
+		return *e.result
+	}
+	return MatchTicket{Ticket: e.ticket, Status: MatchQueued}
+}
+
+// leave takes a ticket out of the queue. It reports false if there is no
+// such ticket, and returns the match if it came too late.
+func (m *matchmaker) leave(id string) (*MatchTicket, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.tickets[id]
+	if !ok {
+		return nil, false
+	}
+	if e.result != nil {
+		return e.result, true
+	}
+	delete(m.tickets, id)
+	m.remove([]*matchEntry{e})
+	return nil, true
+}
+
+// watch sends MATCH_FOUND to client when ticket id is matched, or at once
+// if it already has been.
+func (m *matchmaker) watch(id string, client *Client) bool {
+	m.mu.Lock()
+	e, ok := m.tickets[id]
+	if ok && e.result == nil {
+		e.seen = time.Now()
+		e.watchers = append(e.watchers, client)
+	}
+	var result *MatchTicket
+	if ok {
+		result = e.result
+	}
+	m.mu.Unlock()
+	if result != nil {
+		sendMatchFound(client, *result)
+	}
+	return ok
+}
+
+// remove takes entries out of the queue. The caller must hold the lock.
+func (m *matchmaker) remove(entries []*matchEntry) {
+	gone := make(map[*matchEntry]bool, len(entries))
+	for _, e := range entries {
+		gone[e] = true
+	}
+	queue := m.queue[:0]
+	for _, e := range m.queue {
+		if !gone[e] {
+			queue = append(queue, e)
+		}
+	}
+	clear(m.queue[len(queue):])
+	m.queue = queue
+}
+
+// run matches players as they join and as they wait, for as long as the
+// server runs.
+func (m *matchmaker) run() {
+	ticker := time.NewTicker(matchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.wake:
+		case <-ticker.C:
+		}
+		m.match()
+	}
+}
+
+// match drops stale tickets and starts a game for every group that can
+// play now.
+func (m *matchmaker) match() {
+	m.mu.Lock()
+	now := time.Now()
+	m.expire(now)
+	groups := m.takeGroups(now)
+	m.mu.Unlock()
+	for _, group := range groups {
+		m.startGame(group)
+	}
+}
+
+// expire drops tickets nobody is waiting on any more. The caller must hold
+// the lock.
+func (m *matchmaker) expire(now time.Time) {
+	var stale []*matchEntry
+	for id, e := range m.tickets {
+		if !e.live(now) {
+			delete(m.tickets, id)
+			if e.result == nil {
+				stale = append(stale, e)
+			}
+		}
+	}
+	m.remove(stale)
+}
+
+// takeGroups takes every group that can play now out of the queue, going
+// through it oldest first. The caller must hold the lock.
+func (m *matchmaker) takeGroups(now time.Time) [][]*matchEntry {
+	var groups [][]*matchEntry
+	for i := 0; i < len(m.queue); {
+		e := m.queue[i]
+		group := m.groupFor(e, e.max)
+		if now.Sub(e.queuedAt) >= hub.config.MatchWait {
+			for n := e.max - 1; group == nil && n >= e.min; n-- {
+				group = m.groupFor(e, n)
+			}
+		}
+		if group == nil {
+			// Taking others out of the queue can't help e later in this
+			// pass, so it is left until the next.
+			i++
+			continue
+		}
+		m.remove(group)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// groupFor returns e and the longest-waiting players after it who would
+// all play a game of n, or nil if there aren't enough of them. Names and
+// identities in a group are all different. The caller must hold the lock.
+func (m *matchmaker) groupFor(e *matchEntry, n int) []*matchEntry {
+	group := []*matchEntry{e}
+	names := map[string]bool{e.name: true}
+	for _, f := range m.queue {
+		if len(group) == n {
+			break
+		}
+		if f == e || names[f.name] || f.min > n || f.max < n || f.playerID != "" && f.playerID == e.playerID {
+			continue
+		}
+		group = append(group, f)
+		names[f.name] = true
+	}
+	if len(group) < n {
+		return nil
+	}
+	return group
+}
+
+// startGame seats group in a new room and tells each of them where it is.
+// If no room can be had, they go back in the queue.
+func (m *matchmaker) startGame(group []*matchEntry) {
+	opts := defaultRoomOptions()
+	opts.Private = true
+	opts.MaxPlayers = max(len(group), opts.MinPlayers)
+	room, err := createCodedRoom(opts)
+	if err != nil {
+		slog.Warn("creating a matched room", "players", len(group), "err", err)
+		m.mu.Lock()
+		m.queue = append(group, m.queue...)
+		m.mu.Unlock()
+		return
+	}
+	results := make([]*MatchTicket, len(group))
+	room.do(func() {
+		for i, e := range group {
+			room.GameState.Players[e.name] = &Player{Name: e.name, Balance: room.Options.HouseRules.StartingBalance}
+			room.GameState.TurnOrder = append(room.GameState.TurnOrder, e.name)
+			if e.playerID != "" {
+				room.playerIDs[e.name] = e.playerID
+			}
+			results[i] = &MatchTicket{
+				Ticket:  e.ticket,
+				Status:  MatchMatched,
+				GameID:  room.ID,
+				Player:  e.name,
+				Token:   room.issueSession(e.name),
+				Players: len(group),
+			}
+		}
+		room.GameState.Host = group[0].name
+		room.publishListing()
+		room.logger().Info("room created by matchmaking", "players", len(group))
+	})
+
+	m.mu.Lock()
+	notify := make(map[*Client]MatchTicket)
+	for i, e := range group {
+		e.result = results[i]
+		e.seen = time.Now()
+		close(e.done)
+		for _, c := range e.watchers {
+			notify[c] = *e.result
+		}
+		e.watchers = nil
+	}
+	m.mu.Unlock()
+	for c, result := range notify {
+		sendMatchFound(c, result)
+	}
+}
+
+func sendMatchFound(client *Client, result MatchTicket) {
+	data, _ := json.Marshal(GameEvent{Event: "MATCH_FOUND", GameID: result.GameID, Payload: result})
+	client.Send(newOutboundMessage(0, data))
+}
+
+// handleMatchmakingJoin queues the caller for a game.
+func handleMatchmakingJoin(w http.ResponseWriter, r *http.Request) {
+	if refuseWhileShuttingDown(w) {
+		return
+	}
+	identity, err := hub.auth.identify(r, hub.config.AllowGuests)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	var req MatchmakingRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	if req.MinPlayers == 0 {
+		req.MinPlayers = defaultMinPlayers
+	}
+	if req.MaxPlayers == 0 {
+		req.MaxPlayers = max(req.MinPlayers, min(defaultMatchSize, hub.config.MaxPlayers))
+	}
+	if req.MinPlayers < defaultMinPlayers || req.MaxPlayers > hub.config.MaxPlayers || req.MinPlayers > req.MaxPlayers {
+		writeError(w, http.StatusBadRequest, errMatchSize.Error())
+		return
+	}
+	playerID := ""
+	if identity != nil {
+		req.Name, playerID = identity.Name, identity.ID
+	}
+	name, err := validateName(req.Name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	e, err := matches.join(name, playerID, req.MinPlayers, req.MaxPlayers)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, MatchTicket{Ticket: e.ticket, Status: MatchQueued})
+}
+
+// handleMatchmakingTicket serves a ticket. With ?wait= it holds the
+// request until the player is matched or that long has passed.
+func handleMatchmakingTicket(w http.ResponseWriter, r *http.Request) {
+	e, ticket, ok := matches.ticket(r.PathValue("ticket"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such ticket; it may have expired")
+		return
+	}
+	if wait := r.URL.Query().Get("wait"); wait != "" && ticket.Status == MatchQueued {
+		d, err := time.ParseDuration(wait)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "wait must be a duration such as 20s")
+			return
+		}
+		timer := time.NewTimer(min(d, maxMatchPoll))
+		select {
+		case <-e.done:
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		timer.Stop()
+		if e, ticket, ok = matches.ticket(ticket.Ticket); !ok {
+			writeError(w, http.StatusNotFound, "no such ticket; it may have expired")
+			return
+		}
+	}
+	if ticket.GameID != "" {
+		ticket.WSURL = joinURL(r, ticket.GameID, "")
+	}
+	writeJSON(w, http.StatusOK, ticket)
+}
+
+// handleMatchmakingLeave takes a ticket out of the queue. A ticket that
+// has already been matched stays, and its match is returned with 409.
+func handleMatchmakingLeave(w http.ResponseWriter, r *http.Request) {
+	result, ok := matches.leave(r.PathValue("ticket"))
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "no such ticket; it may have expired")
+	case result != nil:
+		ticket := *result
+		ticket.WSURL = joinURL(r, ticket.GameID, "")
+		writeJSON(w, http.StatusConflict, ticket)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleWatchMatchEvent has MATCH_FOUND sent to the connection when the
+// ticket in the payload is matched.
+func HandleWatchMatchEvent(client *Client, event GameEvent) {
+	metrics.Inc(metricEventsReceived, eventLabel(event.Event))
+	var payload WatchMatchPayload
+	if err := decodePayload(event, &payload); err != nil || strings.TrimSpace(payload.Ticket) == "" {
+		SendError(client, "", event.RequestID, "INVALID_PAYLOAD", "WATCH_MATCH needs a ticket")
+		return
+	}
+	if !matches.watch(payload.Ticket, client) {
+		SendError(client, "", event.RequestID, "UNKNOWN_TICKET", "no such ticket; it may have expired")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMatchmakingConcurrentJoins queues many players at once for games of
+// two while the matcher runs, and checks every one of them is matched into
+// exactly one game, seated with one other player.
+func TestMatchmakingConcurrentJoins(t *testing.T) {
+	ts := newTestServer(t, nil)
+	saved := matches
+	m := &matchmaker{tickets: make(map[string]*matchEntry), wake: make(chan struct{}, 1)}
+	matches = m
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-m.wake:
+				m.match()
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-stopped
+		matches = saved
+	})
+
+	const n = 60
+	tickets := make([]string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, _ := json.Marshal(MatchmakingRequest{Name: fmt.Sprintf("p%d", i), MinPlayers: 2, MaxPlayers: 2})
+			resp, err := http.Post(ts.srv.URL+"/api/matchmaking/join", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			var ticket MatchTicket
+			if resp.StatusCode != http.StatusAccepted || json.NewDecoder(resp.Body).Decode(&ticket) != nil {
+				t.Errorf("p%d joining: %s", i, resp.Status)
+				return
+			}
+			tickets[i] = ticket.Ticket
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	seatedIn := make(map[string]string, n)
+	for i, id := range tickets {
+		var ticket MatchTicket
+		ts.getJSON("/api/matchmaking/"+id+"?wait=5s", &ticket)
+		if ticket.Status != MatchMatched || ticket.Player != fmt.Sprintf("p%d", i) || ticket.Players != 2 {
+			t.Errorf("p%d's ticket %+v", i, ticket)
+			continue
+		}
+		seatedIn[ticket.Player] = ticket.GameID
+	}
+
+	hub.Mutex.RLock()
+	rooms := make([]*GameRoom, 0, len(hub.Rooms))
+	for _, room := range hub.Rooms {
+		rooms = append(rooms, room)
+	}
+	hub.Mutex.RUnlock()
+	games := make(map[string]int)
+	for _, room := range rooms {
+		room.do(func() {
+			if len(room.GameState.TurnOrder) != 2 {
+				t.Errorf("%s seats %v", room.ID, room.GameState.TurnOrder)
+			}
+			for _, name := range room.GameState.TurnOrder {
+				games[name]++
+				if seatedIn[name] != room.ID {
+					t.Errorf("%s is seated in %s, but their ticket says %s", name, room.ID, seatedIn[name])
+				}
+			}
+		})
+	}
+	for i := 0; i < n; i++ {
+		if name := fmt.Sprintf("p%d", i); games[name] != 1 {
+			t.Errorf("%s is seated in %d games", name, games[name])
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(rooms) != n/2 || len(m.queue) != 0 {
+		t.Errorf("%d rooms for %d players, %d still queued", len(rooms), n, len(m.queue))
+	}
+}
+
+// TestMatchmakingLeave checks a player who left the queue isn't matched,
+// and one already matched can't leave.
+func TestMatchmakingLeave(t *testing.T) {
+	newTestServer(t, nil)
+	m := &matchmaker{tickets: make(map[string]*matchEntry), wake: make(chan struct{}, 1)}
+	ann, _ := m.join("ann", "", 2, 2)
+	bob, _ := m.join("bob", "", 2, 2)
+	if result, ok := m.leave(ann.ticket); !ok || result != nil {
+		t.Fatalf("leaving: %v, %v", result, ok)
+	}
+	m.match()
+	if _, ticket, _ := m.ticket(bob.ticket); ticket.Status != MatchQueued {
+		t.Errorf("bob was matched with nobody: %+v", ticket)
+	}
+
+	cat, _ := m.join("cat", "", 2, 2)
+	m.match()
+	if result, ok := m.leave(cat.ticket); !ok || result == nil || result.Status != MatchMatched {
+		t.Errorf("leaving after the match: %v, %v", result, ok)
+	}
+	select {
+	case <-bob.done:
+	case <-time.After(time.Second):
+		t.Error("bob's ticket wasn't matched")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics records what the server does. Counters go up by one per Inc,
+// split by an optional label value; Observe adds a sample to a histogram.
+// Gauges such as open rooms aren't recorded at all: they are read off the
+// hub when scraped, so they can't drift.
+type Metrics interface {
+	Inc(name string, label string)
+	Observe(name string, value float64)
+}
+
+// Metric names.
+const (
+	metricGamesStarted     = "monopoly_games_started_total"
+	metricGamesFinished    = "monopoly_games_finished_total"
+	metricEventsReceived   = "monopoly_events_received_total"
+	metricEventsRejected   = "monopoly_events_rejected_total"
+	metricInvalidMessages  = "monopoly_invalid_messages_total"
+	metricJoinsRejected    = "monopoly_joins_rejected_total"
+	metricUpgradeFailures  = "monopoly_websocket_upgrade_failures_total"
+	metricSlowClients      = "monopoly_slow_clients_dropped_total"
+	metricRateLimitKicks   = "monopoly_rate_limit_disconnects_total"
+	metricEventPanics      = "monopoly_event_panics_total"
+	metricBroadcastSeconds = "monopoly_broadcast_seconds"
+	metricDuplicateEvents  = "monopoly_duplicate_events_total"
+	metricWebhooks         = "monopoly_webhook_deliveries_total"
+	metricLatencySeconds   = "monopoly_client_latency_seconds"
+)
+
+// metricInfo describes a metric. One with buckets is a histogram with
+// those bounds.
+type metricInfo struct {
+	help    string
+	label   string
+	buckets []float64
+}
+
+var metricInfos = map[string]metricInfo{
+	metricGamesStarted:     {help: "Games started."},
+	metricGamesFinished:    {help: "Games played to the end."},
+	metricEventsReceived:   {help: "Game events received from clients, by type.", label: "event"},
+	metricEventsRejected:   {help: "Game events refused with an error, by error code.", label: "code"},
+	metricInvalidMessages:  {help: "Messages that couldn't be decoded, by reason.", label: "reason"},
+	metricJoinsRejected:    {help: "Connections refused before joining a room, by error code.", label: "code"},
+	metricUpgradeFailures:  {help: "Failed websocket upgrades."},
+	metricSlowClients:      {help: "Connections dropped because their send queue filled up or a write timed out."},
+	metricRateLimitKicks:   {help: "Connections dropped for sending events far over their rate limit."},
+	metricEventPanics:      {help: "Event handlers that panicked, by event.", label: "event"},
+	metricBroadcastSeconds: {help: "Time taken to fan a broadcast out to a room's subscribers.", buckets: broadcastBuckets},
+	metricDuplicateEvents:  {help: "Retried events answered without being applied again, by event.", label: "event"},
+	metricWebhooks:         {help: "Webhook deliveries, by outcome: delivered, retried, failed or dropped.", label: "outcome"},
+	metricLatencySeconds:   {help: "Round trips of PING events, as clients answer them with PONG.", buckets: latencyBucketsSeconds},
+}
+
+// broadcastBuckets are the histogram bounds for metricBroadcastSeconds,
+// and latencyBucketsSeconds those for metricLatencySeconds.
+var (
+	broadcastBuckets      = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1}
+	latencyBucketsSeconds = []float64{0.025, 0.05, 0.1, 0.2, 0.35, 0.5, 1, 2, 5}
+)
+
+// knownEvents are the events dispatchGameEvent and the read loop
+// understand; anything else is counted as UNKNOWN so clients can't create
+// labels at will. Keep it in step with the switches there.
+var knownEvents = map[string]bool{
+	"READY": true, "START_GAME": true, "SET_HOUSE_RULES": true, "SELECT_TOKEN": true,
+	"ADD_BOT": true, "KICK_PLAYER": true, "TRANSFER_HOST": true, "PAUSE_GAME": true,
+	"RESUME_GAME": true, "VOTE_KICK": true, "SAVE_GAME": true, "APPROVE_REJOIN": true,
+	"VOTE": true, "ROLL_DICE": true, "BUY_PROPERTY": true, "DECLINE_PURCHASE": true,
+	"PAY_BAIL": true, "UNMORTGAGE": true, "MORTGAGE_TRANSFER_CHOICE": true, "END_TURN": true, "CHAT_MESSAGE": true, "STATE_SYNC": true,
+	"EMOTE": true, "BOARD_DATA": true, "PLAYER_SUMMARY": true, "DICE_STATS": true, "UNDO_REQUEST": true, "UNDO_VOTE": true, "SET_PREFERENCES": true, "JOIN_ROOM": true, "LEAVE_ROOM": true, "WATCH_MATCH": true,
+	"PLAY": true, "PAUSE": true, "SEEK": true, "SPEED": true, "PONG": true,
+}
+
+func eventLabel(event string) string {
+	if knownEvents[event] {
+		return event
+	}
+	return "UNKNOWN"
+}
+
+var metrics Metrics = newPromMetrics()
+
+// promMetrics keeps metrics in memory for /metrics to serve in the
+// Prometheus text format.
+type promMetrics struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]uint64
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // one per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{counts: make([]uint64, len(buckets)+1)}
+}
+
+func newPromMetrics() *promMetrics {
+	return &promMetrics{
+		counters:   make(map[string]map[string]uint64),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+func (m *promMetrics) Inc(name string, label string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]uint64)
+	}
+	m.counters[name][label]++
+}
+
+func (m *promMetrics) Observe(name string, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buckets := metricInfos[name].buckets
+	h := m.histograms[name]
+	if h == nil {
+		h = newHistogram(buckets)
+		m.histograms[name] = h
+	}
+	i := sort.SearchFloat64s(buckets, value)
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
+
+// write renders every metric recorded so far.
+func (m *promMetrics) write(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(metricInfos))
+	for name := range metricInfos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info := metricInfos[name]
+		if info.buckets != nil {
+			h := m.histograms[name]
+			if h == nil {
+				h = newHistogram(info.buckets)
+			}
+			fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, info.help, name)
+			var cumulative uint64
+			for i, bound := range info.buckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(b, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+			}
+			fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, h.count, name, h.sum, name, h.count)
+			continue
+		}
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, info.help, name)
+		values := m.counters[name]
+		if info.label == "" {
+			fmt.Fprintf(b, "%s %d\n", name, values[""])
+			continue
+		}
+		labels := make([]string, 0, len(values))
+		for label := range values {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			fmt.Fprintf(b, "%s{%s=%q} %d\n", name, info.label, label, values[label])
+		}
+	}
+}
+
+// writeGauge renders a single gauge.
+func writeGauge(b *strings.Builder, name string, help string, value int) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+}
+
+// handleMetrics serves the metrics in the Prometheus text format. Gauges
+// are counted room by room, each on the room's own goroutine.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var players, spectators, queued, maxQueued int
+	all := hubRooms()
+	for _, room := range all {
+		room.do(func() {
+			players += len(room.Players)
+			spectators += len(room.Spectators)
+			for _, clients := range []map[*Client]string{room.Players, room.Spectators} {
+				for client := range clients {
+					depth := len(client.send)
+					queued += depth
+					if depth > maxQueued {
+						maxQueued = depth
+					}
+				}
+			}
+		})
+	}
+
+	var b strings.Builder
+	writeGauge(&b, "monopoly_rooms", "Rooms open on this instance.", len(all))
+	writeGauge(&b, "monopoly_players_connected", "Players connected to a room.", players)
+	writeGauge(&b, "monopoly_spectators_connected", "Spectators connected to a room.", spectators)
+	writeGauge(&b, "monopoly_send_queue_messages", "Messages waiting in connections' send queues, in all.", queued)
+	writeGauge(&b, "monopoly_send_queue_max_messages", "The longest send queue of any connection.", maxQueued)
+	if m, ok := metrics.(*promMetrics); ok {
+		m.write(&b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, b.String())
+}
+
+// since returns the seconds elapsed since start, for Observe.
+func since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
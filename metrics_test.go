@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape fetches /metrics and returns each sample's value by its name and
+// labels, as written.
+func (ts *testServer) scrape() map[string]float64 {
+	ts.t.Helper()
+	resp, err := http.Get(ts.srv.URL + "/metrics")
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	samples := make(map[string]float64)
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		line := lines.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if i < 0 || err != nil {
+			ts.t.Fatalf("/metrics line %q", line)
+		}
+		samples[line[:i]] = value
+	}
+	return samples
+}
+
+// TestMetrics plays a short game with a spectator watching and an event
+// sent out of turn, and checks the counters went up by what happened and
+// the gauges read what is open.
+func TestMetrics(t *testing.T) {
+	ts := newTestServer(t, nil)
+	before := ts.scrape()
+	if before["monopoly_rooms"] != 0 || before["monopoly_players_connected"] != 0 {
+		t.Fatalf("a fresh server has gauges %v", before)
+	}
+
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 2})
+	clients := ts.startGame(code, "ann", "bob")
+	ts.join(code, "cat", url.Values{"role": {"spectator"}})
+	clients[0].expect("SPECTATOR_JOINED")
+	room := ts.room(code)
+	var first *testClient
+	room.do(func() { first = map[string]*testClient{"ann": clients[0], "bob": clients[1]}[room.GameState.Turn] })
+	first.send("END_TURN", nil)
+	first.expectError("WRONG_PHASE")
+
+	during := ts.scrape()
+	for name, want := range map[string]float64{
+		"monopoly_rooms":                1,
+		"monopoly_players_connected":    2,
+		"monopoly_spectators_connected": 1,
+	} {
+		if during[name] != want {
+			t.Errorf("%s is %v during the game, want %v", name, during[name], want)
+		}
+	}
+
+	room.do(func() { room.forfeitPlayer("ann", "left") })
+	clients[1].expect("GAME_OVER")
+	after := ts.scrape()
+	for name, want := range map[string]float64{
+		"monopoly_games_started_total":                       1,
+		"monopoly_games_finished_total":                      1,
+		`monopoly_events_received_total{event="START_GAME"}`: 1,
+		`monopoly_events_received_total{event="READY"}`:      1,
+		`monopoly_events_received_total{event="END_TURN"}`:   1,
+		`monopoly_events_rejected_total{code="WRONG_PHASE"}`: 1,
+	} {
+		if got := after[name] - before[name]; got != want {
+			t.Errorf("%s went up by %v, want %v", name, got, want)
+		}
+	}
+	// ann's connection stays on to watch.
+	if after["monopoly_players_connected"] != 1 || after["monopoly_spectators_connected"] != 2 {
+		t.Errorf("after ann forfeited, %v players and %v spectators connected", after["monopoly_players_connected"], after["monopoly_spectators_connected"])
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// Mortgages are part of the rules in package game. A player given
+// mortgaged deeds owes a choice of which mortgages to lift, and is asked
+// for it with MORTGAGE_TRANSFER_CHOICE; they answer with the same event,
+// naming the deeds to unmortgage. If they haven't answered within
+// Config.MortgageTimeout, or are played by a bot, the server keeps them
+// all mortgaged for them.
+
+// MortgagedPayload names the deeds a player mortgaged to pay a debt and
+// what that raised.
+type MortgagedPayload struct {
+	Player     string   `json:"player"`
+	Properties []string `json:"properties"`
+	Raised     int      `json:"raised"`
+}
+
+// MortgageChoicePayload asks a player which of the mortgaged deeds they
+// were given to unmortgage, for what each raised, by Deadline. Interest
+// is what they have already paid on them.
+type MortgageChoicePayload struct {
+	Player     string     `json:"player"`
+	Properties []string   `json:"properties"`
+	Interest   int        `json:"interest"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+}
+
+// MortgageChoiceMadePayload is the choice a player made over the mortgaged
+// deeds they were given. Auto is set when the server made it for them.
+type MortgageChoiceMadePayload struct {
+	Player      string   `json:"player"`
+	Unmortgaged []string `json:"unmortgaged"`
+	Kept        []string `json:"kept"`
+	Paid        int      `json:"paid"`
+	Auto        bool     `json:"auto,omitempty"`
+}
+
+// UnmortgagedPayload names a deed whose mortgage its owner lifted, and
+// what that cost.
+type UnmortgagedPayload struct {
+	Player   string `json:"player"`
+	Property string `json:"property"`
+	Cost     int    `json:"cost"`
+}
+
+// HandleMortgageTransferChoiceEvent makes the choice the sender owes over
+// mortgaged deeds they were given, unmortgaging those the payload names,
+// if any, and keeping the rest.
+func HandleMortgageTransferChoiceEvent(room *GameRoom, event GameEvent, client *Client) {
+	var payload struct {
+		Unmortgage []string `json:"unmortgage"`
+	}
+	if event.Payload != nil {
+		if err := decodePayload(event, &payload); err != nil {
+			room.rejectEvent(client, event, "INVALID_PAYLOAD", err.Error())
+			return
+		}
+	}
+	name := connName(room, client)
+	if err := room.chooseMortgages(game.ChooseMortgages{Player: name, Unmortgage: payload.Unmortgage}, false); err != nil {
+		room.rejectEvent(client, event, "INVALID_MORTGAGE_CHOICE", "you can't unmortgage those deeds now")
+	}
+}
+
+// HandleUnmortgageEvent lifts the mortgage on the sender's deed named in
+// the payload.
+func HandleUnmortgageEvent(room *GameRoom, event GameEvent, client *Client) {
+	var payload struct {
+		Property string `json:"property"`
+	}
+	if err := decodePayload(event, &payload); err != nil || payload.Property == "" {
+		room.rejectEvent(client, event, "INVALID_PAYLOAD", "property is required")
+		return
+	}
+	room.actFor(client, event, game.Unmortgage{Player: connName(room, client), Property: payload.Property})
+}
+
+// chooseMortgages applies choice and announces it, auto if the server made
+// it. Unlike act it keeps no undo point, since the choice needn't be made
+// by the player whose turn it is, and it drops the one there was: taking
+// back the move before it would put back a choice already paid for. It
+// must run on the room's goroutine.
+func (room *GameRoom) chooseMortgages(choice game.ChooseMortgages, auto bool) error {
+	effects, err := room.apply(choice)
+	if err != nil {
+		return err
+	}
+	room.clearUndo("a mortgage choice was made")
+	room.armMortgageChoices()
+	if room.beginResolution() {
+		defer room.endResolution()
+	}
+	for _, effect := range effects {
+		if e, ok := effect.(game.MortgagesChosen); ok {
+			room.mortgagesChosen(e, auto)
+		}
+	}
+	if room.GameState.Turn == choice.Player {
+		room.sendAvailableActions()
+	}
+	return nil
+}
+
+// mortgagesChosen announces the choice a player made over mortgaged
+// deeds. It must run on the room's goroutine.
+func (room *GameRoom) mortgagesChosen(e game.MortgagesChosen, auto bool) {
+	payload := MortgageChoiceMadePayload{Player: e.Player, Unmortgaged: e.Unmortgaged, Kept: e.Kept, Paid: e.Paid, Auto: auto}
+	if payload.Unmortgaged == nil {
+		payload.Unmortgaged = []string{}
+	}
+	if payload.Kept == nil {
+		payload.Kept = []string{}
+	}
+	SendGameEventToAll(room, "MORTGAGE_TRANSFER_SETTLED", room.ID, payload)
+	if len(e.Unmortgaged) > 0 {
+		room.logAction("mortgageLift", map[string]interface{}{"player": e.Player, "count": len(e.Unmortgaged), "amount": e.Paid})
+	}
+	if len(e.Kept) > 0 {
+		room.logAction("mortgageKeep", map[string]interface{}{"player": e.Player, "count": len(e.Kept)})
+	}
+}
+
+// mortgageChoiceOwed asks a player for the choice they owe over mortgaged
+// deeds they were given, having paid interest on them, or makes it for a
+// bot. It must run on the room's goroutine.
+func (room *GameRoom) mortgageChoiceOwed(e game.MortgageChoiceOwed, interest int) {
+	room.armMortgageChoices()
+	choice, _ := room.GameState.PendingMortgageChoice(e.Player)
+	SendGameEventToAll(room, "MORTGAGE_TRANSFER_CHOICE", room.ID, MortgageChoicePayload{Player: e.Player, Properties: e.Properties, Interest: interest, Deadline: choice.Deadline})
+	if player, ok := room.GameState.Players[e.Player]; ok && player.Bot {
+		room.chooseMortgages(game.ChooseMortgages{Player: e.Player}, true)
+	}
+}
+
+// armMortgageChoices starts the timer of every choice owed over mortgaged
+// deeds that hasn't got one, and stops those of choices no longer owed,
+// as after an undo. While the game is paused new timers wait for it to
+// resume. It must run on the room's goroutine.
+func (room *GameRoom) armMortgageChoices() {
+	owed := make(map[string]bool)
+	for i := range room.GameState.MortgageChoices {
+		choice := &room.GameState.MortgageChoices[i]
+		owed[choice.Player] = true
+		if _, ok := room.mortgageTimers[choice.Player]; ok {
+			continue
+		}
+		name := choice.Player
+		deadline := time.Now().Add(hub.config.MortgageTimeout)
+		choice.Deadline = &deadline
+		var timer *roomTimer
+		timer = newRoomTimer(hub.config.MortgageTimeout, func() {
+			room.do(func() { room.mortgageChoiceExpired(name, timer) })
+		})
+		if room.GameState.Paused {
+			timer.pause()
+		}
+		room.mortgageTimers[name] = timer
+	}
+	for name, timer := range room.mortgageTimers {
+		if !owed[name] {
+			timer.stop()
+			delete(room.mortgageTimers, name)
+		}
+	}
+}
+
+// mortgageChoiceExpired keeps every mortgage on the deeds name was given,
+// since they didn't choose in time. A timer that was stopped or paused
+// after it fired is ignored. It must run on the room's goroutine.
+func (room *GameRoom) mortgageChoiceExpired(name string, timer *roomTimer) {
+	if room.mortgageTimers[name] != timer || room.GameState.Paused {
+		return
+	}
+	delete(room.mortgageTimers, name)
+	if room.closed || room.GameState.Status != StatusInProgress {
+		return
+	}
+	room.logger().Info("mortgage choice timed out", "player", name)
+	room.chooseMortgages(game.ChooseMortgages{Player: name}, true)
+}
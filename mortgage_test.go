@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// bankruptWithMortgages starts a game between ann, bob and cat in which
+// the first player's roll lands on Reading Railroad, which the second
+// owns, and bankrupts them to the second, who takes over their two
+// mortgaged deeds. It returns the room, ann's client, which everything
+// the room broadcasts reaches, the second player's client and the choice
+// they are asked to make.
+func bankruptWithMortgages(t *testing.T) (*GameRoom, *Client, *Client, MortgageChoicePayload) {
+	t.Helper()
+	room := newGameRoom("MORTGAGE", defaultRoomOptions())
+	t.Cleanup(room.abandon)
+	clients := map[string]*Client{"ann": fakeClient(), "bob": fakeClient(), "cat": fakeClient()}
+	ann := clients["ann"]
+	room.do(func() {
+		for _, name := range []string{"ann", "bob", "cat"} {
+			seat(room, clients[name], name)
+		}
+		room.Subscribe(ann)
+	})
+	handleGameEvent(room, GameEvent{Event: "START_GAME"}, ann)
+	replies(t, ann)
+	var first, second string
+	dice := loadedDice{{2, 3}}
+	room.do(func() {
+		room.dice = &dice
+		first, second = room.GameState.TurnOrder[0], room.GameState.TurnOrder[1]
+		players := room.GameState.Players
+		players[first].Balance = 0
+		players[first].Properties = []string{"Mediterranean Avenue", "Baltic Avenue"}
+		players[first].Mortgaged = []string{"Mediterranean Avenue", "Baltic Avenue"}
+		players[second].Properties = []string{"Reading Railroad"}
+	})
+
+	handleGameEvent(room, GameEvent{Event: "ROLL_DICE"}, clients[first])
+	asked := steps(t, replies(t, ann), "MORTGAGE_TRANSFER_CHOICE")
+	if len(asked) != 1 {
+		t.Fatalf("%d MORTGAGE_TRANSFER_CHOICE steps, want 1", len(asked))
+	}
+	var choice MortgageChoicePayload
+	if err := json.Unmarshal(asked[0].Payload, &choice); err != nil {
+		t.Fatal(err)
+	}
+	if choice.Player != second || len(choice.Properties) != 2 || choice.Interest != 6 || choice.Deadline == nil {
+		t.Fatalf("choice %+v", choice)
+	}
+	return room, ann, clients[second], choice
+}
+
+// refused reports whether c was sent an ERROR with code among what is
+// queued for it.
+func refused(t *testing.T, c *Client, code string) bool {
+	t.Helper()
+	for _, r := range replies(t, c) {
+		var e struct {
+			Code string `json:"code"`
+		}
+		if r.Event == "ERROR" && json.Unmarshal(r.Payload, &e) == nil && e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// settled decodes the MORTGAGE_TRANSFER_SETTLED step among rs.
+func settled(t *testing.T, rs []reply) (made MortgageChoiceMadePayload, ok bool) {
+	t.Helper()
+	found := steps(t, rs, "MORTGAGE_TRANSFER_SETTLED")
+	if len(found) == 0 {
+		return made, false
+	}
+	if err := json.Unmarshal(found[0].Payload, &made); err != nil {
+		t.Fatal(err)
+	}
+	return made, true
+}
+
+func TestMortgageTransferChoice(t *testing.T) {
+	room, ann, owner, choice := bankruptWithMortgages(t)
+
+	handleGameEvent(room, GameEvent{Event: "MORTGAGE_TRANSFER_CHOICE", Payload: map[string]interface{}{"unmortgage": []string{"Boardwalk"}}}, owner)
+	if !refused(t, owner, "INVALID_MORTGAGE_CHOICE") {
+		t.Fatal("choosing Boardwalk wasn't refused")
+	}
+	handleGameEvent(room, GameEvent{Event: "MORTGAGE_TRANSFER_CHOICE", Payload: map[string]interface{}{"unmortgage": []string{"Baltic Avenue"}}}, owner)
+	made, ok := settled(t, replies(t, ann))
+	want := MortgageChoiceMadePayload{Player: choice.Player, Unmortgaged: []string{"Baltic Avenue"}, Kept: []string{"Mediterranean Avenue"}, Paid: 30}
+	if !ok || !reflect.DeepEqual(made, want) {
+		t.Errorf("settled %+v, want %+v", made, want)
+	}
+	room.do(func() {
+		if p := room.GameState.Players[choice.Player]; !reflect.DeepEqual(p.Mortgaged, []string{"Mediterranean Avenue"}) || p.Balance != 1500-6-30 {
+			t.Errorf("%s has %d, mortgaged %v", choice.Player, p.Balance, p.Mortgaged)
+		}
+		if room.GameState.MortgageChoices != nil || len(room.mortgageTimers) != 0 {
+			t.Errorf("choice still pending: %v", room.GameState.MortgageChoices)
+		}
+	})
+}
+
+func TestMortgageTransferTimeout(t *testing.T) {
+	hub.Mutex.Lock()
+	cfg := *hub.config
+	cfg.MortgageTimeout = 50 * time.Millisecond
+	saved := hub.config
+	hub.config = &cfg
+	hub.Mutex.Unlock()
+	t.Cleanup(func() {
+		hub.Mutex.Lock()
+		hub.config = saved
+		hub.Mutex.Unlock()
+	})
+	room, ann, owner, choice := bankruptWithMortgages(t)
+
+	var made MortgageChoiceMadePayload
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var ok bool
+		if made, ok = settled(t, replies(t, ann)); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the choice never timed out")
+		}
+	}
+	if !made.Auto || len(made.Unmortgaged) != 0 || !reflect.DeepEqual(made.Kept, choice.Properties) {
+		t.Errorf("settled %+v", made)
+	}
+	handleGameEvent(room, GameEvent{Event: "MORTGAGE_TRANSFER_CHOICE", Payload: map[string]interface{}{"unmortgage": []string{"Baltic Avenue"}}}, owner)
+	if !refused(t, owner, "INVALID_MORTGAGE_CHOICE") {
+		t.Error("choosing after the timeout wasn't refused")
+	}
+	room.do(func() {
+		if p := room.GameState.Players[choice.Player]; len(p.Mortgaged) != 2 {
+			t.Errorf("%s's mortgages: %v", choice.Player, p.Mortgaged)
+		}
+	})
+}
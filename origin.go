@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// originPolicy decides which Origin headers may open a websocket. Origins
+// are compared by scheme and host, with default ports made explicit so that
+// https://example.com and https://example.com:443 are the same origin.
+type originPolicy struct {
+	any     bool
+	allowed map[string]bool
+	noneOK  bool
+}
+
+func newOriginPolicy(list string, allowNone bool) originPolicy {
+	p := originPolicy{allowed: make(map[string]bool), noneOK: allowNone}
+	for _, o := range strings.Split(list, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		if o == "*" {
+			p.any = true
+			continue
+		}
+		if key, ok := originKey(o); ok {
+			p.allowed[key] = true
+		}
+	}
+	return p
+}
+
+func (p originPolicy) check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return p.noneOK
+	}
+	if p.any {
+		return true
+	}
+	key, ok := originKey(origin)
+	return ok && p.allowed[key]
+}
+
+// originKey normalises an origin to lower-case scheme://host:port.
+func originKey(origin string) (string, bool) {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		switch scheme {
+		case "http", "ws":
+			port = "80"
+		case "https", "wss":
+			port = "443"
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(host, port), true
+}
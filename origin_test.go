@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		list      string
+		allowNone bool
+		origin    string
+		want      bool
+	}{
+		{"https://play.example.com", false, "https://play.example.com", true},
+		{"https://play.example.com", false, "https://PLAY.example.com", true},
+		{"https://play.example.com", false, "https://play.example.com:443", true},
+		{"https://play.example.com:443", false, "https://play.example.com", true},
+		{"http://localhost:3000", false, "http://localhost:3000", true},
+		{"http://localhost:3000", false, "http://localhost:3001", false},
+		{"http://localhost:3000", false, "http://localhost", false},
+		{"https://play.example.com", false, "http://play.example.com", false},
+		{"https://play.example.com", false, "https://play.example.com.evil.net", false},
+		{"https://play.example.com", false, "https://evil.net", false},
+		{"https://play.example.com", false, "null", false},
+		{" https://a.example.com , https://b.example.com ", false, "https://b.example.com", true},
+		{"*", false, "https://anywhere.net", true},
+		{"*", false, "", false},
+		{"*", true, "", true},
+		{"https://play.example.com", true, "", true},
+		{"", false, "https://play.example.com", false},
+	} {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if got := newOriginPolicy(tc.list, tc.allowNone).check(r); got != tc.want {
+			t.Errorf("origin %q against %q (none ok %v): got %v", tc.origin, tc.list, tc.allowNone, got)
+		}
+	}
+}
+
+// TestOriginRefused checks a websocket from an origin that isn't allowed
+// is refused with 403 before it is upgraded.
+func TestOriginRefused(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.AllowedOrigins = "https://play.example.com" })
+	code := ts.createRoom(nil)
+	u := "ws" + strings.TrimPrefix(ts.srv.URL, "http") + "/ws?gameId=" + code + "&name=ann"
+
+	conn, resp, err := websocket.DefaultDialer.Dial(u, http.Header{"Origin": {"https://evil.net"}})
+	if err == nil {
+		conn.Close()
+		t.Fatal("upgraded for another origin")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("another origin: %v, %v", resp, err)
+	}
+
+	conn, _, err = websocket.DefaultDialer.Dial(u, http.Header{"Origin": {"https://play.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
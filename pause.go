@@ -0,0 +1,125 @@
+package main
+
+import "time"
+
+type PausedPayload struct {
+	PausedBy string `json:"pausedBy"`
+	Auto     bool   `json:"auto,omitempty"`
+}
+
+type ResumedPayload struct {
+	ResumedBy string `json:"resumedBy"`
+}
+
+// pausedEvents are rejected while the game is paused. Chat, STATE_SYNC and
+// host housekeeping still go through.
+var pausedEvents = map[string]bool{
+	"ROLL_DICE":                true,
+	"BUY_PROPERTY":             true,
+	"DECLINE_PURCHASE":         true,
+	"PAY_BAIL":                 true,
+	"UNMORTGAGE":               true,
+	"MORTGAGE_TRANSFER_CHOICE": true,
+	"END_TURN":                 true,
+	"PAUSE_GAME":               true,
+}
+
+// roomTimer is a one-shot timer that can be paused and later resumed with
+// whatever time it had left. Like time.Timer, fn runs on its own
+// goroutine and must hand its work to the room's with do; all methods are
+// called on the room's goroutine.
+type roomTimer struct {
+	fn        func()
+	remaining time.Duration
+	started   time.Time
+	timer     *time.Timer
+}
+
+func newRoomTimer(d time.Duration, fn func()) *roomTimer {
+	t := &roomTimer{fn: fn, remaining: d}
+	t.resume()
+	return t
+}
+
+func (t *roomTimer) pause() {
+	if t.timer == nil {
+		return
+	}
+	t.timer.Stop()
+	t.timer = nil
+	if t.remaining -= time.Since(t.started); t.remaining < 0 {
+		t.remaining = 0
+	}
+}
+
+func (t *roomTimer) resume() {
+	if t.timer != nil {
+		return
+	}
+	t.started = time.Now()
+	t.timer = time.AfterFunc(t.remaining, t.fn)
+}
+
+func (t *roomTimer) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+// HandlePauseGameEvent freezes the game at the host's request.
+func HandlePauseGameEvent(room *GameRoom, event GameEvent, client *Client) {
+	room.pause(connName(room, client), false)
+}
+
+// HandleResumeGameEvent unfreezes a paused game.
+func HandleResumeGameEvent(room *GameRoom, event GameEvent, client *Client) {
+	if !room.GameState.Paused {
+		room.rejectEvent(client, event, "GAME_NOT_PAUSED", "the game isn't paused")
+		return
+	}
+	room.resume(connName(room, client))
+}
+
+// pause freezes the game: pending timers keep their remaining time until
+// resume. It must run on the room's goroutine.
+func (room *GameRoom) pause(by string, auto bool) {
+	room.GameState.Paused = true
+	room.GameState.PausedBy = by
+	room.autoPaused = auto
+	for _, t := range room.graceTimers {
+		t.pause()
+	}
+	for _, t := range room.mortgageTimers {
+		t.pause()
+	}
+	room.pauseTurnTimer()
+	room.logger().Info("game paused", "player", by, "auto", auto)
+	SendGameEventToAll(room, "GAME_PAUSED", room.ID, PausedPayload{PausedBy: by, Auto: auto})
+	room.announceClocks()
+}
+
+// resume restarts the game and any timers pause stopped. It must run on
+// the room's goroutine.
+func (room *GameRoom) resume(by string) {
+	room.GameState.Paused = false
+	room.GameState.PausedBy = ""
+	room.autoPaused = false
+	for _, t := range room.graceTimers {
+		t.resume()
+	}
+	for _, t := range room.mortgageTimers {
+		t.resume()
+	}
+	room.logger().Info("game resumed", "player", by)
+	SendGameEventToAll(room, "GAME_RESUMED", room.ID, ResumedPayload{ResumedBy: by})
+	if room.awaitingPlayers {
+		room.stopAwaitingPlayers()
+	}
+	room.resumeTurnTimer()
+	room.announceClocks()
+	if player, ok := room.GameState.Players[room.GameState.Turn]; ok && player.Bot {
+		room.scheduleBotTurn()
+	}
+	room.scheduleAutoAction()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// Players can have the server make their routine moves for them. With
+// SET_PREFERENCES they ask it to roll as their turn starts, to end their
+// turn once there is nothing left to decide, to turn down any property
+// that would leave them with less than a cash floor, and to pay their way
+// out of jail rather than try for doubles. Each move is made -auto-action-
+// delay after it becomes possible, so the player can follow along and
+// still make it themselves, and goes out exactly as if they had; only the
+// action log marks it as automatic. Preferences belong to the seat, so
+// they outlast a reconnect and are saved with the room, and only the
+// player themselves sees them, in their STATE.
+
+// Preferences are a player's auto-action settings. DeclineBelow, if set,
+// is the cash floor: a property that would leave them with less is
+// declined.
+type Preferences struct {
+	AutoRoll     bool `json:"autoRoll"`
+	AutoEndTurn  bool `json:"autoEndTurn"`
+	DeclineBelow *int `json:"declineBelow,omitempty"`
+	AutoPayBail  bool `json:"autoPayBail"`
+}
+
+var errDeclineBelow = errors.New("declineBelow must not be negative")
+
+// any reports whether p asks for anything to be done automatically.
+func (p Preferences) any() bool {
+	return p.AutoRoll || p.AutoEndTurn || p.DeclineBelow != nil || p.AutoPayBail
+}
+
+// HandleSetPreferencesEvent replaces the sender's preferences, and makes
+// the move they now ask for if it is the sender's turn.
+func HandleSetPreferencesEvent(room *GameRoom, event GameEvent, client *Client) {
+	name, ok := room.Players[client]
+	if !ok {
+		room.rejectEvent(client, event, "SPECTATOR", "spectators have no preferences")
+		return
+	}
+	var prefs Preferences
+	if err := decodePayload(event, &prefs); err != nil {
+		room.rejectEvent(client, event, "INVALID_PAYLOAD", "preferences must be an object")
+		return
+	}
+	if prefs.DeclineBelow != nil && *prefs.DeclineBelow < 0 {
+		room.rejectEvent(client, event, "INVALID_PAYLOAD", errDeclineBelow.Error())
+		return
+	}
+	if prefs.any() {
+		room.preferences[name] = prefs
+	} else {
+		delete(room.preferences, name)
+	}
+	room.markDirty()
+	data, err := json.Marshal(GameEvent{Event: "PREFERENCES", GameID: room.ID, RequestID: event.RequestID, Payload: prefs})
+	if err != nil {
+		room.logger().Error("encoding preferences", "err", err)
+	} else {
+		client.Send(newOutboundMessage(0, data))
+	}
+	if room.GameState.Turn == name {
+		room.scheduleAutoAction()
+	}
+}
+
+// autoAction returns the move name's preferences make for them now, if
+// any. It must run on the room's goroutine.
+func (room *GameRoom) autoAction(name string) game.Action {
+	prefs, ok := room.preferences[name]
+	player := room.GameState.Players[name]
+	if !ok || player == nil || room.GameState.Turn != name {
+		return nil
+	}
+	switch room.GameState.Phase {
+	case game.PhaseAwaitingRoll:
+		if prefs.AutoPayBail && player.JailTurns > 0 && player.Balance >= game.BailCost {
+			return game.PayBail{Player: name}
+		}
+		if prefs.AutoRoll {
+			return game.RollDice{Player: name}
+		}
+	case game.PhaseAwaitingPurchase:
+		price, _ := room.board.PropertyPrice(room.GameState.Offer)
+		if prefs.DeclineBelow != nil && player.Balance-price < *prefs.DeclineBelow {
+			return game.DeclinePurchase{Player: name}
+		}
+	case game.PhaseAwaitingEnd:
+		if prefs.AutoEndTurn {
+			return game.EndTurn{Player: name}
+		}
+	}
+	return nil
+}
+
+// scheduleAutoAction arranges for the current player's preferences to
+// make their next move after -auto-action-delay, if they ask for one. The
+// move is only made if the turn is still where it was. It must run on the
+// room's goroutine.
+func (room *GameRoom) scheduleAutoAction() {
+	room.stopAutoAction()
+	name := room.GameState.Turn
+	if room.GameState.Status != StatusInProgress || room.GameState.Paused || room.autoAction(name) == nil {
+		return
+	}
+	turns, phase := room.GameState.Turns, room.GameState.Phase
+	var timer *time.Timer
+	timer = time.AfterFunc(hub.config.AutoActionDelay, func() {
+		room.do(func() {
+			if room.autoTimer != timer {
+				return
+			}
+			room.autoTimer = nil
+			if room.GameState.Paused || room.GameState.Turn != name || room.GameState.Turns != turns || room.GameState.Phase != phase {
+				return
+			}
+			action := room.autoAction(name)
+			if action == nil {
+				return
+			}
+			room.actor, room.autoActing = name, true
+			defer func() { room.actor, room.autoActing = "", false }()
+			if err := room.act(action); err != nil {
+				room.logger().Warn("auto action refused", "player", name, "err", err)
+			}
+		})
+	})
+	room.autoTimer = timer
+}
+
+// stopAutoAction cancels the pending automatic move, if any. It must run
+// on the room's goroutine.
+func (room *GameRoom) stopAutoAction() {
+	if room.autoTimer != nil {
+		room.autoTimer.Stop()
+		room.autoTimer = nil
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/url"
+)
+
+const maxPasswordLength = 128
+
+var errPasswordTooLong = errors.New("password is too long")
+
+// admits reports whether a join or event stream request carrying query may
+// enter the room. Public rooms admit everyone; private rooms want the
+// invite token, the room password or a session token issued in this room.
+// It must run on the room's goroutine.
+func (room *GameRoom) admits(query url.Values) bool {
+	if !room.Options.Private {
+		return true
+	}
+	if secretEqual(query.Get("invite"), room.inviteToken) {
+		return true
+	}
+	if room.password != "" && secretEqual(query.Get("password"), room.password) {
+		return true
+	}
+	_, ok := room.sessions[query.Get("token")]
+	return ok
+}
+
+// secretEqual compares a credential from a request with the room's copy
+// in constant time. An empty credential never matches.
+func secretEqual(given, want string) bool {
+	if given == "" || want == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(want)) == 1
+}
+
+// admits is room.admits for a room that is no longer open.
+func (rec *RoomRecord) admits(query url.Values) bool {
+	if !rec.Options.Private {
+		return true
+	}
+	if secretEqual(query.Get("invite"), rec.InviteToken) {
+		return true
+	}
+	if rec.Password != "" && secretEqual(query.Get("password"), rec.Password) {
+		return true
+	}
+	_, ok := rec.Sessions[query.Get("token")]
+	return ok
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"time"
+)
+
+// tokenBucket allows bursts of up to burst events, refilling at rate
+// tokens a second.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// allow takes a token at now if there is one.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// eventLimiter limits how fast one connection may send events. Chat and
+// emotes have buckets of their own, stricter than the one for everything
+// else. Events over the limit are refused; each one also uses up some of
+// the strikes bucket, and a client that empties that is abusive. Only the
+// connection's read loop uses it, so it needs no lock.
+type eventLimiter struct {
+	events  *tokenBucket
+	chat    *tokenBucket
+	emotes  *tokenBucket
+	strikes *tokenBucket
+}
+
+func newEventLimiter(cfg *Config, now time.Time) *eventLimiter {
+	return &eventLimiter{
+		events:  newTokenBucket(cfg.EventRate, cfg.EventBurst, now),
+		chat:    newTokenBucket(cfg.ChatRate, cfg.ChatBurst, now),
+		emotes:  newTokenBucket(cfg.EmoteRate, cfg.EmoteBurst, now),
+		strikes: newTokenBucket(cfg.EventRate, cfg.RateLimitKick, now),
+	}
+}
+
+// allow reports whether event may be handled, and if not, whether the
+// client has gone past the limit often enough to be disconnected.
+func (l *eventLimiter) allow(event string, now time.Time) (ok bool, abusive bool) {
+	bucket := l.events
+	switch event {
+	case "CHAT_MESSAGE":
+		bucket = l.chat
+	case "EMOTE":
+		bucket = l.emotes
+	}
+	if bucket.allow(now) {
+		return true, false
+	}
+	return false, !l.strikes.allow(now)
+}
+
+// rateLimit checks event against the client's limits before it reaches
+// the room, so a flood never holds up the room's goroutine. It reports
+// whether the event should be dropped, and whether the client is being
+// disconnected for flooding, in which case the read loop should stop.
+func rateLimit(client *Client, event GameEvent) (drop bool, disconnect bool) {
+	ok, abusive := client.limiter.allow(event.Event, time.Now())
+	if ok {
+		return false, false
+	}
+	if abusive {
+		client.log.Warn("disconnecting client over rate limit", "event", event.Event, "gameId", event.GameID)
+		metrics.Inc(metricRateLimitKicks, "")
+		client.CloseWith(CloseRateLimited, "rate limit exceeded")
+		return true, true
+	}
+	client.log.Debug("event rate limited", "event", event.Event, "gameId", event.GameID)
+	metrics.Inc(metricEventsRejected, "RATE_LIMITED")
+	requestID := event.RequestID
+	if len(requestID) > maxRequestIDLength {
+		requestID = ""
+	}
+	SendError(client, event.GameID, requestID, "RATE_LIMITED", "you are sending events too quickly")
+	return true, false
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEventLimiter(t *testing.T) {
+	cfg := newConfig()
+	now := time.Now()
+	l := newEventLimiter(cfg, now)
+
+	// A human turn, a few events every couple of seconds, is never limited.
+	for turn := 0; turn < 50; turn++ {
+		for _, event := range []string{"ROLL_DICE", "BUY_PROPERTY", "UNMORTGAGE", "END_TURN", "STATE_SYNC"} {
+			if ok, _ := l.allow(event, now); !ok {
+				t.Fatalf("turn %d: %s limited", turn, event)
+			}
+		}
+		now = now.Add(2 * time.Second)
+	}
+
+	l = newEventLimiter(cfg, now)
+	for i := 0; i < cfg.EventBurst; i++ {
+		if ok, _ := l.allow("ROLL_DICE", now); !ok {
+			t.Fatalf("event %d of the burst limited", i)
+		}
+	}
+	if ok, abusive := l.allow("ROLL_DICE", now); ok || abusive {
+		t.Errorf("past the burst: allowed %v, abusive %v", ok, abusive)
+	}
+	// Chat has its own bucket, which the flood hasn't touched.
+	if ok, _ := l.allow("CHAT_MESSAGE", now); !ok {
+		t.Error("chat limited by other events")
+	}
+	if ok, _ := l.allow("ROLL_DICE", now.Add(time.Second/time.Duration(cfg.EventRate))); !ok {
+		t.Error("bucket didn't refill")
+	}
+
+	abusive := false
+	for i := 0; i < cfg.RateLimitKick+1 && !abusive; i++ {
+		_, abusive = l.allow("ROLL_DICE", now)
+	}
+	if !abusive {
+		t.Errorf("%d events over the limit at once aren't abusive", cfg.RateLimitKick+1)
+	}
+}
+
+// TestRateLimitFlood has bob flood the room with events, and checks bob is
+// refused and then disconnected while ann's chat still reaches cat
+// promptly.
+func TestRateLimitFlood(t *testing.T) {
+	defaults := newConfig()
+	ts := newTestServer(t, func(cfg *Config) {
+		cfg.EventRate, cfg.EventBurst, cfg.RateLimitKick = defaults.EventRate, defaults.EventBurst, defaults.RateLimitKick
+	})
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 3})
+	clients := ts.startGame(code, "ann", "bob", "cat")
+	ann, bob, cat := clients[0], clients[1], clients[2]
+
+	// bob sends each event as soon as the last is answered, so the room
+	// is kept busy without a backlog the server never reads.
+	limited := make(chan int, 1)
+	go func() {
+		n := 0
+		defer func() { limited <- n }()
+		for bob.conn.WriteJSON(map[string]interface{}{"event": "ROLL_DICE"}) == nil {
+			for e := range bob.events {
+				if e.Event == "ERROR" {
+					if bytes.Contains(e.Payload, []byte("RATE_LIMITED")) {
+						n++
+					}
+					break
+				}
+			}
+		}
+		for range bob.events {
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		text := fmt.Sprintf("chat %d", i)
+		sent := time.Now()
+		ann.send("CHAT_MESSAGE", map[string]string{"text": text})
+		cat.expectWhere("CHAT_MESSAGE", func(e receivedEvent) bool { return bytes.Contains(e.Payload, []byte(text)) })
+		if d := time.Since(sent); d > 500*time.Millisecond {
+			t.Errorf("%s took %v to reach cat", text, d)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case n := <-limited:
+		if n == 0 {
+			t.Error("bob was never told about the limit")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bob wasn't disconnected")
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(bob.closeErr, &closeErr) || closeErr.Code != CloseRateLimited {
+		t.Errorf("bob's connection ended with %v", bob.closeErr)
+	}
+}
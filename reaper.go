@@ -0,0 +1,123 @@
+package main
+
+import "time"
+
+type RoomClosedPayload struct {
+	Reason string `json:"reason"`
+}
+
+// connectionCount returns how many websocket connections the room has.
+// It must run on the room's goroutine.
+func (room *GameRoom) connectionCount() int {
+	return len(room.Players) + len(room.Spectators)
+}
+
+// touch records activity in the room. It must run on the room's
+// goroutine.
+func (room *GameRoom) touch() {
+	room.lastActivity = time.Now()
+}
+
+// scheduleEmptyCheck arms the timer that removes the room if nobody has
+// joined by the end of the grace period. It must run on the room's
+// goroutine, or before it starts.
+func (room *GameRoom) scheduleEmptyCheck() {
+	if room.emptyTimer != nil {
+		room.emptyTimer.Stop()
+	}
+	if room.tournament != nil && room.GameState.Status == StatusWaiting {
+		// A tournament table waits for its no-show deadline instead.
+		room.emptyTimer = nil
+		return
+	}
+	room.emptyTimer = time.AfterFunc(hub.config.EmptyRoomGrace, func() {
+		room.do(func() {
+			if room.connectionCount() == 0 {
+				hub.closeRoom(room, "room is empty")
+			}
+		})
+	})
+}
+
+// cancelEmptyCheck stops a pending empty-room removal. It must run on
+// the room's goroutine.
+func (room *GameRoom) cancelEmptyCheck() {
+	if room.emptyTimer != nil {
+		room.emptyTimer.Stop()
+		room.emptyTimer = nil
+	}
+}
+
+// closeRoom tells everyone in the room it is closing, removes it from the
+// hub and stops its timers and streams. It must run on the room's
+// goroutine, which stops taking commands once it returns; the hub lock is
+// taken here.
+func (h *GameHub) closeRoom(room *GameRoom, reason string) {
+	if room.closed {
+		return
+	}
+	room.closed = true
+	room.cancelEmptyCheck()
+	room.cancelAbandon()
+	room.cancelAllGrace()
+	room.stopTurnTimer()
+	room.cancelKickVote("room closed")
+	room.clearUndo("room closed")
+	room.stopAutoAction()
+	room.reportTournamentUnplayed()
+	SendGameEventToAll(room, "ROOM_CLOSED", room.ID, RoomClosedPayload{Reason: reason})
+
+	clients := make([]*Client, 0, len(room.Players)+len(room.Spectators))
+	for client := range room.Players {
+		clients = append(clients, client)
+	}
+	for client := range room.Spectators {
+		clients = append(clients, client)
+	}
+	h.Mutex.Lock()
+	if h.Rooms[room.ID] == room {
+		delete(h.Rooms, room.ID)
+	}
+	remaining := len(h.Rooms)
+	h.Mutex.Unlock()
+
+	room.persistClosed()
+	close(room.done)
+	closeCode := CloseRoomClosed
+	if shuttingDown.Load() {
+		closeCode = CloseServerShutdown
+	} else if room.handedOff {
+		// Reconnecting reaches whichever instance hosts the room next.
+		closeCode = CloseTryAgain
+	} else {
+		go cluster.Release(room.ID)
+	}
+	for _, client := range clients {
+		client.part(room, closeCode, "room closed: "+reason)
+	}
+	room.logger().Info("room closed", "reason", reason, "roomsRemaining", remaining)
+}
+
+// reapIdleRooms periodically closes rooms that have seen no activity for
+// roomIdleTimeout, and concludes games nobody has moved in for
+// -abandon-idle.
+func reapIdleRooms() {
+	ticker := time.NewTicker(hub.config.ReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-background.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, room := range hubRooms() {
+			room.do(func() {
+				if room.movesStalled() {
+					room.abandonGame("no moves")
+				} else if time.Since(room.lastActivity) > hub.config.RoomIdleTimeout {
+					hub.closeRoom(room, "room was idle")
+				}
+			})
+		}
+	}
+}
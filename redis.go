@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const redisTimeout = 5 * time.Second
+
+// redisClient is a minimal Redis client speaking RESP over one connection,
+// enough for room leases. Commands are serialized; a broken
+// connection is redialed on the next command.
+type redisClient struct {
+	addr string
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func newRedisClient(addr string) *redisClient {
+	return &redisClient{addr: addr}
+}
+
+// Do sends one command and returns its reply: a string, an int64, nil, or
+// a []interface{} of those.
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, redisTimeout)
+		if err != nil {
+			return nil, err
+		}
+		c.conn, c.r = conn, bufio.NewReader(conn)
+	}
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	reply, err := c.roundTrip(args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+func (c *redisClient) roundTrip(args []string) (interface{}, error) {
+	if _, err := c.conn.Write(encodeRESP(args)); err != nil {
+		return nil, err
+	}
+	return readRESP(c.r)
+}
+
+func (c *redisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func encodeRESP(args []string) []byte {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(a)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, a...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a Redis server that knows just the commands the cluster
+// sends: SET NX PX, GET, and EVAL of the lease scripts. While it is down
+// it hangs up on every connection.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu    sync.Mutex
+	keys  map[string]fakeKey
+	conns map[net.Conn]bool
+	down  bool
+}
+
+type fakeKey struct {
+	value   string
+	expires time.Time
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, keys: make(map[string]fakeKey), conns: make(map[net.Conn]bool)}
+	go f.accept()
+	t.Cleanup(func() {
+		ln.Close()
+		f.setDown(true)
+	})
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) accept() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		if f.down {
+			conn.Close()
+		} else {
+			f.conns[conn] = true
+		}
+		f.mu.Unlock()
+		go f.serve(conn)
+	}
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		command, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		items, _ := command.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if _, err := conn.Write([]byte(f.exec(args))); err != nil {
+			return
+		}
+	}
+}
+
+// setDown hangs up on every connection while down, and lets them in
+// again once it isn't.
+func (f *fakeRedis) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+	if down {
+		for conn := range f.conns {
+			conn.Close()
+			delete(f.conns, conn)
+		}
+	}
+}
+
+// get returns key's value, if it is set and hasn't expired. The caller
+// must hold f.mu.
+func (f *fakeRedis) get(key string) (string, bool) {
+	k, ok := f.keys[key]
+	if !ok || time.Now().After(k.expires) {
+		delete(f.keys, key)
+		return "", false
+	}
+	return k.value, true
+}
+
+// set sets key to value for lease, as if another instance had claimed
+// it.
+func (f *fakeRedis) set(key, value string, lease time.Duration) {
+	f.mu.Lock()
+	f.keys[key] = fakeKey{value: value, expires: time.Now().Add(lease)}
+	f.mu.Unlock()
+}
+
+// value returns key's value, or "" if it isn't set.
+func (f *fakeRedis) value(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, _ := f.get(key)
+	return value
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	millis := func(s string) time.Duration {
+		n, _ := strconv.Atoi(s)
+		return time.Duration(n) * time.Millisecond
+	}
+	switch {
+	case len(args) == 6 && args[0] == "SET" && args[3] == "NX" && args[4] == "PX":
+		if _, ok := f.get(args[1]); ok {
+			return "$-1\r\n"
+		}
+		f.keys[args[1]] = fakeKey{value: args[2], expires: time.Now().Add(millis(args[5]))}
+		return "+OK\r\n"
+	case len(args) == 2 && args[0] == "GET":
+		value, ok := f.get(args[1])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+	case len(args) >= 5 && args[0] == "EVAL" && args[2] == "1":
+		key, self := args[3], args[4]
+		if value, ok := f.get(key); !ok || value != self {
+			return ":0\r\n"
+		}
+		switch {
+		case args[1] == renewLeaseScript && len(args) == 6:
+			f.keys[key] = fakeKey{value: self, expires: time.Now().Add(millis(args[5]))}
+			return ":1\r\n"
+		case args[1] == releaseLeaseScript:
+			delete(f.keys, key)
+			return ":1\r\n"
+		}
+		return "-ERR unknown script\r\n"
+	}
+	return "-ERR unknown command '" + strings.Join(args, " ") + "'\r\n"
+}
+
+func TestReadRESP(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    interface{}
+		wantErr string
+	}{
+		{in: "+OK\r\n", want: "OK"},
+		{in: "-ERR wrong type\r\n", wantErr: "redis: ERR wrong type"},
+		{in: ":42\r\n", want: int64(42)},
+		{in: "$5\r\nhello\r\n", want: "hello"},
+		{in: "$4\r\na\r\nb\r\n", want: "a\r\nb"},
+		{in: "$0\r\n\r\n", want: ""},
+		{in: "$-1\r\n", want: nil},
+		{in: "*0\r\n", want: []interface{}{}},
+		{in: "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n", want: []interface{}{"a", int64(1), nil}},
+		{in: "*2\r\n*1\r\n+x\r\n:2\r\n", want: []interface{}{[]interface{}{"x"}, int64(2)}},
+		{in: "?what\r\n", wantErr: "unknown reply type"},
+		{in: "+OK\n", wantErr: "malformed reply"},
+		{in: ":many\r\n", wantErr: "invalid syntax"},
+		{in: "$5\r\nhi\r\n", wantErr: "EOF"},
+		{in: "*2\r\n+x\r\n", wantErr: "EOF"},
+	} {
+		got, err := readRESP(bufio.NewReader(strings.NewReader(tc.in)))
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%q: got %v, %v; want an error about %s", tc.in, got, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %#v, %v; want %#v", tc.in, got, err, tc.want)
+		}
+	}
+	var replyErr redisError
+	if _, err := readRESP(bufio.NewReader(strings.NewReader("-ERR no\r\n"))); !errors.As(err, &replyErr) {
+		t.Errorf("an error reply gave %v, want a redisError", err)
+	}
+}
+
+func TestEncodeRESP(t *testing.T) {
+	args := []string{"SET", "monopoly:room:AB12:owner", "", "line\r\nbreak"}
+	encoded := encodeRESP(args)
+	if want := "*4\r\n$3\r\nSET\r\n$24\r\nmonopoly:room:AB12:owner\r\n$0\r\n\r\n$11\r\nline\r\nbreak\r\n"; string(encoded) != want {
+		t.Errorf("encoded %q, want %q", encoded, want)
+	}
+	decoded, err := readRESP(bufio.NewReader(strings.NewReader(string(encoded))))
+	if err != nil || !reflect.DeepEqual(decoded, []interface{}{"SET", "monopoly:room:AB12:owner", "", "line\r\nbreak"}) {
+		t.Errorf("decoded %#v, %v", decoded, err)
+	}
+}
+
+// TestRedisClientRedials checks an error reply leaves the connection be,
+// and a broken one fails the command on it and is redialed for the next.
+func TestRedisClientRedials(t *testing.T) {
+	f := newFakeRedis(t)
+	c := newRedisClient(f.addr())
+	t.Cleanup(func() { c.Close() })
+
+	if reply, err := c.Do("SET", "k", "v", "NX", "PX", "10000"); reply != "OK" || err != nil {
+		t.Fatalf("SET: %v, %v", reply, err)
+	}
+	var replyErr redisError
+	if _, err := c.Do("FLUSHALL"); !errors.As(err, &replyErr) {
+		t.Fatalf("FLUSHALL: %v, want an error reply", err)
+	}
+	c.mu.Lock()
+	kept := c.conn != nil
+	c.mu.Unlock()
+	if !kept {
+		t.Error("an error reply closed the connection")
+	}
+
+	f.setDown(true)
+	if _, err := c.Do("GET", "k"); err == nil {
+		t.Fatal("GET succeeded while the server was down")
+	}
+	f.setDown(false)
+	if reply, err := c.Do("GET", "k"); reply != "v" || err != nil {
+		t.Errorf("GET after the server came back: %v, %v", reply, err)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// Replays let a finished game be kept and watched again. GET
+// /api/games/{id}/replay exports everything needed to do so as one JSON
+// document, and connecting to /ws with ?replay={id} streams the game's
+// broadcasts back as they were sent, at a pace the client controls with
+// PLAY, PAUSE, SEEK and SPEED. A replay session only reads the game's log
+// and never touches a live room. Only finished games can be replayed,
+// since the dice seed would tell the future of one still being played.
+// Either leaves out emotes with ?emotes=false.
+
+// replayVersion is the version of the replay format. Fields may be added
+// to it freely; anything that would change what existing fields mean
+// bumps it, so readers of old replays know what they are looking at.
+// Version 2 records each action as one RESOLUTION event, though games
+// played before resolutions still have their steps as separate events.
+// Version 3 records the moves the rules engine made for an event, whose
+// change its diff leaves out; see LogEntry.
+const replayVersion = 3
+
+const (
+	minReplaySpeed = 0.25
+	maxReplaySpeed = 16
+	// maxReplayGap caps the pause between two events at normal speed, so
+	// a player who took a minute to move doesn't stall the replay.
+	maxReplayGap = 3 * time.Second
+)
+
+var (
+	errReplayNotFound   = errors.New("game not found")
+	errReplayForbidden  = errors.New("this room is private")
+	errReplayUnfinished = errors.New("the game hasn't finished yet")
+)
+
+// Replay is a finished game as exported for playback. Board is the
+// definition the game was played on, unless it was the standard board;
+// BoardID names it if it came from -boards-dir. InitialState is the game
+// as it stood when it started, and Events every broadcast made in the
+// room, oldest first, each with the change it made to the state.
+// DiceHistory is every roll of the game's dice, which the state leaves out.
+type Replay struct {
+	Version      int             `json:"version"`
+	GameID       string          `json:"gameId"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	HouseRules   HouseRules      `json:"houseRules"`
+	BoardID      string          `json:"boardId,omitempty"`
+	Board        json.RawMessage `json:"board,omitempty"`
+	DiceSeed     int64           `json:"diceSeed"`
+	DiceHistory  []game.DiceRoll `json:"diceHistory,omitempty"`
+	InitialState GameState       `json:"initialState"`
+	Events       []LogEntry      `json:"events"`
+
+	rules logRules
+}
+
+// ReplayStatusPayload tells a replay connection where playback is: Seq is
+// the last event streamed, 0 before the first, and LastSeq the game's
+// last. State is the game at Seq, sent when playback starts and after a
+// SEEK.
+type ReplayStatusPayload struct {
+	Seq     uint64     `json:"seq"`
+	LastSeq uint64     `json:"lastSeq"`
+	Playing bool       `json:"playing"`
+	Speed   float64    `json:"speed"`
+	Ended   bool       `json:"ended,omitempty"`
+	State   *GameState `json:"state,omitempty"`
+}
+
+type ReplaySeekPayload struct {
+	Seq uint64 `json:"seq"`
+}
+
+type ReplaySpeedPayload struct {
+	Speed float64 `json:"speed"`
+}
+
+// replayEvents are the events a replay connection understands.
+var replayEvents = map[string]bool{"PLAY": true, "PAUSE": true, "SEEK": true, "SPEED": true}
+
+// loadReplay exports the finished game id, from its room if it is still
+// open and otherwise from the store. query must admit the caller to a
+// private room, and may leave emotes out.
+func loadReplay(id string, query url.Values) (*Replay, error) {
+	var (
+		rec      *RoomRecord
+		entries  []LogEntry
+		allowed  = true
+		finished = true
+	)
+	hub.Mutex.RLock()
+	room, live := hub.lookup(id)
+	hub.Mutex.RUnlock()
+	if live {
+		live = room.do(func() {
+			if allowed = room.admits(query); !allowed {
+				return
+			}
+			if finished = room.GameState.Status == StatusFinished; !finished {
+				return
+			}
+			rec = &RoomRecord{ID: room.ID, Options: room.Options, CreatedAt: room.CreatedAt}
+			seed := room.GameState.DiceSeed
+			rec.DiceSeed = &seed
+			rec.DiceHistory = append([]game.DiceRoll(nil), room.GameState.DiceHistory...)
+		})
+	}
+	if live && allowed && finished {
+		// A room that closed in the meantime has its game in the store.
+		var complete bool
+		var err error
+		entries, complete, err = room.eventLog()
+		switch {
+		case err == errRoomClosed:
+			live = false
+		case err != nil:
+			return nil, err
+		case !complete:
+			// The start of the game has been dropped from memory, with
+			// nowhere to read it back from.
+			return nil, errReplayNotFound
+		}
+	}
+	if !live {
+		var err error
+		rec, err = store.LoadRoom(id)
+		if err == nil && rec == nil && strings.ToUpper(id) != id {
+			rec, err = store.LoadRoom(strings.ToUpper(id))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			return nil, errReplayNotFound
+		}
+		allowed, finished = rec.admits(query), rec.Finished()
+		if allowed && finished {
+			if entries, err = store.LoadLog(rec.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch {
+	case !allowed:
+		return nil, errReplayForbidden
+	case !finished:
+		return nil, errReplayUnfinished
+	case len(entries) == 0 || rec.DiceSeed == nil:
+		// Games from before the seed was kept can't be replayed.
+		return nil, errReplayNotFound
+	}
+
+	replay := &Replay{
+		Version:     replayVersion,
+		GameID:      rec.ID,
+		CreatedAt:   rec.CreatedAt,
+		HouseRules:  rec.Options.HouseRules,
+		BoardID:     rec.Options.BoardID,
+		Board:       rec.Options.Board,
+		DiceSeed:    *rec.DiceSeed,
+		DiceHistory: rec.DiceHistory,
+		rules:       newLogRules(rec.Options, *rec.DiceSeed),
+	}
+	skipEmotes := query.Get("emotes") == "false"
+	for _, e := range entries {
+		if !e.Rejected && !(skipEmotes && e.Event == "EMOTE") {
+			replay.Events = append(replay.Events, e)
+		}
+	}
+	start := 1
+	for i, e := range replay.Events {
+		if e.Event == "GAME_STARTED" {
+			start = i + 1
+			break
+		}
+	}
+	var err error
+	if replay.InitialState, err = replayLog(replay.Events[:start], replay.rules); err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
+
+// handleGameReplay serves the replay of a finished game as a download.
+func handleGameReplay(w http.ResponseWriter, r *http.Request) {
+	replay, err := loadReplay(r.PathValue("id"), r.URL.Query())
+	switch err {
+	case nil:
+	case errReplayNotFound:
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errReplayForbidden:
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	case errReplayUnfinished:
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	default:
+		slog.Error("exporting replay", "gameId", r.PathValue("id"), "err", err)
+		writeError(w, http.StatusInternalServerError, "couldn't load the game")
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+replay.GameID+`-replay.json"`)
+	writeJSON(w, http.StatusOK, replay)
+}
+
+// replaySession plays a replay back to one connection. Its goroutine owns
+// the playback position, and state, the game as it stands there; the read
+// loop passes it control events.
+type replaySession struct {
+	client  *Client
+	replay  *Replay
+	state   *logReplay
+	control chan GameEvent
+}
+
+// startReplay starts playing replay to client, paused at the start.
+func startReplay(client *Client, replay *Replay) *replaySession {
+	s := &replaySession{client: client, replay: replay, state: newLogReplay(replay.rules), control: make(chan GameEvent)}
+	client.log = client.log.With("replay", replay.GameID)
+	client.log.Info("replay started", "events", len(replay.Events))
+	go s.run()
+	return s
+}
+
+// handle passes a control event from the read loop to the session.
+func (s *replaySession) handle(event GameEvent) {
+	metrics.Inc(metricEventsReceived, eventLabel(event.Event))
+	if !replayEvents[event.Event] {
+		SendError(s.client, s.replay.GameID, event.RequestID, "REPLAY_ONLY", "a replay connection only takes PLAY, PAUSE, SEEK and SPEED")
+		return
+	}
+	select {
+	case s.control <- event:
+	case <-s.client.done:
+	}
+}
+
+func (s *replaySession) run() {
+	events := s.replay.Events
+	pos, playing, speed := 0, false, 1.0
+	// next is when events[pos] is due while playing.
+	var next time.Time
+	gap := func() time.Duration {
+		if pos == 0 {
+			return 0
+		}
+		return min(events[pos].At.Sub(events[pos-1].At), maxReplayGap)
+	}
+	schedule := func() { next = time.Now().Add(time.Duration(float64(gap()) / speed)) }
+
+	s.status("", pos, playing, speed, true)
+	for {
+		var due <-chan time.Time
+		var timer *time.Timer
+		if playing {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+		select {
+		case <-s.client.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+
+		case <-due:
+			if !s.send(events[pos]) {
+				return
+			}
+			pos++
+			if pos == len(events) {
+				playing = false
+				s.status("", pos, playing, speed, false)
+			} else {
+				schedule()
+			}
+
+		case event := <-s.control:
+			if timer != nil {
+				timer.Stop()
+			}
+			withState := false
+			switch event.Event {
+			case "PLAY":
+				if pos == len(events) {
+					// Playing a finished replay starts it over.
+					pos, withState = 0, true
+					s.seek(pos)
+				}
+				playing = true
+				schedule()
+			case "PAUSE":
+				playing = false
+			case "SEEK":
+				var payload ReplaySeekPayload
+				if err := decodePayload(event, &payload); err != nil {
+					SendError(s.client, s.replay.GameID, event.RequestID, "INVALID_PAYLOAD", "SEEK needs a seq")
+					continue
+				}
+				pos = 0
+				for pos < len(events) && events[pos].Seq <= payload.Seq {
+					pos++
+				}
+				s.seek(pos)
+				withState = true
+				if playing {
+					schedule()
+				}
+			case "SPEED":
+				var payload ReplaySpeedPayload
+				if err := decodePayload(event, &payload); err != nil || payload.Speed < minReplaySpeed || payload.Speed > maxReplaySpeed {
+					SendError(s.client, s.replay.GameID, event.RequestID, "INVALID_PAYLOAD", "speed must be between 0.25 and 16")
+					continue
+				}
+				// Keep the time left to the next event in proportion.
+				left := time.Until(next)
+				next = time.Now().Add(time.Duration(float64(left) * speed / payload.Speed))
+				speed = payload.Speed
+			}
+			s.status(event.RequestID, pos, playing, speed, withState)
+		}
+	}
+}
+
+// seek replays the game up to events[pos] so the session's state is the
+// game there.
+func (s *replaySession) seek(pos int) {
+	s.state = newLogReplay(s.replay.rules)
+	for _, entry := range s.replay.Events[:pos] {
+		if _, _, err := s.state.apply(entry); err != nil {
+			s.client.log.Error("rebuilding replay state", "seq", entry.Seq, "err", err)
+			return
+		}
+	}
+}
+
+// send streams a recorded broadcast as it was sent in the game, moving the
+// session's state past it. A resolution gets back the change it made to
+// the state, which its log entry keeps apart.
+func (s *replaySession) send(entry LogEntry) bool {
+	diff, removed, err := s.state.apply(entry)
+	if err != nil {
+		s.client.log.Error("replaying event", "seq", entry.Seq, "err", err)
+	}
+	var payload interface{}
+	if len(entry.Payload) > 0 {
+		payload = entry.Payload
+	}
+	if entry.Event == "RESOLUTION" {
+		var r ResolutionPayload
+		if err := json.Unmarshal(entry.Payload, &r); err == nil {
+			r.Diff, r.Removed = diff, removed
+			payload = r
+		}
+	}
+	seq := entry.Seq
+	if entry.Transient {
+		seq = 0
+	}
+	data, err := json.Marshal(GameEvent{Event: entry.Event, GameID: s.replay.GameID, Seq: seq, Payload: payload})
+	if err != nil {
+		s.client.log.Error("encoding replayed event", "seq", entry.Seq, "err", err)
+		return true
+	}
+	return s.client.Send(newOutboundMessage(0, data))
+}
+
+// status sends REPLAY_STATUS for position pos, with the state of the game
+// there if withState is set.
+func (s *replaySession) status(requestID string, pos int, playing bool, speed float64, withState bool) {
+	events := s.replay.Events
+	payload := ReplayStatusPayload{
+		LastSeq: events[len(events)-1].Seq,
+		Playing: playing,
+		Speed:   speed,
+		Ended:   pos == len(events),
+	}
+	if pos > 0 {
+		payload.Seq = events[pos-1].Seq
+	}
+	if withState && pos > 0 {
+		state, err := s.state.state()
+		if err != nil {
+			s.client.log.Error("rebuilding replay state", "seq", payload.Seq, "err", err)
+		} else {
+			payload.State = &state
+		}
+	}
+	data, err := json.Marshal(GameEvent{Event: "REPLAY_STATUS", GameID: s.replay.GameID, RequestID: requestID, Payload: payload})
+	if err != nil {
+		s.client.log.Error("encoding replay status", "err", err)
+		return
+	}
+	s.client.Send(newOutboundMessage(0, data))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestReplayRoundTrip plays a game between ann and two bots, watched by
+// sam, exports its replay and plays it back, and checks the replay streams
+// every broadcast sam saw, as sam saw it.
+func TestReplayRoundTrip(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(map[string]interface{}{"houseRules": map[string]int{"startingBalance": 300}, "diceSeed": 7})
+	ann := ts.join(code, "ann", nil)
+	sam := ts.join(code, "sam", url.Values{"role": {"spectator"}})
+	for i := 0; i < 2; i++ {
+		ann.send("ADD_BOT", nil)
+		ann.expect("PLAYER_JOINED")
+	}
+	ann.send("START_GAME", nil)
+	ann.expect("GAME_STARTED")
+	if _, _, err := ann.playOut(30 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	room := ts.room(code)
+	var last uint64
+	room.do(func() { last = room.seq })
+	watched := make(map[uint64]receivedEvent)
+	for timeout := time.After(5 * time.Second); watched[last].Seq != last; {
+		select {
+		case e := <-sam.events:
+			// The STATE sam was welcomed with is a snapshot, not a broadcast.
+			if e.Seq > 0 && e.Event != "STATE" {
+				watched[e.Seq] = e
+			}
+		case <-timeout:
+			t.Fatalf("sam saw %d broadcasts, not up to %d", len(watched), last)
+		}
+	}
+
+	resp, err := http.Get(ts.srv.URL + "/api/games/" + code + "/replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var replay Replay
+	if err := json.NewDecoder(resp.Body).Decode(&replay); err != nil {
+		t.Fatal(err)
+	}
+	if replay.Version != replayVersion || replay.GameID != code || replay.DiceSeed != 7 || replay.HouseRules.StartingBalance != 300 {
+		t.Errorf("replay version %d of %s with seed %d and house rules %+v", replay.Version, replay.GameID, replay.DiceSeed, replay.HouseRules)
+	}
+	if replay.InitialState.Status != StatusInProgress || len(replay.InitialState.Players) != 3 {
+		t.Errorf("replay starts %s with %d players", replay.InitialState.Status, len(replay.InitialState.Players))
+	}
+	if n := len(replay.Events); n == 0 || replay.Events[n-1].Seq != last {
+		t.Fatalf("replay has %d events, the room made %d", n, last)
+	}
+
+	viewer := ts.dial("", "", url.Values{"replay": {code}})
+	viewer.expect("REPLAY_STATUS")
+	viewer.send("SPEED", ReplaySpeedPayload{Speed: maxReplaySpeed})
+	viewer.expect("REPLAY_STATUS")
+	viewer.send("PLAY", nil)
+	viewer.expect("REPLAY_STATUS")
+	played := make(map[uint64]receivedEvent)
+	for timeout := time.After(10 * time.Second); ; {
+		var e receivedEvent
+		select {
+		case e = <-viewer.events:
+		case <-timeout:
+			t.Fatalf("replayed %d of %d broadcasts", len(played), last)
+		}
+		if e.Event == "REPLAY_STATUS" {
+			var status ReplayStatusPayload
+			if e.decode(t, &status); status.Ended {
+				break
+			}
+		}
+		if e.Seq > 0 {
+			played[e.Seq] = e
+		}
+	}
+	for seq, want := range watched {
+		got, ok := played[seq]
+		if !ok {
+			t.Errorf("broadcast %d, %s, wasn't replayed", seq, want.Event)
+			continue
+		}
+		var gotPayload, wantPayload interface{}
+		got.decode(t, &gotPayload)
+		want.decode(t, &wantPayload)
+		if got.Event != want.Event || !reflect.DeepEqual(gotPayload, wantPayload) {
+			t.Errorf("broadcast %d replayed as %s %s, sent as %s %s", seq, got.Event, got.Payload, want.Event, want.Payload)
+		}
+	}
+
+	// Seeking back shows the game as it stood there.
+	middle := replay.Events[len(replay.Events)/2].Seq
+	viewer.send("SEEK", ReplaySeekPayload{Seq: middle})
+	var status ReplayStatusPayload
+	viewer.expect("REPLAY_STATUS").decode(t, &status)
+	if status.Seq != middle || status.Ended || status.State == nil {
+		t.Errorf("after seeking to %d: at %d, ended %v, state %v", middle, status.Seq, status.Ended, status.State != nil)
+	}
+}
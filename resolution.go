@@ -0,0 +1,91 @@
+package main
+
+import "encoding/json"
+
+// One action can set off a chain of broadcasts: a roll moves the player,
+// logs it and offers them the square, and ending a turn announces the next
+// player and starts their timer and clock. Rather than broadcast each link
+// on its own, where a client that missed one would be left with the wrong
+// board, act collects them into a single RESOLUTION broadcast with one
+// sequence number. Its steps are the broadcasts the action would otherwise
+// have made, in order, for clients to animate one by one, and its diff is
+// the change the whole chain made to the game state, for them to apply at
+// once. A decision the player has to make, such as whether to buy, ends
+// the chain: their answer is another action and another resolution.
+
+// ResolutionStep is one broadcast of a resolution, as it would have been
+// sent alone.
+type ResolutionStep struct {
+	Event   string      `json:"event"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// ResolutionPayload is what an action did: its steps in order, and the
+// change they made to the game state as JSON-pointer paths and values,
+// along with the paths they removed, as in the event log.
+type ResolutionPayload struct {
+	Steps   []ResolutionStep           `json:"steps"`
+	Diff    map[string]json.RawMessage `json:"diff,omitempty"`
+	Removed []string                   `json:"removed,omitempty"`
+}
+
+func (p ResolutionPayload) localize(room *GameRoom, locale string) interface{} {
+	steps := make([]ResolutionStep, len(p.Steps))
+	for i, step := range p.Steps {
+		if payload, ok := step.Payload.(localizable); ok {
+			step.Payload = payload.localize(room, locale)
+		}
+		steps[i] = step
+	}
+	p.Steps = steps
+	return p
+}
+
+// resolution collects the broadcasts of the action being applied.
+// sendActions is set when the current player's actions are to be sent
+// once it has been broadcast.
+type resolution struct {
+	steps       []ResolutionStep
+	sendActions bool
+}
+
+// beginResolution starts collecting broadcasts into a resolution. It
+// returns false if one is already being collected, in which case the
+// caller's broadcasts join it and it is not theirs to end. It must run on
+// the room's goroutine.
+func (room *GameRoom) beginResolution() bool {
+	if room.resolution != nil {
+		return false
+	}
+	room.resolution = &resolution{}
+	return true
+}
+
+// endResolution broadcasts the resolution collected since beginResolution
+// as RESOLUTION, then sends the current player their actions if that was
+// held back. It must run on the room's goroutine.
+func (room *GameRoom) endResolution() {
+	r := room.resolution
+	room.resolution = nil
+	if r == nil || len(r.steps) == 0 {
+		return
+	}
+	diff, removed, _ := room.stateDiff(room.logState)
+	SendGameEventToAll(room, "RESOLUTION", room.ID, ResolutionPayload{Steps: r.steps, Diff: diff, Removed: removed})
+	// Webhooks are told about the steps they are interested in on their
+	// own, numbered as the resolution that carried them.
+	for _, step := range r.steps {
+		if !webhookEvents[step.Event] {
+			continue
+		}
+		data, err := json.Marshal(GameEvent{Event: step.Event, GameID: room.ID, Seq: room.seq, ActorRequestID: room.actorRequestID, Payload: step.Payload})
+		if err != nil {
+			room.logger().Error("encoding broadcast", "event", step.Event, "seq", room.seq, "err", err)
+			continue
+		}
+		webhooks.notify(room, step.Event, data)
+	}
+	if r.sendActions {
+		room.sendAvailableActions()
+	}
+}
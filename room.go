@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+const (
+	defaultMinPlayers  = 2
+	maxStartingBalance = 100000
+)
+
+var (
+	ErrHubFull    = errors.New("room limit reached")
+	ErrRoomExists = errors.New("room already exists")
+
+	errDiceSeed   = errors.New("diceSeed is only accepted on servers started with -allow-dice-seed")
+	errBoardTwice = errors.New("give either boardId or board, not both")
+)
+
+type HouseRules struct {
+	StartingBalance int `json:"startingBalance"`
+}
+
+type RoomOptions struct {
+	MinPlayers int        `json:"minPlayers"`
+	MaxPlayers int        `json:"maxPlayers"`
+	HouseRules HouseRules `json:"houseRules"`
+	Private    bool       `json:"private"`
+	// Password, if set, makes the room private and lets players join with
+	// ?password= as well as with the invite token.
+	Password string `json:"password,omitempty"`
+
+	// DisconnectTurns is DisconnectSkip or DisconnectWait; see
+	// disconnect.go. BotTakeover hands a player who doesn't reconnect in
+	// time to a bot instead of forfeiting them. AutoPause pauses the game
+	// when a player drops on their own turn, and resumes it when they
+	// come back.
+	DisconnectTurns string `json:"disconnectTurns"`
+	BotTakeover     bool   `json:"botTakeover"`
+	AutoPause       bool   `json:"autoPause"`
+
+	// VoteKickMajority is the share of voters that must be exceeded for a
+	// vote-kick to pass.
+	VoteKickMajority float64 `json:"voteKickMajority"`
+
+	// TurnSeconds overrides -turn-timeout for the room.
+	TurnSeconds int `json:"turnSeconds"`
+
+	// ClockSeconds gives every player a time bank, like a chess clock,
+	// that runs down on their turns; 0 plays without one. ClockExpiry
+	// is ClockAuto or ClockForfeit; see clock.go.
+	ClockSeconds int    `json:"clockSeconds,omitempty"`
+	ClockExpiry  string `json:"clockExpiry,omitempty"`
+
+	// UndoApproval is who must agree to undo a move: UndoAll, every other
+	// active player, or UndoHost; see undo.go.
+	UndoApproval string `json:"undoApproval,omitempty"`
+
+	// DiceSeed fixes the seed of the room's dice, so a demo game rolls the
+	// same every time. It is only accepted with -allow-dice-seed.
+	DiceSeed *int64 `json:"diceSeed,omitempty"`
+
+	// BoardID picks a board from -boards-dir, and Board is a board
+	// definition given with the room; without either the game is played
+	// on the standard board. Validate reads a BoardID's definition into
+	// Board, so the room keeps the board it was created with whatever
+	// happens to the file. board is the definition decoded.
+	BoardID string          `json:"boardId,omitempty"`
+	Board   json.RawMessage `json:"board,omitempty"`
+	board   *game.Board
+}
+
+// Validate fills in defaults for zero values and rejects options the
+// game can't be played with.
+func (o *RoomOptions) Validate() error {
+	if o.MaxPlayers == 0 {
+		o.MaxPlayers = hub.config.MaxPlayers
+	}
+	if o.MaxPlayers < 2 || o.MaxPlayers > hub.config.MaxPlayers {
+		return fmt.Errorf("maxPlayers must be between 2 and %d", hub.config.MaxPlayers)
+	}
+	if o.MinPlayers == 0 {
+		o.MinPlayers = defaultMinPlayers
+	}
+	if o.MinPlayers < 2 || o.MinPlayers > o.MaxPlayers {
+		return errors.New("minPlayers must be between 2 and maxPlayers")
+	}
+	if o.HouseRules.StartingBalance == 0 {
+		o.HouseRules.StartingBalance = hub.config.StartingBalance
+	}
+	if o.HouseRules.StartingBalance < 1 || o.HouseRules.StartingBalance > maxStartingBalance {
+		return fmt.Errorf("startingBalance must be between 1 and %d, or 0 for the server's default", maxStartingBalance)
+	}
+	if len(o.Password) > maxPasswordLength {
+		return errPasswordTooLong
+	}
+	if o.Password != "" {
+		o.Private = true
+	}
+	if o.DisconnectTurns == "" {
+		o.DisconnectTurns = DisconnectSkip
+	}
+	if o.DisconnectTurns != DisconnectSkip && o.DisconnectTurns != DisconnectWait {
+		return errDisconnectTurns
+	}
+	if o.VoteKickMajority == 0 {
+		o.VoteKickMajority = defaultVoteKickMajority
+	}
+	if o.VoteKickMajority < 0 || o.VoteKickMajority >= 1 {
+		return errVoteKickMajority
+	}
+	if o.TurnSeconds != 0 && (o.TurnSeconds < minTurnSeconds || o.TurnSeconds > maxTurnSeconds) {
+		return errTurnSeconds
+	}
+	if o.ClockSeconds != 0 && (o.ClockSeconds < minClockSeconds || o.ClockSeconds > maxClockSeconds) {
+		return errClockSeconds
+	}
+	if o.ClockSeconds != 0 && o.ClockExpiry == "" {
+		o.ClockExpiry = ClockAuto
+	}
+	if o.ClockExpiry != "" && o.ClockExpiry != ClockAuto && o.ClockExpiry != ClockForfeit {
+		return errClockExpiry
+	}
+	if o.UndoApproval == "" {
+		o.UndoApproval = UndoAll
+	}
+	if o.UndoApproval != UndoAll && o.UndoApproval != UndoHost {
+		return errUndoApproval
+	}
+	if o.DiceSeed != nil && !hub.config.AllowDiceSeed {
+		return errDiceSeed
+	}
+	if o.board == nil && (o.BoardID != "" || len(o.Board) > 0) {
+		if o.BoardID != "" && len(o.Board) > 0 {
+			return errBoardTwice
+		}
+		if o.BoardID != "" {
+			data, err := readBoard(o.BoardID)
+			if err != nil {
+				return err
+			}
+			o.Board = data
+		}
+		board, err := game.ParseBoard(o.Board, hub.config.BoardSize)
+		if err != nil {
+			return err
+		}
+		o.board = board
+	}
+	return nil
+}
+
+// gameBoard returns the board the options have the game played on. A
+// restored room's board was checked when the room was created, and isn't
+// checked again in case the rules have changed since; if it can't even be
+// decoded, the game is played on the standard board.
+func (o *RoomOptions) gameBoard() (*game.Board, error) {
+	if o.board != nil {
+		return o.board, nil
+	}
+	if len(o.Board) == 0 {
+		return game.Standard, nil
+	}
+	var board game.Board
+	if err := json.Unmarshal(o.Board, &board); err != nil {
+		return game.Standard, err
+	}
+	return &board, nil
+}
+
+func defaultRoomOptions() RoomOptions {
+	return RoomOptions{
+		MinPlayers:       defaultMinPlayers,
+		MaxPlayers:       hub.config.MaxPlayers,
+		HouseRules:       HouseRules{StartingBalance: hub.config.StartingBalance},
+		DisconnectTurns:  DisconnectSkip,
+		VoteKickMajority: defaultVoteKickMajority,
+		UndoApproval:     UndoAll,
+	}
+}
+
+// newGameRoom builds an empty room. A private room gets an invite token,
+// and the password is moved out of Options so it can't leak into anything
+// that serializes them.
+func newGameRoom(id string, opts RoomOptions) *GameRoom {
+	now := time.Now()
+	password := opts.Password
+	opts.Password = ""
+	room := &GameRoom{
+		ID:              id,
+		Options:         opts,
+		CreatedAt:       now,
+		lastActivity:    now,
+		done:            make(chan struct{}),
+		commands:        make(chan func()),
+		Players:         make(map[*Client]string),
+		Spectators:      make(map[*Client]string),
+		eliminated:      make(map[*Client]bool),
+		subscribers:     make(map[Subscriber]struct{}),
+		chatTimes:       make(map[string][]time.Time),
+		sessions:        make(map[string]string),
+		graceTimers:     make(map[string]*roomTimer),
+		mortgageTimers:  make(map[string]*roomTimer),
+		voteCooldowns:   make(map[string]time.Time),
+		rejoinApprovals: make(map[string]time.Time),
+		rejoinRequests:  make(map[string]time.Time),
+		playerIDs:       make(map[string]string),
+		eventIDs:        make(map[string]*eventOutcomes),
+		bots:            make(map[string]Strategy),
+		preferences:     make(map[string]Preferences),
+		GameState: GameState{
+			Status:  StatusWaiting,
+			Players: make(map[string]*Player),
+		},
+		password: password,
+	}
+	if opts.Private {
+		room.inviteToken = newSessionToken()
+	}
+	room.GameState.DiceSeed = game.NewSeed()
+	if opts.DiceSeed != nil {
+		room.GameState.DiceSeed = *opts.DiceSeed
+	}
+	room.dice = game.NewSeededRoller(room.GameState.DiceSeed, 0)
+	board, err := room.Options.gameBoard()
+	if err != nil {
+		room.logger().Error("decoding board", "err", err)
+	}
+	room.Options.board, room.board = board, board
+	room.publishListing()
+	// Nobody is in the room yet; drop it if nobody turns up. This is the
+	// last chance to touch the room before its goroutine owns it.
+	room.scheduleEmptyCheck()
+	go room.run()
+	return room
+}
+
+// run is the room's goroutine. It carries out commands one at a time until
+// the room is closed, which is what lets them use the room without locks.
+func (room *GameRoom) run() {
+	for !room.closed {
+		(<-room.commands)()
+	}
+}
+
+// do runs fn on the room's goroutine and waits for it to finish. It
+// reports false, without running fn, if the room has already closed. It
+// must not be called from the room's goroutine, or with hub.Mutex held:
+// closing a room takes the hub lock.
+func (room *GameRoom) do(fn func()) bool {
+	finished := make(chan struct{})
+	select {
+	case room.commands <- func() {
+		defer close(finished)
+		fn()
+	}:
+	case <-room.done:
+		return false
+	}
+	<-finished
+	return true
+}
+
+// abandon stops a room that never made it into the hub, such as a saved
+// game brought back twice at once. Unlike closeRoom it tells nobody and
+// leaves the store alone.
+func (room *GameRoom) abandon() {
+	room.do(func() {
+		room.closed = true
+		room.cancelEmptyCheck()
+		room.cancelAbandon()
+		room.cancelAllGrace()
+		room.stopTurnTimer()
+		close(room.done)
+	})
+}
+
+// CreateRoom registers a new room under id. The caller must hold h.Mutex.
+func (h *GameHub) CreateRoom(id string, opts RoomOptions) (*GameRoom, error) {
+	if _, exists := h.Rooms[id]; exists {
+		return nil, ErrRoomExists
+	}
+	if len(h.Rooms) >= h.config.MaxRooms {
+		return nil, ErrHubFull
+	}
+	room := newGameRoom(id, opts)
+	h.Rooms[id] = room
+	return room, nil
+}
+
+// Room codes are short enough to read out loud and use only letters that
+// can't be mistaken for each other or for digits.
+const (
+	roomCodeLength   = 6
+	roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+func generateRoomCode() string {
+	// Bytes past the last whole multiple of the alphabet are thrown away
+	// so every letter is equally likely.
+	limit := 256 - 256%len(roomCodeAlphabet)
+	code := make([]byte, 0, roomCodeLength)
+	b := make([]byte, roomCodeLength)
+	for len(code) < roomCodeLength {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		for _, c := range b {
+			if int(c) < limit && len(code) < roomCodeLength {
+				code = append(code, roomCodeAlphabet[int(c)%len(roomCodeAlphabet)])
+			}
+		}
+	}
+	return string(code)
+}
+
+// lookup finds a room by the gameId a client supplied. Generated codes
+// match case-insensitively; ids chosen by clients under -implicit-rooms
+// match exactly. The caller must hold h.Mutex.
+func (h *GameHub) lookup(id string) (*GameRoom, bool) {
+	if room, ok := h.Rooms[id]; ok {
+		return room, true
+	}
+	room, ok := h.Rooms[strings.ToUpper(id)]
+	return room, ok
+}
+
+// isFull reports whether the room has no free seats. It must run on the
+// room's goroutine.
+func (room *GameRoom) isFull() bool {
+	return len(room.GameState.Players) >= room.Options.MaxPlayers
+}
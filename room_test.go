@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestScriptedGame plays a game between three players to the end, each on
+// a goroutine of their own, and checks they all saw the same game: the
+// same winner, and every broadcast once, in order, up to the room's last.
+func TestScriptedGame(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 3, "houseRules": map[string]int{"startingBalance": 300}, "diceSeed": 11})
+	clients := ts.startGame(code, "ann", "bob", "cat")
+
+	winners := make([]string, len(clients))
+	seqs := make([][]uint64, len(clients))
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			winners[i], seqs[i], errs[i] = c.playOut(30 * time.Second)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	room := ts.room(code)
+	var status string
+	var last uint64
+	places := make(map[string]int)
+	room.do(func() {
+		status, last = room.GameState.Status, room.seq
+		for name, p := range room.GameState.Players {
+			places[name] = p.Place
+		}
+	})
+	if status != StatusFinished || winners[0] == "" || places[winners[0]] != 1 {
+		t.Fatalf("game %s, won by %q, places %v", status, winners[0], places)
+	}
+	for i, c := range clients {
+		if winners[i] != winners[0] {
+			t.Errorf("%s saw %s win, not %s", c.name, winners[i], winners[0])
+		}
+		for j := 1; j < len(seqs[i]); j++ {
+			if seqs[i][j] != seqs[i][j-1]+1 {
+				t.Errorf("%s missed broadcasts between %d and %d", c.name, seqs[i][j-1], seqs[i][j])
+				break
+			}
+		}
+		if n := len(seqs[i]); n == 0 || seqs[i][n-1] != last {
+			t.Errorf("%s saw up to broadcast %v, the room made %d", c.name, seqs[i], last)
+		}
+	}
+}
+
+// TestConcurrentJoins has players join one room, chat and leave all at
+// once while the lobby is listed, and checks the room ends up with exactly
+// the players who stayed.
+func TestConcurrentJoins(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(map[string]interface{}{"maxPlayers": 8})
+	room := ts.room(code)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := ts.dial(code, fmt.Sprintf("player%d", i), nil)
+			c.conn.WriteJSON(map[string]interface{}{"event": "CHAT_MESSAGE", "payload": map[string]string{"text": "hi"}})
+			if i%2 == 1 {
+				c.conn.WriteJSON(map[string]interface{}{"event": "LEAVE_ROOM"})
+			}
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handleListRooms(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/rooms", nil))
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var players, connected int
+		room.do(func() {
+			players, connected = len(room.GameState.Players), len(room.Players)
+		})
+		if players == 4 && connected == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("room has %d players, %d connected; want 4", players, connected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestJoinLeaveUnderLoad has players and spectators join ann's room and
+// leave it, some politely and some by dropping their connection, while ann
+// chats without pause and /metrics is scraped, and checks only ann is left.
+// It is meant to be run with -race.
+func TestJoinLeaveUnderLoad(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(map[string]interface{}{"maxPlayers": 8})
+	ann := ts.join(code, "ann", nil)
+	room := ts.room(code)
+
+	done := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			ann.send("CHAT_MESSAGE", map[string]string{"text": fmt.Sprint("message ", i)})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if resp, err := http.Get(ts.srv.URL + "/metrics"); err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}
+	}()
+	go func() {
+		for range ann.events {
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var query url.Values
+			if i%3 == 0 {
+				query = url.Values{"role": {"spectator"}}
+			}
+			c := ts.dial(code, fmt.Sprint("guest", i), query)
+			c.conn.WriteJSON(map[string]interface{}{"event": "CHAT_MESSAGE", "payload": map[string]string{"text": "hi"}})
+			time.Sleep(time.Duration(i%5) * time.Millisecond)
+			if i%2 == 0 {
+				c.conn.WriteJSON(map[string]interface{}{"event": "LEAVE_ROOM"})
+			} else {
+				c.conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var players []string
+		var connected, spectators int
+		room.do(func() {
+			for name := range room.GameState.Players {
+				players = append(players, name)
+			}
+			connected, spectators = len(room.Players), len(room.Spectators)
+		})
+		if len(players) == 1 && players[0] == "ann" && connected == 1 && spectators == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("room has players %v, %d connected and %d spectators; want only ann", players, connected, spectators)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	background.Wait()
+
+	resp, err := http.Get(ts.srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{"monopoly_players_connected 1\n", "monopoly_spectators_connected 0\n"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics doesn't have %q:\n%s", strings.TrimSpace(want), body)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+// RosterEntry describes one seated player in roster broadcasts. LatencyMs
+// is their connection's latency rounded up to a bucket, if it is known.
+type RosterEntry struct {
+	Name      string `json:"name"`
+	Token     string `json:"token,omitempty"`
+	Seat      int    `json:"seat"`
+	Connected bool   `json:"connected"`
+	LatencyMs int    `json:"latencyMs,omitempty"`
+}
+
+// RosterPayload goes with every change to who is in the room, so clients
+// can replace their roster rather than patch it.
+type RosterPayload struct {
+	Player      string        `json:"player"`
+	Roster      []RosterEntry `json:"roster"`
+	PlayerCount int           `json:"playerCount"`
+}
+
+type PlayerJoinedPayload struct {
+	RosterPayload
+	Token string `json:"token,omitempty"`
+	Seat  int    `json:"seat"`
+}
+
+// roster lists the seated players in seat order. It must run on the
+// room's goroutine.
+func (room *GameRoom) roster() []RosterEntry {
+	entries := make([]RosterEntry, 0, len(room.GameState.TurnOrder))
+	for i, name := range room.GameState.TurnOrder {
+		p := room.GameState.Players[name]
+		entries = append(entries, RosterEntry{Name: name, Token: p.Token, Seat: i, Connected: p.Connected, LatencyMs: room.playerLatency(name)})
+	}
+	return entries
+}
+
+// rosterPayload builds the roster part of a join, leave or reconnect
+// broadcast about name. It must run on the room's goroutine.
+func (room *GameRoom) rosterPayload(name string) RosterPayload {
+	roster := room.roster()
+	return RosterPayload{Player: name, Roster: roster, PlayerCount: len(roster)}
+}
+
+// seatOf returns name's index in the turn order, or -1.
+func (room *GameRoom) seatOf(name string) int {
+	for i, n := range room.GameState.TurnOrder {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
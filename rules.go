@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// The rules themselves live in package game. The handlers here decode a
+// client's event into a game.Action, and act applies it and turns what it
+// did into broadcasts.
+
+type DiceRolledPayload struct {
+	Player   string `json:"player"`
+	DiceRoll int    `json:"diceRoll"`
+	// Dice are how each die came up.
+	Dice []int `json:"dice,omitempty"`
+	// Auto is set when the server rolled for a player whose turn ran out.
+	Auto bool `json:"auto,omitempty"`
+}
+
+type RentPaidPayload struct {
+	Player   string `json:"player"`
+	Owner    string `json:"owner"`
+	Property string `json:"property"`
+	Amount   int    `json:"amount"`
+}
+
+// TaxPaidPayload is what a player paid in tax for landing on a tax
+// square.
+type TaxPaidPayload struct {
+	Player string `json:"player"`
+	Square string `json:"square"`
+	Amount int    `json:"amount"`
+}
+
+// StayedInJailPayload names a player who didn't roll doubles in jail and
+// how many more turns they may stay there.
+type StayedInJailPayload struct {
+	Player    string `json:"player"`
+	TurnsLeft int    `json:"turnsLeft"`
+}
+
+// BankruptPayload names a player who couldn't pay what they owed, who
+// they owed it to (empty for the bank), the deeds that changed hands and
+// the place they finished in.
+type BankruptPayload struct {
+	Player     string   `json:"player"`
+	Creditor   string   `json:"creditor,omitempty"`
+	Properties []string `json:"properties"`
+	Place      int      `json:"place"`
+}
+
+type PropertyBoughtPayload struct {
+	Player   string `json:"player"`
+	Property string `json:"property"`
+}
+
+type PurchaseDeclinedPayload struct {
+	Player   string `json:"player"`
+	Property string `json:"property"`
+}
+
+// AvailableActionsPayload tells the player whose turn it is what they may
+// do now, and which phase of the turn they are in.
+type AvailableActionsPayload struct {
+	Player  string           `json:"player"`
+	Phase   string           `json:"phase"`
+	Actions []game.Available `json:"actions"`
+}
+
+// turnActions are the events that act on the current turn. Each is only
+// accepted in the phases game.AllowedIn lists it for.
+var turnActions = map[string]bool{
+	game.ActionRollDice:        true,
+	game.ActionPayBail:         true,
+	game.ActionBuyProperty:     true,
+	game.ActionDeclinePurchase: true,
+	game.ActionUnmortgage:      true,
+	game.ActionEndTurn:         true,
+}
+
+// ruleErrorCodes are the error codes clients see for actions the rules
+// refuse.
+var ruleErrorCodes = map[error]string{
+	game.ErrUnknownPlayer: "UNKNOWN_PLAYER",
+	game.ErrNotYourTurn:   "NOT_YOUR_TURN",
+	game.ErrNotAvailable:  "ACTION_NOT_AVAILABLE",
+}
+
+// phaseNames describe the phases of a turn in error messages.
+var phaseNames = map[string]string{
+	game.PhaseAwaitingRoll:     "waiting for the roll",
+	game.PhaseAwaitingPurchase: "waiting for a purchase decision",
+	game.PhaseAwaitingEnd:      "waiting to be ended",
+}
+
+// engine returns the rules engine for the room's game. It must run on the
+// room's goroutine.
+func (room *GameRoom) engine() *game.Engine {
+	return &game.Engine{Board: room.board, Dice: room.dice, SkipAbsent: room.Options.DisconnectTurns == DisconnectSkip}
+}
+
+// apply applies action to the room's game and records it for the event
+// log, along with the change it made, so that a replay of the log can
+// make it again. It must run on the room's goroutine.
+func (room *GameRoom) apply(action game.Action) ([]game.Effect, error) {
+	rolls, before := room.GameState.DiceRolls, room.flatState()
+	effects, err := room.engine().Apply(&room.GameState, action)
+	if err != nil {
+		return nil, err
+	}
+	diff, removed := diffFlat(before, room.flatState())
+	if room.logEngine == nil {
+		room.logEngine = make(map[string]json.RawMessage)
+	}
+	for path, value := range diff {
+		room.logEngine[path] = value
+	}
+	for _, path := range removed {
+		room.logEngine[path] = nil
+	}
+	room.logActions = append(room.logActions, loggedAction(action, rolls))
+	return effects, nil
+}
+
+// act applies action to the room's game, announces what it did as one
+// resolution and tells the current player what they may do next. The
+// state from before it is kept in case its player asks to undo it. It
+// must run on the room's goroutine.
+func (room *GameRoom) act(action game.Action) error {
+	player, before := room.GameState.Turn, game.TakeSnapshot(&room.GameState)
+	actions := append([]ActionLogEntry(nil), room.actionLog...)
+	effects, err := room.apply(action)
+	if err != nil {
+		return err
+	}
+	if room.beginResolution() {
+		defer func() {
+			room.endResolution()
+			room.recordUndoPoint(player, action, before, actions, effects)
+		}()
+	}
+	if room.announce(effects, false) {
+		// turnPassed sent the next player their actions.
+		return nil
+	}
+	if room.GameState.Status == StatusInProgress {
+		room.sendAvailableActions()
+	}
+	return nil
+}
+
+// announce broadcasts and logs each of effects, which the rules engine
+// returned for the current player's action, auto if the server took it
+// for them. It reports whether the turn passed, in which case the next
+// player has been sent their actions. It must run on the room's
+// goroutine.
+func (room *GameRoom) announce(effects []game.Effect, auto bool) bool {
+	passed, interest := false, 0
+	for _, effect := range effects {
+		switch e := effect.(type) {
+		case game.DiceRolled:
+			SendGameEventToAll(room, "ROLL_DICE", room.ID, DiceRolledPayload{Player: e.Player, DiceRoll: e.Roll, Dice: e.Dice[:], Auto: auto})
+			if auto {
+				room.logAction("autoRoll", room.rollParams(e))
+			} else {
+				room.logAction("roll", room.rollParams(e))
+			}
+		case game.RentPaid:
+			SendGameEventToAll(room, "RENT_PAID", room.ID, RentPaidPayload{Player: e.Player, Owner: e.Owner, Property: e.Property, Amount: e.Amount})
+			room.logAction("rent", map[string]interface{}{"player": e.Player, "owner": e.Owner, "property": e.Property, "amount": e.Amount})
+		case game.TaxPaid:
+			SendGameEventToAll(room, "TAX_PAID", room.ID, TaxPaidPayload{Player: e.Player, Square: e.Square, Amount: e.Amount})
+			room.logAction("tax", map[string]interface{}{"player": e.Player, "square": e.Square, "amount": e.Amount})
+		case game.SentToJail:
+			SendGameEventToAll(room, "SENT_TO_JAIL", room.ID, PlayerPayload{Player: e.Player})
+			room.logAction("jail", map[string]interface{}{"player": e.Player})
+		case game.LeftJail:
+			SendGameEventToAll(room, "LEFT_JAIL", room.ID, PlayerPayload{Player: e.Player})
+			room.logAction("jailLeave", map[string]interface{}{"player": e.Player})
+		case game.StayedInJail:
+			SendGameEventToAll(room, "STAYED_IN_JAIL", room.ID, StayedInJailPayload{Player: e.Player, TurnsLeft: e.TurnsLeft})
+			room.logAction("jailStay", map[string]interface{}{"player": e.Player, "turns": e.TurnsLeft})
+		case game.Mortgaged:
+			SendGameEventToAll(room, "PROPERTY_MORTGAGED", room.ID, MortgagedPayload{Player: e.Player, Properties: e.Properties, Raised: e.Raised})
+			room.logAction("mortgage", map[string]interface{}{"player": e.Player, "count": len(e.Properties), "amount": e.Raised})
+		case game.Bankrupted:
+			room.wentBankrupt(e)
+		case game.MortgagesTransferred:
+			interest = e.Interest
+			room.logAction("mortgageInterest", map[string]interface{}{"player": e.Player, "count": len(e.Properties), "amount": e.Interest})
+		case game.MortgageChoiceOwed:
+			room.mortgageChoiceOwed(e, interest)
+		case game.BailPaid:
+			SendGameEventToAll(room, "PAY_BAIL", room.ID, PlayerPayload{Player: e.Player})
+			room.logAction("bail", map[string]interface{}{"player": e.Player, "amount": e.Cost})
+		case game.PropertyBought:
+			SendGameEventToAll(room, "BUY_PROPERTY", room.ID, PropertyBoughtPayload{Player: e.Player, Property: e.Property})
+			room.logAction("buy", map[string]interface{}{"player": e.Player, "property": e.Property, "price": e.Price})
+		case game.PurchaseDeclined:
+			SendGameEventToAll(room, "DECLINE_PURCHASE", room.ID, PurchaseDeclinedPayload{Player: e.Player, Property: e.Property})
+			room.logAction("decline", map[string]interface{}{"player": e.Player, "property": e.Property})
+		case game.Unmortgaged:
+			SendGameEventToAll(room, "UNMORTGAGE", room.ID, UnmortgagedPayload{Player: e.Player, Property: e.Property, Cost: e.Cost})
+			room.logAction("unmortgage", map[string]interface{}{"player": e.Player, "property": e.Property, "amount": e.Cost})
+		case game.TurnPassed:
+			room.turnPassed(e.Next)
+			passed = true
+		}
+	}
+	return passed
+}
+
+// wentBankrupt announces that a player went bankrupt and takes them out of
+// the room's game, which ends if only one player is left. It must run on
+// the room's goroutine.
+func (room *GameRoom) wentBankrupt(e game.Bankrupted) {
+	room.logger().Info("player bankrupt", "player", e.Player, "creditor", e.Creditor)
+	SendGameEventToAll(room, "PLAYER_BANKRUPT", room.ID, BankruptPayload{Player: e.Player, Creditor: e.Creditor, Properties: e.Properties, Place: e.Place})
+	room.playerOut(e.Player, "bankrupt")
+	if e.Creditor != "" {
+		room.logAction("bankrupt", map[string]interface{}{"player": e.Player, "creditor": e.Creditor})
+	} else {
+		room.logAction("bankruptBank", map[string]interface{}{"player": e.Player})
+	}
+	room.checkLastPlayer()
+}
+
+// sendAvailableActions tells the player whose turn it is what they may do
+// now, if they are connected. During a resolution it waits for it to be
+// broadcast, so the message carries its number. It must run on the room's
+// goroutine.
+func (room *GameRoom) sendAvailableActions() {
+	if room.resolution != nil {
+		room.resolution.sendActions = true
+		return
+	}
+	name := room.GameState.Turn
+	client := clientFor(room, name)
+	if client == nil {
+		return
+	}
+	room.scheduleAutoAction()
+	actions := game.AvailableActions(room.board, &room.GameState, name)
+	if actions == nil {
+		actions = []game.Available{}
+	}
+	data, err := json.Marshal(GameEvent{Event: "AVAILABLE_ACTIONS", GameID: room.ID, Seq: room.seq, Payload: AvailableActionsPayload{Player: name, Phase: room.GameState.Phase, Actions: actions}})
+	if err != nil {
+		room.logger().Error("encoding available actions", "err", err)
+		return
+	}
+	client.Send(newOutboundMessage(0, data))
+}
+
+// rollParams are the action log parameters for a roll.
+func (room *GameRoom) rollParams(e game.DiceRolled) map[string]interface{} {
+	return map[string]interface{}{"player": e.Player, "roll": e.Roll, "square": room.board.SquareAt(e.Position).Name}
+}
+
+// actFor applies action on behalf of client, refusing event if the rules
+// don't allow it. It must run on the room's goroutine.
+func (room *GameRoom) actFor(client *Client, event GameEvent, action game.Action) {
+	err := room.act(action)
+	if err == nil {
+		return
+	}
+	code, ok := ruleErrorCodes[err]
+	if !ok {
+		code = "INVALID_ACTION"
+	}
+	room.rejectEvent(client, event, code, err.Error())
+}
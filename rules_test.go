@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// TestRollIsTheSenders has a player ask to roll for someone else, and for
+// a roll of their choosing, and checks the server rolls for the sender.
+func TestRollIsTheSenders(t *testing.T) {
+	room := newGameRoom("ROLL", defaultRoomOptions())
+	t.Cleanup(room.abandon)
+	ann, bob := fakeClient(), fakeClient()
+	room.do(func() {
+		seat(room, ann, "ann")
+		seat(room, bob, "bob")
+		room.Subscribe(ann)
+	})
+	handleGameEvent(room, GameEvent{Event: "START_GAME"}, ann)
+	replies(t, ann)
+	var first, second string
+	room.do(func() { first, second = room.GameState.TurnOrder[0], room.GameState.TurnOrder[1] })
+	sender := map[string]*Client{"ann": ann, "bob": bob}[first]
+
+	handleGameEvent(room, GameEvent{Event: "ROLL_DICE", Payload: map[string]interface{}{"player": second, "diceRoll": 12}}, sender)
+	var rolled DiceRolledPayload
+	for _, r := range replies(t, ann) {
+		var resolution struct {
+			Steps []reply `json:"steps"`
+		}
+		if r.Event != "RESOLUTION" {
+			continue
+		}
+		if err := json.Unmarshal(r.Payload, &resolution); err != nil {
+			t.Fatal(err)
+		}
+		for _, step := range resolution.Steps {
+			if step.Event == "ROLL_DICE" {
+				if err := json.Unmarshal(step.Payload, &rolled); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	room.do(func() {
+		firstAt, secondAt := room.GameState.Players[first].Position, room.GameState.Players[second].Position
+		if rolled.Player != first || rolled.DiceRoll < 2 || rolled.DiceRoll > 12 {
+			t.Errorf("rolled %+v", rolled)
+		}
+		if firstAt != rolled.DiceRoll || secondAt != 0 {
+			t.Errorf("%s at %d, %s at %d; the dice rolled %d", first, firstAt, second, secondAt, rolled.DiceRoll)
+		}
+	})
+}
+
+// loadedDice rolls the pairs it holds, in order.
+type loadedDice [][2]int
+
+func (d *loadedDice) Roll() (int, int) {
+	pair := (*d)[0]
+	*d = (*d)[1:]
+	return pair[0], pair[1]
+}
+
+// startRigged starts a game of ann and bob in a new room whose dice roll
+// dice, and returns the room, ann's client, which everything the room
+// broadcasts reaches, and the client of whoever plays first.
+func startRigged(t *testing.T, dice ...[2]int) (room *GameRoom, ann, first *Client) {
+	t.Helper()
+	room = newGameRoom("RIGGED", defaultRoomOptions())
+	// A game that ends saves its summary in the background.
+	t.Cleanup(pendingWrites.Wait)
+	t.Cleanup(room.abandon)
+	ann, bob := fakeClient(), fakeClient()
+	room.do(func() {
+		seat(room, ann, "ann")
+		seat(room, bob, "bob")
+		room.Subscribe(ann)
+	})
+	handleGameEvent(room, GameEvent{Event: "START_GAME"}, ann)
+	replies(t, ann)
+	loaded := loadedDice(dice)
+	room.do(func() {
+		room.dice = &loaded
+		first = map[string]*Client{"ann": ann, "bob": bob}[room.GameState.TurnOrder[0]]
+	})
+	return room, ann, first
+}
+
+// steps returns the steps of the RESOLUTIONs among rs that are event.
+func steps(t *testing.T, rs []reply, event string) []reply {
+	t.Helper()
+	var got []reply
+	for _, r := range rs {
+		var resolution struct {
+			Steps []reply `json:"steps"`
+		}
+		if r.Event != "RESOLUTION" {
+			continue
+		}
+		if err := json.Unmarshal(r.Payload, &resolution); err != nil {
+			t.Fatal(err)
+		}
+		for _, step := range resolution.Steps {
+			if step.Event == event {
+				got = append(got, step)
+			}
+		}
+	}
+	return got
+}
+
+// TestRentIsCollected has the first player land on Baltic Avenue, which
+// the second owns, and checks they pay its rent.
+func TestRentIsCollected(t *testing.T) {
+	room, ann, first := startRigged(t, [2]int{1, 2})
+	var player, owner string
+	room.do(func() {
+		player, owner = room.GameState.TurnOrder[0], room.GameState.TurnOrder[1]
+		room.GameState.Players[owner].Properties = []string{"Baltic Avenue"}
+	})
+
+	handleGameEvent(room, GameEvent{Event: "ROLL_DICE"}, first)
+	paid := steps(t, replies(t, ann), "RENT_PAID")
+	if len(paid) != 1 {
+		t.Fatalf("%d RENT_PAID steps, want 1", len(paid))
+	}
+	var rent RentPaidPayload
+	if err := json.Unmarshal(paid[0].Payload, &rent); err != nil {
+		t.Fatal(err)
+	}
+	if rent.Player != player || rent.Owner != owner || rent.Property != "Baltic Avenue" || rent.Amount != 4 {
+		t.Fatalf("rent paid: %+v", rent)
+	}
+	room.do(func() {
+		if got := room.GameState.Players[owner].RentCollected; got != rent.Amount {
+			t.Errorf("%s collected %d, want %d", owner, got, rent.Amount)
+		}
+	})
+}
+
+// TestTaxBankrupts has the first player land on Income Tax with less
+// than it costs, and checks they go bankrupt to the bank and the game
+// ends.
+func TestTaxBankrupts(t *testing.T) {
+	room, ann, first := startRigged(t, [2]int{1, 3})
+	var player string
+	room.do(func() {
+		player = room.GameState.TurnOrder[0]
+		room.GameState.Players[player].Balance = 100
+	})
+
+	handleGameEvent(room, GameEvent{Event: "ROLL_DICE"}, first)
+	if over := steps(t, replies(t, ann), "GAME_OVER"); len(over) != 1 {
+		t.Fatalf("%d GAME_OVER steps, want 1", len(over))
+	}
+	room.do(func() {
+		if p := room.GameState.Players[player]; !p.Forfeited || p.Balance != 0 {
+			t.Errorf("%s has %d after the tax, forfeited %v", player, p.Balance, p.Forfeited)
+		}
+		logged := make(map[string]bool)
+		for _, entry := range room.actionLog {
+			logged[entry.Key] = true
+		}
+		if !logged["tax"] || !logged["bankruptBank"] {
+			t.Errorf("action log %+v", room.actionLog)
+		}
+	})
+}
+
+// TestTurnPhases walks ann through a turn, sending what isn't allowed at
+// each point, and checks it is refused and the phase reported as it moves.
+func TestTurnPhases(t *testing.T) {
+	ts := newTestServer(t, nil)
+	seed := seedWhere(t, 1, func(totals []int) bool { return forSale(totals[0]) })
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 2, "diceSeed": seed})
+	clients := ts.startGame(code, "ann", "bob")
+	ann, bob := clients[0], clients[1]
+	phase := func(want string) {
+		t.Helper()
+		var actions AvailableActionsPayload
+		ann.expect("AVAILABLE_ACTIONS").decode(t, &actions)
+		if actions.Phase != want {
+			t.Errorf("AVAILABLE_ACTIONS in phase %s, want %s", actions.Phase, want)
+		}
+		ann.send("STATE_SYNC", nil)
+		var state GameState
+		ann.expect("STATE").decode(t, &state)
+		if state.Phase != want {
+			t.Errorf("STATE in phase %s, want %s", state.Phase, want)
+		}
+	}
+	refused := func(events ...string) {
+		t.Helper()
+		for _, event := range events {
+			ann.send(event, nil)
+			ann.expectError("WRONG_PHASE")
+		}
+	}
+
+	phase(game.PhaseAwaitingRoll)
+	refused("BUY_PROPERTY", "DECLINE_PURCHASE", "END_TURN")
+	ann.send("ROLL_DICE", nil)
+	phase(game.PhaseAwaitingPurchase)
+	refused("ROLL_DICE", "END_TURN", "UNMORTGAGE")
+	ann.send("DECLINE_PURCHASE", nil)
+	phase(game.PhaseAwaitingEnd)
+	refused("ROLL_DICE", "BUY_PROPERTY")
+	ann.send("END_TURN", nil)
+	var passed map[string]string
+	bob.expectStep("END_TURN").decode(t, &passed)
+	if passed["nextTurn"] != "bob" || passed["phase"] != game.PhaseAwaitingRoll {
+		t.Errorf("END_TURN %v", passed)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errNoSavedGame   = errors.New("no saved game with this code")
+	errSaveExpired   = errors.New("this saved game has expired")
+	errSaveForbidden = errors.New("this room is private; an invite or password is required")
+)
+
+type GameSavedPayload struct {
+	SavedBy   string    `json:"savedBy"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// AwaitingPlayersPayload is sent while a resumed game waits for its
+// players: Missing are those not yet back, and Needed how many more must
+// reconnect before the game carries on.
+type AwaitingPlayersPayload struct {
+	Missing []string `json:"missing"`
+	Needed  int      `json:"needed"`
+}
+
+// HandleSaveGameEvent saves the game under the room code and closes the
+// room. Anyone with a session token, or the room code via
+// POST /api/rooms/{code}/resume, can bring it back later.
+func HandleSaveGameEvent(room *GameRoom, event GameEvent, client *Client) {
+	if _, ok := store.(memoryStore); ok {
+		room.rejectEvent(client, event, "SAVE_UNAVAILABLE", "this server doesn't keep games")
+		return
+	}
+	room.savedAt = time.Now()
+	room.logger().Info("game saved", "player", connName(room, client))
+	SendGameEventToAll(room, "GAME_SAVED", room.ID, GameSavedPayload{
+		SavedBy:   connName(room, client),
+		ExpiresAt: room.savedAt.Add(hub.config.SavedGameRetention),
+	})
+	hub.closeRoom(room, "game saved")
+}
+
+// resumeSavedGame brings the saved game id back into the hub, or returns
+// it if it is there already. query must admit the caller to a private
+// room.
+func resumeSavedGame(id string, query map[string][]string) (*GameRoom, error) {
+	hub.Mutex.RLock()
+	room, live := hub.lookup(id)
+	hub.Mutex.RUnlock()
+	// A room that closed in the meantime may just have been saved.
+	allowed := false
+	if live && room.do(func() { allowed = room.admits(query) }) {
+		if !allowed {
+			return nil, errSaveForbidden
+		}
+		return room, nil
+	}
+
+	rec, err := store.LoadRoom(id)
+	if err == nil && rec == nil && strings.ToUpper(id) != id {
+		rec, err = store.LoadRoom(strings.ToUpper(id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.SavedAt == nil || rec.Finished() {
+		return nil, errNoSavedGame
+	}
+	if time.Since(*rec.SavedAt) > hub.config.SavedGameRetention {
+		store.DeleteRoom(rec.ID)
+		return nil, errSaveExpired
+	}
+	room, err = restoreRoom(rec)
+	if err != nil {
+		return nil, err
+	}
+	room.do(func() { allowed = room.admits(query) })
+	if !allowed {
+		room.abandon()
+		return nil, errSaveForbidden
+	}
+
+	hub.Mutex.Lock()
+	existing, ok := hub.Rooms[room.ID]
+	if !ok {
+		hub.Rooms[room.ID] = room
+	}
+	hub.Mutex.Unlock()
+	if ok {
+		room.abandon()
+		return existing, nil
+	}
+	room.logger().Info("game resumed from save")
+	return room, nil
+}
+
+// handleResumeRoom serves POST /api/rooms/{code}/resume, bringing a saved
+// game back so its players can reconnect.
+func handleResumeRoom(w http.ResponseWriter, r *http.Request) {
+	if refuseWhileShuttingDown(w) {
+		return
+	}
+	if routeToOwner(w, r, r.PathValue("id")) {
+		return
+	}
+	room, err := resumeSavedGame(r.PathValue("id"), r.URL.Query())
+	switch err {
+	case nil:
+	case errNoSavedGame:
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errSaveExpired:
+		writeError(w, http.StatusGone, err.Error())
+		return
+	case errSaveForbidden:
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	default:
+		slog.Error("resuming game", "gameId", r.PathValue("id"), "err", err)
+		writeError(w, http.StatusInternalServerError, "couldn't load the saved game")
+		return
+	}
+	writeJSON(w, http.StatusOK, CreateRoomResponse{
+		Code:  room.ID,
+		WSURL: joinURL(r, room.ID, ""),
+	})
+}
+
+// missingPlayers lists the players a resumed game is still waiting for.
+// It must run on the room's goroutine.
+func (room *GameRoom) missingPlayers() []string {
+	var missing []string
+	for _, name := range room.GameState.TurnOrder {
+		if p := room.GameState.Players[name]; !p.Connected && !p.Bot && !p.Forfeited {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// checkResumeQuorum carries on a resumed game once enough of its players
+// are back, or tells everyone who is still missing. It must run on the
+// room's goroutine.
+func (room *GameRoom) checkResumeQuorum() {
+	if !room.awaitingPlayers {
+		return
+	}
+	humans := 0
+	for _, name := range room.GameState.TurnOrder {
+		if p := room.GameState.Players[name]; !p.Bot && !p.Forfeited {
+			humans++
+		}
+	}
+	missing := room.missingPlayers()
+	needed := int(math.Ceil(hub.config.ResumeQuorum*float64(humans))) - (humans - len(missing))
+	if needed > 0 {
+		SendGameEventToAll(room, "AWAITING_PLAYERS", room.ID, AwaitingPlayersPayload{Missing: missing, Needed: needed})
+		return
+	}
+	room.resume("")
+}
+
+// stopAwaitingPlayers ends a resumed game's wait for its players. Those
+// still away get the usual reconnect window from now on. It must run on
+// the room's goroutine.
+func (room *GameRoom) stopAwaitingPlayers() {
+	room.awaitingPlayers = false
+	room.savedAt = time.Time{}
+	for _, name := range room.missingPlayers() {
+		room.startGrace(name)
+	}
+	if room.turnTimer == nil {
+		room.startTurnTimer()
+	}
+}
+
+// HandleApproveRejoinEvent lets a disconnected player who lost their
+// session token back in: for a while, connecting under their name without
+// a token is enough.
+func HandleApproveRejoinEvent(room *GameRoom, event GameEvent, client *Client) {
+	payload, _ := event.Payload.(map[string]interface{})
+	target, _ := payload["player"].(string)
+	player, ok := room.GameState.Players[target]
+	if !ok || player.Connected || player.Forfeited {
+		room.rejectEvent(client, event, "INVALID_PLAYER", "can't let "+target+" rejoin")
+		return
+	}
+	room.rejoinApprovals[target] = time.Now().Add(hub.config.RejoinApprovalWindow)
+	delete(room.rejoinRequests, target)
+	SendGameEventToAll(room, "REJOIN_APPROVED", room.ID, PlayerPayload{Player: target})
+}
+
+// requestRejoin asks the host to let name back in without a token,
+// unless they asked very recently. It must run on the room's goroutine.
+func (room *GameRoom) requestRejoin(name string) {
+	if last, ok := room.rejoinRequests[name]; ok && time.Since(last) < 10*time.Second {
+		return
+	}
+	room.rejoinRequests[name] = time.Now()
+	SendGameEventToAll(room, "REJOIN_REQUESTED", room.ID, PlayerPayload{Player: name})
+}
+
+// takeRejoinApproval reports whether the host let name rejoin without a
+// token, using the approval up. It must run on the room's goroutine.
+func (room *GameRoom) takeRejoinApproval(name string) bool {
+	until, ok := room.rejoinApprovals[name]
+	delete(room.rejoinApprovals, name)
+	return ok && time.Now().Before(until)
+}
+
+// expireSavedGames periodically deletes saved games past their retention
+// period.
+func expireSavedGames() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-background.Done():
+			return
+		case <-ticker.C:
+		}
+		recs, err := store.LoadRooms()
+		if err != nil {
+			slog.Error("loading saved games", "err", err)
+			continue
+		}
+		for _, rec := range recs {
+			if rec.SavedAt != nil && time.Since(*rec.SavedAt) > hub.config.SavedGameRetention {
+				if err := store.DeleteRoom(rec.ID); err != nil {
+					slog.Error("deleting expired save", "gameId", rec.ID, "err", err)
+					continue
+				}
+				slog.Info("saved game expired", "gameId", rec.ID)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// WelcomePayload is sent only to a joining player. Token lets the same
+// player reconnect with ?token= and resume where they left off; it lives
+// as long as the room does.
+type WelcomePayload struct {
+	Player string `json:"player"`
+	Token  string `json:"token"`
+}
+
+type PlayerPayload struct {
+	Player string `json:"player"`
+}
+
+func newSessionToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// issueSession creates a session token for name. It must run on the
+// room's goroutine.
+func (room *GameRoom) issueSession(name string) string {
+	token := newSessionToken()
+	room.sessions[token] = name
+	delete(room.eventIDs, name)
+	return token
+}
+
+// sessionToken returns the token issued to name, if any. It must run on
+// the room's goroutine.
+func (room *GameRoom) sessionToken(name string) string {
+	for token, n := range room.sessions {
+		if n == name {
+			return token
+		}
+	}
+	return ""
+}
+
+// revokeSession forgets name's token. It must run on the room's
+// goroutine.
+func (room *GameRoom) revokeSession(name string) {
+	if token := room.sessionToken(name); token != "" {
+		delete(room.sessions, token)
+	}
+	delete(room.eventIDs, name)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shuttingDown is set once shutdown begins; from then on no new rooms or
+// connections are accepted.
+var shuttingDown atomic.Bool
+
+// background is cancelled on shutdown to stop the server's periodic jobs.
+var background, stopBackground = context.WithCancel(context.Background())
+
+// shutdownDone is closed once shutdown has finished.
+var shutdownDone = make(chan struct{})
+
+// pendingWrites tracks store writes still running in the background, so
+// shutdown can wait for them.
+var pendingWrites sync.WaitGroup
+
+type ServerShutdownPayload struct {
+	Seconds int       `json:"seconds"`
+	At      time.Time `json:"at"`
+}
+
+// refuseWhileShuttingDown answers 503 and returns true once shutdown has
+// begun.
+func refuseWhileShuttingDown(w http.ResponseWriter) bool {
+	if !shuttingDown.Load() {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	writeError(w, http.StatusServiceUnavailable, "the server is shutting down")
+	return true
+}
+
+// shutdown winds the server down: it stops taking new connections, warns
+// every room, waits out the countdown (or until cut short), then closes
+// the rooms, keeping their state in the store, and finally stops servers.
+// hurry ends the countdown early, e.g. on a second signal.
+func shutdown(hurry context.Context, servers ...*http.Server) {
+	defer close(shutdownDone)
+	shuttingDown.Store(true)
+	stopBackground()
+
+	rooms := hubRooms()
+	at := time.Now().Add(hub.config.ShutdownCountdown)
+	for _, room := range rooms {
+		room.do(func() {
+			SendGameEventToAll(room, "SERVER_SHUTDOWN", room.ID, ServerShutdownPayload{
+				Seconds: int(hub.config.ShutdownCountdown.Seconds()),
+				At:      at,
+			})
+		})
+	}
+	slog.Info("shutting down", "countdown", hub.config.ShutdownCountdown)
+	select {
+	case <-time.After(hub.config.ShutdownCountdown):
+	case <-hurry.Done():
+	}
+
+	var clients []*Client
+	for _, room := range hubRooms() {
+		room.do(func() {
+			for client := range room.Players {
+				clients = append(clients, client)
+			}
+			for client := range room.Spectators {
+				clients = append(clients, client)
+			}
+			// Keep the room in the store so it comes back on restart, or on
+			// whichever instance claims it next.
+			room.handedOff = true
+			hub.closeRoom(room, "server shutting down")
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hub.config.ShutdownTimeout)
+	defer cancel()
+	for _, client := range clients {
+		select {
+		case <-client.done:
+		case <-ctx.Done():
+		}
+	}
+	flushed := make(chan struct{})
+	go func() {
+		pendingWrites.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		slog.Warn("gave up waiting for the store")
+	}
+	cluster.Close()
+	if err := store.Close(); err != nil {
+		slog.Error("closing store", "err", err)
+	}
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("shutting down", "addr", server.Addr, "err", err)
+		}
+	}
+	slog.Info("server stopped")
+}
+
+// hubRooms returns every room in the hub.
+func hubRooms() []*GameRoom {
+	hub.Mutex.RLock()
+	defer hub.Mutex.RUnlock()
+	rooms := make([]*GameRoom, 0, len(hub.Rooms))
+	for _, room := range hub.Rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
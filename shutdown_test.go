@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestShutdown shuts the server down while a broadcast is still being
+// made, and checks the broadcast reaches everyone, then the warning, and
+// then a going-away close frame, all before the listener stops.
+func TestShutdown(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.ShutdownCountdown = 50 * time.Millisecond })
+	t.Cleanup(func() {
+		shuttingDown.Store(false)
+		shutdownDone = make(chan struct{})
+		background, stopBackground = context.WithCancel(context.Background())
+	})
+	code := ts.createRoom(nil)
+	ann := ts.join(code, "ann", nil)
+	bob := ts.join(code, "bob", nil)
+	room := ts.room(code)
+	var clients []*Client
+	room.do(func() {
+		for client := range room.Players {
+			clients = append(clients, client)
+		}
+	})
+
+	// By the time the listener starts to stop, every connection has been
+	// closed.
+	closedFirst := make(chan bool, 1)
+	ts.srv.Config.RegisterOnShutdown(func() {
+		closed := true
+		for _, client := range clients {
+			select {
+			case <-client.done:
+			default:
+				closed = false
+			}
+		}
+		closedFirst <- closed
+	})
+
+	busy, release := make(chan struct{}), make(chan struct{})
+	go room.do(func() {
+		close(busy)
+		<-release
+		SendGameEventToAll(room, "CHAT_MESSAGE", room.ID, map[string]string{"from": "ann", "text": "last words"})
+	})
+	<-busy
+	stopped := make(chan struct{})
+	go func() {
+		shutdown(context.Background(), ts.srv.Config)
+		close(stopped)
+	}()
+	for !shuttingDown.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	for _, c := range []*testClient{ann, bob} {
+		var events []string
+		for e := range c.events {
+			last := e.Event == "CHAT_MESSAGE" && bytes.Contains(e.Payload, []byte("last words"))
+			if last || e.Event == "SERVER_SHUTDOWN" || e.Event == "ROOM_CLOSED" {
+				events = append(events, e.Event)
+			}
+		}
+		if got := strings.Join(events, " "); got != "CHAT_MESSAGE SERVER_SHUTDOWN ROOM_CLOSED" {
+			t.Errorf("%s got %s", c.name, got)
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(c.closeErr, &closeErr) || closeErr.Code != CloseServerShutdown {
+			t.Errorf("%s: connection ended with %v", c.name, c.closeErr)
+		}
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown didn't finish")
+	}
+	if !<-closedFirst {
+		t.Error("the listener stopped before every connection was closed")
+	}
+	if resp, err := http.Get(ts.srv.URL + "/api/rooms"); err == nil {
+		resp.Body.Close()
+		t.Error("still listening after shutdown")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// sseBufferSize bounds how far an SSE reader may fall behind before it is
+// dropped.
+const sseBufferSize = 64
+
+type sseSubscriber struct {
+	messages chan *OutboundMessage
+	dropped  chan struct{}
+	once     sync.Once
+}
+
+func newSSESubscriber() *sseSubscriber {
+	return &sseSubscriber{
+		messages: make(chan *OutboundMessage, sseBufferSize),
+		dropped:  make(chan struct{}),
+	}
+}
+
+func (s *sseSubscriber) Send(message *OutboundMessage) bool {
+	select {
+	case s.messages <- message:
+		return true
+	default:
+		s.once.Do(func() { close(s.dropped) })
+		return false
+	}
+}
+
+func writeSSE(w http.ResponseWriter, message *OutboundMessage) error {
+	_, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", message.Seq, message.JSON)
+	return err
+}
+
+// handleRoomEvents streams a room's broadcasts as Server-Sent Events for
+// read-only spectating, in the locale given with ?locale=. The stream
+// starts with a STATE snapshot unless the client resumes with a
+// Last-Event-ID that is still in the room's history.
+func handleRoomEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	if routeToOwner(w, r, r.PathValue("id")) {
+		return
+	}
+	hub.Mutex.RLock()
+	room, exists := hub.lookup(r.PathValue("id"))
+	hub.Mutex.RUnlock()
+	if !exists {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	locale := matchLocale(r.URL.Query().Get("locale"))
+	var resume []*OutboundMessage
+	var snapshot *OutboundMessage
+	sub := newSSESubscriber()
+	// Subscribing and picking the starting point on the room's goroutine
+	// keeps broadcasts out in between, so nothing is missed or sent twice.
+	allowed := false
+	open := room.do(func() {
+		if allowed = room.admits(r.URL.Query()); !allowed {
+			return
+		}
+		room.Subscribe(sub)
+		resumed := false
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			if lastSeq, err := strconv.ParseUint(id, 10, 64); err == nil {
+				resume, resumed = room.messagesSince(lastSeq)
+			}
+		}
+		if !resumed {
+			snapshot = room.snapshot("", locale, "")
+		}
+	})
+	if !open {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	if !allowed {
+		writeError(w, http.StatusForbidden, "this room is private")
+		return
+	}
+	defer room.do(func() { room.Unsubscribe(sub) })
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if snapshot != nil {
+		if err := writeSSE(w, snapshot); err != nil {
+			return
+		}
+	}
+	for _, m := range resume {
+		if err := writeSSE(w, m.In(locale)); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-room.done:
+			// Pass on the closing notice before ending the stream.
+			for {
+				select {
+				case m := <-sub.messages:
+					writeSSE(w, m.In(locale))
+				default:
+					flusher.Flush()
+					return
+				}
+			}
+		case <-sub.dropped:
+			room.logger().Warn("dropping slow SSE subscriber", "remote", clientIP(r))
+			return
+		case m := <-sub.messages:
+			if err := writeSSE(w, m.In(locale)); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,1025 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// RoomRecord is everything needed to bring a room back after a restart.
+type RoomRecord struct {
+	ID          string            `json:"id"`
+	Options     RoomOptions       `json:"options"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+	GameState   GameState         `json:"gameState"`
+	Seq         uint64            `json:"seq"`
+	Sessions    map[string]string `json:"sessions"`
+	InviteToken string            `json:"inviteToken,omitempty"`
+	Password    string            `json:"password,omitempty"`
+	PlayerIDs   map[string]string `json:"playerIds,omitempty"`
+	// SavedAt is set on games saved with SAVE_GAME, which wait to be
+	// resumed rather than coming back on their own.
+	SavedAt *time.Time `json:"savedAt,omitempty"`
+	// DiceSeed and DiceRolls carry GameState's, which aren't part of its
+	// JSON. Records from before they were kept have no DiceSeed; those
+	// games carry on with a fresh one.
+	DiceSeed  *int64 `json:"diceSeed,omitempty"`
+	DiceRolls int    `json:"diceRolls,omitempty"`
+	// DiceHistory carries GameState's, which isn't part of its JSON
+	// either.
+	DiceHistory []game.DiceRoll `json:"diceHistory,omitempty"`
+	// ActionLog is the room's recent action feed.
+	ActionLog []ActionLogEntry `json:"actionLog,omitempty"`
+	// Preferences are the players' auto-action settings.
+	Preferences map[string]Preferences `json:"preferences,omitempty"`
+	// Tournament is the tournament table the room was set up for.
+	Tournament *tournamentSeat `json:"tournament,omitempty"`
+}
+
+// Finished reports whether the record is of a game that has ended.
+func (rec *RoomRecord) Finished() bool {
+	return rec.GameState.Status == StatusFinished
+}
+
+// Store persists rooms. Finished games are kept, marked by their status;
+// rooms torn down before their game finished are deleted.
+type Store interface {
+	SaveRoom(rec *RoomRecord) error
+	DeleteRoom(id string) error
+	// LoadRooms returns every room whose game hasn't finished, and
+	// LoadRoom the one called id, or nil if there is no such room.
+	LoadRooms() ([]*RoomRecord, error)
+	LoadRoom(id string) (*RoomRecord, error)
+	// AppendLog adds entries to the end of a room's event log, and
+	// LoadLog reads it back; rooms with no log return nil.
+	AppendLog(id string, entries []LogEntry) error
+	LoadLog(id string) ([]LogEntry, error)
+	// SaveSummary keeps the summary of a finished game and, in the same
+	// go, adds it to the stats of every player in it with a persistent
+	// ID. LoadPlayerStats returns one player's stats, or nil, and
+	// Leaderboard the top limit players by one of leaderboardSorts.
+	// ListSummaries
+	// returns a page of them, newest first, optionally only those player
+	// took part in, along with how many there are in all; LoadSummary
+	// returns one, or nil. DeleteSummaries removes those of games that
+	// finished before cutoff.
+	SaveSummary(sum *GameSummary) error
+	ListSummaries(player string, limit, offset int) ([]*GameSummary, int, error)
+	LoadSummary(id string) (*GameSummary, error)
+	DeleteSummaries(cutoff time.Time) error
+	LoadPlayerStats(id string) (*PlayerStats, error)
+	Leaderboard(sort string, limit int) ([]*PlayerStats, error)
+	// SaveTournament keeps a tournament as it now stands, and
+	// LoadTournaments returns every tournament kept.
+	SaveTournament(rec *TournamentRecord) error
+	LoadTournaments() ([]*TournamentRecord, error)
+	Close() error
+}
+
+var store Store = memoryStore{}
+
+func openStore(cfg *Config) (Store, error) {
+	switch cfg.Store {
+	case "memory":
+		return memoryStore{}, nil
+	case "file":
+		return newFileStore(cfg.StorePath)
+	case "sql":
+		return newSQLStore(cfg.StoreDriver, cfg.StoreDSN)
+	}
+	return nil, fmt.Errorf("unknown store %q", cfg.Store)
+}
+
+// memoryStore keeps nothing: rooms live only as long as the process.
+type memoryStore struct{}
+
+func (memoryStore) SaveRoom(*RoomRecord) error               { return nil }
+func (memoryStore) DeleteRoom(string) error                  { return nil }
+func (memoryStore) LoadRooms() ([]*RoomRecord, error)        { return nil, nil }
+func (memoryStore) LoadRoom(string) (*RoomRecord, error)     { return nil, nil }
+func (memoryStore) AppendLog(string, []LogEntry) error       { return nil }
+func (memoryStore) LoadLog(string) ([]LogEntry, error)       { return nil, nil }
+func (memoryStore) SaveSummary(*GameSummary) error           { return nil }
+func (memoryStore) LoadSummary(string) (*GameSummary, error) { return nil, nil }
+func (memoryStore) DeleteSummaries(time.Time) error          { return nil }
+func (memoryStore) Close() error                             { return nil }
+
+func (memoryStore) ListSummaries(string, int, int) ([]*GameSummary, int, error) {
+	return nil, 0, nil
+}
+
+func (memoryStore) LoadPlayerStats(string) (*PlayerStats, error)    { return nil, nil }
+func (memoryStore) Leaderboard(string, int) ([]*PlayerStats, error) { return nil, nil }
+func (memoryStore) SaveTournament(*TournamentRecord) error          { return nil }
+func (memoryStore) LoadTournaments() ([]*TournamentRecord, error)   { return nil, nil }
+
+// fileStore keeps one JSON file per room in a directory.
+type fileStore struct {
+	dir string
+	// statsMu serializes updates to player stats, which are read,
+	// changed and written back.
+	statsMu sync.Mutex
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *fileStore) SaveRoom(rec *RoomRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	// Write then rename, so a crash never leaves half a file behind.
+	tmp := s.path(rec.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(rec.ID))
+}
+
+func (s *fileStore) logPath(id string) string {
+	return filepath.Join(s.dir, id+".log")
+}
+
+func (s *fileStore) DeleteRoom(id string) error {
+	for _, p := range []string{s.path(id), s.logPath(id)} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendLog writes entries as JSON lines to the room's .log file.
+func (s *fileStore) AppendLog(id string, entries []LogEntry) error {
+	f, err := os.OpenFile(s.logPath(id), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func (s *fileStore) LoadLog(id string) ([]LogEntry, error) {
+	f, err := os.Open(s.logPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []LogEntry
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var entry LogEntry
+		if err := dec.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *fileStore) LoadRoom(id string) (*RoomRecord, error) {
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec RoomRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *fileStore) LoadRooms() ([]*RoomRecord, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var recs []*RoomRecord
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var rec RoomRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		if !rec.Finished() {
+			recs = append(recs, &rec)
+		}
+	}
+	return recs, nil
+}
+
+// Summaries live in a summaries subdirectory, one JSON file per game.
+func (s *fileStore) summaryPath(id string) string {
+	return filepath.Join(s.dir, "summaries", id+".json")
+}
+
+// SaveSummary updates the players' stats before writing the summary
+// itself. The two can't be written atomically; a crash in between counts
+// the game in the stats without listing it.
+func (s *fileStore) SaveSummary(sum *GameSummary) error {
+	if err := s.addPlayerStats(sum); err != nil {
+		return err
+	}
+	data, err := json.Marshal(sum)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.summaryPath(sum.ID)), 0o755); err != nil {
+		return err
+	}
+	tmp := s.summaryPath(sum.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.summaryPath(sum.ID))
+}
+
+func (s *fileStore) LoadSummary(id string) (*GameSummary, error) {
+	data, err := os.ReadFile(s.summaryPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sum GameSummary
+	if err := json.Unmarshal(data, &sum); err != nil {
+		return nil, err
+	}
+	return &sum, nil
+}
+
+func (s *fileStore) loadSummaries() ([]*GameSummary, error) {
+	paths, err := filepath.Glob(s.summaryPath("*"))
+	if err != nil {
+		return nil, err
+	}
+	sums := make([]*GameSummary, 0, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var sum GameSummary
+		if err := json.Unmarshal(data, &sum); err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		sums = append(sums, &sum)
+	}
+	return sums, nil
+}
+
+func (s *fileStore) ListSummaries(player string, limit, offset int) ([]*GameSummary, int, error) {
+	all, err := s.loadSummaries()
+	if err != nil {
+		return nil, 0, err
+	}
+	sums := all[:0]
+	for _, sum := range all {
+		if player == "" || sum.hasPlayer(player) {
+			sums = append(sums, sum)
+		}
+	}
+	sort.Slice(sums, func(i, j int) bool { return sums[i].FinishedAt.After(sums[j].FinishedAt) })
+	total := len(sums)
+	if offset > total {
+		offset = total
+	}
+	sums = sums[offset:]
+	if len(sums) > limit {
+		sums = sums[:limit]
+	}
+	return sums, total, nil
+}
+
+func (s *fileStore) DeleteSummaries(cutoff time.Time) error {
+	sums, err := s.loadSummaries()
+	if err != nil {
+		return err
+	}
+	for _, sum := range sums {
+		if sum.FinishedAt.Before(cutoff) {
+			if err := os.Remove(s.summaryPath(sum.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Player stats live in a players subdirectory, one JSON file per player.
+func (s *fileStore) statsPath(id string) string {
+	return filepath.Join(s.dir, "players", id+".json")
+}
+
+func (s *fileStore) addPlayerStats(sum *GameSummary) error {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	for _, p := range sum.Players {
+		if p.PlayerID == "" {
+			continue
+		}
+		stats, err := s.LoadPlayerStats(p.PlayerID)
+		if err != nil {
+			return err
+		}
+		if stats == nil {
+			stats = &PlayerStats{ID: p.PlayerID}
+		}
+		stats.add(sum, p)
+		if err := s.writeStats(stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStats writes stats with the total net worth, which PlayerStats
+// leaves out of its JSON.
+func (s *fileStore) writeStats(stats *PlayerStats) error {
+	data, err := json.Marshal(storedStats{PlayerStats: stats, TotalNetWorth: stats.TotalNetWorth})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.statsPath(stats.ID)), 0o755); err != nil {
+		return err
+	}
+	tmp := s.statsPath(stats.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.statsPath(stats.ID))
+}
+
+type storedStats struct {
+	*PlayerStats
+	TotalNetWorth int `json:"totalNetWorth"`
+}
+
+func readStats(data []byte) (*PlayerStats, error) {
+	stored := storedStats{PlayerStats: &PlayerStats{}}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	stored.PlayerStats.TotalNetWorth = stored.TotalNetWorth
+	return stored.PlayerStats, nil
+}
+
+func (s *fileStore) LoadPlayerStats(id string) (*PlayerStats, error) {
+	if validatePlayerID(id) != nil || id == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(s.statsPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readStats(data)
+}
+
+func (s *fileStore) Leaderboard(sort string, limit int) ([]*PlayerStats, error) {
+	paths, err := filepath.Glob(s.statsPath("*"))
+	if err != nil {
+		return nil, err
+	}
+	all := make([]*PlayerStats, 0, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		stats, err := readStats(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		all = append(all, stats)
+	}
+	rankPlayers(all, sort)
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	return all, nil
+}
+
+// Tournaments live in a tournaments subdirectory, one JSON file each.
+func (s *fileStore) tournamentPath(id string) string {
+	return filepath.Join(s.dir, "tournaments", id+".json")
+}
+
+func (s *fileStore) SaveTournament(rec *TournamentRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.tournamentPath(rec.ID)), 0o755); err != nil {
+		return err
+	}
+	tmp := s.tournamentPath(rec.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.tournamentPath(rec.ID))
+}
+
+func (s *fileStore) LoadTournaments() ([]*TournamentRecord, error) {
+	paths, err := filepath.Glob(s.tournamentPath("*"))
+	if err != nil {
+		return nil, err
+	}
+	recs := make([]*TournamentRecord, 0, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var rec TournamentRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		recs = append(recs, &rec)
+	}
+	return recs, nil
+}
+
+func (s *fileStore) Close() error { return nil }
+
+// sqlStore keeps rooms in a rooms table through database/sql. The SQL is
+// shared by SQLite and Postgres. SQLite's driver, sqlite3, is linked into
+// the binary; Postgres's has to be added with a blank import.
+type sqlStore struct {
+	db *sql.DB
+}
+
+var createTables = []string{
+	`CREATE TABLE IF NOT EXISTS rooms (
+		id TEXT PRIMARY KEY,
+		status TEXT NOT NULL,
+		data TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS room_events (
+		room_id TEXT NOT NULL,
+		idx INTEGER NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (room_id, idx)
+	)`,
+	`CREATE TABLE IF NOT EXISTS game_summaries (
+		id TEXT PRIMARY KEY,
+		finished_at TIMESTAMP NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS game_players (
+		game_id TEXT NOT NULL,
+		player TEXT NOT NULL,
+		PRIMARY KEY (game_id, player)
+	)`,
+	`CREATE TABLE IF NOT EXISTS player_stats (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		games INTEGER NOT NULL,
+		wins INTEGER NOT NULL,
+		rent INTEGER NOT NULL,
+		inflicted INTEGER NOT NULL,
+		net_worth INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS tournaments (
+		id TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	)`,
+}
+
+// statsOrder is the ORDER BY expression for each of leaderboardSorts.
+var statsOrder = map[string]string{
+	"wins":                  "wins",
+	"gamesPlayed":           "games",
+	"rentCollected":         "rent",
+	"bankruptciesInflicted": "inflicted",
+	"averageNetWorth":       "net_worth / games",
+}
+
+func newSQLStore(driver, dsn string) (*sqlStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range createTables {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &sqlStore{db: db}, nil
+}
+
+func (s *sqlStore) SaveRoom(rec *RoomRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO rooms (id, status, data, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, data = excluded.data, updated_at = excluded.updated_at`,
+		rec.ID, rec.GameState.Status, string(data), rec.UpdatedAt)
+	return err
+}
+
+func (s *sqlStore) DeleteRoom(id string) error {
+	if _, err := s.db.Exec(`DELETE FROM room_events WHERE room_id = $1`, id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM rooms WHERE id = $1`, id)
+	return err
+}
+
+func (s *sqlStore) AppendLog(id string, entries []LogEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var next int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(idx), 0) FROM room_events WHERE room_id = $1`, id).Scan(&next); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		next++
+		data, err := json.Marshal(&entry)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO room_events (room_id, idx, data) VALUES ($1, $2, $3)`, id, next, string(data)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) LoadLog(id string) ([]LogEntry, error) {
+	rows, err := s.db.Query(`SELECT data FROM room_events WHERE room_id = $1 ORDER BY idx`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []LogEntry
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var entry LogEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqlStore) LoadRoom(id string) (*RoomRecord, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM rooms WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec RoomRecord
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *sqlStore) LoadRooms() ([]*RoomRecord, error) {
+	rows, err := s.db.Query(`SELECT data FROM rooms WHERE status <> $1`, StatusFinished)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var recs []*RoomRecord
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var rec RoomRecord
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, &rec)
+	}
+	return recs, rows.Err()
+}
+
+func (s *sqlStore) SaveSummary(sum *GameSummary) error {
+	data, err := json.Marshal(sum)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO game_summaries (id, finished_at, data) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET finished_at = excluded.finished_at, data = excluded.data`,
+		sum.ID, sum.FinishedAt, string(data)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM game_players WHERE game_id = $1`, sum.ID); err != nil {
+		return err
+	}
+	for _, p := range sum.Players {
+		if _, err := tx.Exec(`INSERT INTO game_players (game_id, player) VALUES ($1, $2)`, sum.ID, p.Name); err != nil {
+			return err
+		}
+		if p.PlayerID == "" {
+			continue
+		}
+		var one PlayerStats
+		one.add(sum, p)
+		if _, err := tx.Exec(`INSERT INTO player_stats (id, name, games, wins, rent, inflicted, net_worth) VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (id) DO UPDATE SET name = excluded.name, games = player_stats.games + excluded.games,
+				wins = player_stats.wins + excluded.wins, rent = player_stats.rent + excluded.rent,
+				inflicted = player_stats.inflicted + excluded.inflicted, net_worth = player_stats.net_worth + excluded.net_worth`,
+			p.PlayerID, one.Name, one.GamesPlayed, one.Wins, one.RentCollected, one.BankruptciesInflicted, one.TotalNetWorth); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const selectStats = `SELECT id, name, games, wins, rent, inflicted, net_worth FROM player_stats`
+
+func scanStats(row interface{ Scan(...interface{}) error }) (*PlayerStats, error) {
+	var stats PlayerStats
+	err := row.Scan(&stats.ID, &stats.Name, &stats.GamesPlayed, &stats.Wins, &stats.RentCollected, &stats.BankruptciesInflicted, &stats.TotalNetWorth)
+	return &stats, err
+}
+
+func (s *sqlStore) LoadPlayerStats(id string) (*PlayerStats, error) {
+	stats, err := scanStats(s.db.QueryRow(selectStats+` WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (s *sqlStore) Leaderboard(sort string, limit int) ([]*PlayerStats, error) {
+	rows, err := s.db.Query(selectStats+` ORDER BY `+statsOrder[sort]+` DESC, id LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []*PlayerStats
+	for rows.Next() {
+		stats, err := scanStats(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, stats)
+	}
+	return all, rows.Err()
+}
+
+func (s *sqlStore) LoadSummary(id string) (*GameSummary, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM game_summaries WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sum GameSummary
+	if err := json.Unmarshal([]byte(data), &sum); err != nil {
+		return nil, err
+	}
+	return &sum, nil
+}
+
+func (s *sqlStore) ListSummaries(player string, limit, offset int) ([]*GameSummary, int, error) {
+	const filter = `WHERE $1 = '' OR id IN (SELECT game_id FROM game_players WHERE player = $1)`
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM game_summaries `+filter, player).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.Query(`SELECT data FROM game_summaries `+filter+` ORDER BY finished_at DESC LIMIT $2 OFFSET $3`, player, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var sums []*GameSummary
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, 0, err
+		}
+		var sum GameSummary
+		if err := json.Unmarshal([]byte(data), &sum); err != nil {
+			return nil, 0, err
+		}
+		sums = append(sums, &sum)
+	}
+	return sums, total, rows.Err()
+}
+
+func (s *sqlStore) DeleteSummaries(cutoff time.Time) error {
+	if _, err := s.db.Exec(`DELETE FROM game_players WHERE game_id IN (SELECT id FROM game_summaries WHERE finished_at < $1)`, cutoff); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM game_summaries WHERE finished_at < $1`, cutoff)
+	return err
+}
+
+func (s *sqlStore) SaveTournament(rec *TournamentRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO tournaments (id, data) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data`, rec.ID, string(data))
+	return err
+}
+
+func (s *sqlStore) LoadTournaments() ([]*TournamentRecord, error) {
+	rows, err := s.db.Query(`SELECT data FROM tournaments`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var recs []*TournamentRecord
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var rec TournamentRecord
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, &rec)
+	}
+	return recs, rows.Err()
+}
+
+func (s *sqlStore) Close() error { return s.db.Close() }
+
+// record captures the room for the store. The GameState is deep-copied so
+// it can be encoded off the room's goroutine, which record must run on.
+func (room *GameRoom) record() *RoomRecord {
+	rec := &RoomRecord{
+		ID:          room.ID,
+		Options:     room.Options,
+		CreatedAt:   room.CreatedAt,
+		UpdatedAt:   time.Now(),
+		Sessions:    make(map[string]string, len(room.sessions)),
+		InviteToken: room.inviteToken,
+		Password:    room.password,
+		PlayerIDs:   make(map[string]string, len(room.playerIDs)),
+	}
+	for name, id := range room.playerIDs {
+		rec.PlayerIDs[name] = id
+	}
+	state, _ := json.Marshal(&room.GameState)
+	json.Unmarshal(state, &rec.GameState)
+	for token, name := range room.sessions {
+		rec.Sessions[token] = name
+	}
+	if !room.savedAt.IsZero() {
+		savedAt := room.savedAt
+		rec.SavedAt = &savedAt
+	}
+	rec.Seq = room.seq
+	seed := room.GameState.DiceSeed
+	rec.DiceSeed = &seed
+	rec.DiceRolls = room.GameState.DiceRolls
+	rec.DiceHistory = append([]game.DiceRoll(nil), room.GameState.DiceHistory...)
+	rec.ActionLog = append([]ActionLogEntry(nil), room.actionLog...)
+	rec.Tournament = room.tournament
+	if len(room.preferences) > 0 {
+		rec.Preferences = make(map[string]Preferences, len(room.preferences))
+		for name, prefs := range room.preferences {
+			rec.Preferences[name] = prefs
+		}
+	}
+	return rec
+}
+
+// markDirty schedules a save of the room, gathering changes for
+// storeDebounce first. It must run on the room's goroutine.
+func (room *GameRoom) markDirty() {
+	if _, none := store.(memoryStore); none || room.saveTimer != nil {
+		return
+	}
+	room.saveTimer = time.AfterFunc(hub.config.StoreDebounce, room.save)
+}
+
+// save writes the room to the store. saveMu keeps saves and the final save
+// or delete in closeRoom in order.
+func (room *GameRoom) save() {
+	room.saveMu.Lock()
+	defer room.saveMu.Unlock()
+
+	var rec *RoomRecord
+	var pending []LogEntry
+	if !room.do(func() {
+		room.saveTimer = nil
+		rec = room.record()
+		pending = room.takePendingLog()
+		room.trimLog()
+	}) {
+		return
+	}
+	if err := store.AppendLog(room.ID, pending); err != nil {
+		room.logger().Error("saving event log", "err", err)
+	}
+	if err := store.SaveRoom(rec); err != nil {
+		room.logger().Error("saving room", "err", err)
+	}
+}
+
+// persistClosed records a room that is being torn down: a finished game is
+// kept for history, and so are saved games and rooms handed off to another
+// instance; anything else is deleted. It must run on the room's goroutine,
+// after room.closed is set.
+func (room *GameRoom) persistClosed() {
+	if room.saveTimer != nil {
+		room.saveTimer.Stop()
+		room.saveTimer = nil
+	}
+	pending := room.takePendingLog()
+
+	var rec *RoomRecord
+	if room.GameState.Status == StatusFinished || room.handedOff || !room.savedAt.IsZero() {
+		rec = room.record()
+	}
+	pendingWrites.Add(1)
+	go func() {
+		defer pendingWrites.Done()
+		room.saveMu.Lock()
+		defer room.saveMu.Unlock()
+		var err error
+		if rec != nil {
+			if err = store.AppendLog(room.ID, pending); err == nil {
+				err = store.SaveRoom(rec)
+			}
+		} else {
+			err = store.DeleteRoom(room.ID)
+		}
+		if err != nil {
+			room.logger().Error("persisting closed room", "err", err)
+		}
+	}()
+}
+
+// restoreRooms loads unfinished games from the store into the hub. Nobody
+// is connected yet, so every player starts out disconnected: they rejoin
+// with their session tokens, within the usual grace periods.
+func restoreRooms() error {
+	recs, err := store.LoadRooms()
+	if err != nil {
+		return err
+	}
+	rooms := make([]*GameRoom, 0, len(recs))
+	for _, rec := range recs {
+		if rec.SavedAt != nil {
+			// Saved games wait for someone to resume them.
+			continue
+		}
+		room, err := restoreRoom(rec)
+		if err != nil {
+			return err
+		}
+		rooms = append(rooms, room)
+	}
+
+	hub.Mutex.Lock()
+	defer hub.Mutex.Unlock()
+	for _, room := range rooms {
+		hub.Rooms[room.ID] = room
+		room.logger().Info("room restored", "status", room.GameState.Status)
+	}
+	return nil
+}
+
+// restoreRoom rebuilds a room from its record, ready to be added to the
+// hub. A room that isn't added after all must be abandoned.
+func restoreRoom(rec *RoomRecord) (*GameRoom, error) {
+	room := newGameRoom(rec.ID, rec.Options)
+	room.CreatedAt = rec.CreatedAt
+	seed := room.GameState.DiceSeed
+	room.GameState = rec.GameState
+	if rec.DiceSeed != nil {
+		seed = *rec.DiceSeed
+	}
+	room.GameState.DiceSeed, room.GameState.DiceRolls = seed, rec.DiceRolls
+	room.GameState.DiceHistory = rec.DiceHistory
+	room.dice = game.NewSeededRoller(seed, rec.DiceRolls)
+	room.seq = rec.Seq
+	room.actionLog = rec.ActionLog
+	room.tournament = rec.Tournament
+	for name, prefs := range rec.Preferences {
+		room.preferences[name] = prefs
+	}
+	room.sessions = rec.Sessions
+	room.inviteToken = rec.InviteToken
+	room.password = rec.Password
+	for name, id := range rec.PlayerIDs {
+		room.playerIDs[name] = id
+	}
+	// Carry on the event log where it left off, diffing against the
+	// state it describes.
+	var err error
+	if room.log, err = store.LoadLog(rec.ID); err != nil {
+		room.abandon()
+		return nil, err
+	}
+	replay := newLogReplay(newLogRules(room.Options, seed))
+	for _, entry := range room.log {
+		if _, _, err = replay.apply(entry); err != nil {
+			break
+		}
+	}
+	if err == nil && room.log != nil {
+		room.logState = replay.flat
+	} else if err != nil {
+		room.logger().Error("replaying event log", "err", err)
+	}
+	room.trimLog()
+
+	room.do(func() {
+		room.GameState.TurnDeadline = nil
+		room.GameState.ClockDeadline = nil
+		if room.GameState.Phase == "" {
+			room.GameState.Phase = game.InferPhase(&room.GameState)
+		}
+		room.GameState.TurnTimeLeft = 0
+		for name, p := range room.GameState.Players {
+			p.Connected = false
+			if p.Bot {
+				room.bots[name] = strategies[defaultStrategy]()
+			}
+		}
+		if rec.SavedAt != nil {
+			// A saved game waits, paused, for its players to come back.
+			room.savedAt = *rec.SavedAt
+			room.awaitingPlayers = true
+			room.GameState.Paused = true
+			room.GameState.PausedBy = ""
+		} else if room.GameState.Status == StatusInProgress {
+			for _, name := range room.GameState.TurnOrder {
+				if !room.GameState.Players[name].Bot {
+					room.startGrace(name)
+				}
+			}
+			if p, ok := room.GameState.Players[room.GameState.Turn]; ok && p.Bot {
+				room.scheduleBotTurn()
+			}
+			room.lastMove = time.Now()
+			room.checkAbandoned()
+		}
+		room.scheduleEmptyCheck()
+		room.publishListing()
+	})
+	return room, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// testRecord is a room in the middle of a game, with everything a record
+// carries set.
+func testRecord(id string) *RoomRecord {
+	now := time.Now().UTC().Truncate(time.Second)
+	seed := int64(42)
+	return &RoomRecord{
+		ID:        id,
+		Options:   defaultRoomOptions(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		GameState: GameState{
+			Status: StatusInProgress,
+			Host:   "ann",
+			Players: map[string]*Player{
+				"ann": {Name: "ann", Balance: 1400, Position: 6, Properties: []string{"Oriental Avenue", "Baltic Avenue"}, Mortgaged: []string{"Baltic Avenue"}, Connected: true},
+				"bob": {Name: "bob", Balance: 1600, Position: 10, JailTurns: 2, RentCollected: 6},
+			},
+			TurnOrder:       []string{"ann", "bob"},
+			Turn:            "bob",
+			Phase:           game.PhaseAwaitingRoll,
+			MortgageChoices: []game.MortgageChoice{{Player: "ann", Properties: []string{"Baltic Avenue"}}},
+			Turns:           4,
+			DiceSeed:        seed,
+			DiceRolls:       3,
+		},
+		Seq:         17,
+		Sessions:    map[string]string{"ann": "a-token", "bob": "b-token"},
+		InviteToken: "invite",
+		PlayerIDs:   map[string]string{"ann": "player-1"},
+		DiceSeed:    &seed,
+		DiceRolls:   3,
+		DiceHistory: []game.DiceRoll{{Player: "ann", Turn: 3, Dice: [2]int{2, 4}}},
+		ActionLog:   []ActionLogEntry{{Seq: 12, Key: "roll", Params: map[string]interface{}{"player": "ann"}, Text: "ann rolled 6"}},
+	}
+}
+
+// TestStoreRoundTrip saves rooms, logs and summaries to each persistent
+// store and checks they come back as they went in.
+func TestStoreRoundTrip(t *testing.T) {
+	for name, open := range map[string]func(t *testing.T) Store{
+		"file": func(t *testing.T) Store {
+			s, err := newFileStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"sqlite": func(t *testing.T) Store {
+			s, err := newSQLStore("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+
+			rec := testRecord("ROOM01")
+			if err := s.SaveRoom(rec); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SaveRoom(testRecord("ROOM02")); err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.LoadRoom(rec.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := json.Marshal(rec)
+			if data, _ := json.Marshal(got); string(data) != string(want) {
+				t.Errorf("loaded\n%s\nsaved\n%s", data, want)
+			}
+			if missing, err := s.LoadRoom("NOSUCH"); missing != nil || err != nil {
+				t.Errorf("loaded a room that was never saved: %v, %v", missing, err)
+			}
+
+			// A finished game is kept, but isn't brought back on startup.
+			rec.GameState.Status = StatusFinished
+			if err := s.SaveRoom(rec); err != nil {
+				t.Fatal(err)
+			}
+			rooms, err := s.LoadRooms()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rooms) != 1 || rooms[0].ID != "ROOM02" {
+				t.Errorf("unfinished rooms: %v", rooms)
+			}
+			if got, err := s.LoadRoom(rec.ID); err != nil || got == nil || !got.Finished() {
+				t.Errorf("finished room: %v, %v", got, err)
+			}
+
+			entries := []LogEntry{
+				{Seq: 1, At: rec.CreatedAt, Actor: "ann", Event: "ROLL_DICE", Payload: json.RawMessage(`{"diceRoll":6}`)},
+				{Seq: 2, At: rec.CreatedAt, Event: "END_TURN", Diff: map[string]json.RawMessage{"turn": json.RawMessage(`"bob"`)}},
+			}
+			if err := s.AppendLog(rec.ID, entries[:1]); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.AppendLog(rec.ID, entries[1:]); err != nil {
+				t.Fatal(err)
+			}
+			log, err := s.LoadLog(rec.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, wantLog := mustMarshal(t, log), mustMarshal(t, entries); string(data) != string(wantLog) {
+				t.Errorf("log %s, want %s", data, wantLog)
+			}
+
+			sum := &GameSummary{
+				ID:         rec.ID,
+				Winner:     "ann",
+				Players:    []PlayerSummary{{Name: "ann", PlayerID: "player-1", NetWorth: 2000, Place: 1, RentCollected: 6}, {Name: "bob", Place: 2}},
+				StartedAt:  rec.CreatedAt,
+				FinishedAt: rec.CreatedAt.Add(time.Hour),
+				Turns:      4,
+			}
+			if err := s.SaveSummary(sum); err != nil {
+				t.Fatal(err)
+			}
+			sums, total, err := s.ListSummaries("bob", 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != 1 || len(sums) != 1 || !reflect.DeepEqual(sums[0], sum) {
+				t.Errorf("summaries %d: %+v", total, sums)
+			}
+			stats, err := s.LoadPlayerStats("player-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats == nil || stats.GamesPlayed != 1 || stats.Wins != 1 || stats.RentCollected != 6 {
+				t.Errorf("stats %+v", stats)
+			}
+
+			if err := s.DeleteRoom(rec.ID); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := s.LoadRoom(rec.ID); got != nil || err != nil {
+				t.Errorf("deleted room loaded: %v, %v", got, err)
+			}
+			if log, err := s.LoadLog(rec.ID); log != nil || err != nil {
+				t.Errorf("deleted room's log loaded: %v, %v", log, err)
+			}
+		})
+	}
+}
+
+// mustMarshal returns v as JSON.
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The server speaks plain HTTP unless -tls-cert and -tls-key are given, in
+// which case it serves HTTPS and wss:// on -listen. The pair is read again
+// when the certificate file changes, so certificates renewed in place, by
+// certbot for instance, are picked up without a restart. -redirect-listen
+// adds a plain HTTP listener, usually :80, that sends everything to HTTPS
+// except the health checks, which it answers itself so load balancers can
+// keep probing over plain HTTP.
+
+// certCheckInterval is how often the certificate file is checked for a
+// renewal.
+const certCheckInterval = time.Minute
+
+// certReloader serves the certificate pair in certFile and keyFile,
+// reloading it when certFile changes.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// newCertReloader loads the pair, failing if it can't be used.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the pair from disk. It must be called with c.mu held, or
+// before c is shared.
+func (c *certReloader) load() error {
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modTime, c.checked = &cert, info.ModTime(), time.Now()
+	return nil
+}
+
+// getCertificate is the server's tls.Config.GetCertificate. A renewal that
+// can't be loaded is logged and the old certificate kept.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) >= certCheckInterval {
+		c.checked = time.Now()
+		if info, err := os.Stat(c.certFile); err == nil && !info.ModTime().Equal(c.modTime) {
+			if err := c.load(); err != nil {
+				slog.Error("reloading TLS certificate", "cert", c.certFile, "err", err)
+			} else {
+				slog.Info("TLS certificate reloaded", "cert", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// tlsConfig returns the TLS configuration for cfg, or nil if it doesn't
+// use TLS.
+func tlsConfig(cfg *Config) (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		return nil, nil
+	}
+	certs, err := newCertReloader(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.getCertificate}, nil
+}
+
+// redirectServer returns the plain HTTP server for -redirect-listen, which
+// sends requests to the same host and path over HTTPS on the port of
+// -listen.
+func redirectServer(cfg *Config) *http.Server {
+	_, port, _ := net.SplitHostPort(cfg.Listen)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+	return &http.Server{Addr: cfg.RedirectListen, Handler: mux, ReadHeaderTimeout: cfg.ReadHeaderTimeout, ReadTimeout: cfg.ReadTimeout}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for commonName, and its key,
+// to certFile and keyFile.
+func writeCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedName is the common name of the certificate c serves now.
+func servedName(t *testing.T, c *certReloader) string {
+	t.Helper()
+	cert, err := c.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+// renew rewrites certFile's timestamp to a new one and makes c check it
+// on its next handshake.
+func renew(t *testing.T, c *certReloader, at time.Time) {
+	t.Helper()
+	if err := os.Chtimes(c.certFile, at, at); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	c.checked = time.Now().Add(-certCheckInterval)
+	c.mu.Unlock()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Fatal("loaded a missing certificate")
+	}
+	writeCert(t, certFile, keyFile, "first")
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, c); name != "first" {
+		t.Fatalf("serving %q", name)
+	}
+
+	// A renewal isn't noticed until the next check is due.
+	writeCert(t, certFile, keyFile, "second")
+	if err := os.Chtimes(certFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, c); name != "first" {
+		t.Errorf("reloaded before the check interval: serving %q", name)
+	}
+	renew(t, c, time.Now().Add(2*time.Minute))
+	if name := servedName(t, c); name != "second" {
+		t.Errorf("renewal not picked up: serving %q", name)
+	}
+
+	// A renewal that can't be loaded leaves the old pair in place.
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	renew(t, c, time.Now().Add(3*time.Minute))
+	if name := servedName(t, c); name != "second" {
+		t.Errorf("broken renewal replaced the certificate: serving %q", name)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	cfg := newConfig()
+	if tc, err := tlsConfig(cfg); tc != nil || err != nil {
+		t.Errorf("TLS without a certificate: %v, %v", tc, err)
+	}
+	dir := t.TempDir()
+	cfg.TLSCert, cfg.TLSKey = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, cfg.TLSCert, cfg.TLSKey, "server")
+	tc, err := tlsConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.GetCertificate == nil {
+		t.Error("no certificate callback")
+	}
+	if cfg.ReadHeaderTimeout <= 0 {
+		t.Errorf("read header timeout defaults to %v", cfg.ReadHeaderTimeout)
+	}
+	if srv := redirectServer(cfg); srv.ReadHeaderTimeout != cfg.ReadHeaderTimeout {
+		t.Errorf("redirect server reads headers for %v", srv.ReadHeaderTimeout)
+	}
+}
@@ -0,0 +1,818 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// A tournament is a series of rounds played by a fixed list of
+// participants. POST /api/tournaments creates one and answers with an
+// organizer token, which is needed to void a round. Each round the
+// participants are shuffled and split into tables of at most tableSize,
+// each a room of its own that only the players seated at it may join, by
+// name; with access tokens, names are verified, so only they can. A table
+// plays as any other room does, and once the round's -tournament-no-show
+// has passed a table still waiting is started with whoever is there, or
+// settled without a game if that is fewer than a game needs. Seated
+// players who never joined are no-shows and score nothing.
+//
+// When a table's game is over its players are placed: the winner first,
+// then those still playing and then those who dropped out, each by net
+// worth. Scoring by position gives each place the points the tournament
+// was created with; scoring by net worth gives each player their final net
+// worth. Once every table of a round has a result the next round starts,
+// and after the last the tournament is finished. GET /api/tournaments/{id}
+// shows the schedule and results, and GET /api/tournaments/{id}/standings
+// the totals so far.
+//
+// A table whose room closed before its game finished holds its round up.
+// The organizer, or an administrator, can void the latest round with
+// POST /api/tournaments/{id}/rounds/{round}/replay: its results are thrown
+// away, any of its games still going are closed, and it is played again
+// with the same tables. Tournaments belong to the instance they were
+// created on; without a cluster they are restored after a restart.
+
+const (
+	maxTournamentParticipants = 256
+	maxTournamentRounds       = 20
+	maxTournamentNameLength   = 100
+	maxTournamentRequestSize  = 64 << 10
+	// tournamentRetry is how long to wait before trying again to set up a
+	// round whose rooms couldn't be had.
+	tournamentRetry = time.Minute
+)
+
+// Tournament statuses.
+const (
+	TournamentRunning  = "running"
+	TournamentFinished = "finished"
+)
+
+// Scoring modes.
+const (
+	ScoreByPosition = "position"
+	ScoreByNetWorth = "netWorth"
+)
+
+// defaultTournamentPoints are the points for first, second and so on when
+// scoring by position, unless the tournament says otherwise. Places past
+// the end score nothing.
+var defaultTournamentPoints = []int{10, 6, 3, 1}
+
+var (
+	errTournamentParticipants = fmt.Errorf("a tournament needs between 2 and %d participants, each named once", maxTournamentParticipants)
+	errTournamentRounds       = fmt.Errorf("rounds must be between 1 and %d", maxTournamentRounds)
+	errTournamentName         = errors.New("name is too long")
+	errTournamentScoring      = errors.New(`scoring mode must be "position" or "netWorth", and points, which only position scoring takes, must not be negative`)
+	errTournamentTables       = errors.New("the participants can't be split into tables of at least two")
+	errNotLatestRound         = errors.New("only the latest round can be replayed")
+)
+
+// TournamentScoring says how a table's result turns into points. Points
+// are for first, second and so on, when scoring by position.
+type TournamentScoring struct {
+	Mode   string `json:"mode"`
+	Points []int  `json:"points,omitempty"`
+}
+
+// TournamentRequest creates a tournament. TableSize is the most players
+// seated at one table, by default as many as matchmaking seats.
+type TournamentRequest struct {
+	Name         string            `json:"name"`
+	Participants []string          `json:"participants"`
+	Rounds       int               `json:"rounds"`
+	TableSize    int               `json:"tableSize,omitempty"`
+	Scoring      TournamentScoring `json:"scoring"`
+}
+
+// Tournament is a tournament as it stands: its rules, and every round set
+// up so far.
+type Tournament struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name,omitempty"`
+	Participants []string          `json:"participants"`
+	Rounds       int               `json:"rounds"`
+	TableSize    int               `json:"tableSize"`
+	Scoring      TournamentScoring `json:"scoring"`
+	Status       string            `json:"status"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	Schedule     []TournamentRound `json:"schedule"`
+}
+
+// TournamentRound is one round's tables. NoShowBy is when tables still
+// waiting are started without the players who haven't joined. Replays
+// counts how often the round was voided and played again.
+type TournamentRound struct {
+	Round     int               `json:"round"`
+	StartedAt time.Time         `json:"startedAt"`
+	NoShowBy  time.Time         `json:"noShowBy"`
+	Replays   int               `json:"replays,omitempty"`
+	Tables    []TournamentTable `json:"tables"`
+}
+
+// TournamentTable is one room in a round and the players seated at it.
+// Done is set once it has a result; Unplayed marks a table whose room
+// closed before its game finished, which waits for the round to be
+// replayed.
+type TournamentTable struct {
+	GameID   string        `json:"gameId"`
+	Players  []string      `json:"players"`
+	Done     bool          `json:"done"`
+	Unplayed bool          `json:"unplayed,omitempty"`
+	Results  []TableResult `json:"results,omitempty"`
+}
+
+// TableResult is how one player did at a table. No-shows have no place.
+type TableResult struct {
+	Player    string `json:"player"`
+	Place     int    `json:"place,omitempty"`
+	NetWorth  int    `json:"netWorth"`
+	Points    int    `json:"points"`
+	Forfeited bool   `json:"forfeited,omitempty"`
+	NoShow    bool   `json:"noShow,omitempty"`
+}
+
+// TournamentRecord is a tournament as the store keeps it, and as its
+// organizer is first given it.
+type TournamentRecord struct {
+	Tournament
+	OrganizerToken string `json:"organizerToken"`
+}
+
+// TournamentStanding is one participant's totals. Participants with the
+// same points, wins and net worth share a rank.
+type TournamentStanding struct {
+	Rank     int    `json:"rank"`
+	Player   string `json:"player"`
+	Points   int    `json:"points"`
+	Played   int    `json:"played"`
+	Wins     int    `json:"wins"`
+	NoShows  int    `json:"noShows"`
+	NetWorth int    `json:"netWorth"`
+}
+
+type StandingsResponse struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"`
+	// RoundsPlayed counts the rounds every table of which has a result.
+	RoundsPlayed int                  `json:"roundsPlayed"`
+	Rounds       int                  `json:"rounds"`
+	Standings    []TournamentStanding `json:"standings"`
+}
+
+// tournamentSeat ties a room to the tournament table it was set up for.
+// reported is set once the table's result, or its lack of one, has been
+// passed on.
+type tournamentSeat struct {
+	ID       string   `json:"id"`
+	Round    int      `json:"round"`
+	Players  []string `json:"players"`
+	reported bool
+}
+
+// seated reports whether name has a seat at the table.
+func (s *tournamentSeat) seated(name string) bool {
+	for _, p := range s.Players {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tournamentEntry is a tournament held on this instance. rec is guarded
+// by mu. roundMu is held while a round is set up, so rounds are started
+// and replayed one at a time, and saveMu orders writes to the store.
+type tournamentEntry struct {
+	mu       sync.Mutex
+	rec      TournamentRecord
+	deadline *time.Timer
+	roundMu  sync.Mutex
+	saveMu   sync.Mutex
+}
+
+// tournamentRegistry holds this instance's tournaments.
+type tournamentRegistry struct {
+	mu   sync.RWMutex
+	byID map[string]*tournamentEntry
+}
+
+var tournaments = &tournamentRegistry{byID: make(map[string]*tournamentEntry)}
+
+func (r *tournamentRegistry) get(id string) (*tournamentEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.byID[id]
+	return t, ok
+}
+
+func (r *tournamentRegistry) add(t *tournamentEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byID[t.rec.ID] = t
+}
+
+// validate checks req, filling in its defaults.
+func (req *TournamentRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if utf8.RuneCountInString(req.Name) > maxTournamentNameLength {
+		return errTournamentName
+	}
+	if len(req.Participants) < defaultMinPlayers || len(req.Participants) > maxTournamentParticipants {
+		return errTournamentParticipants
+	}
+	seen := make(map[string]bool, len(req.Participants))
+	for i, p := range req.Participants {
+		name, err := validateName(p)
+		if err != nil {
+			return fmt.Errorf("participant %q: %w", p, err)
+		}
+		if seen[name] {
+			return errTournamentParticipants
+		}
+		seen[name] = true
+		req.Participants[i] = name
+	}
+	if req.Rounds < 1 || req.Rounds > maxTournamentRounds {
+		return errTournamentRounds
+	}
+	if req.TableSize == 0 {
+		req.TableSize = min(defaultMatchSize, hub.config.MaxPlayers)
+	}
+	if req.TableSize < defaultMinPlayers || req.TableSize > hub.config.MaxPlayers {
+		return fmt.Errorf("tableSize must be between %d and %d", defaultMinPlayers, hub.config.MaxPlayers)
+	}
+	if tables := seatTables(req.Participants, req.TableSize); len(tables[len(tables)-1]) < defaultMinPlayers {
+		return errTournamentTables
+	}
+	switch req.Scoring.Mode {
+	case "", ScoreByPosition:
+		req.Scoring.Mode = ScoreByPosition
+		if req.Scoring.Points == nil {
+			req.Scoring.Points = defaultTournamentPoints
+		}
+		for _, p := range req.Scoring.Points {
+			if p < 0 {
+				return errTournamentScoring
+			}
+		}
+	case ScoreByNetWorth:
+		if req.Scoring.Points != nil {
+			return errTournamentScoring
+		}
+	default:
+		return errTournamentScoring
+	}
+	return nil
+}
+
+// seatTables splits players, in order, into as few tables of at most size
+// as will hold them, as evenly as can be; the last table is the smallest.
+func seatTables(players []string, size int) [][]string {
+	n := (len(players) + size - 1) / size
+	tables := make([][]string, 0, n)
+	for i := 0; i < n; i++ {
+		from, to := i*len(players)/n, (i+1)*len(players)/n
+		tables = append(tables, players[from:to:to])
+	}
+	sort.SliceStable(tables, func(i, j int) bool { return len(tables[i]) > len(tables[j]) })
+	return tables
+}
+
+// createTournament sets up a tournament for req, which must be valid, and
+// its first round.
+func createTournament(req TournamentRequest) (*tournamentEntry, error) {
+	t := &tournamentEntry{rec: TournamentRecord{
+		Tournament: Tournament{
+			ID:           newSessionToken()[:16],
+			Name:         req.Name,
+			Participants: req.Participants,
+			Rounds:       req.Rounds,
+			TableSize:    req.TableSize,
+			Scoring:      req.Scoring,
+			Status:       TournamentRunning,
+			CreatedAt:    time.Now(),
+			Schedule:     []TournamentRound{},
+		},
+		OrganizerToken: newSessionToken(),
+	}}
+	if err := t.startRound(1, 0, nil); err != nil {
+		return nil, err
+	}
+	tournaments.add(t)
+	t.save()
+	return t, nil
+}
+
+// logger returns a logger that tags its records with the tournament.
+func (t *tournamentEntry) logger() *slog.Logger {
+	return slog.With("tournament", t.rec.ID)
+}
+
+// view returns a copy of the tournament as it stands, safe to encode
+// without the lock.
+func (t *tournamentEntry) view() *Tournament {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var view Tournament
+	data, _ := json.Marshal(&t.rec.Tournament)
+	json.Unmarshal(data, &view)
+	return &view
+}
+
+// save writes the tournament to the store.
+func (t *tournamentEntry) save() {
+	t.saveMu.Lock()
+	defer t.saveMu.Unlock()
+	t.mu.Lock()
+	var rec TournamentRecord
+	data, _ := json.Marshal(&t.rec)
+	t.mu.Unlock()
+	json.Unmarshal(data, &rec)
+	if err := store.SaveTournament(&rec); err != nil {
+		t.logger().Error("saving tournament", "err", err)
+	}
+}
+
+// startRound sets up round, as its replays'th replay, with a room for each
+// of tables; with no tables the participants are shuffled into new ones.
+// The rooms are created before the round is added to the schedule, so
+// nobody can find them until all of them are ready.
+func (t *tournamentEntry) startRound(round int, replays int, tables [][]string) error {
+	t.mu.Lock()
+	id, size := t.rec.ID, t.rec.TableSize
+	participants := append([]string(nil), t.rec.Participants...)
+	t.mu.Unlock()
+	if tables == nil {
+		rand.Shuffle(len(participants), func(i, j int) { participants[i], participants[j] = participants[j], participants[i] })
+		tables = seatTables(participants, size)
+	}
+
+	now := time.Now()
+	next := TournamentRound{Round: round, StartedAt: now, NoShowBy: now.Add(hub.config.TournamentNoShow), Replays: replays}
+	var rooms []*GameRoom
+	for _, players := range tables {
+		opts := defaultRoomOptions()
+		opts.MaxPlayers = max(len(players), opts.MinPlayers)
+		room, err := createCodedRoom(opts)
+		if err != nil {
+			for _, room := range rooms {
+				room.do(func() { hub.closeRoom(room, "the tournament round couldn't be set up") })
+			}
+			return err
+		}
+		room.do(func() {
+			room.tournament = &tournamentSeat{ID: id, Round: round, Players: players}
+			// The table waits for its players until the no-show deadline
+			// rather than for -empty-room-grace.
+			room.cancelEmptyCheck()
+			room.markDirty()
+			room.logger().Info("room created for a tournament", "tournament", id, "round", round, "players", len(players))
+		})
+		rooms = append(rooms, room)
+		next.Tables = append(next.Tables, TournamentTable{GameID: room.ID, Players: players})
+	}
+
+	t.mu.Lock()
+	if round > len(t.rec.Schedule) {
+		t.rec.Schedule = append(t.rec.Schedule, next)
+	} else {
+		t.rec.Schedule[round-1] = next
+	}
+	t.rec.Status = TournamentRunning
+	t.armDeadline()
+	t.mu.Unlock()
+	t.logger().Info("tournament round started", "round", round, "tables", len(tables), "replays", replays)
+	return nil
+}
+
+// armDeadline sets the no-show timer for the latest round. The caller must
+// hold the lock.
+func (t *tournamentEntry) armDeadline() {
+	if t.deadline != nil {
+		t.deadline.Stop()
+	}
+	current := t.rec.Schedule[len(t.rec.Schedule)-1]
+	round, replays := current.Round, current.Replays
+	t.deadline = time.AfterFunc(time.Until(current.NoShowBy), func() { t.noShowDeadline(round, replays) })
+}
+
+// noShowDeadline has every table of the round that is still waiting start
+// without whoever hasn't joined. A round voided since is left alone.
+func (t *tournamentEntry) noShowDeadline(round int, replays int) {
+	t.mu.Lock()
+	var waiting []string
+	if current := t.rec.Schedule[len(t.rec.Schedule)-1]; current.Round == round && current.Replays == replays {
+		for _, table := range current.Tables {
+			if !table.Done && !table.Unplayed {
+				waiting = append(waiting, table.GameID)
+			}
+		}
+	}
+	t.mu.Unlock()
+	for _, id := range waiting {
+		hub.Mutex.RLock()
+		room, ok := hub.lookup(id)
+		hub.Mutex.RUnlock()
+		if ok {
+			room.do(room.startTournamentTable)
+		}
+	}
+}
+
+// startTournamentTable starts the room's tournament game with the players
+// who have joined, if it is still waiting for the rest. If too few have
+// joined for a game, the table is settled without one and the room
+// closed. It must run on the room's goroutine.
+func (room *GameRoom) startTournamentTable() {
+	if room.tournament == nil || room.GameState.Status != StatusWaiting {
+		return
+	}
+	absent := len(room.tournament.Players) - len(room.GameState.TurnOrder)
+	if len(room.GameState.TurnOrder) < room.Options.MinPlayers {
+		room.logger().Info("tournament table settled without a game", "absent", absent)
+		winner := ""
+		if len(room.GameState.TurnOrder) > 0 {
+			winner = room.GameState.TurnOrder[0]
+		}
+		room.reportTournamentResult(winner)
+		hub.closeRoom(room, "not enough players turned up for the tournament game")
+		return
+	}
+	for _, name := range room.GameState.TurnOrder {
+		room.GameState.Players[name].Ready = true
+	}
+	room.logger().Info("tournament table started at the no-show deadline", "absent", absent)
+	room.startGame()
+}
+
+// tableResults places the table's players after a game won by winner, or
+// settled without one. It must run on the room's goroutine.
+func (room *GameRoom) tableResults(winner string) []TableResult {
+	results := make([]TableResult, 0, len(room.tournament.Players))
+	for _, name := range room.tournament.Players {
+		p, ok := room.GameState.Players[name]
+		if !ok {
+			results = append(results, TableResult{Player: name, NoShow: true})
+			continue
+		}
+		results = append(results, TableResult{Player: name, NetWorth: game.NetWorth(room.board, p), Forfeited: p.Forfeited})
+	}
+	tier := func(r TableResult) int {
+		switch {
+		case r.NoShow:
+			return 3
+		case r.Forfeited:
+			return 2
+		case r.Player == winner:
+			return 0
+		}
+		return 1
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if tier(a) != tier(b) {
+			return tier(a) < tier(b)
+		}
+		if a.NetWorth != b.NetWorth {
+			return a.NetWorth > b.NetWorth
+		}
+		return a.Player < b.Player
+	})
+	for i := range results {
+		if !results[i].NoShow {
+			results[i].Place = i + 1
+		}
+	}
+	return results
+}
+
+// reportTournamentResult passes the table's result on to its tournament,
+// once. It must run on the room's goroutine.
+func (room *GameRoom) reportTournamentResult(winner string) {
+	seat := room.tournament
+	if seat == nil || seat.reported {
+		return
+	}
+	seat.reported = true
+	results := room.tableResults(winner)
+	pendingWrites.Add(1)
+	go func() {
+		defer pendingWrites.Done()
+		tournaments.report(seat.ID, room.ID, results)
+	}()
+}
+
+// reportTournamentUnplayed tells the room's tournament its table closed
+// without a result. Rooms put aside to come back later, saved or handed
+// off, aren't reported. It must run on the room's goroutine, as the room
+// closes.
+func (room *GameRoom) reportTournamentUnplayed() {
+	seat := room.tournament
+	if seat == nil || seat.reported || room.handedOff || !room.savedAt.IsZero() || room.GameState.Status == StatusFinished {
+		return
+	}
+	seat.reported = true
+	pendingWrites.Add(1)
+	go func() {
+		defer pendingWrites.Done()
+		tournaments.report(seat.ID, room.ID, nil)
+	}()
+}
+
+// report records the result of the table played in room gameID, or with
+// none that it went unplayed, and starts the next round if that was the
+// last table. Tables no longer in the schedule, from a voided round, are
+// ignored.
+func (r *tournamentRegistry) report(id string, gameID string, results []TableResult) {
+	t, ok := r.get(id)
+	if !ok {
+		slog.Warn("tournament result for an unknown tournament", "tournament", id, "gameId", gameID)
+		return
+	}
+	t.mu.Lock()
+	current := &t.rec.Schedule[len(t.rec.Schedule)-1]
+	var table *TournamentTable
+	for i := range current.Tables {
+		if current.Tables[i].GameID == gameID {
+			table = &current.Tables[i]
+		}
+	}
+	if table == nil || table.Done || table.Unplayed {
+		t.mu.Unlock()
+		return
+	}
+	if results == nil {
+		table.Unplayed = true
+		t.mu.Unlock()
+		t.logger().Warn("tournament table closed without a result; the round must be replayed", "round", current.Round, "gameId", gameID)
+		t.save()
+		return
+	}
+	for i := range results {
+		results[i].Points = t.rec.Scoring.points(results[i])
+	}
+	table.Results, table.Done = results, true
+	complete := true
+	for _, other := range current.Tables {
+		complete = complete && other.Done
+	}
+	round := current.Round
+	if complete && round == t.rec.Rounds {
+		t.rec.Status = TournamentFinished
+		if t.deadline != nil {
+			t.deadline.Stop()
+		}
+	}
+	t.mu.Unlock()
+	t.logger().Info("tournament table finished", "round", round, "gameId", gameID)
+	if complete && round < t.rec.Rounds {
+		t.advance(round + 1)
+	} else if complete {
+		t.logger().Info("tournament finished")
+	}
+	t.save()
+}
+
+// advance starts round, trying again later if its rooms can't be had. It
+// does nothing unless the round before is the latest and complete, as it
+// may not be if it was voided in the meantime.
+func (t *tournamentEntry) advance(round int) {
+	t.roundMu.Lock()
+	defer t.roundMu.Unlock()
+	t.mu.Lock()
+	ready := len(t.rec.Schedule) == round-1
+	for _, table := range t.rec.Schedule[len(t.rec.Schedule)-1].Tables {
+		ready = ready && table.Done
+	}
+	t.mu.Unlock()
+	if !ready {
+		return
+	}
+	if err := t.startRound(round, 0, nil); err != nil {
+		t.logger().Error("starting tournament round", "round", round, "err", err)
+		time.AfterFunc(tournamentRetry, func() {
+			t.advance(round)
+			t.save()
+		})
+	}
+}
+
+// points is what a result scores.
+func (s TournamentScoring) points(r TableResult) int {
+	switch {
+	case r.NoShow:
+		return 0
+	case s.Mode == ScoreByNetWorth:
+		return r.NetWorth
+	case r.Place <= len(s.Points):
+		return s.Points[r.Place-1]
+	}
+	return 0
+}
+
+// standings adds up the results so far. The caller must hold the lock.
+func (t *tournamentEntry) standings() StandingsResponse {
+	resp := StandingsResponse{ID: t.rec.ID, Name: t.rec.Name, Status: t.rec.Status, Rounds: t.rec.Rounds}
+	totals := make(map[string]*TournamentStanding, len(t.rec.Participants))
+	for _, name := range t.rec.Participants {
+		totals[name] = &TournamentStanding{Player: name}
+	}
+	for _, round := range t.rec.Schedule {
+		complete := true
+		for _, table := range round.Tables {
+			complete = complete && table.Done
+			for _, r := range table.Results {
+				s := totals[r.Player]
+				s.Points += r.Points
+				s.NetWorth += r.NetWorth
+				if r.NoShow {
+					s.NoShows++
+					continue
+				}
+				s.Played++
+				if r.Place == 1 {
+					s.Wins++
+				}
+			}
+		}
+		if complete {
+			resp.RoundsPlayed++
+		}
+	}
+	resp.Standings = make([]TournamentStanding, 0, len(totals))
+	for _, s := range totals {
+		resp.Standings = append(resp.Standings, *s)
+	}
+	sort.Slice(resp.Standings, func(i, j int) bool {
+		a, b := resp.Standings[i], resp.Standings[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if a.NetWorth != b.NetWorth {
+			return a.NetWorth > b.NetWorth
+		}
+		return a.Player < b.Player
+	})
+	for i := range resp.Standings {
+		s := &resp.Standings[i]
+		s.Rank = i + 1
+		if prev := resp.Standings[max(i-1, 0)]; i > 0 && prev.Points == s.Points && prev.Wins == s.Wins && prev.NetWorth == s.NetWorth {
+			s.Rank = prev.Rank
+		}
+	}
+	return resp
+}
+
+// replay voids the latest round, which must be round, and plays it again
+// at the same tables. Its rooms still open are closed once the new ones
+// have taken their place.
+func (t *tournamentEntry) replay(round int) error {
+	t.roundMu.Lock()
+	defer t.roundMu.Unlock()
+	t.mu.Lock()
+	current := t.rec.Schedule[len(t.rec.Schedule)-1]
+	t.mu.Unlock()
+	if current.Round != round {
+		return errNotLatestRound
+	}
+	tables := make([][]string, len(current.Tables))
+	for i, table := range current.Tables {
+		tables[i] = table.Players
+	}
+	if err := t.startRound(round, current.Replays+1, tables); err != nil {
+		return err
+	}
+	for _, table := range current.Tables {
+		hub.Mutex.RLock()
+		room, ok := hub.lookup(table.GameID)
+		hub.Mutex.RUnlock()
+		if ok {
+			room.do(func() { hub.closeRoom(room, "the tournament round was voided") })
+		}
+	}
+	t.save()
+	return nil
+}
+
+// restoreTournaments loads the tournaments kept in the store. The no-show
+// deadline of a round still running is set again, and acted on at once if
+// it has passed.
+func restoreTournaments() error {
+	recs, err := store.LoadTournaments()
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		t := &tournamentEntry{rec: *rec}
+		if t.rec.Status == TournamentRunning && len(t.rec.Schedule) > 0 {
+			t.mu.Lock()
+			t.armDeadline()
+			t.mu.Unlock()
+		}
+		tournaments.add(t)
+	}
+	return nil
+}
+
+// handleCreateTournament creates a tournament and starts its first round.
+// The organizer token is only ever given here.
+func handleCreateTournament(w http.ResponseWriter, r *http.Request) {
+	if refuseWhileShuttingDown(w) {
+		return
+	}
+	var req TournamentRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTournamentRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid tournament: "+err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t, err := createTournament(req)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	t.logger().Info("tournament created", "participants", len(req.Participants), "rounds", req.Rounds, "remote", clientIP(r))
+	writeJSON(w, http.StatusCreated, TournamentRecord{Tournament: *t.view(), OrganizerToken: t.rec.OrganizerToken})
+}
+
+// tournamentLookup finds the tournament named in the path, answering 404
+// if there is none.
+func tournamentLookup(w http.ResponseWriter, r *http.Request) *tournamentEntry {
+	t, ok := tournaments.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "tournament not found")
+		return nil
+	}
+	return t
+}
+
+// handleGetTournament serves a tournament's schedule and results.
+func handleGetTournament(w http.ResponseWriter, r *http.Request) {
+	if t := tournamentLookup(w, r); t != nil {
+		writeJSON(w, http.StatusOK, t.view())
+	}
+}
+
+// handleTournamentStandings serves a tournament's standings so far.
+func handleTournamentStandings(w http.ResponseWriter, r *http.Request) {
+	t := tournamentLookup(w, r)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	resp := t.standings()
+	t.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleReplayTournamentRound voids a round and plays it again. It takes
+// the organizer token, or the admin token, as a bearer token.
+func handleReplayTournamentRound(w http.ResponseWriter, r *http.Request) {
+	t := tournamentLookup(w, r)
+	if t == nil {
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(t.rec.OrganizerToken)) != 1 && !isAdmin(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="tournament"`)
+		writeError(w, http.StatusUnauthorized, "the organizer token is required")
+		return
+	}
+	round, err := strconv.Atoi(r.PathValue("round"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "round must be a number")
+		return
+	}
+	if err := t.replay(round); errors.Is(err, errNotLatestRound) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	t.logger().Warn("tournament round voided and replayed", "round", round, "remote", clientIP(r))
+	writeJSON(w, http.StatusOK, t.view())
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postTournament creates a tournament from req over the API and returns
+// the response.
+func (ts *testServer) postTournament(req interface{}) *http.Response {
+	ts.t.Helper()
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(ts.srv.URL+"/api/tournaments", "application/json", bytes.NewReader(body))
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return resp
+}
+
+// createTournament creates a tournament from req and returns it as its
+// organizer is given it.
+func (ts *testServer) createTournament(req TournamentRequest) TournamentRecord {
+	ts.t.Helper()
+	resp := ts.postTournament(req)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		ts.t.Fatalf("creating tournament: %s", resp.Status)
+	}
+	var rec TournamentRecord
+	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil {
+		ts.t.Fatal(err)
+	}
+	return rec
+}
+
+// getJSON fetches path and decodes it into v.
+func (ts *testServer) getJSON(path string, v interface{}) {
+	ts.t.Helper()
+	resp, err := http.Get(ts.srv.URL + path)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		ts.t.Fatalf("GET %s: %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		ts.t.Fatal(err)
+	}
+}
+
+// tournamentWhere polls tournament id until ok holds of it.
+func (ts *testServer) tournamentWhere(id string, ok func(Tournament) bool) Tournament {
+	ts.t.Helper()
+	var tournament Tournament
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		ts.getJSON("/api/tournaments/"+id, &tournament)
+		if ok(tournament) {
+			return tournament
+		}
+		if time.Now().After(deadline) {
+			ts.t.Fatalf("tournament never got there: %+v", tournament)
+		}
+	}
+}
+
+// standings fetches tournament id's standings, keyed by player.
+func (ts *testServer) standings(id string) (StandingsResponse, map[string]TournamentStanding) {
+	ts.t.Helper()
+	var resp StandingsResponse
+	ts.getJSON("/api/tournaments/"+id+"/standings", &resp)
+	byPlayer := make(map[string]TournamentStanding, len(resp.Standings))
+	for _, s := range resp.Standings {
+		byPlayer[s.Player] = s
+	}
+	return resp, byPlayer
+}
+
+// replayRound voids round of tournament id with token and returns the
+// response status.
+func (ts *testServer) replayRound(id, round, token string) int {
+	ts.t.Helper()
+	req, _ := http.NewRequest("POST", ts.srv.URL+"/api/tournaments/"+id+"/rounds/"+round+"/replay", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// forfeitTable starts the game at a table of ann and bob and has ann
+// forfeit it, so bob wins.
+func (ts *testServer) forfeitTable(code string) {
+	ts.t.Helper()
+	clients := ts.startGame(code, "ann", "bob")
+	room := ts.room(code)
+	room.do(func() { room.forfeitPlayer("ann", "left") })
+	clients[1].expect("GAME_OVER")
+}
+
+func TestTournamentInvalid(t *testing.T) {
+	ts := newTestServer(t, nil)
+	for _, tc := range []struct {
+		req  interface{}
+		want string
+	}{
+		{TournamentRequest{Participants: []string{"ann"}, Rounds: 1}, "participants"},
+		{TournamentRequest{Participants: []string{"ann", "bob", "ann"}, Rounds: 1}, "participants"},
+		{TournamentRequest{Participants: []string{"ann", ""}, Rounds: 1}, "participant"},
+		{TournamentRequest{Participants: []string{"ann", "bob"}}, "rounds"},
+		{TournamentRequest{Participants: []string{"ann", "bob"}, Rounds: maxTournamentRounds + 1}, "rounds"},
+		{TournamentRequest{Participants: []string{"ann", "bob"}, Rounds: 1, TableSize: 1}, "tableSize"},
+		{TournamentRequest{Participants: []string{"ann", "bob", "cat"}, Rounds: 1, TableSize: 2}, "tables"},
+		{TournamentRequest{Participants: []string{"ann", "bob"}, Rounds: 1, Scoring: TournamentScoring{Mode: "luck"}}, "scoring"},
+		{TournamentRequest{Participants: []string{"ann", "bob"}, Rounds: 1, Scoring: TournamentScoring{Mode: ScoreByNetWorth, Points: []int{1}}}, "scoring"},
+		{TournamentRequest{Participants: []string{"ann", "bob"}, Rounds: 1, Scoring: TournamentScoring{Points: []int{5, -1}}}, "scoring"},
+		{map[string]interface{}{"participants": []string{"ann", "bob"}, "rounds": 1, "prize": 100}, "prize"},
+	} {
+		resp := ts.postTournament(tc.req)
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body.Error, tc.want) {
+			t.Errorf("%+v: %s %q, want 400 about %s", tc.req, resp.Status, body.Error, tc.want)
+		}
+	}
+}
+
+// TestTournamentTableParticipants checks only the players seated at a
+// table may take a seat there.
+func TestTournamentTableParticipants(t *testing.T) {
+	ts := newTestServer(t, nil)
+	rec := ts.createTournament(TournamentRequest{Participants: []string{"ann", "bob"}, Rounds: 1})
+	if len(rec.Schedule) != 1 || len(rec.Schedule[0].Tables) != 1 || rec.OrganizerToken == "" {
+		t.Fatalf("created %+v", rec)
+	}
+	code := rec.Schedule[0].Tables[0].GameID
+
+	ts.dial(code, "cat", nil).expectClose(CloseInvalidJoin, "tournament")
+	ann := ts.join(code, "ann", nil)
+	ann.send("ADD_BOT", nil)
+	ann.expectError("TOURNAMENT_TABLE")
+	ts.join(code, "bob", nil)
+	ann.send("START_GAME", nil)
+	ann.expectError("PLAYERS_NOT_READY")
+}
+
+// TestTournamentNoShow checks a table still waiting at the no-show
+// deadline starts without the player who didn't come, who scores nothing.
+func TestTournamentNoShow(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.TournamentNoShow = 200 * time.Millisecond })
+	rec := ts.createTournament(TournamentRequest{Participants: []string{"ann", "bob", "cat"}, Rounds: 1, TableSize: 3})
+	code := rec.Schedule[0].Tables[0].GameID
+	ts.join(code, "ann", nil)
+	bob := ts.join(code, "bob", nil)
+
+	bob.expect("GAME_STARTED")
+	room := ts.room(code)
+	room.do(func() { room.forfeitPlayer("ann", "left") })
+	bob.expect("GAME_OVER")
+	done := ts.tournamentWhere(rec.ID, func(t Tournament) bool { return t.Status == TournamentFinished })
+	results := done.Schedule[0].Tables[0].Results
+	want := []TableResult{{Player: "bob", Place: 1, Points: 10}, {Player: "ann", Place: 2, Points: 6, Forfeited: true}, {Player: "cat", NoShow: true}}
+	for i := range results {
+		results[i].NetWorth = 0
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results %+v, want %+v", results, want)
+	}
+	_, standings := ts.standings(rec.ID)
+	if cat := standings["cat"]; cat.Points != 0 || cat.NoShows != 1 || cat.Played != 0 || cat.Rank != 3 {
+		t.Errorf("cat's standing %+v", cat)
+	}
+}
+
+// TestTournamentNoShowSettles checks a table where too few players came
+// for a game is settled without one at the no-show deadline.
+func TestTournamentNoShowSettles(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.TournamentNoShow = 100 * time.Millisecond })
+	rec := ts.createTournament(TournamentRequest{Participants: []string{"ann", "bob"}, Rounds: 1})
+	ann := ts.join(rec.Schedule[0].Tables[0].GameID, "ann", nil)
+
+	ann.expect("ROOM_CLOSED")
+	ts.tournamentWhere(rec.ID, func(t Tournament) bool { return t.Status == TournamentFinished })
+	_, standings := ts.standings(rec.ID)
+	if ann, bob := standings["ann"], standings["bob"]; ann.Points != 10 || ann.Wins != 1 || bob.Points != 0 || bob.NoShows != 1 {
+		t.Errorf("standings ann %+v, bob %+v", ann, bob)
+	}
+}
+
+// TestTournamentRounds plays a tournament of two rounds and checks the
+// second starts once the first is over, and the standings add them up.
+func TestTournamentRounds(t *testing.T) {
+	ts := newTestServer(t, nil)
+	rec := ts.createTournament(TournamentRequest{Participants: []string{"ann", "bob"}, Rounds: 2, Scoring: TournamentScoring{Points: []int{3, 1}}})
+
+	ts.forfeitTable(rec.Schedule[0].Tables[0].GameID)
+	second := ts.tournamentWhere(rec.ID, func(t Tournament) bool { return len(t.Schedule) == 2 })
+	if second.Status != TournamentRunning || second.Schedule[1].Tables[0].GameID == rec.Schedule[0].Tables[0].GameID {
+		t.Fatalf("second round %+v", second)
+	}
+	resp, standings := ts.standings(rec.ID)
+	if resp.RoundsPlayed != 1 || standings["bob"].Points != 3 || standings["ann"].Points != 1 {
+		t.Errorf("standings after a round %+v", resp)
+	}
+
+	ts.forfeitTable(second.Schedule[1].Tables[0].GameID)
+	ts.tournamentWhere(rec.ID, func(t Tournament) bool { return t.Status == TournamentFinished })
+	resp, standings = ts.standings(rec.ID)
+	if resp.RoundsPlayed != 2 || resp.Standings[0].Player != "bob" {
+		t.Errorf("final standings %+v", resp)
+	}
+	if bob := standings["bob"]; bob.Points != 6 || bob.Wins != 2 || bob.Played != 2 || bob.Rank != 1 {
+		t.Errorf("bob's standing %+v", bob)
+	}
+}
+
+// TestTournamentReplay voids a round being played and checks only the
+// organizer may, its room is closed, and only the replay's result counts.
+func TestTournamentReplay(t *testing.T) {
+	ts := newTestServer(t, nil)
+	rec := ts.createTournament(TournamentRequest{Participants: []string{"ann", "bob"}, Rounds: 1})
+	voided := rec.Schedule[0].Tables[0].GameID
+	clients := ts.startGame(voided, "ann", "bob")
+
+	if status := ts.replayRound(rec.ID, "1", ""); status != http.StatusUnauthorized {
+		t.Errorf("replay without the organizer token: %d", status)
+	}
+	if status := ts.replayRound(rec.ID, "2", rec.OrganizerToken); status != http.StatusConflict {
+		t.Errorf("replay of a round not yet played: %d", status)
+	}
+	if status := ts.replayRound(rec.ID, "1", rec.OrganizerToken); status != http.StatusOK {
+		t.Fatalf("replay: %d", status)
+	}
+	clients[0].expect("ROOM_CLOSED")
+	replayed := ts.tournamentWhere(rec.ID, func(t Tournament) bool { return t.Schedule[0].Replays == 1 })
+	table := replayed.Schedule[0].Tables[0]
+	if table.GameID == voided || table.Done || !reflect.DeepEqual(table.Players, rec.Schedule[0].Tables[0].Players) {
+		t.Fatalf("replayed table %+v", table)
+	}
+
+	ts.forfeitTable(table.GameID)
+	ts.tournamentWhere(rec.ID, func(t Tournament) bool { return t.Status == TournamentFinished })
+	resp, standings := ts.standings(rec.ID)
+	if resp.RoundsPlayed != 1 || standings["bob"].Played != 1 || standings["bob"].Points != 10 {
+		t.Errorf("standings after the replay %+v", resp)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+const (
+	minTurnSeconds = 10
+	maxTurnSeconds = 3600
+)
+
+var errTurnSeconds = errors.New("turnSeconds must be between 10 and 3600")
+
+type TurnTimerPayload struct {
+	Player   string    `json:"player"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// turnLength is how long each turn lasts in the room.
+func (room *GameRoom) turnLength() time.Duration {
+	if room.Options.TurnSeconds > 0 {
+		return time.Duration(room.Options.TurnSeconds) * time.Second
+	}
+	return hub.config.TurnTimeout
+}
+
+// startTurnTimer starts the clock on the current turn, replacing any
+// earlier one. Bots and disconnected players get no timer: bots play on
+// their own and absent players are handled by the grace period. It must
+// run on the room's goroutine.
+func (room *GameRoom) startTurnTimer() {
+	room.stopTurnTimer()
+	name := room.GameState.Turn
+	player, ok := room.GameState.Players[name]
+	if !ok || player.Bot || !player.Connected {
+		room.announceClocks()
+		return
+	}
+	room.startClock(name)
+	var timer *roomTimer
+	timer = newRoomTimer(room.turnLength(), func() {
+		room.do(func() { room.turnExpired(name, timer) })
+	})
+	room.turnTimer = timer
+	if room.GameState.Paused {
+		room.pauseTurnTimer()
+		room.announceClocks()
+		return
+	}
+	room.announceTurnTimer()
+	room.announceClocks()
+}
+
+// announceTurnTimer records the running turn timer's deadline in the game
+// state and tells everyone. It must run on the room's goroutine.
+func (room *GameRoom) announceTurnTimer() {
+	deadline := time.Now().Add(room.turnTimer.remaining)
+	room.GameState.TurnDeadline = &deadline
+	room.GameState.TurnTimeLeft = 0
+	SendGameEventToAll(room, "TURN_TIMER", room.ID, TurnTimerPayload{Player: room.GameState.Turn, Deadline: deadline})
+}
+
+// stopTurnTimer cancels the turn timer, if any, and stops the player's
+// clock. It must run on the room's goroutine.
+func (room *GameRoom) stopTurnTimer() {
+	room.stopClock()
+	if room.turnTimer != nil {
+		room.turnTimer.stop()
+		room.turnTimer = nil
+	}
+	room.GameState.TurnDeadline = nil
+	room.GameState.TurnTimeLeft = 0
+}
+
+// pauseTurnTimer freezes the turn timer; snapshots then show the time left
+// instead of a deadline. The player's clock is frozen with it. It must
+// run on the room's goroutine.
+func (room *GameRoom) pauseTurnTimer() {
+	room.pauseClock()
+	if room.turnTimer == nil {
+		return
+	}
+	room.turnTimer.pause()
+	room.GameState.TurnDeadline = nil
+	room.GameState.TurnTimeLeft = room.turnTimer.remaining.Milliseconds()
+}
+
+// resumeTurnTimer restarts a paused turn timer with the time it had left.
+// The player's clock carries on with it. It must run on the room's
+// goroutine.
+func (room *GameRoom) resumeTurnTimer() {
+	room.resumeClock()
+	if room.turnTimer == nil {
+		return
+	}
+	room.turnTimer.resume()
+	room.announceTurnTimer()
+}
+
+// turnExpired plays out the rest of name's turn: it rolls for them if they
+// haven't rolled yet, then ends the turn. A timer that was replaced or
+// paused after it fired is ignored. It must run on the room's goroutine.
+func (room *GameRoom) turnExpired(name string, timer *roomTimer) {
+	if room.turnTimer != timer || room.GameState.Paused || room.closed || room.GameState.Status != StatusInProgress || room.GameState.Turn != name {
+		return
+	}
+	room.turnTimer = nil
+	room.logger().Info("turn timed out", "player", name)
+	SendGameEventToAll(room, "TURN_TIMEOUT", room.ID, PlayerPayload{Player: name})
+	room.playOutTurn(name)
+}
+
+// playOutTurn finishes name's turn for them: it rolls if they haven't
+// rolled yet, then passes the turn on, unless the roll already did. It
+// must run on the room's goroutine.
+func (room *GameRoom) playOutTurn(name string) {
+	if !room.GameState.Rolled && room.autoRoll(name) {
+		return
+	}
+	if room.GameState.Status == StatusInProgress {
+		room.setTurn(room.nextSeat(name))
+	}
+}
+
+// autoRoll rolls and moves for name when they can't: bots, and players
+// whose turn ran out. It reports whether the roll ended their turn, by
+// putting them out of the game. It must run on the room's goroutine.
+func (room *GameRoom) autoRoll(name string) bool {
+	effects, err := room.apply(game.RollDice{Player: name})
+	if err != nil {
+		room.logger().Error("rolling for player", "player", name, "err", err)
+		return false
+	}
+	return room.announce(effects, true)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// A player who made a move by mistake can ask to take it back with
+// UNDO_REQUEST, within -undo-window of making it and before anything else
+// happens in the game. Only their last move can be undone, and not a roll,
+// since the dice have been seen. The other active players vote on it with
+// UNDO_VOTE, or with RoomOptions.UndoApproval set to UndoHost the host
+// alone decides; nobody to ask means yes. If the vote passes the game goes
+// back to how it was before the move, announced as UNDO_APPLIED with the
+// sequence number of the resolution undone and the state as it now
+// stands. Sequence numbers never go back: UNDO_APPLIED is a broadcast of
+// its own, so a client resuming from the history, or a replay of the log,
+// sees the move and then its reversal.
+const (
+	UndoAll  = "all"
+	UndoHost = "host"
+)
+
+var errUndoApproval = errors.New(`undoApproval must be "all" or "host"`)
+
+// undoPoint is the last move made in the room, which its player may still
+// take back: the state before it and the resolution that announced it.
+type undoPoint struct {
+	player   string
+	action   string
+	seq      uint64
+	at       time.Time
+	snapshot game.Snapshot
+	actions  []ActionLogEntry
+}
+
+// undoVote is an open request to undo the room's undo point. Voters are
+// fixed when it opens.
+type undoVote struct {
+	voters    map[string]bool
+	yes       map[string]bool
+	expiresAt time.Time
+	timer     *time.Timer
+}
+
+type UndoRequestedPayload struct {
+	Player    string    `json:"player"`
+	Action    string    `json:"action"`
+	UndoSeq   uint64    `json:"undoSeq"`
+	Voters    []string  `json:"voters"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type UndoRejectedPayload struct {
+	Player  string `json:"player"`
+	UndoSeq uint64 `json:"undoSeq"`
+	Reason  string `json:"reason"`
+}
+
+// UndoAppliedPayload announces an undone move. State is the game as it
+// stands after it.
+type UndoAppliedPayload struct {
+	Player  string     `json:"player"`
+	Action  string     `json:"action"`
+	UndoSeq uint64     `json:"undoSeq"`
+	State   *GameState `json:"state"`
+}
+
+// actionName returns the event action is sent as.
+func actionName(action game.Action) string {
+	switch action.(type) {
+	case game.RollDice:
+		return game.ActionRollDice
+	case game.PayBail:
+		return game.ActionPayBail
+	case game.BuyProperty:
+		return game.ActionBuyProperty
+	case game.DeclinePurchase:
+		return game.ActionDeclinePurchase
+	case game.Unmortgage:
+		return game.ActionUnmortgage
+	case game.EndTurn:
+		return game.ActionEndTurn
+	}
+	return ""
+}
+
+// recordUndoPoint replaces the room's undo point after a move: with one
+// for the move if its player made it themselves and it can be taken back,
+// and otherwise with none. An open undo vote is dropped, since the game
+// has moved on. It must run on the room's goroutine, once the move's
+// resolution has been broadcast.
+func (room *GameRoom) recordUndoPoint(player string, action game.Action, before game.Snapshot, actions []ActionLogEntry, effects []game.Effect) {
+	room.endUndoVote(false, "the game moved on")
+	room.undo = nil
+	if p, ok := room.GameState.Players[player]; !ok || p.Bot || room.actor != player || !game.Reversible(effects) {
+		return
+	}
+	room.undo = &undoPoint{
+		player:   player,
+		action:   actionName(action),
+		seq:      room.seq,
+		at:       time.Now(),
+		snapshot: before,
+		actions:  actions,
+	}
+}
+
+// clearUndo drops the undo point and any vote on it. It must run on the
+// room's goroutine.
+func (room *GameRoom) clearUndo(reason string) {
+	room.endUndoVote(false, reason)
+	room.undo = nil
+}
+
+// HandleUndoRequestEvent opens a vote to undo the sender's last move.
+func HandleUndoRequestEvent(room *GameRoom, event GameEvent, client *Client) {
+	name := connName(room, client)
+	undo := room.undo
+	switch {
+	case room.undoVote != nil:
+		room.rejectEvent(client, event, "VOTE_IN_PROGRESS", "an undo is already being voted on")
+		return
+	case undo == nil || undo.player != name:
+		room.rejectEvent(client, event, "NOTHING_TO_UNDO", "you have no move that can be undone")
+		return
+	case time.Since(undo.at) > hub.config.UndoWindow:
+		room.rejectEvent(client, event, "UNDO_TOO_LATE", "it's too late to undo that move")
+		return
+	}
+
+	vote := &undoVote{voters: make(map[string]bool), yes: make(map[string]bool), expiresAt: time.Now().Add(hub.config.UndoVoteTimeout)}
+	if room.Options.UndoApproval == UndoHost {
+		if host := room.GameState.Host; host != name {
+			vote.voters[host] = true
+		}
+	} else {
+		for _, other := range room.GameState.TurnOrder {
+			if p := room.GameState.Players[other]; other != name && p.Connected && !p.Bot {
+				vote.voters[other] = true
+			}
+		}
+	}
+	voters := make([]string, 0, len(vote.voters))
+	for voter := range vote.voters {
+		voters = append(voters, voter)
+	}
+	sort.Strings(voters)
+	vote.timer = time.AfterFunc(hub.config.UndoVoteTimeout, func() {
+		room.do(func() {
+			if room.undoVote == vote {
+				room.endUndoVote(false, "vote timed out")
+			}
+		})
+	})
+	room.undoVote = vote
+
+	room.logger().Info("undo requested", "player", name, "action", undo.action, "undoSeq", undo.seq)
+	SendGameEventToAll(room, "UNDO_REQUESTED", room.ID, UndoRequestedPayload{
+		Player:    name,
+		Action:    undo.action,
+		UndoSeq:   undo.seq,
+		Voters:    voters,
+		ExpiresAt: vote.expiresAt,
+	})
+	room.tallyUndoVote()
+}
+
+// HandleUndoVoteEvent records a vote on the open undo request. A single
+// no fails it.
+func HandleUndoVoteEvent(room *GameRoom, event GameEvent, client *Client) {
+	vote := room.undoVote
+	if vote == nil {
+		room.rejectEvent(client, event, "NO_VOTE", "there is no undo to vote on")
+		return
+	}
+	voter := connName(room, client)
+	if !vote.voters[voter] {
+		room.rejectEvent(client, event, "NOT_A_VOTER", "you can't vote on this")
+		return
+	}
+	var payload VotePayload
+	if err := decodePayload(event, &payload); err != nil || payload.Approve == nil {
+		room.rejectEvent(client, event, "INVALID_PAYLOAD", "approve must be true or false")
+		return
+	}
+	approve, no := *payload.Approve, 0
+	if approve {
+		vote.yes[voter] = true
+	} else {
+		no = 1
+	}
+	SendGameEventToAll(room, "UNDO_VOTE_CAST", room.ID, VoteCastPayload{Voter: voter, Approve: approve, Yes: len(vote.yes), No: no})
+	if !approve {
+		room.endUndoVote(false, voter+" said no")
+		return
+	}
+	room.tallyUndoVote()
+}
+
+// tallyUndoVote applies the undo once every voter has agreed. It must run
+// on the room's goroutine.
+func (room *GameRoom) tallyUndoVote() {
+	if vote := room.undoVote; vote != nil && len(vote.yes) == len(vote.voters) {
+		room.endUndoVote(true, "")
+	}
+}
+
+// leaveUndoVote updates the open undo vote for name leaving: the move is
+// no longer theirs to undo if it was, and otherwise their vote is no
+// longer needed. A host who decides alone and leaves leaves nobody to
+// ask, so the undo goes through. It must run on the room's goroutine.
+func (room *GameRoom) leaveUndoVote(name string) {
+	switch {
+	case room.undo != nil && room.undo.player == name:
+		room.clearUndo(name + " left")
+	case room.undoVote != nil && room.undoVote.voters[name]:
+		delete(room.undoVote.voters, name)
+		delete(room.undoVote.yes, name)
+		room.tallyUndoVote()
+	}
+}
+
+// endUndoVote closes the open undo vote, if any, undoing the move if it
+// passed. A move that wasn't undone can't be asked about again. It must
+// run on the room's goroutine.
+func (room *GameRoom) endUndoVote(passed bool, reason string) {
+	vote, undo := room.undoVote, room.undo
+	if vote == nil {
+		return
+	}
+	vote.timer.Stop()
+	room.undoVote = nil
+	room.undo = nil
+	if !passed {
+		room.logger().Info("undo refused", "player", undo.player, "reason", reason)
+		SendGameEventToAll(room, "UNDO_REJECTED", room.ID, UndoRejectedPayload{Player: undo.player, UndoSeq: undo.seq, Reason: reason})
+		return
+	}
+	room.applyUndo(undo)
+}
+
+// applyUndo puts the game back as it was before undo's move. It must run
+// on the room's goroutine.
+func (room *GameRoom) applyUndo(undo *undoPoint) {
+	turn := room.GameState.Turn
+	undo.snapshot.Restore(&room.GameState)
+	room.actionLog = undo.actions
+	room.logger().Info("move undone", "player", undo.player, "action", undo.action, "undoSeq", undo.seq)
+	SendGameEventToAll(room, "UNDO_APPLIED", room.ID, UndoAppliedPayload{
+		Player:  undo.player,
+		Action:  undo.action,
+		UndoSeq: undo.seq,
+		State:   &room.GameState,
+	})
+	room.logAction("undo", map[string]interface{}{"player": undo.player})
+	if room.GameState.Turn != turn {
+		room.startTurnTimer()
+	}
+	room.sendAvailableActions()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zishan044/monopoly-backend/game"
+)
+
+// undoGame starts a game between ann, the host, and bob in which ann's
+// first roll lands where nothing is for sale and bob's on a property, which
+// bob buys. undoApproval is the room's.
+func undoGame(t *testing.T, ts *testServer, undoApproval string) (ann, bob *testClient) {
+	t.Helper()
+	seed := seedWhere(t, 2, func(totals []int) bool { return !forSale(totals[0]) && forSale(totals[1]) })
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 2, "undoApproval": undoApproval, "diceSeed": seed})
+	clients := ts.startGame(code, "ann", "bob")
+	ann, bob = clients[0], clients[1]
+	ann.playTurn()
+	bob.actions()
+	bob.send("ROLL_DICE", nil)
+	if actions := bob.actions(); actions[0] != "BUY_PROPERTY" {
+		t.Fatalf("bob was offered %v", actions)
+	}
+	bob.send("BUY_PROPERTY", nil)
+	bob.actions()
+	return ann, bob
+}
+
+func TestUndoVote(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ann, bob := undoGame(t, ts, UndoAll)
+	bob.send("UNDO_REQUEST", nil)
+	ann.expect("UNDO_REQUESTED")
+	ann.send("UNDO_VOTE", map[string]interface{}{})
+	ann.expectError("INVALID_PAYLOAD")
+	ann.send("UNDO_VOTE", map[string]bool{"approve": true})
+	var applied UndoAppliedPayload
+	bob.expect("UNDO_APPLIED").decode(t, &applied)
+	if applied.Player != "bob" || applied.Action != "BUY_PROPERTY" || len(applied.State.Players["bob"].Properties) != 0 {
+		t.Errorf("undo applied %+v", applied)
+	}
+	if actions := bob.actions(); actions[0] != "BUY_PROPERTY" {
+		t.Errorf("after the undo bob may %v", actions)
+	}
+}
+
+func TestUndoHostLeaves(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ann, bob := undoGame(t, ts, UndoHost)
+	bob.send("UNDO_REQUEST", nil)
+	var requested UndoRequestedPayload
+	ann.expect("UNDO_REQUESTED").decode(t, &requested)
+	if len(requested.Voters) != 1 || requested.Voters[0] != "ann" {
+		t.Fatalf("voters %v, want the host", requested.Voters)
+	}
+	ann.conn.Close()
+	bob.expect("UNDO_APPLIED")
+}
+
+func TestUndoTooLate(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.UndoWindow = time.Millisecond })
+	_, bob := undoGame(t, ts, UndoAll)
+	time.Sleep(5 * time.Millisecond)
+	bob.send("UNDO_REQUEST", nil)
+	bob.expectError("UNDO_TOO_LATE")
+}
+
+func TestUndoUnmortgage(t *testing.T) {
+	ts := newTestServer(t, nil)
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 2})
+	clients := ts.startGame(code, "ann", "bob")
+	ann, bob := clients[0], clients[1]
+	room := ts.room(code)
+	ann.actions()
+	room.do(func() {
+		ann := room.GameState.Players["ann"]
+		ann.Properties = []string{"Baltic Avenue"}
+		ann.Mortgaged = []string{"Baltic Avenue"}
+	})
+
+	ann.send("UNMORTGAGE", map[string]string{"property": "Baltic Avenue"})
+	ann.actions()
+	ann.send("UNDO_REQUEST", nil)
+	var requested UndoRequestedPayload
+	bob.expect("UNDO_REQUESTED").decode(t, &requested)
+	if requested.Action != "UNMORTGAGE" {
+		t.Errorf("undo requested for %q, want UNMORTGAGE", requested.Action)
+	}
+	bob.send("UNDO_VOTE", map[string]bool{"approve": true})
+	var applied UndoAppliedPayload
+	ann.expect("UNDO_APPLIED").decode(t, &applied)
+	if p := applied.State.Players["ann"]; applied.Action != "UNMORTGAGE" || p.Balance != 1500 || len(p.Mortgaged) != 1 {
+		t.Errorf("undo applied %+v; ann has %d, mortgaged %v", applied, p.Balance, p.Mortgaged)
+	}
+}
+
+// TestUndoAfterMortgageChoice has bob buy a property while owing a choice
+// over mortgaged deeds taken over from ann, then make the choice, and
+// checks the purchase can no longer be undone.
+func TestUndoAfterMortgageChoice(t *testing.T) {
+	ts := newTestServer(t, nil)
+	var first int
+	seed := seedWhere(t, 2, func(totals []int) bool {
+		first = totals[0]
+		mortgaged := func(total int) bool { return total == 1 || total == 3 }
+		return forSale(first) && forSale(totals[1]) && first != totals[1] && !mortgaged(first) && !mortgaged(totals[1])
+	})
+	code := ts.createRoom(map[string]interface{}{"minPlayers": 3, "diceSeed": seed})
+	clients := ts.startGame(code, "ann", "bob", "cat")
+	ann, bob := clients[0], clients[1]
+	room := ts.room(code)
+	room.do(func() {
+		players := room.GameState.Players
+		players["ann"].Balance = 0
+		players["ann"].Properties = []string{"Mediterranean Avenue", "Baltic Avenue"}
+		players["ann"].Mortgaged = []string{"Mediterranean Avenue", "Baltic Avenue"}
+		players["bob"].Properties = []string{game.Standard.SquareAt(first).Name}
+	})
+
+	ann.actions()
+	ann.send("ROLL_DICE", nil)
+	bob.expectStep("MORTGAGE_TRANSFER_CHOICE")
+	bob.actions()
+	bob.send("ROLL_DICE", nil)
+	if actions := bob.actions(); actions[0] != game.ActionBuyProperty {
+		t.Fatalf("bob was offered %v", actions)
+	}
+	bob.send("BUY_PROPERTY", nil)
+	bob.actions()
+	bob.send("MORTGAGE_TRANSFER_CHOICE", map[string]interface{}{"unmortgage": []string{"Baltic Avenue"}})
+	bob.expectStep("MORTGAGE_TRANSFER_SETTLED")
+	bob.send("UNDO_REQUEST", nil)
+	bob.expectError("NOTHING_TO_UNDO")
+}
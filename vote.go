@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+const defaultVoteKickMajority = 0.5
+
+var errVoteKickMajority = errors.New("voteKickMajority must be between 0 and 1")
+
+// kickVote is a room's open vote to kick target. Only one runs at a time.
+// Voters are fixed when it opens: every seated, connected, human player
+// other than the target.
+type kickVote struct {
+	target    string
+	initiator string
+	voters    map[string]bool
+	yes       map[string]bool
+	no        map[string]bool
+	expiresAt time.Time
+	timer     *time.Timer
+}
+
+type VoteKickStartedPayload struct {
+	Target    string    `json:"target"`
+	Initiator string    `json:"initiator"`
+	Needed    int       `json:"needed"`
+	Voters    int       `json:"voters"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// VotePayload is a vote cast with VOTE. Approve is required.
+type VotePayload struct {
+	Approve *bool `json:"approve"`
+}
+
+type VoteCastPayload struct {
+	Voter   string `json:"voter"`
+	Approve bool   `json:"approve"`
+	Yes     int    `json:"yes"`
+	No      int    `json:"no"`
+}
+
+type VoteKickResultPayload struct {
+	Target string `json:"target"`
+	Passed bool   `json:"passed"`
+	Reason string `json:"reason"`
+}
+
+// needed is how many yes votes pass the vote: more than the room's
+// majority share of the voters.
+func (v *kickVote) needed(majority float64) int {
+	return int(majority*float64(len(v.voters))) + 1
+}
+
+// HandleVoteKickEvent opens a vote to kick the player named in the
+// payload. The sender's own vote counts as a yes.
+func HandleVoteKickEvent(room *GameRoom, event GameEvent, client *Client) {
+	var payload PlayerPayload
+	if err := decodePayload(event, &payload); err != nil {
+		room.rejectEvent(client, event, "INVALID_PAYLOAD", "VOTE_KICK needs the player to kick")
+		return
+	}
+	target := payload.Player
+	initiator := connName(room, client)
+
+	if room.kickVote != nil {
+		room.rejectEvent(client, event, "VOTE_IN_PROGRESS", "a vote is already open against "+room.kickVote.target)
+		return
+	}
+	if until := room.voteCooldowns[initiator]; time.Now().Before(until) {
+		room.rejectEvent(client, event, "VOTE_COOLDOWN", "you can't start another vote yet")
+		return
+	}
+	player, ok := room.GameState.Players[target]
+	if !ok || player.Forfeited || target == initiator {
+		room.rejectEvent(client, event, "INVALID_VOTE", "can't vote to kick "+target)
+		return
+	}
+
+	vote := &kickVote{
+		target:    target,
+		initiator: initiator,
+		voters:    make(map[string]bool),
+		yes:       map[string]bool{initiator: true},
+		no:        make(map[string]bool),
+		expiresAt: time.Now().Add(hub.config.VoteKickTimeout),
+	}
+	for _, name := range room.GameState.TurnOrder {
+		if p := room.GameState.Players[name]; name != target && p.Connected && !p.Bot {
+			vote.voters[name] = true
+		}
+	}
+	if !vote.voters[initiator] {
+		room.rejectEvent(client, event, "INVALID_VOTE", "only active players can start a vote")
+		return
+	}
+	if len(vote.voters) < 2 {
+		// Otherwise one player could throw out the other in a duel.
+		room.rejectEvent(client, event, "INVALID_VOTE", "not enough players to hold a vote")
+		return
+	}
+	vote.timer = time.AfterFunc(hub.config.VoteKickTimeout, func() {
+		room.do(func() {
+			if room.kickVote == vote {
+				room.endKickVote(false, "vote timed out")
+			}
+		})
+	})
+	room.kickVote = vote
+	room.voteCooldowns[initiator] = time.Now().Add(hub.config.VoteKickCooldown)
+
+	room.logger().Info("vote-kick started", "target", target, "player", initiator)
+	SendGameEventToAll(room, "VOTE_KICK_STARTED", room.ID, VoteKickStartedPayload{
+		Target:    target,
+		Initiator: initiator,
+		Needed:    vote.needed(room.Options.VoteKickMajority),
+		Voters:    len(vote.voters),
+		ExpiresAt: vote.expiresAt,
+	})
+	room.tallyKickVote()
+}
+
+// HandleVoteEvent records a vote on the open vote-kick. A voter may change
+// their mind until the vote closes.
+func HandleVoteEvent(room *GameRoom, event GameEvent, client *Client) {
+	vote := room.kickVote
+	if vote == nil {
+		room.rejectEvent(client, event, "NO_VOTE", "there is no open vote")
+		return
+	}
+	voter := connName(room, client)
+	if !vote.voters[voter] {
+		room.rejectEvent(client, event, "NOT_A_VOTER", "you can't vote on this")
+		return
+	}
+	var payload VotePayload
+	if err := decodePayload(event, &payload); err != nil || payload.Approve == nil {
+		room.rejectEvent(client, event, "INVALID_PAYLOAD", "approve must be true or false")
+		return
+	}
+	approve := *payload.Approve
+
+	delete(vote.yes, voter)
+	delete(vote.no, voter)
+	if approve {
+		vote.yes[voter] = true
+	} else {
+		vote.no[voter] = true
+	}
+	SendGameEventToAll(room, "VOTE_CAST", room.ID, VoteCastPayload{
+		Voter:   voter,
+		Approve: approve,
+		Yes:     len(vote.yes),
+		No:      len(vote.no),
+	})
+	room.tallyKickVote()
+}
+
+// tallyKickVote closes the open vote as soon as its outcome is certain.
+// It must run on the room's goroutine.
+func (room *GameRoom) tallyKickVote() {
+	vote := room.kickVote
+	needed := vote.needed(room.Options.VoteKickMajority)
+	switch {
+	case len(vote.yes) >= needed:
+		room.endKickVote(true, "vote passed")
+	case len(vote.voters)-len(vote.no) < needed:
+		room.endKickVote(false, "vote failed")
+	}
+}
+
+// endKickVote closes the open vote and, if it passed, forfeits the target
+// and closes their connection. It must run on the room's goroutine.
+func (room *GameRoom) endKickVote(passed bool, reason string) {
+	vote := room.kickVote
+	vote.timer.Stop()
+	room.kickVote = nil
+
+	room.logger().Info("vote-kick ended", "target", vote.target, "reason", reason)
+	SendGameEventToAll(room, "VOTE_KICK_RESULT", room.ID, VoteKickResultPayload{Target: vote.target, Passed: passed, Reason: reason})
+	if !passed {
+		return
+	}
+	conn := clientFor(room, vote.target)
+	room.revokeSession(vote.target)
+	room.forfeitPlayer(vote.target, "vote kicked")
+	if conn != nil {
+		conn.part(room, CloseKicked, "kicked by a vote")
+	}
+}
+
+// leaveKickVote updates the open vote for name leaving the game: a vote
+// against them is dropped, and a vote they could cast no longer counts
+// towards the total. It must run on the room's goroutine.
+func (room *GameRoom) leaveKickVote(name string, reason string) {
+	vote := room.kickVote
+	switch {
+	case vote == nil:
+	case vote.target == name:
+		room.endKickVote(false, reason)
+	case vote.voters[name]:
+		delete(vote.voters, name)
+		delete(vote.yes, name)
+		delete(vote.no, name)
+		room.tallyKickVote()
+	}
+}
+
+// cancelKickVote drops the open vote, if any. It must run on the room's
+// goroutine.
+func (room *GameRoom) cancelKickVote(reason string) {
+	if room.kickVote != nil {
+		room.endKickVote(false, reason)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhooks tell outside services, such as a chat bot, when games start and
+// end and when players drop out. Each event is POSTed to every URL in
+// -webhook-urls with the same JSON as the websocket broadcast, signed
+// with -webhook-secret in the X-Monopoly-Signature header as
+// "sha256=<hex HMAC of the body>"; X-Monopoly-Delivery stays the same
+// when a delivery is retried. A delivery that fails is retried with
+// backoff a few times and then dropped. Deliveries are queued and sent in
+// the background, each URL on its own, so a slow or broken target never
+// holds up a game; what happens to them is logged and counted.
+
+// webhookEvents are the broadcasts sent to webhooks.
+var webhookEvents = map[string]bool{
+	"GAME_STARTED":     true,
+	"GAME_OVER":        true,
+	"PLAYER_FORFEITED": true,
+	"PLAYER_BANKRUPT":  true,
+}
+
+const (
+	// webhookQueueSize is how many deliveries may wait for each target
+	// before new ones are dropped.
+	webhookQueueSize = 256
+	webhookTimeout   = 10 * time.Second
+	webhookBackoff   = time.Second
+	webhookMaxDelay  = 30 * time.Second
+)
+
+// webhooks sends events to the configured targets; it is nil if there are
+// none.
+var webhooks *webhookSender
+
+// webhookSender delivers to its targets, waiting backoff before the first
+// retry of a failed delivery and twice as long before each one after.
+type webhookSender struct {
+	secret  []byte
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	targets []*webhookTarget
+}
+
+// webhookTarget is one URL and the deliveries waiting for it.
+type webhookTarget struct {
+	url   string
+	queue chan webhookDelivery
+}
+
+type webhookDelivery struct {
+	id    string
+	event string
+	body  []byte
+}
+
+// newWebhookSender starts delivering to the targets cfg lists, or returns
+// nil if it lists none.
+func newWebhookSender(cfg *Config) *webhookSender {
+	s := &webhookSender{
+		secret:  []byte(cfg.WebhookSecret),
+		retries: cfg.WebhookRetries,
+		backoff: webhookBackoff,
+		client:  &http.Client{Timeout: webhookTimeout},
+	}
+	for _, url := range strings.Split(cfg.WebhookURLs, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			t := &webhookTarget{url: url, queue: make(chan webhookDelivery, webhookQueueSize)}
+			s.targets = append(s.targets, t)
+			go s.deliver(t)
+		}
+	}
+	if len(s.targets) == 0 {
+		return nil
+	}
+	return s
+}
+
+// notify queues a broadcast for every target if it is one webhooks get.
+// It never blocks: a delivery a target's queue has no room for is dropped.
+func (s *webhookSender) notify(room *GameRoom, event string, body []byte) {
+	if s == nil || !webhookEvents[event] {
+		return
+	}
+	d := webhookDelivery{id: newSessionToken()[:32], event: event, body: body}
+	for _, t := range s.targets {
+		select {
+		case t.queue <- d:
+		default:
+			room.logger().Warn("webhook queue full, dropping delivery", "url", t.url, "event", event)
+			metrics.Inc(metricWebhooks, "dropped")
+		}
+	}
+}
+
+// deliver sends t's deliveries in order, for as long as the server runs.
+func (s *webhookSender) deliver(t *webhookTarget) {
+	for d := range t.queue {
+		delay := s.backoff
+		for attempt := 0; ; attempt++ {
+			err := s.post(t.url, d)
+			if err == nil {
+				metrics.Inc(metricWebhooks, "delivered")
+				break
+			}
+			if attempt >= s.retries || !retryable(err) {
+				slog.Warn("webhook delivery failed", "url", t.url, "event", d.event, "delivery", d.id, "attempts", attempt+1, "err", err)
+				metrics.Inc(metricWebhooks, "failed")
+				break
+			}
+			slog.Debug("retrying webhook delivery", "url", t.url, "event", d.event, "delivery", d.id, "err", err)
+			metrics.Inc(metricWebhooks, "retried")
+			time.Sleep(delay)
+			delay = min(2*delay, webhookMaxDelay)
+		}
+	}
+}
+
+// webhookStatusError is a response a webhook target gave other than 2xx.
+type webhookStatusError struct {
+	status int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("target answered %d %s", e.status, http.StatusText(e.status))
+}
+
+// retryable reports whether a failed delivery may succeed if tried again:
+// anything but a target refusing it outright.
+func retryable(err error) bool {
+	if e, ok := err.(*webhookStatusError); ok {
+		return e.status >= 500 || e.status == http.StatusTooManyRequests || e.status == http.StatusRequestTimeout
+	}
+	return true
+}
+
+// post makes one attempt at delivering d to url.
+func (s *webhookSender) post(url string, d webhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "monopoly-backend")
+	req.Header.Set("X-Monopoly-Event", d.event)
+	req.Header.Set("X-Monopoly-Delivery", d.id)
+	if len(s.secret) > 0 {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(d.body)
+		req.Header.Set("X-Monopoly-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &webhookStatusError{status: resp.StatusCode}
+	}
+	return nil
+}
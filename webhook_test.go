@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hook is a webhook target that answers each delivery with the next of
+// its statuses, 200 once they run out, and records what it was sent.
+type hook struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	got      []hookRequest
+	arrived  chan struct{}
+}
+
+// hookRequest is a delivery attempt as a hook received it.
+type hookRequest struct {
+	at     time.Time
+	header http.Header
+	body   string
+}
+
+func newHook(t *testing.T, statuses ...int) *hook {
+	h := &hook{statuses: statuses, arrived: make(chan struct{}, 100)}
+	h.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		h.mu.Lock()
+		h.got = append(h.got, hookRequest{at: time.Now(), header: r.Header, body: string(body)})
+		status := http.StatusOK
+		if len(h.statuses) > 0 {
+			status, h.statuses = h.statuses[0], h.statuses[1:]
+		}
+		h.mu.Unlock()
+		w.WriteHeader(status)
+		h.arrived <- struct{}{}
+	}))
+	t.Cleanup(h.srv.Close)
+	return h
+}
+
+// wait waits for n more attempts to arrive.
+func (h *hook) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-h.arrived:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d webhook attempts arrived", i, n)
+		}
+	}
+}
+
+// requests returns the attempts received so far.
+func (h *hook) requests() []hookRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]hookRequest(nil), h.got...)
+}
+
+// startWebhooks starts delivering to urls, retrying up to retries times
+// with a short backoff. Deliveries stop when the test ends.
+func startWebhooks(t *testing.T, secret string, retries int, urls ...string) *webhookSender {
+	s := &webhookSender{secret: []byte(secret), retries: retries, backoff: 10 * time.Millisecond, client: &http.Client{Timeout: 5 * time.Second}}
+	for _, url := range urls {
+		target := &webhookTarget{url: url, queue: make(chan webhookDelivery, webhookQueueSize)}
+		s.targets = append(s.targets, target)
+		go s.deliver(target)
+		t.Cleanup(func() { close(target.queue) })
+	}
+	return s
+}
+
+// webhookRoom returns a room to notify webhooks from.
+func webhookRoom(t *testing.T) *GameRoom {
+	room := newGameRoom("HOOKS", defaultRoomOptions())
+	t.Cleanup(room.abandon)
+	return room
+}
+
+func TestWebhookSignature(t *testing.T) {
+	h := newHook(t)
+	s := startWebhooks(t, "shh", 0, h.srv.URL)
+	room := webhookRoom(t)
+
+	s.notify(room, "CHAT", []byte(`{"event":"CHAT"}`))
+	body := []byte(`{"event":"GAME_OVER","payload":{"winner":"ann"}}`)
+	s.notify(room, "GAME_OVER", body)
+	h.wait(t, 1)
+
+	got := h.requests()
+	if len(got) != 1 || got[0].body != string(body) {
+		t.Fatalf("target got %+v, want only the GAME_OVER", got)
+	}
+	mac := hmac.New(sha256.New, []byte("shh"))
+	mac.Write(body)
+	header := got[0].header
+	if sig, want := header.Get("X-Monopoly-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); sig != want {
+		t.Errorf("signature %q, want %q", sig, want)
+	}
+	if header.Get("X-Monopoly-Event") != "GAME_OVER" || header.Get("Content-Type") != "application/json" || header.Get("X-Monopoly-Delivery") == "" {
+		t.Errorf("headers %v", header)
+	}
+
+	unsigned := newHook(t)
+	startWebhooks(t, "", 0, unsigned.srv.URL).notify(room, "GAME_STARTED", []byte(`{}`))
+	unsigned.wait(t, 1)
+	if sig := unsigned.requests()[0].header.Get("X-Monopoly-Signature"); sig != "" {
+		t.Errorf("signed %q without a secret", sig)
+	}
+}
+
+// TestWebhookRetries checks which failures are retried, that the delay
+// between attempts doubles, and that a retry is the same delivery. A
+// second delivery follows each one: a target gets them in order, so once
+// it has arrived the first has had all its attempts.
+func TestWebhookRetries(t *testing.T) {
+	room := webhookRoom(t)
+	for _, tc := range []struct {
+		statuses []int
+		attempts int
+	}{
+		{[]int{200}, 1},
+		{[]int{500, 200}, 2},
+		{[]int{408, 429, 503, 200}, 4},
+		{[]int{502, 502, 502, 502, 502}, 4},
+		{[]int{400}, 1},
+		{[]int{404}, 1},
+		{[]int{503, 401}, 2},
+	} {
+		h := newHook(t, tc.statuses...)
+		s := startWebhooks(t, "", 3, h.srv.URL)
+		s.notify(room, "GAME_OVER", []byte(`"first"`))
+		s.notify(room, "GAME_STARTED", []byte(`"second"`))
+		h.wait(t, tc.attempts+1)
+
+		got := h.requests()
+		if last := got[len(got)-1]; len(got) != tc.attempts+1 || last.body != `"second"` {
+			t.Errorf("%v: %d attempts before the next delivery, want %d", tc.statuses, len(got)-1, tc.attempts)
+			continue
+		}
+		delay := s.backoff
+		for i := 1; i < tc.attempts; i++ {
+			if got[i].header.Get("X-Monopoly-Delivery") != got[0].header.Get("X-Monopoly-Delivery") {
+				t.Errorf("%v: attempt %d is a different delivery", tc.statuses, i+1)
+			}
+			if gap := got[i].at.Sub(got[i-1].at); gap < delay {
+				t.Errorf("%v: attempt %d came %v after the one before, want at least %v", tc.statuses, i+1, gap, delay)
+			}
+			delay *= 2
+		}
+	}
+}
+
+// TestWebhookOrdering checks each target gets its deliveries in the order
+// they were made, and one that hangs holds up none but its own.
+func TestWebhookOrdering(t *testing.T) {
+	release := make(chan struct{})
+	var stuck sync.Mutex
+	var stuckGot []string
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		<-release
+		stuck.Lock()
+		stuckGot = append(stuckGot, string(body))
+		stuck.Unlock()
+	}))
+	t.Cleanup(hung.Close)
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+	flaky := newHook(t, 503, 503)
+	healthy := newHook(t)
+	s := startWebhooks(t, "", 3, hung.URL, flaky.srv.URL, healthy.srv.URL)
+	room := webhookRoom(t)
+
+	var want []string
+	for _, body := range []string{`1`, `2`, `3`, `4`, `5`} {
+		s.notify(room, "PLAYER_FORFEITED", []byte(body))
+		want = append(want, body)
+	}
+	healthy.wait(t, len(want))
+	flaky.wait(t, len(want)+2)
+	bodies := func(rs []hookRequest) []string {
+		var got []string
+		for _, r := range rs {
+			got = append(got, r.body)
+		}
+		return got
+	}
+	if got := bodies(healthy.requests()); !reflect.DeepEqual(got, want) {
+		t.Errorf("healthy target got %v, want %v", got, want)
+	}
+	if got := bodies(flaky.requests()); !reflect.DeepEqual(got, append([]string{`1`, `1`}, want...)) {
+		t.Errorf("flaky target got %v, want the first retried and then the rest in order", got)
+	}
+
+	close(release)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		stuck.Lock()
+		got := append([]string(nil), stuckGot...)
+		stuck.Unlock()
+		if reflect.DeepEqual(got, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hung target got %v once released, want %v", got, want)
+		}
+	}
+}